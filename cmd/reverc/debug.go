@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/lexer"
+	"github.com/polidog/reverhttp/internal/parser"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+// debugFiles implements -tokens and -ast: it prints the token stream and/or
+// the parsed AST of each file to stdout instead of compiling, and returns
+// the exit code (1 if a file could not be read or parsed).
+func debugFiles(files []string, tokens, tree bool) int {
+	code := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			code = 1
+			continue
+		}
+		if len(files) > 1 {
+			fmt.Printf("# %s\n", file)
+		}
+		if tokens {
+			dumpTokens(os.Stdout, string(data), file)
+		}
+		if tree {
+			f, errs := parser.Parse(string(data), file)
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e)
+				code = 1
+			}
+			dumpAST(os.Stdout, f)
+		}
+	}
+	return code
+}
+
+// dumpTokens writes the raw token stream of src, one token per line as
+// "line:col TYPE literal", followed by any lexical errors.
+func dumpTokens(w io.Writer, src, filename string) {
+	l := lexer.New(src, filename)
+	for {
+		tok := l.NextToken()
+		fmt.Fprintf(w, "%d:%d %s %q\n", tok.Pos.Line, tok.Pos.Column, tok.Type, tok.Literal)
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+	for _, e := range l.Errors() {
+		fmt.Fprintln(w, e)
+	}
+}
+
+// dumpAST writes file as an indented tree. Zero-valued fields other than
+// kinds are left out, positions print as line:col, and a node reached a
+// second time (such as the group of a route) is named instead of repeated.
+func dumpAST(w io.Writer, file *ast.File) {
+	d := &astDumper{w: w, seen: make(map[uintptr]bool)}
+	d.value(reflect.ValueOf(file), 0)
+	fmt.Fprintln(w)
+}
+
+type astDumper struct {
+	w    io.Writer
+	seen map[uintptr]bool
+}
+
+var positionType = reflect.TypeOf(token.Position{})
+
+func (d *astDumper) value(v reflect.Value, depth int) {
+	indent := strings.Repeat("  ", depth)
+	if v.Type() == positionType {
+		pos := v.Interface().(token.Position)
+		fmt.Fprintf(d.w, "%d:%d", pos.Line, pos.Column)
+		return
+	}
+	if s, ok := v.Interface().(fmt.Stringer); ok && v.Kind() != reflect.Pointer {
+		fmt.Fprint(d.w, s.String())
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			fmt.Fprint(d.w, "nil")
+			return
+		}
+		if d.seen[v.Pointer()] {
+			fmt.Fprintf(d.w, "*%s (above)", v.Elem().Type().Name())
+			return
+		}
+		d.seen[v.Pointer()] = true
+		fmt.Fprint(d.w, "*")
+		d.value(v.Elem(), depth)
+	case reflect.Interface:
+		if v.IsNil() {
+			fmt.Fprint(d.w, "nil")
+			return
+		}
+		d.value(v.Elem(), depth)
+	case reflect.Struct:
+		fmt.Fprintf(d.w, "%s {\n", v.Type().Name())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			// Kinds are enums whose zero value is meaningful.
+			if _, enum := field.Interface().(fmt.Stringer); field.IsZero() && !enum {
+				continue
+			}
+			fmt.Fprintf(d.w, "%s  %s: ", indent, v.Type().Field(i).Name)
+			d.value(field, depth+1)
+			fmt.Fprintln(d.w)
+		}
+		fmt.Fprintf(d.w, "%s}", indent)
+	case reflect.Slice:
+		fmt.Fprintln(d.w, "[")
+		for i := 0; i < v.Len(); i++ {
+			fmt.Fprintf(d.w, "%s  %d: ", indent, i)
+			d.value(v.Index(i), depth+1)
+			fmt.Fprintln(d.w)
+		}
+		fmt.Fprintf(d.w, "%s]", indent)
+	case reflect.Map:
+		fmt.Fprint(d.w, v.Interface())
+	case reflect.String:
+		fmt.Fprintf(d.w, "%q", v.String())
+	default:
+		fmt.Fprint(d.w, v.Interface())
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/polidog/reverhttp/internal/parser"
+)
+
+func TestDumpTokens(t *testing.T) {
+	var b strings.Builder
+	dumpTokens(&b, "GET /users/{id}\n  |> respond 200", "users.rever")
+
+	want := `1:1 GET "GET"
+1:5 PATH "/users/{id}"
+1:16 NEWLINE "\n"
+2:3 |> "|>"
+2:6 respond "respond"
+2:14 INT "200"
+2:17 EOF ""
+`
+	if b.String() != want {
+		t.Errorf("unexpected token dump:\n%s\nexpected:\n%s", b.String(), want)
+	}
+}
+
+func TestDumpAST(t *testing.T) {
+	file, errs := parser.Parse("group /api {\n  GET /users\n    |> respond 200\n}", "users.rever")
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	var b strings.Builder
+	dumpAST(&b, file)
+	out := b.String()
+
+	for _, want := range []string{
+		"*File {\n",
+		"      Method: \"GET\"\n",
+		"      Path: \"/api/users\"\n",
+		"          Kind: respond\n",
+		"            Status: \"200\"\n",
+		"      Group: *Group (above)\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected the dump to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Doc:") {
+		t.Errorf("expected empty fields to be left out, got:\n%s", out)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/polidog/reverhttp/internal/gen"
+	"github.com/polidog/reverhttp/internal/parser"
+	"github.com/polidog/reverhttp/internal/resolve"
+	"github.com/polidog/reverhttp/internal/sema"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+// diagnostic is a message reported while compiling. Pos has no line for
+// errors not tied to a place in the source, such as an unreadable file.
+type diagnostic struct {
+	Pos      token.Position
+	Severity sema.Severity
+	Message  string
+}
+
+// String formats the diagnostic as "file:line:col: message", with the
+// severity before the message for anything but errors.
+func (d diagnostic) String() string {
+	switch {
+	case d.Pos.Line == 0:
+		return d.Message
+	case d.Severity == sema.SeverityError:
+		return fmt.Sprintf("%s:%d:%d: %s", d.Pos.File, d.Pos.Line, d.Pos.Column, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s: %s", d.Pos.File, d.Pos.Line, d.Pos.Column, d.Severity, d.Message)
+}
+
+// errorDiagnostic turns an error of any compiler stage into a diagnostic,
+// keeping its position when it has one.
+func errorDiagnostic(err error) diagnostic {
+	d := diagnostic{Severity: sema.SeverityError, Message: err.Error()}
+	switch e := err.(type) {
+	case parser.Error:
+		d.Pos, d.Message = e.Pos, e.Message
+	case resolve.Error:
+		d.Pos, d.Message = e.Pos, e.Message
+	case gen.Error:
+		d.Pos, d.Message = e.Pos, e.Message
+		if e.Warning {
+			d.Severity = sema.SeverityWarning
+		}
+	default:
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			d.Pos.File = pathErr.Path
+		}
+	}
+	return d
+}
+
+func semaDiagnostic(d sema.Diagnostic) diagnostic {
+	return diagnostic{Pos: d.Pos, Severity: d.Severity, Message: d.Message}
+}
+
+// jsonDiagnostic is a diagnostic as written by -errors json. Line and
+// column are 0 when the diagnostic has no position.
+type jsonDiagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// writeDiagnostics writes diags to w in format: "text", one per line, or
+// "json", a single array that is empty when there is nothing to report.
+func writeDiagnostics(w io.Writer, diags []diagnostic, format string) error {
+	if format != "json" {
+		for _, d := range diags {
+			if _, err := fmt.Fprintln(w, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	out := make([]jsonDiagnostic, len(diags))
+	for i, d := range diags {
+		out[i] = jsonDiagnostic{
+			File:     d.Pos.File,
+			Line:     d.Pos.Line,
+			Column:   d.Pos.Column,
+			Severity: d.Severity.String(),
+			Message:  d.Message,
+		}
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// exitCode is 1 when diags contain an error, or a warning with failOnWarning
+// set, and 0 otherwise. Hints never fail the build.
+func exitCode(diags []diagnostic, failOnWarning bool) int {
+	for _, d := range diags {
+		switch {
+		case d.Severity == sema.SeverityError:
+			return 1
+		case d.Severity == sema.SeverityWarning && failOnWarning:
+			return 1
+		}
+	}
+	return 0
+}
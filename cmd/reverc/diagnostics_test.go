@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/polidog/reverhttp/internal/gen"
+	"github.com/polidog/reverhttp/internal/sema"
+)
+
+func TestExitCode(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"clean.rever": "GET /health\n  |> respond 200",
+		"warning.rever": `GET /accounts
+  |> input(role: header.x-role)
+  |> match role {
+       _:       ~> 400 { error: "unknown role" }
+       "admin": ~> 403 { error: "forbidden" }
+     }
+  |> respond 200 { role: role }`,
+		"error.rever": "GET /users\n  |> respond 200 { list: users }",
+	})
+
+	tests := []struct {
+		file          string
+		failOnWarning bool
+		want          int
+	}{
+		{"clean.rever", false, 0},
+		{"clean.rever", true, 0},
+		{"warning.rever", false, 0},
+		{"warning.rever", true, 1},
+		{"error.rever", false, 1},
+		{"error.rever", true, 1},
+	}
+	for _, tt := range tests {
+		_, diags := compileFiles([]string{filepath.Join(dir, tt.file)}, gen.Options{})
+		if got := exitCode(diags, tt.failOnWarning); got != tt.want {
+			t.Errorf("%s (fail-on-warning=%v): expected exit code %d, got %d (%v)", tt.file, tt.failOnWarning, tt.want, got, diags)
+		}
+	}
+}
+
+func TestCompileFilesWarningsKeepOutput(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"warning.rever": `GET /accounts
+  |> input(role: header.x-role)
+  |> match role {
+       _:       ~> 400 { error: "unknown role" }
+       "admin": ~> 403 { error: "forbidden" }
+     }
+  |> respond 200 { role: role }`,
+	})
+
+	root, diags := compileFiles([]string{filepath.Join(dir, "warning.rever")}, gen.Options{})
+	if len(root.Routes) != 1 {
+		t.Errorf("expected the route despite the warning, got %d routes", len(root.Routes))
+	}
+	if len(diags) != 1 || diags[0].Severity != sema.SeverityWarning {
+		t.Fatalf("expected one warning, got %v", diags)
+	}
+	if !strings.HasSuffix(diags[0].String(), ":5:8: warning: unreachable match arm: follows wildcard at line 4") {
+		t.Errorf("expected the warning to be labelled, got %q", diags[0])
+	}
+}
+
+func TestCompileFilesGenWarningsKeepOutput(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"format.rever": "POST /books\n  |> input(code: body.code)\n  |> validate(code: string & format(isbn))\n  |> respond 201",
+	})
+
+	root, diags := compileFiles([]string{filepath.Join(dir, "format.rever")}, gen.Options{})
+	if len(root.Routes) != 1 {
+		t.Errorf("expected the route despite the warning, got %d routes", len(root.Routes))
+	}
+	if len(diags) != 1 || diags[0].Severity != sema.SeverityWarning || !strings.HasSuffix(diags[0].String(), `:3:30: warning: unknown format "isbn": expected one of email, uuid, url, date, datetime, ipv4, ipv6 or hostname`) {
+		t.Fatalf("expected one warning, got %v", diags)
+	}
+}
+
+func TestCompileFilesSemanticErrorsDropFile(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"error.rever": "GET /users\n  |> respond 200 { list: users }",
+	})
+
+	root, diags := compileFiles([]string{filepath.Join(dir, "error.rever")}, gen.Options{})
+	if len(root.Routes) != 0 {
+		t.Errorf("expected no routes from a file with errors, got %d", len(root.Routes))
+	}
+	if len(diags) != 1 || !strings.HasSuffix(diags[0].String(), `:2:26: undefined name "users"`) {
+		t.Errorf("expected an undefined name error, got %v", diags)
+	}
+}
+
+func TestWriteDiagnosticsJSON(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"errors.rever": "GET /users\n  |> respond 200 { list: users, next: cursor }",
+	})
+	file := filepath.Join(dir, "errors.rever")
+
+	_, diags := compileFiles([]string{file}, gen.Options{})
+	var buf bytes.Buffer
+	if err := writeDiagnostics(&buf, diags, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("expected a JSON array, got %s: %v", buf.String(), err)
+	}
+	want := []map[string]interface{}{
+		{"file": file, "line": 2.0, "column": 26.0, "severity": "error", "message": `undefined name "users"`},
+		{"file": file, "line": 2.0, "column": 39.0, "severity": "error", "message": `undefined name "cursor"`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if exitCode(diags, false) != 1 {
+		t.Error("expected the errors to fail the build")
+	}
+
+	buf.Reset()
+	if err := writeDiagnostics(&buf, nil, "json"); err != nil || buf.String() != "[]\n" {
+		t.Errorf("expected an empty array without diagnostics, got %q (%v)", buf.String(), err)
+	}
+}
+
+func TestErrorDiagnosticKeepsPosition(t *testing.T) {
+	_, errs := compileFiles([]string{filepath.Join(t.TempDir(), "missing.rever")}, gen.Options{})
+	if len(errs) != 1 || errs[0].Pos.Line != 0 || !strings.HasSuffix(errs[0].Pos.File, "missing.rever") {
+		t.Fatalf("expected an unreadable file error naming the file, got %+v", errs)
+	}
+
+	dir := writeTree(t, map[string]string{"bad.rever": "GET /users\n  |> 42"})
+	_, errs = compileFiles([]string{filepath.Join(dir, "bad.rever")}, gen.Options{})
+	if len(errs) == 0 || errs[0].Pos.Line != 2 || errs[0].Pos.Column != 6 || strings.Contains(errs[0].Message, "bad.rever") {
+		t.Fatalf("expected a parse error at 2:6 with a bare message, got %+v", errs)
+	}
+	if s := errs[0].String(); !strings.HasPrefix(s, filepath.Join(dir, "bad.rever")+":2:6: ") {
+		t.Errorf("expected the text form to lead with the position, got %q", s)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/polidog/reverhttp/internal/format"
+	"github.com/polidog/reverhttp/internal/parser"
+)
+
+// runFmt implements `reverc fmt [-w] <file.rever> ...`.
+func runFmt(args []string) {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write result to the source file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: reverc fmt [options] <file.rever> ...\n\nOptions:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	hasErrors := false
+	for _, file := range fs.Args() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if _, errs := parser.Parse(string(data), file); len(errs) > 0 {
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			hasErrors = true
+			continue
+		}
+
+		formatted := format.Source(string(data))
+		if !*write {
+			os.Stdout.WriteString(formatted)
+			continue
+		}
+		if formatted == string(data) {
+			continue
+		}
+		if err := os.WriteFile(file, []byte(formatted), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing %s: %v\n", file, err)
+			os.Exit(1)
+		}
+	}
+
+	if hasErrors {
+		os.Exit(1)
+	}
+}
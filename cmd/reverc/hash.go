@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// hashFiles returns the hex SHA-256 of files, read in sorted path order so
+// that the order of the arguments does not matter. Each source is
+// normalized first: a byte order mark is dropped and CRLF line endings
+// become LF, so a checkout on another platform hashes the same.
+func hashFiles(files []string) (string, error) {
+	sorted := slices.Clone(files)
+	slices.Sort(sorted)
+	h := sha256.New()
+	for _, file := range sorted {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		src := strings.TrimPrefix(string(data), "\ufeff")
+		src = strings.ReplaceAll(src, "\r\n", "\n")
+		// The length keeps the boundary between two files unambiguous.
+		fmt.Fprintf(h, "%d\n%s", len(src), src)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashFiles(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"a.rever":    "GET /a\n  |> respond 200",
+		"b.rever":    "GET /b\n  |> respond 200",
+		"copy.rever": "GET /a\n  |> respond 200",
+		"crlf.rever": "\ufeffGET /a\r\n  |> respond 200",
+	})
+	a, b := filepath.Join(dir, "a.rever"), filepath.Join(dir, "b.rever")
+	hash := func(files ...string) string {
+		t.Helper()
+		h, err := hashFiles(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	first := hash(a, b)
+	if len(first) != 64 {
+		t.Fatalf("expected a hex SHA-256, got %q", first)
+	}
+	if again := hash(a, b); again != first {
+		t.Errorf("expected identical inputs to hash the same, got %s and %s", first, again)
+	}
+	if reordered := hash(b, a); reordered != first {
+		t.Errorf("expected the argument order not to matter, got %s and %s", first, reordered)
+	}
+	if single := hash(a); single != hash(filepath.Join(dir, "copy.rever")) || single != hash(filepath.Join(dir, "crlf.rever")) {
+		t.Error("expected the same source to hash the same regardless of BOM and line endings")
+	}
+
+	if err := os.WriteFile(b, []byte("GET /b\n  |> respond 204"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if changed := hash(a, b); changed == first {
+		t.Errorf("expected a changed input to change the hash, got %s for both", first)
+	}
+
+	if _, err := hashFiles([]string{filepath.Join(dir, "missing.rever")}); err == nil {
+		t.Error("expected an unreadable file to be an error")
+	}
+}
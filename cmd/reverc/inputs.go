@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// expandInputs turns the command-line arguments into the list of files to
+// compile. Plain files are kept as given. Glob patterns (where "**" matches
+// any number of directories) and, with recursive set, directories expand to
+// the .rever files they contain, sorted by path. Hidden directories are
+// skipped and a file named twice is compiled once.
+func expandInputs(args []string, recursive bool) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, arg := range args {
+		if isGlob(arg) {
+			matches, err := globFiles(arg)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no .rever files match", arg)
+			}
+			for _, m := range matches {
+				add(m)
+			}
+			continue
+		}
+
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			add(arg)
+			continue
+		}
+		if !recursive {
+			return nil, fmt.Errorf("%s is a directory (use -r to compile the .rever files in it)", arg)
+		}
+		found, err := walkRever(arg, func(string) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range found {
+			add(f)
+		}
+	}
+	return files, nil
+}
+
+func isGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// globFiles returns the .rever files matching pattern, walking from the
+// directory before its first wildcard.
+func globFiles(pattern string) ([]string, error) {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	segments := strings.Split(pattern, "/")
+
+	base := 0
+	for base < len(segments) && !isGlob(segments[base]) {
+		base++
+	}
+	root := strings.Join(segments[:base], "/")
+	switch {
+	case root == "" && strings.HasPrefix(pattern, "/"):
+		root = "/"
+	case root == "":
+		root = "."
+	}
+
+	for _, seg := range segments {
+		if _, err := path.Match(seg, ""); err != nil {
+			return nil, fmt.Errorf("%s: %v", pattern, err)
+		}
+	}
+
+	return walkRever(filepath.FromSlash(root), func(file string) bool {
+		return matchSegments(segments, strings.Split(filepath.ToSlash(file), "/"))
+	})
+}
+
+// walkRever returns the sorted .rever files below root accepted by keep,
+// skipping hidden directories.
+func walkRever(root string, keep func(string) bool) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) == ".rever" && keep(p) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// matchSegments reports whether the path segments name match the pattern
+// segments pat, where a "**" segment matches zero or more segments.
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
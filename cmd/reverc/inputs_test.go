@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/polidog/reverhttp/internal/gen"
+)
+
+// writeTree creates files (slash-separated paths relative to a temporary
+// directory) and returns the directory.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+var tree = map[string]string{
+	"users/list.rever":   "GET /users\n  |> respond 200",
+	"users/get.rever":    "GET /users/{id}\n  |> input(id: path.id)\n  |> respond 200",
+	"health.rever":       "GET /health\n  |> respond 200",
+	"notes.txt":          "not a rever file",
+	".cache/old.rever":   "GET /old\n  |> respond 200",
+	"users/.tmp/x.rever": "GET /tmp\n  |> respond 200",
+}
+
+func relative(t *testing.T, dir string, files []string) []string {
+	t.Helper()
+	rel := make([]string, len(files))
+	for i, f := range files {
+		r, err := filepath.Rel(dir, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel[i] = filepath.ToSlash(r)
+	}
+	return rel
+}
+
+func TestExpandInputsDirectory(t *testing.T) {
+	dir := writeTree(t, tree)
+
+	files, err := expandInputs([]string{dir}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"health.rever", "users/get.rever", "users/list.rever"}
+	if got := relative(t, dir, files); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if _, err := expandInputs([]string{dir}, false); err == nil || !strings.Contains(err.Error(), "use -r") {
+		t.Errorf("expected a directory without -r to be rejected, got %v", err)
+	}
+}
+
+func TestExpandInputsGlob(t *testing.T) {
+	dir := writeTree(t, tree)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"**/*.rever", []string{"health.rever", "users/get.rever", "users/list.rever"}},
+		{"users/*.rever", []string{"users/get.rever", "users/list.rever"}},
+		{"*.rever", []string{"health.rever"}},
+	}
+	for _, tt := range tests {
+		files, err := expandInputs([]string{filepath.Join(dir, tt.pattern)}, false)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.pattern, err)
+		}
+		if got := relative(t, dir, files); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.pattern, tt.want, got)
+		}
+	}
+
+	if _, err := expandInputs([]string{filepath.Join(dir, "**", "*.json")}, false); err == nil {
+		t.Error("expected a pattern matching nothing to be an error")
+	}
+}
+
+func TestExpandInputsDeduplicates(t *testing.T) {
+	dir := writeTree(t, tree)
+	health := filepath.Join(dir, "health.rever")
+
+	files, err := expandInputs([]string{health, filepath.Join(dir, "*.rever")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(files, []string{health}) {
+		t.Errorf("expected health.rever once, got %v", files)
+	}
+}
+
+func TestCompileDirectory(t *testing.T) {
+	dir := writeTree(t, tree)
+
+	files, err := expandInputs([]string{dir}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, diags := compileFiles(files, gen.Options{})
+	if len(diags) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+	var paths []string
+	for _, r := range root.Routes {
+		paths = append(paths, r.RouteInfo.Path)
+	}
+	if want := []string{"/health", "/users/{id}", "/users"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected routes %v in path order, got %v", want, paths)
+	}
+	methods := map[string][]string{"/health": {"GET"}, "/users/{id}": {"GET"}, "/users": {"GET"}}
+	if !reflect.DeepEqual(root.PathMethods, methods) {
+		t.Errorf("expected the path methods of every file %v, got %v", methods, root.PathMethods)
+	}
+}
+
+func TestCompileFilesVersionMismatch(t *testing.T) {
+	dir := writeTree(t, map[string]string{
+		"a.rever": "version 0.2\n\nGET /a\n  |> respond 200",
+		"b.rever": "version 0.2\n\nGET /b\n  |> respond 200",
+		"c.rever": "GET /c\n  |> respond 200",
+	})
+	a, b, c := filepath.Join(dir, "a.rever"), filepath.Join(dir, "b.rever"), filepath.Join(dir, "c.rever")
+
+	root, diags := compileFiles([]string{a, b}, gen.Options{})
+	if len(diags) != 0 || root.Version != "0.2" || len(root.Routes) != 2 {
+		t.Fatalf("expected files of the same version to merge, got version %q, %d routes, %v", root.Version, len(root.Routes), diags)
+	}
+
+	root, diags = compileFiles([]string{a, c}, gen.Options{})
+	if len(diags) != 1 || diags[0].String() != c+":1:1: targets IR version 0.1, but earlier files target 0.2" {
+		t.Fatalf("expected a version mismatch error, got %v", diags)
+	}
+	if len(root.Routes) != 1 || exitCode(diags, false) != 1 {
+		t.Errorf("expected the mismatched file to be left out and the build to fail, got %d routes", len(root.Routes))
+	}
+
+	_, diags = compileFiles([]string{c, a}, gen.Options{})
+	if len(diags) != 1 || diags[0].String() != a+":1:1: targets IR version 0.2, but earlier files target 0.1" {
+		t.Errorf("expected the error at the version pragma, got %v", diags)
+	}
+}
@@ -8,15 +8,34 @@ import (
 
 	"github.com/polidog/reverhttp/internal/gen"
 	"github.com/polidog/reverhttp/internal/ir"
-	"github.com/polidog/reverhttp/internal/lexer"
 	"github.com/polidog/reverhttp/internal/parser"
+	"github.com/polidog/reverhttp/internal/resolve"
+	"github.com/polidog/reverhttp/internal/sema"
+	"github.com/polidog/reverhttp/internal/token"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		runFmt(os.Args[2:])
+		return
+	}
+
 	output := flag.String("o", "", "output file (default: stdout)")
 	indent := flag.Bool("indent", true, "indent JSON output")
+	inlineDefaults := flag.Bool("inline-defaults", false, "copy defaults into each route that does not override them")
+	recursive := flag.Bool("r", false, "compile the .rever files in directory arguments recursively")
+	corsPreflight := flag.Bool("cors-preflight", false, "generate an OPTIONS route for each path with CORS enabled")
+	deriveHead := flag.Bool("derive-head", false, "generate a HEAD route without a body for each GET route")
+	hash := flag.Bool("hash", false, "add the SHA-256 of the input files to the IR as source_hash")
+	showTokens := flag.Bool("tokens", false, "print the token stream of each file instead of compiling")
+	showAST := flag.Bool("ast", false, "print the parsed AST of each file instead of compiling")
+	errorFormat := flag.String("errors", "text", "format of errors and warnings: text or json")
+	errorFile := flag.String("errors-file", "", "write errors and warnings to this file (default: stderr)")
+	var failOnWarning bool
+	flag.BoolVar(&failOnWarning, "fail-on-warning", false, "exit with status 1 when there are warnings")
+	flag.BoolVar(&failOnWarning, "Werror", false, "same as -fail-on-warning")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: reverc [options] <file.rever> ...\n\nOptions:\n")
+		fmt.Fprintf(os.Stderr, "Usage: reverc [options] <file.rever|dir|glob> ...\n       reverc fmt [-w] <file.rever> ...\n\nOptions:\n")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
@@ -26,42 +45,55 @@ func main() {
 		flag.Usage()
 		os.Exit(1)
 	}
-
-	// Parse and merge all files
-	root := &ir.Root{
-		Version: "0.1",
+	if *errorFormat != "text" && *errorFormat != "json" {
+		fmt.Fprintf(os.Stderr, "error: -errors must be text or json, got %q\n", *errorFormat)
+		os.Exit(1)
 	}
-
-	hasErrors := false
-	for _, file := range args {
-		data, err := os.ReadFile(file)
+	report := func(diags []diagnostic) {
+		if *errorFile == "" {
+			writeDiagnostics(os.Stderr, diags, *errorFormat)
+			return
+		}
+		f, err := os.Create(*errorFile)
+		if err == nil {
+			err = writeDiagnostics(f, diags, *errorFormat)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			fmt.Fprintf(os.Stderr, "error writing errors: %v\n", err)
 			os.Exit(1)
 		}
+	}
 
-		l := lexer.New(string(data), file)
-		p := parser.New(l)
-		ast := p.ParseFile()
-
-		if errs := p.Errors(); len(errs) > 0 {
-			for _, e := range errs {
-				fmt.Fprintln(os.Stderr, e)
-			}
-			hasErrors = true
-			continue
-		}
+	files, err := expandInputs(args, *recursive)
+	if err != nil {
+		report([]diagnostic{errorDiagnostic(err)})
+		os.Exit(1)
+	}
 
-		fileIR := gen.Generate(ast)
-		mergeIR(root, fileIR)
+	if *showTokens || *showAST {
+		os.Exit(debugFiles(files, *showTokens, *showAST))
 	}
 
-	if hasErrors {
-		os.Exit(1)
+	root, diags := compileFiles(files, gen.Options{
+		InlineDefaults:        *inlineDefaults,
+		GenerateCORSPreflight: *corsPreflight,
+		DeriveHEAD:            *deriveHead,
+	})
+	if *hash && exitCode(diags, failOnWarning) == 0 {
+		if root.SourceHash, err = hashFiles(files); err != nil {
+			diags = append(diags, errorDiagnostic(err))
+		}
+	}
+	// Reported once: a second report would truncate -errors-file.
+	report(diags)
+	if code := exitCode(diags, failOnWarning); code != 0 {
+		os.Exit(code)
 	}
 
 	var jsonData []byte
-	var err error
 	if *indent {
 		jsonData, err = json.MarshalIndent(root, "", "  ")
 	} else {
@@ -84,7 +116,88 @@ func main() {
 	}
 }
 
-func mergeIR(dst, src *ir.Root) {
+// compileFiles compiles and merges files in order. A file with errors is
+// left out of the merged IR; warnings do not stop compilation.
+func compileFiles(files []string, opts gen.Options) (*ir.Root, []diagnostic) {
+	root := &ir.Root{}
+
+	var diags []diagnostic
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			diags = append(diags, errorDiagnostic(err))
+			continue
+		}
+
+		ast, errs := parser.Parse(string(data), file)
+		if len(errs) > 0 {
+			for _, e := range errs {
+				diags = append(diags, errorDiagnostic(e))
+			}
+			continue
+		}
+		if _, errs := resolve.Load(ast, file); len(errs) > 0 {
+			for _, e := range errs {
+				diags = append(diags, errorDiagnostic(e))
+			}
+			continue
+		}
+
+		failed := false
+		for _, d := range sema.Check(ast) {
+			diags = append(diags, semaDiagnostic(d))
+			if d.Severity == sema.SeverityError {
+				failed = true
+			}
+		}
+		if failed {
+			continue
+		}
+
+		fileIR, genErrs := gen.GenerateWithOptions(ast, opts)
+		for _, e := range genErrs {
+			diags = append(diags, errorDiagnostic(e))
+			if !e.Warning {
+				failed = true
+			}
+		}
+		if failed {
+			continue
+		}
+		if err := mergeIR(root, fileIR); err != nil {
+			pos := ast.VersionPos
+			if pos.Line == 0 {
+				pos = token.Position{File: file, Line: 1, Column: 1}
+			}
+			diags = append(diags, diagnostic{Pos: pos, Severity: sema.SeverityError, Message: err.Error()})
+		}
+	}
+	if root.Version == "" {
+		root.Version = ir.DefaultVersion
+	}
+	return root, diags
+}
+
+// mergeIR adds the declarations and routes of src to dst. Files can only be
+// merged when they target the same IR version; the first merged file sets
+// it.
+func mergeIR(dst, src *ir.Root) error {
+	if dst.Version == "" {
+		dst.Version = src.Version
+	} else if src.Version != dst.Version {
+		return fmt.Errorf("targets IR version %s, but earlier files target %s", src.Version, dst.Version)
+	}
+
+	// Merge meta (later files win per key)
+	if len(src.Meta) > 0 {
+		if dst.Meta == nil {
+			dst.Meta = make(map[string]interface{})
+		}
+		for k, v := range src.Meta {
+			dst.Meta[k] = v
+		}
+	}
+
 	// Merge imports
 	if len(src.Imports) > 0 {
 		if dst.Imports == nil {
@@ -105,11 +218,31 @@ func mergeIR(dst, src *ir.Root) {
 		}
 	}
 
+	// Merge enums
+	if len(src.Enums) > 0 {
+		if dst.Enums == nil {
+			dst.Enums = make(map[string][]string)
+		}
+		for k, v := range src.Enums {
+			dst.Enums[k] = v
+		}
+	}
+
 	// Merge defaults (last one wins)
 	if src.Defaults != nil {
 		dst.Defaults = src.Defaults
 	}
+	if len(src.MethodDefaults) > 0 {
+		if dst.MethodDefaults == nil {
+			dst.MethodDefaults = make(map[string]*ir.Defaults)
+		}
+		for k, v := range src.MethodDefaults {
+			dst.MethodDefaults[k] = v
+		}
+	}
 
 	// Append routes
 	dst.Routes = append(dst.Routes, src.Routes...)
+	dst.PathMethods = gen.PathMethods(dst.Routes)
+	return nil
 }
@@ -1,13 +1,32 @@
 package ast
 
-import "github.com/polidog/reverhttp/internal/token"
+import (
+	"fmt"
+
+	"github.com/polidog/reverhttp/internal/token"
+)
 
 // File is the root AST node representing a .rever file.
 type File struct {
-	Imports  []*ImportDecl
-	Types    []*TypeDecl
-	Defaults *DefaultsBlock
-	Routes   []*Route
+	Version        string // IR version from "version 0.2"; empty for the default
+	VersionPos     token.Position
+	Meta           *MetaBlock
+	Imports        []*ImportDecl
+	Types          []*TypeDecl
+	Enums          []*EnumDecl
+	Defaults       *DefaultsBlock
+	MethodDefaults []*DefaultsBlock // "defaults for <methods>" blocks
+	Pipelines      []*PipelineDecl
+	Groups         []*Group
+	Routes         []*Route // includes the routes of every group, in source order
+}
+
+// MetaBlock represents the file metadata block.
+//
+//	meta { title: "User API", version: "1.2.0", base: "/api" }
+type MetaBlock struct {
+	Pos    token.Position
+	Fields []*BodyField // literal values only
 }
 
 // ImportDecl represents an import declaration.
@@ -15,59 +34,155 @@ type File struct {
 //	import fetch = github.com/reverhttp/std-fetch@0.1.0
 //	import fetch = @/src/user/fetch.rever
 type ImportDecl struct {
-	Pos     token.Position
-	Alias   string
-	Source  string
-	Version string // empty for local imports
-	Local   bool   // true if source starts with @/
+	Pos      token.Position
+	AliasPos token.Position
+	Alias    string
+	Source   string
+	Version  string // empty for local imports
+	Local    bool   // true if source starts with @/
 }
 
 // TypeDecl represents a type definition.
 //
 //	type User { id: int, name: string }
 type TypeDecl struct {
-	Pos    token.Position
-	Name   string
-	Fields []*Field
+	Pos     token.Position
+	NamePos token.Position
+	Name    string
+	Fields  []*Field
+	Doc     string // leading comment block; see lexer.SetCaptureComments
+}
+
+// EnumDecl represents an enum declaration.
+//
+//	enum Role { user, admin, guest }
+type EnumDecl struct {
+	Pos     token.Position
+	NamePos token.Position
+	Name    string
+	Values  []string
+	Doc     string // leading comment block; see lexer.SetCaptureComments
+}
+
+// PipelineDecl represents a named sequence of steps that routes include
+// with `|> use <name>`.
+//
+//	pipeline userInput {
+//	  |> validate(name: string)
+//	  |> transform(name: trim(name))
+//	}
+type PipelineDecl struct {
+	Pos     token.Position
+	NamePos token.Position
+	Name    string
+	Steps   []*PipelineStep
 }
 
 // Field represents a field in a type declaration.
 type Field struct {
+	Pos      token.Position // position of Name
 	Name     string
 	TypeName string
+	Default  *Expr // literal after '=', nil when the field has no default
 }
 
-// DefaultsBlock represents a defaults block.
+// DefaultsBlock represents a defaults block, optionally scoped to methods.
 //
 //	defaults
 //	  cors(...)
 //	  auth(...)
+//
+//	defaults for POST, PUT, DELETE { auth(bearer) }
 type DefaultsBlock struct {
 	Pos        token.Position
+	Methods    []string // empty for a block that applies to every route
+	MethodPos  []token.Position
 	Directives []*Directive
 }
 
-// Directive represents a route-level directive (cache, cors, auth).
+// Directive represents a route-level directive (cache, cors, auth, retry).
+// retry may also follow a package call step.
 type Directive struct {
 	Pos  token.Position
-	Name string // "cache", "cors", "auth"
+	Name string // "cache", "cors", "auth", "retry", "paginate", "accepts", "compress"
 	Args []*Arg
 	Bind string // for auth: "as current_user"
 }
 
-// Arg is a named or positional argument in a directive or step call.
+// Arg is a named or positional argument in a directive, package call or
+// constraint.
 type Arg struct {
-	Name  string // empty for positional args
-	Value Expr
+	Pos    token.Position // position of Name, or of Value for positional args
+	Name   string         // empty for positional args
+	Value  Expr
+	Refs   []string // {name} placeholders of a string Value, e.g. "id"
+	RefPos []token.Position
 }
 
 // Route represents a route definition with its pipeline.
 type Route struct {
+	Pos         token.Position
+	Method      string
+	Path        string        // includes the prefixes of the enclosing groups
+	Doc         string        // leading comment block; see lexer.SetCaptureComments
+	Annotations []*Annotation // @doc / @deprecated lines above the route
+	Directives  []*Directive
+	Steps       []*PipelineStep
+	Group       *Group // innermost enclosing group; nil at top level
+}
+
+// Annotation is an @name line above a route that is kept in the IR,
+// unlike a comment.
+//
+//	@doc "Returns the user"
+//	@deprecated "use /v2/users"
+type Annotation struct {
+	Pos   token.Position // position of the '@'
+	Name  string         // one of AnnotationNames
+	Value string         // the string after the name; may be empty for @deprecated
+}
+
+// AnnotationNames are the annotations accepted above a route.
+var AnnotationNames = map[string]bool{
+	"doc":        true,
+	"deprecated": true,
+}
+
+// EffectiveDirectives returns the route's own directives followed by those
+// of its enclosing groups, innermost first, that the route does not declare
+// itself. A directive declared closer to the route wins.
+func (r *Route) EffectiveDirectives() []*Directive {
+	if r.Group == nil {
+		return r.Directives
+	}
+	dirs := append([]*Directive(nil), r.Directives...)
+	declared := make(map[string]bool)
+	for _, d := range dirs {
+		declared[d.Name] = true
+	}
+	for g := r.Group; g != nil; g = g.Parent {
+		for _, d := range g.Directives {
+			if !declared[d.Name] {
+				declared[d.Name] = true
+				dirs = append(dirs, d)
+			}
+		}
+	}
+	return dirs
+}
+
+// Group represents a route group sharing a path prefix and directives.
+//
+//	group /api/v1 {
+//	  auth(bearer) as user
+//	  GET /users
+//	    |> respond 200
+//	}
+type Group struct {
 	Pos        token.Position
-	Method     string
-	Path       string
+	Prefix     string // full prefix without trailing slash, including those of the enclosing groups
 	Directives []*Directive
-	Steps      []*PipelineStep
+	Parent     *Group
 }
 
 // PipelineStep represents a step in a pipeline.
@@ -79,9 +194,15 @@ type PipelineStep struct {
 	Transform *TransformStep
 	Guard     *GuardStep
 	Match     *MatchStep
+	Map       *MapStep
+	Enrich    *EnrichStep
+	Forward   *ForwardStep
+	Use       *UseStep
 	PkgCall   *PkgCallStep
 	Respond   *RespondStep
-	Bind      string     // "as name"
+	Bind      string // "as name"
+	BindPos   token.Position
+	Retry     *Directive // "retry(attempts: 3)" after a package call
 	ErrorFlow *ErrorFlow // "~> status { body }"
 }
 
@@ -96,67 +217,151 @@ const (
 	StepMatch
 	StepPkgCall
 	StepRespond
+	StepMap
+	StepEnrich
+	StepUse
+	StepForward
 )
 
+var stepKindNames = [...]string{"input", "validate", "transform", "guard", "match", "pkgcall", "respond", "map", "enrich", "use", "forward"}
+
+func (k StepKind) String() string {
+	if k >= 0 && int(k) < len(stepKindNames) {
+		return stepKindNames[k]
+	}
+	return fmt.Sprintf("StepKind(%d)", int(k))
+}
+
 // InputStep represents input(...).
 type InputStep struct {
+	Pos    token.Position // position of the input keyword
 	Fields []*InputField
 }
 
 // InputField represents a field in input().
 type InputField struct {
-	Pos  token.Position // position of From expression (e.g., "path.id")
-	Name string
-	From string // e.g., "path.id", "body.name", "header.x-role"
+	Pos      token.Position // position of From expression (e.g., "path.id")
+	NamePos  token.Position
+	Name     string
+	From     string // e.g., "path.id", "body.name", "header.x-role"; "header" when Wildcard
+	Wildcard bool   // header.* or headers: the whole header map
+	Default  *Expr  // literal after ??, used when the source is absent; nil if none
 }
 
 // ValidateStep represents validate(...).
 type ValidateStep struct {
-	Rules []*ValidateRule
+	Pos     token.Position // position of the validate keyword
+	Rules   []*ValidateRule
+	Type    string // validate(User): the fields of this type are the rules; Rules is empty
+	TypePos token.Position
 }
 
 // ValidateRule represents a single validation rule.
 type ValidateRule struct {
+	Pos         token.Position // position of Field
 	Field       string
+	Optional    bool // "name?:" — skip the rule when the field is absent
 	Constraints []*Constraint
 }
 
-// Constraint represents a single validation constraint like int, min(1), max(100), format(email).
+// Constraint represents a single validation constraint like int, min(1), max(100), format(email), oneOf("a", "b"), pattern(/^[a-z]+$/).
 type Constraint struct {
+	Pos  token.Position
 	Name string
 	Args []Expr
 }
 
 // TransformStep represents transform(...).
 type TransformStep struct {
+	Pos    token.Position // position of the transform keyword
 	Fields []*TransformField
 }
 
 // TransformField represents a field transformation.
 type TransformField struct {
-	Name string
-	Func string // function name: "int", "trim", "lower", etc.
-	From string // source variable
+	Pos   token.Position // position of Name
+	Name  string
+	Func  string   // outermost function name: "int", "trim", "lower", etc.
+	Chain []string // all functions of nested calls, innermost first: lower(trim(x)) → trim, lower
+	From  string   // source variable, possibly dotted: body.name
+	Args  []Expr   // literal arguments after the source of the innermost call: default(role, "user")
 }
 
-// GuardStep represents guard <expr>.
+// GuardStep represents guard <expr> [~> ...] [else <step>].
 type GuardStep struct {
+	Pos     token.Position // position of Expr
 	Negated bool
-	Expr    string // the expression (variable name)
+	Expr    string        // the expression (variable name)
+	Else    *PipelineStep // alternative step (respond or package call) when the guard fails
+}
+
+// MapElement is the name bound to the current element inside a map body.
+const MapElement = "it"
+
+// MapStep represents map(<source>, { body }): the body is evaluated once per
+// element of source, with the element bound to MapElement.
+type MapStep struct {
+	Pos    token.Position // position of Source
+	Source string         // the list to iterate, e.g. "users" or "page.items"
+	Body   []*BodyField
+}
+
+// EnrichStep represents enrich(a, b, ...): the fields of the sources merged
+// into one object, later sources overriding earlier ones.
+type EnrichStep struct {
+	Pos       token.Position // position of the enrich keyword
+	Sources   []string
+	SourcePos []token.Position // position of each source
+}
+
+// ForwardStep represents forward("http://users-svc/{id}"): the request
+// proxied to the URL, with each {name} placeholder replaced by a binding.
+type ForwardStep struct {
+	Pos      token.Position // position of the URL string
+	URL      string
+	Params   []string         // placeholder names in order, e.g. "id" or "user.id"
+	ParamPos []token.Position // position of each placeholder name
+}
+
+// UseStep represents use <pipeline>: the steps of the named pipeline
+// declaration, inlined in its place.
+type UseStep struct {
+	Pos  token.Position // position of the name
+	Name string
 }
 
 // MatchStep represents match <expr> { ... }.
 type MatchStep struct {
-	On   string // the expression to match on
-	Arms []*MatchArm
+	Pos   token.Position // position of the match keyword
+	OnPos token.Position
+	On    string // the expression to match on
+	Arms  []*MatchArm
+}
+
+// Responds reports whether every arm of m, the wildcard included, ends in a
+// respond: whichever arm is taken, the match ends the request.
+func (m *MatchStep) Responds() bool {
+	hasDefault := false
+	for _, arm := range m.Arms {
+		if arm.Respond == nil {
+			return false
+		}
+		hasDefault = hasDefault || arm.IsDefault
+	}
+	return hasDefault
 }
 
 // MatchArm represents a single arm of a match expression.
 type MatchArm struct {
-	Pattern   Pattern
-	Step      *PkgCallStep // the step to execute (could also be just a variable ref)
-	IsDefault bool
-	ErrorFlow *ErrorFlow
+	Pos         token.Position // position of the pattern
+	Pattern     Pattern
+	When        string // optional arm guard: "when account.active"
+	WhenNegated bool   // true for "when !expr"
+	WhenPos     token.Position
+	Step        *PkgCallStep // the step to execute (could also be just a variable ref)
+	Respond     *RespondStep // a response that ends the request, instead of Step
+	IsDefault   bool
+	ErrorFlow   *ErrorFlow
 	// For default arms that are just an error
 	ErrorOnly bool
 	// For arms that just reference a variable
@@ -165,13 +370,14 @@ type MatchArm struct {
 
 // Pattern represents a match pattern.
 type Pattern struct {
-	Kind      PatternKind
-	Value     string   // for literal
-	Values    []string // for multi-value
-	RangeMin  string   // for range
-	RangeMax  string   // for range
-	Regex     string   // for regex
-	IsDefault bool     // for wildcard _
+	Kind       PatternKind
+	Value      string   // for literal
+	Values     []string // for multi-value
+	RangeMin   string   // for range
+	RangeMax   string   // for range
+	Regex      string   // for regex
+	RegexFlags string   // for regex: any of "i", "m", "s"
+	IsDefault  bool     // for wildcard _
 }
 
 // PatternKind indicates the kind of match pattern.
@@ -185,41 +391,72 @@ const (
 	PatternWildcard
 )
 
+var patternKindNames = [...]string{"literal", "multi", "range", "regex", "wildcard"}
+
+func (k PatternKind) String() string {
+	if k >= 0 && int(k) < len(patternKindNames) {
+		return patternKindNames[k]
+	}
+	return fmt.Sprintf("PatternKind(%d)", int(k))
+}
+
 // PkgCallStep represents a call to an imported package step.
 //
 //	fetch(User, id)
 //	create(User, { name, email })
 type PkgCallStep struct {
+	Pos  token.Position // position of the package alias
 	Pkg  string
-	Args []*PkgArg
-}
-
-// PkgArg represents an argument to a package call.
-type PkgArg struct {
-	Name       string // named arg key (e.g., "key" in redis-cache(key: "..."))
-	Value      string // simple value
-	IsType     bool   // true if this is a type name (starts with uppercase)
-	ObjectArgs []string // for { name, email } shorthand
+	Args []*Arg // e.g. key: "..." in redis-cache(key: "..."), or User in fetch(User, id)
 }
 
 // RespondStep represents respond <status> [{ body }] [with headers { ... }].
 type RespondStep struct {
-	Status  string
-	Body    []*BodyField
-	Headers []*BodyField
+	Pos         token.Position // position of the respond keyword
+	StatusPos   token.Position
+	Status      string
+	StatusRef   string // bound name holding the status, e.g. respond upstream.status
+	SchemaPos   token.Position
+	Schema      string // declared body type, e.g. respond 200: User { ... }
+	ContentType string // optional keyword from ContentTypes, e.g. "html"
+	Body        []*BodyField
+	ExamplePos  token.Position
+	Example     []*BodyField // literal example payload for docs, e.g. respond 200 example { id: 1 }
+	Text        string       // string literal body, e.g. respond 200 text "ok"
+	HasText     bool
+	Headers     []*BodyField
+	Cookies     []*BodyField // cookie name: value pairs plus attributes shared by all of them
+}
+
+// ContentTypes maps the content-type keywords accepted after a respond
+// status to their MIME types.
+var ContentTypes = map[string]string{
+	"json": "application/json",
+	"html": "text/html",
+	"text": "text/plain",
 }
 
 // BodyField represents a key-value pair in a respond body or headers.
 type BodyField struct {
-	Key   string
-	Value string // expression like "user.id" or a string literal
+	Pos      token.Position // position of Value (or Key for shorthand fields)
+	KeyPos   token.Position
+	Key      string
+	Value    interface{} // string (expression like "user.id" or a string literal), []*BodyField (nested object) or BodyList
+	IsString bool        // true if Value is a string literal
+	Spread   bool        // true for ...name; Value holds the spread source and Key is empty
+	Refs     []string    // {name} placeholders of a string Value, e.g. "user.id"
+	RefPos   []token.Position
 }
 
+// BodyList is a [ ... ] body value. Its elements have no Key.
+type BodyList []*BodyField
+
 // ErrorFlow represents ~> <status> [{ body }].
 type ErrorFlow struct {
-	Pos    token.Position
-	Status string
-	Body   []*BodyField
+	Pos       token.Position
+	StatusPos token.Position
+	Status    string
+	Body      []*BodyField
 }
 
 // Expr is a simple expression — for now, just a string value or an int.
@@ -228,6 +465,8 @@ type Expr struct {
 	StrVal  string
 	IntVal  string
 	ListVal []string
+	Items   []Expr // the items of an ExprList with their kinds; ListVal holds their text
+	Flags   string // regex flags for ExprRegex
 }
 
 // ExprKind indicates the type of expression.
@@ -240,8 +479,22 @@ const (
 	ExprBool
 	ExprList
 	ExprFuncCall // for things like hash(user)
+	ExprDuration // for things like 200ms; StrVal holds the literal
+	ExprRegex    // for things like /^[a-z]+$/i; StrVal holds the pattern
+	ExprType     // for a type name such as User; StrVal holds the name
+	ExprObject   // for { name, email }; ListVal holds the names
+	ExprFloat    // for things like -90.5; StrVal holds the literal
 )
 
+var exprKindNames = [...]string{"string", "int", "ident", "bool", "list", "funccall", "duration", "regex", "type", "object", "float"}
+
+func (k ExprKind) String() string {
+	if k >= 0 && int(k) < len(exprKindNames) {
+		return exprKindNames[k]
+	}
+	return fmt.Sprintf("ExprKind(%d)", int(k))
+}
+
 // FuncCallExpr extends Expr for function calls in directive args.
 type FuncCallExpr struct {
 	Func string
@@ -0,0 +1,30 @@
+package ast
+
+import "strings"
+
+// PathParams returns the names of the {param} segments of a route path, in
+// order. The trailing "*" of a catch-all {param*} is not part of the name.
+func PathParams(path string) []string {
+	var names []string
+	for {
+		start := strings.IndexByte(path, '{')
+		if start == -1 {
+			return names
+		}
+		end := strings.IndexByte(path[start:], '}')
+		if end == -1 {
+			return names
+		}
+		if name := strings.TrimSuffix(path[start+1:start+end], "*"); name != "" {
+			names = append(names, name)
+		}
+		path = path[start+end+1:]
+	}
+}
+
+// IsCatchAll reports whether the last segment of path matches the rest of
+// the request path: a bare "*" or a {param*}.
+func IsCatchAll(path string) bool {
+	last := path[strings.LastIndexByte(path, '/')+1:]
+	return last == "*" || strings.HasPrefix(last, "{") && strings.HasSuffix(last, "*}")
+}
@@ -0,0 +1,174 @@
+package ast
+
+// Node is implemented by the pointer types of the AST: the declarations,
+// routes, steps and the parts they are made of. Values that are always held
+// inline, such as Expr and Pattern, are leaves and not nodes.
+type Node interface {
+	node()
+}
+
+func (*File) node()           {}
+func (*MetaBlock) node()      {}
+func (*ImportDecl) node()     {}
+func (*TypeDecl) node()       {}
+func (*EnumDecl) node()       {}
+func (*Field) node()          {}
+func (*DefaultsBlock) node()  {}
+func (*PipelineDecl) node()   {}
+func (*Group) node()          {}
+func (*Route) node()          {}
+func (*Annotation) node()     {}
+func (*Directive) node()      {}
+func (*Arg) node()            {}
+func (*PipelineStep) node()   {}
+func (*InputStep) node()      {}
+func (*InputField) node()     {}
+func (*ValidateStep) node()   {}
+func (*ValidateRule) node()   {}
+func (*Constraint) node()     {}
+func (*TransformStep) node()  {}
+func (*TransformField) node() {}
+func (*GuardStep) node()      {}
+func (*MatchStep) node()      {}
+func (*MatchArm) node()       {}
+func (*MapStep) node()        {}
+func (*EnrichStep) node()     {}
+func (*ForwardStep) node()    {}
+func (*UseStep) node()        {}
+func (*PkgCallStep) node()    {}
+func (*RespondStep) node()    {}
+func (*BodyField) node()      {}
+func (*ErrorFlow) node()      {}
+
+// Walk calls fn for node and, if fn returns true, walks each child of node
+// in source order. The declarations of a file come kind by kind, in the
+// order of the File fields. A route's Group and a group's Parent are links
+// back up the tree and are not followed, so every node is visited once.
+func Walk(node Node, fn func(Node) bool) {
+	if !fn(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *File:
+		if n.Meta != nil {
+			Walk(n.Meta, fn)
+		}
+		walkList(n.Imports, fn)
+		walkList(n.Types, fn)
+		walkList(n.Enums, fn)
+		if n.Defaults != nil {
+			Walk(n.Defaults, fn)
+		}
+		walkList(n.MethodDefaults, fn)
+		walkList(n.Pipelines, fn)
+		walkList(n.Groups, fn)
+		walkList(n.Routes, fn)
+	case *MetaBlock:
+		walkList(n.Fields, fn)
+	case *TypeDecl:
+		walkList(n.Fields, fn)
+	case *DefaultsBlock:
+		walkList(n.Directives, fn)
+	case *PipelineDecl:
+		walkList(n.Steps, fn)
+	case *Group:
+		walkList(n.Directives, fn)
+	case *Route:
+		walkList(n.Annotations, fn)
+		walkList(n.Directives, fn)
+		walkList(n.Steps, fn)
+	case *Directive:
+		walkList(n.Args, fn)
+	case *PipelineStep:
+		// Only the field of the step's Kind is set.
+		if n.Input != nil {
+			Walk(n.Input, fn)
+		}
+		if n.Validate != nil {
+			Walk(n.Validate, fn)
+		}
+		if n.Transform != nil {
+			Walk(n.Transform, fn)
+		}
+		if n.Guard != nil {
+			Walk(n.Guard, fn)
+		}
+		if n.Match != nil {
+			Walk(n.Match, fn)
+		}
+		if n.Map != nil {
+			Walk(n.Map, fn)
+		}
+		if n.Enrich != nil {
+			Walk(n.Enrich, fn)
+		}
+		if n.Forward != nil {
+			Walk(n.Forward, fn)
+		}
+		if n.Use != nil {
+			Walk(n.Use, fn)
+		}
+		if n.PkgCall != nil {
+			Walk(n.PkgCall, fn)
+		}
+		if n.Respond != nil {
+			Walk(n.Respond, fn)
+		}
+		if n.Retry != nil {
+			Walk(n.Retry, fn)
+		}
+		if n.ErrorFlow != nil {
+			Walk(n.ErrorFlow, fn)
+		}
+	case *InputStep:
+		walkList(n.Fields, fn)
+	case *ValidateStep:
+		walkList(n.Rules, fn)
+	case *ValidateRule:
+		walkList(n.Constraints, fn)
+	case *TransformStep:
+		walkList(n.Fields, fn)
+	case *GuardStep:
+		if n.Else != nil {
+			Walk(n.Else, fn)
+		}
+	case *MatchStep:
+		walkList(n.Arms, fn)
+	case *MatchArm:
+		if n.Step != nil {
+			Walk(n.Step, fn)
+		}
+		if n.Respond != nil {
+			Walk(n.Respond, fn)
+		}
+		if n.ErrorFlow != nil {
+			Walk(n.ErrorFlow, fn)
+		}
+	case *MapStep:
+		walkList(n.Body, fn)
+	case *PkgCallStep:
+		walkList(n.Args, fn)
+	case *RespondStep:
+		walkList(n.Example, fn)
+		walkList(n.Body, fn)
+		walkList(n.Headers, fn)
+		walkList(n.Cookies, fn)
+	case *BodyField:
+		switch v := n.Value.(type) {
+		case []*BodyField:
+			walkList(v, fn)
+		case BodyList:
+			walkList(v, fn)
+		}
+	case *ErrorFlow:
+		walkList(n.Body, fn)
+	}
+}
+
+// walkList walks each node of a child list.
+func walkList[T Node](nodes []T, fn func(Node) bool) {
+	for _, n := range nodes {
+		Walk(n, fn)
+	}
+}
@@ -0,0 +1,171 @@
+package ast_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/parser"
+)
+
+func parseFile(t *testing.T, name string) *ast.File {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, errs := parser.Parse(string(data), name)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return f
+}
+
+// count tallies the nodes Walk visits by type name.
+func count(root ast.Node) map[string]int {
+	counts := make(map[string]int)
+	ast.Walk(root, func(n ast.Node) bool {
+		counts[reflect.TypeOf(n).Elem().Name()]++
+		return true
+	})
+	return counts
+}
+
+func TestWalkPostUsers(t *testing.T) {
+	// The example of spec section 7.
+	counts := count(parseFile(t, "post_users.rever"))
+
+	want := map[string]int{
+		"Route":        1,
+		"PipelineStep": 7,
+		"Directive":    0,
+		"ImportDecl":   2,
+		"ErrorFlow":    3,
+		"ValidateRule": 2,
+		"Constraint":   5,
+	}
+	for name, n := range want {
+		if counts[name] != n {
+			t.Errorf("expected %d %s nodes, got %d", n, name, counts[name])
+		}
+	}
+}
+
+func TestWalkDirectives(t *testing.T) {
+	counts := count(parseFile(t, "cache_auth.rever"))
+
+	// Two in defaults, two on each route.
+	if counts["Directive"] != 6 {
+		t.Errorf("expected 6 directives, got %d", counts["Directive"])
+	}
+	if counts["Route"] != 2 || counts["PipelineStep"] != 6 {
+		t.Errorf("expected 2 routes and 6 steps, got %d and %d", counts["Route"], counts["PipelineStep"])
+	}
+}
+
+func TestWalkSourceOrder(t *testing.T) {
+	f, errs := parser.Parse(`GET /users/{id}
+  cache(max-age: 60)
+  |> input(id: path.id)
+  |> respond 200 { id: id }`, "test.rever")
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+
+	var order []string
+	ast.Walk(f, func(n ast.Node) bool {
+		order = append(order, reflect.TypeOf(n).Elem().Name())
+		return true
+	})
+	want := []string{"File", "Route", "Directive", "Arg", "PipelineStep", "InputStep", "InputField", "PipelineStep", "RespondStep", "BodyField"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("expected %v, got %v", want, order)
+	}
+}
+
+func TestWalkSkipsChildren(t *testing.T) {
+	f := parseFile(t, "post_users.rever")
+
+	routes, steps := 0, 0
+	ast.Walk(f, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.Route:
+			routes++
+			return false
+		case *ast.PipelineStep:
+			steps++
+		}
+		return true
+	})
+	if routes != 1 || steps != 0 {
+		t.Errorf("expected the route's steps to be skipped, got %d routes and %d steps", routes, steps)
+	}
+}
+
+// allSyntax uses the constructs the testdata files do not.
+const allSyntax = `meta { title: "User API" }
+import profile = github.com/reverhttp/std-profile@0.1.0
+enum Role { user, admin }
+
+defaults for POST { auth(bearer) }
+
+pipeline userInput {
+  |> input(id: path.id)
+}
+
+group /api {
+  cors(origins: ["*"])
+  @doc "Returns the user"
+  GET /users/{id}
+    |> use userInput
+    |> profile(key: "p:{id}") as p
+    |> guard p else respond 404
+    |> enrich(p, p) as full
+    |> map(full.items, { id: it.id }) as items
+    |> match p.kind {
+         "a": respond 200 { items: [items] }
+         _:   p
+       } as out
+    |> forward("http://svc/{id}") as upstream
+    |> respond 200 { out } with headers { x-id: id } with cookies { sid: id, secure }
+}`
+
+func TestWalkNodesHavePositions(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "testdata", "*.rever"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no testdata files: %v", err)
+	}
+	roots := map[string]*ast.File{}
+	for _, file := range files {
+		roots[file] = parseFile(t, filepath.Base(file))
+	}
+	f, errs := parser.Parse(allSyntax, "all.rever")
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	roots["all.rever"] = f
+
+	seen := make(map[string]bool)
+	for file, root := range roots {
+		ast.Walk(root, func(n ast.Node) bool {
+			v := reflect.ValueOf(n).Elem()
+			name := v.Type().Name()
+			seen[name] = true
+			if name == "File" {
+				return true
+			}
+			pos := v.FieldByName("Pos")
+			if !pos.IsValid() {
+				t.Errorf("%s: %s has no Pos field", file, name)
+			} else if pos.IsZero() {
+				t.Errorf("%s: %s has no position: %+v", file, name, n)
+			}
+			return true
+		})
+	}
+	if len(seen) != 32 {
+		t.Errorf("expected every node type to be visited, got %d: %v", len(seen), seen)
+	}
+}
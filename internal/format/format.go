@@ -0,0 +1,209 @@
+// Package format rewrites ReverHTTP source into its canonical layout.
+//
+// The formatter works line by line so comments and intra-line alignment
+// (such as padded `~>` columns) are preserved. It normalizes:
+//
+//   - indentation: top-level declarations at column 0, directives, pipeline
+//     steps and type fields at 2, and continuation lines, inside brackets or
+//     after a trailing `\`, two columns deeper than the construct that
+//     opened them (aligned after `|> ` for pipeline steps); everything inside
+//     a group or pipeline block is indented two more columns
+//   - trailing whitespace
+//   - runs of blank lines, collapsed to one, with none at the start or end
+//   - a single trailing newline
+//
+// Lines inside a multi-line """...""" string are left untouched.
+package format
+
+import (
+	"strings"
+
+	"github.com/polidog/reverhttp/internal/lexer"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+const indentUnit = 2
+
+// Source formats src. The input should parse without errors; formatting
+// invalid input is best-effort.
+func Source(src string) string {
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	lines := strings.Split(src, "\n")
+	toks, continued := tokensByLine(src, len(lines))
+	verbatim := textBlockLines(toks, len(lines))
+
+	indents := make([]int, len(lines))
+	var stack []int    // content indent for each open bracket
+	base := 0          // indent of declarations in the innermost open block
+	blockHead := false // after a group or pipeline line, before its first declaration
+	lineCont := 0      // indent of the lines continuing the last one with '\'
+
+	for i := range lines {
+		lineToks := toks[i]
+		if len(lineToks) == 0 {
+			indents[i] = -1 // blank or comment-only; resolved below
+			continue
+		}
+
+		first := lineToks[0]
+		switch {
+		case len(stack) > 0 && isCloser(first.Type):
+			indents[i] = stack[len(stack)-1] - indentUnit
+		case len(stack) > 0:
+			indents[i] = stack[len(stack)-1]
+		case continued[i]:
+			indents[i] = lineCont
+		case first.Type == token.RBRACE && base > 0:
+			// Closes a group or pipeline.
+			base -= indentUnit
+			indents[i] = base
+			continue
+		case isTopLevel(first.Type) || isVersionPragma(lineToks):
+			indents[i] = base
+			blockHead = false
+		case first.Type == token.ELSE:
+			// A guard's else continues the step above: align with its content.
+			indents[i] = base + len("|> ")
+			if !blockHead {
+				indents[i] += indentUnit
+			}
+		case blockHead:
+			// Group directives sit level with the group's routes, and
+			// pipeline steps level with each other.
+			indents[i] = base
+		default:
+			indents[i] = base + indentUnit
+		}
+
+		// The '{' ending a group or pipeline line opens a block, not a bracket.
+		opensBlock := len(stack) == 0 && (first.Type == token.GROUP || first.Type == token.PIPELINE) &&
+			lineToks[len(lineToks)-1].Type == token.LBRACE
+		if opensBlock {
+			lineToks = lineToks[:len(lineToks)-1]
+		}
+
+		anchor := indents[i]
+		if first.Type == token.PIPE {
+			anchor += len("|> ")
+		}
+		if !continued[i] {
+			lineCont = anchor + indentUnit
+		}
+		for _, tok := range lineToks {
+			switch {
+			case isOpener(tok.Type):
+				stack = append(stack, anchor+indentUnit)
+			case isCloser(tok.Type) && len(stack) > 0:
+				stack = stack[:len(stack)-1]
+			}
+		}
+		if opensBlock {
+			base += indentUnit
+			blockHead = true
+		}
+	}
+
+	// Comment-only lines take the indentation of the next code line.
+	next := 0
+	for i := len(lines) - 1; i >= 0; i-- {
+		if indents[i] >= 0 {
+			next = indents[i]
+			continue
+		}
+		indents[i] = next
+	}
+
+	var out []string
+	blank := false
+	for i, line := range lines {
+		if verbatim[i] {
+			out = append(out, line)
+			blank = false
+			continue
+		}
+		text := strings.TrimSpace(line)
+		if text == "" {
+			blank = len(out) > 0
+			continue
+		}
+		if blank {
+			out = append(out, "")
+			blank = false
+		}
+		out = append(out, strings.Repeat(" ", indents[i])+text)
+	}
+
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n") + "\n"
+}
+
+// tokensByLine groups the significant tokens of src by 0-based line index.
+// A line is continued when its first token follows a token of an earlier
+// line with no newline token between them: inside brackets, or after a
+// trailing '\'.
+func tokensByLine(src string, n int) (byLine [][]token.Token, continued []bool) {
+	byLine = make([][]token.Token, n)
+	continued = make([]bool, n)
+	l := lexer.New(src, "")
+	lastLine := 0 // line of the previous token, or 0 after a newline
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			break
+		}
+		if tok.Type == token.NEWLINE {
+			lastLine = 0
+			continue
+		}
+		idx := tok.Pos.Line - 1
+		if idx >= 0 && idx < n {
+			if len(byLine[idx]) == 0 && lastLine > 0 && lastLine < tok.Pos.Line {
+				continued[idx] = true
+			}
+			byLine[idx] = append(byLine[idx], tok)
+		}
+		lastLine = tok.Pos.Line
+	}
+	return byLine, continued
+}
+
+// textBlockLines marks the lines after the first of each multi-line string.
+func textBlockLines(toks [][]token.Token, n int) []bool {
+	verbatim := make([]bool, n)
+	for _, lineToks := range toks {
+		for _, tok := range lineToks {
+			if tok.Type != token.STRING {
+				continue
+			}
+			start := tok.Pos.Line - 1
+			for i := 1; i <= strings.Count(tok.Literal, "\n") && start+i < n; i++ {
+				verbatim[start+i] = true
+			}
+		}
+	}
+	return verbatim
+}
+
+func isTopLevel(t token.Type) bool {
+	switch t {
+	case token.META, token.IMPORT, token.TYPE, token.ENUM, token.DEFAULTS, token.GROUP, token.PIPELINE, token.AT:
+		return true
+	}
+	return token.IsHTTPMethod(t)
+}
+
+// isVersionPragma reports whether a line is "version 0.2". The name is an
+// identifier, so it cannot be told from a directive by its type alone.
+func isVersionPragma(toks []token.Token) bool {
+	return len(toks) > 1 && toks[0].Type == token.IDENT && toks[0].Literal == "version" && (toks[1].Type == token.INT || toks[1].Type == token.FLOAT)
+}
+
+func isOpener(t token.Type) bool {
+	return t == token.LPAREN || t == token.LBRACE || t == token.LBRACKET
+}
+
+func isCloser(t token.Type) bool {
+	return t == token.RPAREN || t == token.RBRACE || t == token.RBRACKET
+}
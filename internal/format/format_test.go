@@ -0,0 +1,247 @@
+package format
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSourceReindentsRoute(t *testing.T) {
+	input := `GET /users/{id}
+cache(max-age: 60)
+      |> input(id: path.id)
+|> validate(
+id: int & min(1)
+)   ~> 400 { error: "invalid id" }
+    |> respond 200 { id: id }   `
+
+	expected := `GET /users/{id}
+  cache(max-age: 60)
+  |> input(id: path.id)
+  |> validate(
+       id: int & min(1)
+     )   ~> 400 { error: "invalid id" }
+  |> respond 200 { id: id }
+`
+
+	if got := Source(input); got != expected {
+		t.Fatalf("unexpected output\n--- expected ---\n%s\n--- actual ---\n%s", expected, got)
+	}
+}
+
+func TestSourceTypesMatchAndComments(t *testing.T) {
+	input := `
+
+
+type User {
+id: int
+    name: string
+}
+
+
+
+# accounts by role
+GET /accounts/{id}
+# pick the right table
+  |> match role {
+"user": fetch(User, id)
+  _: ~> 400 { error: "unknown" }
+} as account
+  |> respond 200 { id: account.id }
+
+
+`
+
+	expected := `type User {
+  id: int
+  name: string
+}
+
+# accounts by role
+GET /accounts/{id}
+  # pick the right table
+  |> match role {
+       "user": fetch(User, id)
+       _: ~> 400 { error: "unknown" }
+     } as account
+  |> respond 200 { id: account.id }
+`
+
+	if got := Source(input); got != expected {
+		t.Fatalf("unexpected output\n--- expected ---\n%s\n--- actual ---\n%s", expected, got)
+	}
+}
+
+func TestSourceGuardElseContinuation(t *testing.T) {
+	input := `GET /users
+  |> guard existing
+else respond 200 { status: "missing" }
+  |> respond 200 { ok: "true" }`
+
+	expected := `GET /users
+  |> guard existing
+     else respond 200 { status: "missing" }
+  |> respond 200 { ok: "true" }
+`
+
+	if got := Source(input); got != expected {
+		t.Fatalf("unexpected output\n--- expected ---\n%s\n--- actual ---\n%s", expected, got)
+	}
+}
+
+func TestSourceLineContinuation(t *testing.T) {
+	input := `GET /users/{id}
+  |> find(User, id) \
+  as user \
+        ~> 404 { error: "not found" }
+  |> respond 200 { id: user.id }`
+
+	expected := `GET /users/{id}
+  |> find(User, id) \
+       as user \
+       ~> 404 { error: "not found" }
+  |> respond 200 { id: user.id }
+`
+
+	if got := Source(input); got != expected {
+		t.Fatalf("unexpected output\n--- expected ---\n%s\n--- actual ---\n%s", expected, got)
+	}
+}
+
+func TestSourceGroups(t *testing.T) {
+	input := `group /orgs/{org} {
+auth(bearer) as user
+GET /members
+|> respond 200 { org: path.org }
+group /admin {
+    DELETE /members/{id}
+  |> validate(
+id: int
+)
+        |> respond 204
+}
+}`
+
+	expected := `group /orgs/{org} {
+  auth(bearer) as user
+  GET /members
+    |> respond 200 { org: path.org }
+  group /admin {
+    DELETE /members/{id}
+      |> validate(
+           id: int
+         )
+      |> respond 204
+  }
+}
+`
+
+	if got := Source(input); got != expected {
+		t.Fatalf("unexpected output\n--- expected ---\n%s\n--- actual ---\n%s", expected, got)
+	}
+}
+
+func TestSourceAnnotations(t *testing.T) {
+	input := `  @doc "Lists users"
+GET /users
+|> respond 200
+group /v1 {
+    @deprecated "use /v2/users"
+  GET /users
+  |> respond 200
+}`
+
+	expected := `@doc "Lists users"
+GET /users
+  |> respond 200
+group /v1 {
+  @deprecated "use /v2/users"
+  GET /users
+    |> respond 200
+}
+`
+
+	if got := Source(input); got != expected {
+		t.Fatalf("unexpected output\n--- expected ---\n%s\n--- actual ---\n%s", expected, got)
+	}
+}
+
+func TestSourceVersionPragma(t *testing.T) {
+	input := `  version 0.2
+GET /users
+    |> respond 200`
+
+	expected := `version 0.2
+GET /users
+  |> respond 200
+`
+
+	if got := Source(input); got != expected {
+		t.Fatalf("unexpected output\n--- expected ---\n%s\n--- actual ---\n%s", expected, got)
+	}
+}
+
+func TestSourcePipelineDecl(t *testing.T) {
+	input := `pipeline userInput {
+      |> validate(
+name: string
+)
+|> guard name
+else respond 400
+}`
+
+	expected := `pipeline userInput {
+  |> validate(
+       name: string
+     )
+  |> guard name
+     else respond 400
+}
+`
+
+	if got := Source(input); got != expected {
+		t.Fatalf("unexpected output\n--- expected ---\n%s\n--- actual ---\n%s", expected, got)
+	}
+}
+
+func TestSourceTextBlockVerbatim(t *testing.T) {
+	input := `GET /
+    |> respond 200 html """<ul>
+    <li>one</li>
+
+      <li>two</li>
+</ul>"""
+`
+
+	expected := `GET /
+  |> respond 200 html """<ul>
+    <li>one</li>
+
+      <li>two</li>
+</ul>"""
+`
+
+	if got := Source(input); got != expected {
+		t.Fatalf("unexpected output\n--- expected ---\n%s\n--- actual ---\n%s", expected, got)
+	}
+}
+
+func TestSourceCanonicalFilesUnchanged(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "testdata", "*.rever"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, filepath.Join("..", "..", "examples", "blog.rever"))
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Source(string(data)); got != string(data) {
+				t.Fatalf("expected %s to be canonical, got\n%s", file, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package gen
+
+import (
+	"sort"
+
+	"github.com/polidog/reverhttp/internal/ir"
+)
+
+// PathMethods maps each route path to the methods defined for it, sorted,
+// so that a runtime can answer other methods with 405 and an Allow header.
+// Generated OPTIONS routes count like any other route.
+func PathMethods(routes []*ir.Route) map[string][]string {
+	if len(routes) == 0 {
+		return nil
+	}
+	methods := make(map[string][]string)
+	seen := make(map[[2]string]bool)
+	for _, r := range routes {
+		path, method := r.RouteInfo.Path, r.RouteInfo.Method
+		if !seen[[2]string{path, method}] {
+			seen[[2]string{path, method}] = true
+			methods[path] = append(methods[path], method)
+		}
+	}
+	for _, list := range methods {
+		sort.Strings(list)
+	}
+	return methods
+}
@@ -0,0 +1,136 @@
+package gen
+
+import (
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/ir"
+)
+
+// routeDefaults returns the defaults that apply to routes of method: the
+// method-scoped defaults layered over the file-wide ones, directive by
+// directive. It returns nil when neither exists.
+func routeDefaults(root *ir.Root, method string) *ir.Defaults {
+	scoped := root.MethodDefaults[method]
+	if scoped == nil {
+		return root.Defaults
+	}
+	if root.Defaults == nil {
+		return scoped
+	}
+	d := *root.Defaults
+	if scoped.Cache != nil {
+		d.Cache = scoped.Cache
+	}
+	if scoped.CORS != nil {
+		d.CORS = scoped.CORS
+	}
+	if scoped.Auth != nil {
+		d.Auth = scoped.Auth
+	}
+	if scoped.Retry != nil {
+		d.Retry = scoped.Retry
+	}
+	if scoped.Pagination != nil {
+		d.Pagination = scoped.Pagination
+	}
+	if scoped.Compress != nil {
+		d.Compress = scoped.Compress
+	}
+	return &d
+}
+
+// inlineDefaults fills in each directive of d that route does not declare
+// itself. Any declaration counts, including cors(none) and auth(none), so an
+// explicit opt-out is never overwritten; auth(none) in d is not copied. Values are deep-copied so routes do
+// not share state with the defaults or with each other.
+func inlineDefaults(r *ir.Route, route *ast.Route, d *ir.Defaults) {
+	declared := make(map[string]bool)
+	for _, dir := range route.EffectiveDirectives() {
+		declared[dir.Name] = true
+	}
+
+	if !declared["cache"] && d.Cache != nil {
+		r.Cache = copyCache(d.Cache)
+	}
+	if !declared["cors"] && d.CORS != nil {
+		r.CORS = copyCORS(d.CORS)
+	}
+	if !declared["auth"] && d.Auth != nil && d.Auth.Method != "none" {
+		r.Auth = copyAuth(d.Auth)
+	}
+	if !declared["retry"] && d.Retry != nil {
+		retry := *d.Retry
+		r.Retry = &retry
+	}
+	if !declared["paginate"] && d.Pagination != nil {
+		pagination := *d.Pagination
+		r.Pagination = &pagination
+	}
+	if !declared["compress"] && d.Compress != nil {
+		r.Compress = &ir.Compress{Encodings: copyStrings(d.Compress.Encodings), MinBytes: d.Compress.MinBytes}
+	}
+	// A cache, auth or compression taken from the defaults changes what
+	// responses vary on.
+	if r.Cache != nil && !declaresVary(route) && (declared["cache"] || d.Cache.Vary == nil) {
+		inferVary(r)
+	}
+}
+
+func copyCache(c *ir.Cache) *ir.Cache {
+	out := *c
+	out.MaxAge = copyInt(c.MaxAge)
+	out.SMaxAge = copyInt(c.SMaxAge)
+	out.NoCache = copyBool(c.NoCache)
+	out.NoStore = copyBool(c.NoStore)
+	out.Vary = copyStrings(c.Vary)
+	if fn, ok := c.ETag.(*ir.ETagFn); ok && fn != nil {
+		etag := *fn
+		out.ETag = &etag
+	}
+	if fields, ok := c.ETag.(*ir.ETagFields); ok && fields != nil {
+		out.ETag = &ir.ETagFields{From: copyStrings(fields.From)}
+	}
+	return &out
+}
+
+func copyCORS(c *ir.CORS) *ir.CORS {
+	out := *c
+	out.Origins = append([]interface{}(nil), c.Origins...)
+	out.Methods = copyStrings(c.Methods)
+	out.Headers = copyStrings(c.Headers)
+	out.ExposeHeaders = copyStrings(c.ExposeHeaders)
+	out.MaxAge = copyInt(c.MaxAge)
+	out.Credentials = copyBool(c.Credentials)
+	return &out
+}
+
+func copyAuth(a *ir.Auth) *ir.Auth {
+	out := *a
+	out.Roles = copyStrings(a.Roles)
+	out.Permissions = copyStrings(a.Permissions)
+	out.Scopes = copyStrings(a.Scopes)
+	out.Optional = copyBool(a.Optional)
+	return &out
+}
+
+func copyInt(p *int) *int {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func copyBool(p *bool) *bool {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string(nil), s...)
+}
@@ -1,11 +1,16 @@
 package gen
 
 import (
+	"fmt"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/polidog/reverhttp/internal/ast"
 	"github.com/polidog/reverhttp/internal/ir"
+	"github.com/polidog/reverhttp/internal/token"
 )
 
 // Known type names for cast vs fn distinction in transforms.
@@ -17,10 +22,101 @@ var typeNames = map[string]bool{
 	"datetime": true,
 }
 
-// Generate converts an AST File to the IR Root.
+// Error is a problem found while generating IR, such as a regex that does
+// not compile. The IR is still produced with the value as written.
+type Error struct {
+	Pos     token.Position
+	Message string
+	Warning bool // the IR is still valid, e.g. for an unknown format
+}
+
+// Error formats the error like parser errors: "file:line:col: message",
+// with "warning: " before the message of a warning.
+func (e Error) Error() string {
+	if e.Warning {
+		return fmt.Sprintf("%s:%d:%d: warning: %s", e.Pos.File, e.Pos.Line, e.Pos.Column, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Pos.File, e.Pos.Line, e.Pos.Column, e.Message)
+}
+
+// Options control optional IR transformations.
+type Options struct {
+	// InlineDefaults copies the defaults' cache, cors, auth, retry,
+	// pagination and compression into every route that does not declare its
+	// own, so consumers need not merge them. cors(none) and auth(none) on a route
+	// suppress inheritance.
+	InlineDefaults bool
+
+	// GenerateCORSPreflight adds an OPTIONS route answering the CORS
+	// preflight for every path with CORS enabled, unless the file already
+	// declares an OPTIONS route for that path.
+	GenerateCORSPreflight bool
+
+	// DeriveHEAD adds a HEAD route for every GET route: the same pipeline
+	// with a body-less output. Paths with an author-written HEAD route keep
+	// it.
+	DeriveHEAD bool
+}
+
+type generator struct {
+	opts      Options
+	errors    []Error
+	pipelines map[string]*ast.PipelineDecl
+	types     map[string]*ast.TypeDecl // for validate(Type)
+	enums     map[string]*ast.EnumDecl
+	base      string // meta base path, without a trailing slash
+}
+
+func (g *generator) addError(pos token.Position, msg string) {
+	g.errors = append(g.errors, Error{Pos: pos, Message: msg})
+}
+
+func (g *generator) addWarning(pos token.Position, msg string) {
+	g.errors = append(g.errors, Error{Pos: pos, Message: msg, Warning: true})
+}
+
+// checkDuplicate records name in seen and reports whether it was already
+// there, adding an error that points back at the first occurrence. The IR
+// keys these fields by name, so a duplicate would silently replace the first.
+func (g *generator) checkDuplicate(seen map[string]token.Position, what, name string, pos token.Position) bool {
+	if first, ok := seen[name]; ok {
+		g.addError(pos, fmt.Sprintf("duplicate %s %q (first declared at line %d)", what, name, first.Line))
+		return true
+	}
+	seen[name] = pos
+	return false
+}
+
+// Generate converts an AST File to the IR Root, ignoring generation errors.
 func Generate(file *ast.File) *ir.Root {
+	root, _ := GenerateWithErrors(file)
+	return root
+}
+
+// GenerateWithErrors converts an AST File to the IR Root and also returns the
+// errors found along the way.
+func GenerateWithErrors(file *ast.File) (*ir.Root, []Error) {
+	return GenerateWithOptions(file, Options{})
+}
+
+// GenerateWithOptions is GenerateWithErrors with optional transformations.
+func GenerateWithOptions(file *ast.File, opts Options) (*ir.Root, []Error) {
+	g := &generator{opts: opts}
+	root := g.generate(file)
+	return root, g.errors
+}
+
+func (g *generator) generate(file *ast.File) *ir.Root {
 	root := &ir.Root{
-		Version: "0.1",
+		Version: ir.DefaultVersion,
+	}
+	if file.Version != "" {
+		root.Version = file.Version
+	}
+
+	// Meta
+	if file.Meta != nil {
+		root.Meta = g.genMeta(file.Meta)
 	}
 
 	// Imports
@@ -40,96 +136,269 @@ func Generate(file *ast.File) *ir.Root {
 	// Types
 	if len(file.Types) > 0 {
 		root.Types = make(map[string]ir.TypeFields)
+		g.types = make(map[string]*ast.TypeDecl)
 		for _, td := range file.Types {
+			g.types[td.Name] = td
 			fields := make(ir.TypeFields)
 			for _, f := range td.Fields {
-				fields[f.Name] = f.TypeName
+				if f.Default == nil {
+					fields[f.Name] = f.TypeName
+					continue
+				}
+				fields[f.Name] = &ir.TypeField{Type: f.TypeName, Default: literalValue(f.Default)}
 			}
 			root.Types[td.Name] = fields
 		}
 	}
 
+	// Enums
+	if len(file.Enums) > 0 {
+		root.Enums = make(map[string][]string)
+		g.enums = make(map[string]*ast.EnumDecl)
+		for _, ed := range file.Enums {
+			g.enums[ed.Name] = ed
+			root.Enums[ed.Name] = append([]string(nil), ed.Values...)
+		}
+	}
+
+	// Pipelines are not emitted; routes inline them where they are used.
+	g.pipelines = make(map[string]*ast.PipelineDecl)
+	seenPipelines := make(map[string]token.Position)
+	for _, decl := range file.Pipelines {
+		if !g.checkDuplicate(seenPipelines, "pipeline", decl.Name, decl.NamePos) {
+			g.pipelines[decl.Name] = decl
+		}
+	}
+
 	// Defaults
 	if file.Defaults != nil {
-		root.Defaults = genDefaults(file.Defaults)
+		root.Defaults = g.genDefaults(file.Defaults)
+	}
+	if len(file.MethodDefaults) > 0 {
+		root.MethodDefaults = make(map[string]*ir.Defaults)
+		seen := make(map[string]token.Position)
+		for _, block := range file.MethodDefaults {
+			d := g.genDefaults(block)
+			for i, method := range block.Methods {
+				if !g.checkDuplicate(seen, "defaults for", method, block.MethodPos[i]) {
+					root.MethodDefaults[method] = d
+				}
+			}
+		}
 	}
 
 	// Routes
 	for _, route := range file.Routes {
-		root.Routes = append(root.Routes, genRoute(route))
+		r := g.genRoute(route)
+		if d := routeDefaults(root, route.Method); g.opts.InlineDefaults && d != nil {
+			inlineDefaults(r, route, d)
+		}
+		root.Routes = append(root.Routes, r)
+	}
+	if g.opts.GenerateCORSPreflight {
+		root.Routes = append(root.Routes, preflightRoutes(file.Routes, root)...)
+	}
+	if g.opts.DeriveHEAD {
+		root.Routes = append(root.Routes, headRoutes(root.Routes)...)
 	}
+	root.PathMethods = PathMethods(root.Routes)
 
 	return root
 }
 
-func genDefaults(block *ast.DefaultsBlock) *ir.Defaults {
+// genMeta maps the meta block to IR; a base path is kept for routePath.
+func (g *generator) genMeta(block *ast.MetaBlock) map[string]interface{} {
+	meta := make(map[string]interface{}, len(block.Fields))
+	seen := make(map[string]token.Position)
+	for _, f := range block.Fields {
+		if g.checkDuplicate(seen, "meta field", f.Key, f.Pos) {
+			continue
+		}
+		v, ok := metaValue(f)
+		if !ok {
+			g.addError(f.Pos, fmt.Sprintf("meta %s must be a string, integer or boolean literal", f.Key))
+			continue
+		}
+		if f.Key == "base" {
+			base, _ := v.(string)
+			switch {
+			case !strings.HasPrefix(base, "/"):
+				g.addError(f.Pos, `meta base must be a path starting with "/"`)
+				continue
+			case strings.ContainsAny(base, "{}*"):
+				g.addError(f.Pos, "meta base must not contain path parameters or wildcards")
+				continue
+			}
+			g.base = strings.TrimSuffix(base, "/")
+		}
+		meta[f.Key] = v
+	}
+	return meta
+}
+
+// metaValue returns the literal value of a meta field.
+func metaValue(f *ast.BodyField) (interface{}, bool) {
+	s, ok := f.Value.(string)
+	switch {
+	case !ok:
+		return nil, false
+	case f.IsString:
+		return s, true
+	case s == "true" || s == "false":
+		return s == "true", true
+	}
+	if v, err := strconv.Atoi(s); err == nil {
+		return v, true
+	}
+	return nil, false
+}
+
+// routePath prefixes path with the meta base path.
+func (g *generator) routePath(path string) string {
+	switch {
+	case g.base == "":
+		return path
+	case path == "/":
+		return g.base
+	}
+	return g.base + path
+}
+
+// literalValue converts a literal expression (a type field default or a
+// transform argument) to its JSON value.
+func literalValue(e *ast.Expr) interface{} {
+	switch e.Kind {
+	case ast.ExprInt:
+		if v, err := strconv.Atoi(e.IntVal); err == nil {
+			return v
+		}
+		return e.IntVal
+	case ast.ExprFloat:
+		if v, err := strconv.ParseFloat(e.StrVal, 64); err == nil {
+			return v
+		}
+	case ast.ExprBool:
+		return e.StrVal == "true"
+	case ast.ExprIdent:
+		if e.StrVal == "true" || e.StrVal == "false" {
+			return e.StrVal == "true"
+		}
+	case ast.ExprList:
+		return e.ListVal
+	}
+	return e.StrVal
+}
+
+func (g *generator) genDefaults(block *ast.DefaultsBlock) *ir.Defaults {
 	d := &ir.Defaults{}
 	for _, dir := range block.Directives {
 		switch dir.Name {
 		case "cache":
-			d.Cache = genCache(dir)
+			d.Cache = g.genCache(dir)
 		case "cors":
-			d.CORS = genCORS(dir)
+			d.CORS = g.genCORS(dir)
 		case "auth":
-			d.Auth = genAuth(dir)
+			if isNoneDirective(dir) {
+				// Kept explicit so that method-scoped defaults can turn
+				// off the file-wide auth.
+				d.Auth = &ir.Auth{Method: "none"}
+			} else {
+				d.Auth = g.genAuth(dir)
+			}
+		case "retry":
+			d.Retry = genRetry(dir)
+		case "paginate":
+			d.Pagination = genPaginate(dir)
+		case "compress":
+			d.Compress = g.genCompress(dir)
 		}
 	}
 	return d
 }
 
-func genRoute(route *ast.Route) *ir.Route {
+func (g *generator) genRoute(route *ast.Route) *ir.Route {
 	r := &ir.Route{
 		RouteInfo: &ir.RouteInfo{
-			Method: route.Method,
-			Path:   route.Path,
+			Method:      route.Method,
+			Path:        g.routePath(route.Path),
+			Description: route.Doc,
+			CatchAll:    ast.IsCatchAll(route.Path),
 		},
 	}
+	for _, a := range route.Annotations {
+		if r.RouteInfo.Annotations == nil {
+			r.RouteInfo.Annotations = make(map[string]string)
+		}
+		r.RouteInfo.Annotations[a.Name] = a.Value
+	}
 
-	// Directives
-	for _, dir := range route.Directives {
+	// Directives, including those inherited from enclosing groups
+	for _, dir := range route.EffectiveDirectives() {
 		switch dir.Name {
 		case "cache":
-			r.Cache = genCache(dir)
+			r.Cache = g.genCache(dir)
 		case "cors":
 			if isNoneDirective(dir) {
-				// cors(none) → "cors": null — use a special marker
-				r.CORS = nil // We'll handle this in JSON marshaling
-				// Actually, we need a way to distinguish "no cors" from "cors not set"
-				// We'll use a sentinel: set CORS to explicit null
-				r.CORS = (*ir.CORS)(nil) // explicit nil pointer
+				// cors(none) → "cors": null
+				r.CORSDisabled = true
 			} else {
-				r.CORS = genCORS(dir)
+				r.CORS = g.genCORS(dir)
 			}
 		case "auth":
 			if isNoneDirective(dir) {
 				// auth(none) → omit
 			} else {
-				r.Auth = genAuth(dir)
+				r.Auth = g.genAuth(dir)
 			}
+		case "retry":
+			r.Retry = genRetry(dir)
+		case "paginate":
+			r.Pagination = genPaginate(dir)
+		case "accepts":
+			r.Accepts = g.genAccepts(dir)
+		case "compress":
+			r.Compress = g.genCompress(dir)
 		}
 	}
+	if r.Cache != nil && !declaresVary(route) {
+		inferVary(r)
+	}
 
 	// Pipeline steps
 	var processSteps []interface{}
+	responds := false // a match whose arms all respond ends the route
 
-	for _, step := range route.Steps {
+	for _, step := range g.expandUses(route.Steps, make(map[string]bool)) {
 		switch step.Kind {
 		case ast.StepInput:
-			r.Input = genInput(step.Input)
+			r.Input = g.genInput(step.Input)
 
 		case ast.StepValidate:
-			r.Validate = genValidate(step)
+			r.Validate = g.genValidate(step)
 
 		case ast.StepTransform:
-			r.TransformIn = genTransform(step.Transform)
+			r.TransformIn = g.genTransform(step.Transform)
 
 		case ast.StepGuard:
 			gs := genGuard(step)
 			processSteps = append(processSteps, gs)
 
 		case ast.StepMatch:
-			ms := genMatch(step)
+			ms := g.genMatch(step)
 			processSteps = append(processSteps, ms)
+			responds = responds || step.Match.Responds()
+
+		case ast.StepMap:
+			processSteps = append(processSteps, genMap(step))
+
+		case ast.StepEnrich:
+			processSteps = append(processSteps, &ir.EnrichStep{
+				Bind:   step.Bind,
+				Enrich: append([]string(nil), step.Enrich.Sources...),
+			})
+
+		case ast.StepForward:
+			processSteps = append(processSteps, genForward(step))
 
 		case ast.StepPkgCall:
 			ps := genPkgCall(step)
@@ -143,55 +412,125 @@ func genRoute(route *ast.Route) *ir.Route {
 	if len(processSteps) > 0 {
 		r.Process = &ir.Process{Steps: processSteps}
 	}
+	if r.Output == nil && !responds {
+		g.addError(route.Pos, fmt.Sprintf("route %s %s has no response", route.Method, route.Path))
+	}
 
 	return r
 }
 
-func genInput(input *ast.InputStep) map[string]*ir.Input {
+// expandUses replaces each use step with the steps of the pipeline it names,
+// recursively. expanding holds the pipelines being expanded, to catch cycles.
+func (g *generator) expandUses(steps []*ast.PipelineStep, expanding map[string]bool) []*ast.PipelineStep {
+	var out []*ast.PipelineStep
+	for _, step := range steps {
+		if step.Kind != ast.StepUse {
+			out = append(out, step)
+			continue
+		}
+		name := step.Use.Name
+		decl := g.pipelines[name]
+		switch {
+		case decl == nil:
+			g.addError(step.Use.Pos, fmt.Sprintf("unknown pipeline %q", name))
+		case expanding[name]:
+			g.addError(step.Use.Pos, fmt.Sprintf("pipeline %q uses itself", name))
+		default:
+			expanding[name] = true
+			out = append(out, g.expandUses(decl.Steps, expanding)...)
+			delete(expanding, name)
+		}
+	}
+	return out
+}
+
+func (g *generator) genInput(input *ast.InputStep) *ir.OrderedMap[*ir.Input] {
 	if input == nil {
 		return nil
 	}
-	result := make(map[string]*ir.Input)
+	result := ir.NewOrderedMap[*ir.Input]()
+	seen := make(map[string]token.Position)
 	for _, f := range input.Fields {
-		result[f.Name] = &ir.Input{From: f.From}
+		if g.checkDuplicate(seen, "input field", f.Name, f.NamePos) {
+			continue
+		}
+		in := &ir.Input{From: f.From, Wildcard: f.Wildcard}
+		if f.Default != nil {
+			in.Default = literalValue(f.Default)
+		}
+		result.Set(f.Name, in)
+	}
+	if result.Len() == 0 {
+		return nil
 	}
 	return result
 }
 
-func genValidate(step *ast.PipelineStep) *ir.Validate {
+func (g *generator) genValidate(step *ast.PipelineStep) *ir.Validate {
 	if step.Validate == nil {
 		return nil
 	}
 
 	v := &ir.Validate{
-		Rules: make(map[string]*ir.ValidateRule),
+		Rules: ir.NewOrderedMap[*ir.ValidateRule](),
+	}
+	if step.Validate.Type != "" {
+		g.genTypeRules(v, step.Validate)
 	}
 
+	seen := make(map[string]token.Position)
 	for _, rule := range step.Validate.Rules {
-		vr := &ir.ValidateRule{}
+		if g.checkDuplicate(seen, "validate field", rule.Field, rule.Pos) {
+			continue
+		}
+		vr := &ir.ValidateRule{Optional: rule.Optional}
+		// The bounds of a float rule are floats wherever its type is written.
+		isFloat := slices.ContainsFunc(rule.Constraints, func(c *ast.Constraint) bool { return c.Name == "float" })
+		isString := slices.ContainsFunc(rule.Constraints, func(c *ast.Constraint) bool { return c.Name == "string" })
 		for _, c := range rule.Constraints {
+			if isString && (c.Name == "min" || c.Name == "max") {
+				g.addWarning(c.Pos, fmt.Sprintf("%s on string field %q bounds a number, not the length; use %sLength", c.Name, rule.Field, c.Name))
+			}
 			switch c.Name {
 			case "int", "string", "bool", "float", "datetime":
 				vr.Type = c.Name
 			case "min":
-				if len(c.Args) > 0 {
-					if val, err := strconv.Atoi(c.Args[0].IntVal); err == nil {
-						vr.Min = intPtr(val)
-					}
+				if isFloat {
+					vr.MinF = g.genFloatArg(c)
+				} else {
+					vr.Min = g.genIntArg(c)
 				}
 			case "max":
-				if len(c.Args) > 0 {
-					if val, err := strconv.Atoi(c.Args[0].IntVal); err == nil {
-						vr.Max = intPtr(val)
+				if isFloat {
+					vr.MaxF = g.genFloatArg(c)
+				} else {
+					vr.Max = g.genIntArg(c)
+				}
+			case "minLength":
+				vr.MinLength = g.genLength(c)
+			case "maxLength":
+				vr.MaxLength = g.genLength(c)
+			case "length":
+				if n := g.genLength(c); n != nil {
+					vr.MinLength, vr.MaxLength = n, intPtr(*n)
+				}
+			case "between":
+				if isFloat {
+					if lo, hi, ok := g.genFloatBetween(c); ok {
+						vr.MinF, vr.MaxF = &lo, &hi
 					}
+				} else if lo, hi, ok := g.genBetween(c); ok {
+					vr.Min, vr.Max = intPtr(lo), intPtr(hi)
 				}
 			case "format":
-				if len(c.Args) > 0 {
-					vr.Format = c.Args[0].StrVal
-				}
+				vr.Format = g.genFormat(c)
+			case "oneOf":
+				vr.Enum = g.genEnum(c)
+			case "pattern":
+				vr.Pattern = g.genConstraintPattern(c)
 			}
 		}
-		v.Rules[rule.Field] = vr
+		v.Rules.Set(rule.Field, vr)
 	}
 
 	if step.ErrorFlow != nil {
@@ -201,23 +540,226 @@ func genValidate(step *ast.PipelineStep) *ir.Validate {
 	return v
 }
 
-func genTransform(t *ast.TransformStep) map[string]*ir.Transform {
+// genTypeRules adds a rule for each field of the type named by
+// validate(Type): the field's type, or the values of an enum as strings. A
+// field with a default may be absent.
+func (g *generator) genTypeRules(v *ir.Validate, step *ast.ValidateStep) {
+	td := g.types[step.Type]
+	if td == nil {
+		g.addError(step.TypePos, fmt.Sprintf("unknown type %q in validate", step.Type))
+		return
+	}
+	for _, f := range td.Fields {
+		vr := &ir.ValidateRule{Optional: f.Default != nil, Type: f.TypeName}
+		if ed := g.enums[f.TypeName]; ed != nil {
+			vr.Type = "string"
+			vr.Enum = append([]string(nil), ed.Values...)
+		}
+		v.Rules.Set(f.Name, vr)
+	}
+}
+
+// genIntArg returns the bound of min or max, which takes a single integer.
+func (g *generator) genIntArg(c *ast.Constraint) *int {
+	if len(c.Args) != 1 {
+		g.addError(c.Pos, fmt.Sprintf("%s() expects one argument, got %d", c.Name, len(c.Args)))
+		return nil
+	}
+	if c.Args[0].Kind != ast.ExprInt {
+		g.addError(c.Pos, fmt.Sprintf("%s() expects an integer, got %s", c.Name, c.Args[0].Kind))
+		return nil
+	}
+	n, err := strconv.Atoi(c.Args[0].IntVal)
+	if err != nil {
+		g.addError(c.Pos, fmt.Sprintf("%s() bound %s is out of range", c.Name, c.Args[0].IntVal))
+		return nil
+	}
+	return intPtr(n)
+}
+
+// floatValue returns the value of an integer or float literal.
+func floatValue(e ast.Expr) (float64, bool) {
+	var lit string
+	switch e.Kind {
+	case ast.ExprInt:
+		lit = e.IntVal
+	case ast.ExprFloat:
+		lit = e.StrVal
+	default:
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(lit, 64)
+	return v, err == nil
+}
+
+// genFloatArg returns the bound of min or max on a float rule, which takes
+// a single number; an integer such as 0 is accepted too.
+func (g *generator) genFloatArg(c *ast.Constraint) *float64 {
+	if len(c.Args) != 1 {
+		g.addError(c.Pos, fmt.Sprintf("%s() expects one argument, got %d", c.Name, len(c.Args)))
+		return nil
+	}
+	v, ok := floatValue(c.Args[0])
+	if !ok {
+		g.addError(c.Pos, fmt.Sprintf("%s() expects a number, got %s", c.Name, c.Args[0].Kind))
+		return nil
+	}
+	return &v
+}
+
+// knownFormats are the formats runtimes are expected to check. Others are
+// passed through with a warning, for runtimes that define their own.
+var knownFormats = map[string]bool{
+	"email": true, "uuid": true, "url": true, "date": true,
+	"datetime": true, "ipv4": true, "ipv6": true, "hostname": true,
+}
+
+// genFormat returns the name of a format constraint, such as email, which
+// is written as a bare identifier.
+func (g *generator) genFormat(c *ast.Constraint) string {
+	if len(c.Args) != 1 {
+		g.addError(c.Pos, fmt.Sprintf("format() expects one argument, got %d", len(c.Args)))
+		return ""
+	}
+	if c.Args[0].Kind != ast.ExprIdent {
+		g.addError(c.Pos, fmt.Sprintf("format() expects an identifier, got %s", c.Args[0].Kind))
+		return ""
+	}
+	if name := c.Args[0].StrVal; !knownFormats[name] {
+		g.addWarning(c.Pos, fmt.Sprintf("unknown format %q: expected one of email, uuid, url, date, datetime, ipv4, ipv6 or hostname", name))
+	}
+	return c.Args[0].StrVal
+}
+
+// genLength returns the argument of a string length constraint, which must
+// be a single integer.
+func (g *generator) genLength(c *ast.Constraint) *int {
+	if len(c.Args) == 1 && c.Args[0].Kind == ast.ExprInt {
+		if n, err := strconv.Atoi(c.Args[0].IntVal); err == nil {
+			return intPtr(n)
+		}
+	}
+	g.addError(c.Pos, fmt.Sprintf("%s requires a single integer argument", c.Name))
+	return nil
+}
+
+// genBetween returns the bounds of between(min, max), which takes exactly two
+// integers with min <= max.
+func (g *generator) genBetween(c *ast.Constraint) (int, int, bool) {
+	if len(c.Args) != 2 || c.Args[0].Kind != ast.ExprInt || c.Args[1].Kind != ast.ExprInt {
+		g.addError(c.Pos, "between requires two integer arguments: between(min, max)")
+		return 0, 0, false
+	}
+	lo, err1 := strconv.Atoi(c.Args[0].IntVal)
+	hi, err2 := strconv.Atoi(c.Args[1].IntVal)
+	if err1 != nil || err2 != nil {
+		g.addError(c.Pos, "between requires two integer arguments: between(min, max)")
+		return 0, 0, false
+	}
+	if lo > hi {
+		g.addError(c.Pos, fmt.Sprintf("between min %d is greater than max %d", lo, hi))
+		return 0, 0, false
+	}
+	return lo, hi, true
+}
+
+// genFloatBetween returns the bounds of between(min, max) on a float rule,
+// which takes exactly two numbers with min <= max.
+func (g *generator) genFloatBetween(c *ast.Constraint) (float64, float64, bool) {
+	if len(c.Args) != 2 {
+		g.addError(c.Pos, "between requires two number arguments: between(min, max)")
+		return 0, 0, false
+	}
+	lo, ok1 := floatValue(c.Args[0])
+	hi, ok2 := floatValue(c.Args[1])
+	if !ok1 || !ok2 {
+		g.addError(c.Pos, "between requires two number arguments: between(min, max)")
+		return 0, 0, false
+	}
+	if lo > hi {
+		g.addError(c.Pos, fmt.Sprintf("between min %g is greater than max %g", lo, hi))
+		return 0, 0, false
+	}
+	return lo, hi, true
+}
+
+// genConstraintPattern returns the regex of a pattern constraint, with any
+// flags folded in as a (?flags) prefix.
+func (g *generator) genConstraintPattern(c *ast.Constraint) string {
+	if len(c.Args) != 1 || c.Args[0].Kind != ast.ExprRegex {
+		g.addError(c.Pos, "pattern requires a single regex literal")
+		return ""
+	}
+	re := c.Args[0].StrVal
+	if c.Args[0].Flags != "" {
+		re = "(?" + c.Args[0].Flags + ")" + re
+	}
+	if _, err := regexp.Compile(re); err != nil {
+		g.addError(c.Pos, fmt.Sprintf("invalid regex /%s/: %v", c.Args[0].StrVal, err))
+		return ""
+	}
+	return re
+}
+
+// genEnum returns the allowed values of a oneOf constraint, which must all
+// be string literals.
+func (g *generator) genEnum(c *ast.Constraint) []string {
+	if len(c.Args) == 0 {
+		g.addError(c.Pos, "oneOf requires at least one value")
+		return nil
+	}
+	values := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		if arg.Kind != ast.ExprString {
+			g.addError(c.Pos, "oneOf accepts only string literals")
+			return nil
+		}
+		values = append(values, arg.StrVal)
+	}
+	return values
+}
+
+func (g *generator) genTransform(t *ast.TransformStep) *ir.OrderedMap[*ir.Transform] {
 	if t == nil {
 		return nil
 	}
-	result := make(map[string]*ir.Transform)
+	result := ir.NewOrderedMap[*ir.Transform]()
+	seen := make(map[string]token.Position)
 	for _, f := range t.Fields {
+		if g.checkDuplicate(seen, "transform target", f.Name, f.Pos) {
+			continue
+		}
 		tr := &ir.Transform{From: f.From}
-		if typeNames[f.Func] {
+		if len(f.Chain) > 1 {
+			tr.Chain = append([]string(nil), f.Chain...)
+		} else if typeNames[f.Func] {
 			tr.Cast = f.Func
 		} else {
 			tr.Fn = f.Func
 		}
-		result[f.Name] = tr
+		for i := range f.Args {
+			tr.Args = append(tr.Args, literalValue(&f.Args[i]))
+		}
+		result.Set(f.Name, tr)
+	}
+	if result.Len() == 0 {
+		return nil
 	}
 	return result
 }
 
+func genMap(step *ast.PipelineStep) *ir.MapStep {
+	ms := &ir.MapStep{
+		Bind: step.Bind,
+		Map:  step.Map.Source,
+		Body: genBody(step.Map.Body, nil),
+	}
+	if ms.Body == nil {
+		ms.Body = map[string]interface{}{}
+	}
+	return ms
+}
+
 func genGuard(step *ast.PipelineStep) *ir.GuardStep {
 	gs := &ir.GuardStep{}
 	if step.Guard.Negated {
@@ -228,10 +770,18 @@ func genGuard(step *ast.PipelineStep) *ir.GuardStep {
 	if step.ErrorFlow != nil {
 		gs.Error = genErrorResponse(step.ErrorFlow)
 	}
+	if alt := step.Guard.Else; alt != nil {
+		switch alt.Kind {
+		case ast.StepRespond:
+			gs.Else = genRespond(alt.Respond)
+		case ast.StepPkgCall:
+			gs.Else = genPkgCall(alt)
+		}
+	}
 	return gs
 }
 
-func genMatch(step *ast.PipelineStep) *ir.MatchProcessStep {
+func (g *generator) genMatch(step *ast.PipelineStep) *ir.MatchProcessStep {
 	m := step.Match
 	ms := &ir.MatchProcessStep{
 		Bind: step.Bind,
@@ -249,7 +799,7 @@ func genMatch(step *ast.PipelineStep) *ir.MatchProcessStep {
 			} else if arm.VarRef != "" {
 				// Default arm with variable reference
 				ms.Match.Default = map[string]string{"ref": arm.VarRef}
-			} else if arm.Step != nil {
+			} else if arm.Step != nil || arm.Respond != nil {
 				irArm := genMatchArmStep(arm)
 				ms.Match.Default = irArm
 			}
@@ -257,12 +807,21 @@ func genMatch(step *ast.PipelineStep) *ir.MatchProcessStep {
 		}
 
 		irArm := &ir.MatchArm{
-			Pattern: genPattern(arm.Pattern),
+			Pattern: g.genPattern(arm),
+		}
+		if arm.When != "" {
+			if arm.WhenNegated {
+				irArm.When = map[string]string{"not": arm.When}
+			} else {
+				irArm.When = arm.When
+			}
 		}
 
 		if arm.Step != nil {
 			irArm.Use = arm.Step.Pkg
 			irArm.Input = genPkgInput(arm.Step)
+		} else if arm.Respond != nil {
+			irArm.Output = genRespond(arm.Respond)
 		} else if arm.VarRef != "" {
 			irArm.Ref = arm.VarRef
 		}
@@ -287,13 +846,15 @@ func genMatchArmStep(arm *ast.MatchArm) *ir.MatchArm {
 		irArm.Use = arm.Step.Pkg
 		irArm.Input = genPkgInput(arm.Step)
 	}
+	irArm.Output = genRespond(arm.Respond)
 	if arm.ErrorFlow != nil {
 		irArm.Error = genErrorResponse(arm.ErrorFlow)
 	}
 	return irArm
 }
 
-func genPattern(p ast.Pattern) interface{} {
+func (g *generator) genPattern(arm *ast.MatchArm) interface{} {
+	p := arm.Pattern
 	switch p.Kind {
 	case ast.PatternLiteral:
 		// Try to parse as int
@@ -323,19 +884,40 @@ func genPattern(p ast.Pattern) interface{} {
 		return &ir.PatternRange{Range: &ir.RangeValue{Min: min, Max: max}}
 
 	case ast.PatternRegex:
-		return &ir.PatternRegex{Regex: p.Regex}
+		expr := p.Regex
+		if p.RegexFlags != "" {
+			expr = "(?" + p.RegexFlags + ")" + expr
+		}
+		if _, err := regexp.Compile(expr); err != nil {
+			g.addError(arm.Pos, fmt.Sprintf("invalid regex /%s/: %v", p.Regex, err))
+		}
+		return &ir.PatternRegex{Regex: p.Regex, Flags: p.RegexFlags}
 
 	default:
 		return nil
 	}
 }
 
+func genForward(step *ast.PipelineStep) *ir.ForwardStep {
+	fs := &ir.ForwardStep{
+		Bind:    step.Bind,
+		Forward: &ir.Forward{URL: step.Forward.URL},
+	}
+	if step.ErrorFlow != nil {
+		fs.Error = genErrorResponse(step.ErrorFlow)
+	}
+	return fs
+}
+
 func genPkgCall(step *ast.PipelineStep) *ir.PkgStep {
 	ps := &ir.PkgStep{
 		Bind:  step.Bind,
 		Use:   step.PkgCall.Pkg,
 		Input: genPkgInput(step.PkgCall),
 	}
+	if step.Retry != nil {
+		ps.Retry = genRetry(step.Retry)
+	}
 	if step.ErrorFlow != nil {
 		ps.Error = genErrorResponse(step.ErrorFlow)
 	}
@@ -345,73 +927,277 @@ func genPkgCall(step *ast.PipelineStep) *ir.PkgStep {
 func genPkgInput(call *ast.PkgCallStep) map[string]interface{} {
 	input := make(map[string]interface{})
 	for _, arg := range call.Args {
-		if arg.Name != "" {
-			input[arg.Name] = arg.Value
-		} else if arg.IsType {
-			input["type"] = arg.Value
-		} else if len(arg.ObjectArgs) > 0 {
+		if arg.Name != "" && arg.Value.Kind == ast.ExprString {
+			input[arg.Name] = genString(arg.Value.StrVal, arg.Refs)
+		} else if arg.Name != "" {
+			input[arg.Name] = pkgValue(arg.Value)
+		} else if arg.Value.Kind == ast.ExprType {
+			input["type"] = arg.Value.StrVal
+		} else if arg.Value.Kind == ast.ExprObject {
 			data := make(map[string]string)
-			for _, k := range arg.ObjectArgs {
+			for _, k := range arg.Value.ListVal {
 				data[k] = k
 			}
 			input["data"] = data
-		} else if arg.Value != "" {
+		} else if v, ok := pkgValue(arg.Value).(string); ok && v != "" {
 			// Positional args after the type: use common convention
 			// If there's already a "type", this is likely the ID or other param
 			if _, hasType := input["type"]; hasType {
 				// Determine the key: for single values, use "id" as convention
 				// But we need to be smarter here
-				input["id"] = arg.Value
+				input["id"] = v
 			} else {
-				input[arg.Value] = arg.Value
+				input[v] = v
 			}
 		}
 	}
 	return input
 }
 
+// pkgValue returns a package call argument as written: numbers and names
+// as strings, lists and objects as their items.
+func pkgValue(e ast.Expr) interface{} {
+	switch e.Kind {
+	case ast.ExprInt:
+		return e.IntVal
+	case ast.ExprList, ast.ExprObject:
+		return e.ListVal
+	}
+	return e.StrVal
+}
+
 func genRespond(r *ast.RespondStep) *ir.Output {
 	if r == nil {
 		return nil
 	}
 	status, _ := strconv.Atoi(r.Status)
-	o := &ir.Output{Status: status}
+	o := &ir.Output{Status: status, StatusRef: r.StatusRef, Schema: r.Schema}
 
-	if len(r.Body) > 0 {
-		o.Body = make(map[string]string)
-		for _, f := range r.Body {
-			o.Body[f.Key] = f.Value
-		}
+	o.Body = genBody(r.Body, nil)
+	o.Example = genExample(r.Example)
+	if r.HasText {
+		text := r.Text
+		o.Text = &text
+	}
+
+	o.Cookies = genCookies(r.Cookies)
+
+	// Without a keyword, responses that carry a body are JSON.
+	switch {
+	case r.ContentType != "":
+		o.ContentType = ast.ContentTypes[r.ContentType]
+	case o.Body != nil || o.Text != nil:
+		o.ContentType = ast.ContentTypes["json"]
 	}
 
 	if len(r.Headers) > 0 {
 		o.Headers = make(map[string]string)
 		for _, f := range r.Headers {
-			o.Headers[f.Key] = f.Value
+			o.Headers[f.Key], _ = f.Value.(string)
 		}
 	}
 
 	return o
 }
 
+// cookieAttributes are the keys of a cookies block that configure the
+// cookies rather than set one.
+var cookieAttributes = map[string]bool{
+	"path":      true,
+	"domain":    true,
+	"max-age":   true,
+	"secure":    true,
+	"http-only": true,
+	"same-site": true,
+}
+
+// genCookies turns with cookies { sid: token, path: "/", secure } into one
+// cookie per non-attribute key, each carrying the block's attributes.
+func genCookies(fields []*ast.BodyField) []*ir.Cookie {
+	var cookies []*ir.Cookie
+	attrs := ir.Cookie{}
+	for _, f := range fields {
+		value, _ := f.Value.(string)
+		switch f.Key {
+		case "path":
+			attrs.Path = value
+		case "domain":
+			attrs.Domain = value
+		case "max-age":
+			if v, err := strconv.Atoi(value); err == nil {
+				attrs.MaxAge = intPtr(v)
+			}
+		case "secure":
+			attrs.Secure = true
+		case "http-only":
+			attrs.HTTPOnly = true
+		case "same-site":
+			attrs.SameSite = value
+		default:
+			cookies = append(cookies, &ir.Cookie{Name: f.Key, Value: value})
+		}
+	}
+	for _, c := range cookies {
+		name, value := c.Name, c.Value
+		*c = attrs
+		c.Name, c.Value = name, value
+		c.MaxAge = copyInt(attrs.MaxAge)
+	}
+	return cookies
+}
+
 func genErrorResponse(ef *ast.ErrorFlow) *ir.ErrorResponse {
 	if ef == nil {
 		return nil
 	}
 	status, _ := strconv.Atoi(ef.Status)
 	er := &ir.ErrorResponse{Status: status}
-	if len(ef.Body) > 0 {
-		er.Body = make(map[string]string)
-		for _, f := range ef.Body {
-			er.Body[f.Key] = f.Value
+	er.Body = genBody(ef.Body, errorFlowRefs)
+	return er
+}
+
+// errorFlowRefs are the implicit bindings an error-flow body may reference:
+// the validation errors and the request itself. The runtime injects them, so
+// they are emitted as ir.Ref rather than plain strings.
+var errorFlowRefs = map[string]bool{
+	"errors":  true,
+	"request": true,
+	"path":    true,
+	"query":   true,
+}
+
+// genBody maps body fields to IR, recording a ...name spread under
+// ir.SpreadKey. Values whose root is in refs become ir.Ref.
+func genBody(fields []*ast.BodyField, refs map[string]bool) map[string]interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	body := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		if f.Spread {
+			body[ir.SpreadKey] = f.Value
+			continue
 		}
+		body[f.Key] = genBodyValue(f, refs)
 	}
-	return er
+	return body
+}
+
+// genBodyValue maps a body value to IR: nested objects become maps and lists
+// become arrays.
+func genBodyValue(f *ast.BodyField, refs map[string]bool) interface{} {
+	switch v := f.Value.(type) {
+	case []*ast.BodyField:
+		if nested := genBody(v, refs); nested != nil {
+			return nested
+		}
+		return map[string]interface{}{}
+	case ast.BodyList:
+		list := make([]interface{}, 0, len(v))
+		for _, elem := range v {
+			list = append(list, genBodyValue(elem, refs))
+		}
+		return list
+	case string:
+		if f.IsString {
+			return genString(v, f.Refs)
+		}
+		if refs[refRoot(v)] {
+			return &ir.Ref{Ref: v}
+		}
+		return v
+	}
+	// Shorthand field, e.g. { name }
+	if refs[f.Key] {
+		return &ir.Ref{Ref: f.Key}
+	}
+	return ""
+}
+
+// genExample maps an example payload to IR. Unlike a body, whose values are
+// references, its values keep their JSON types: 1 is a number and true a
+// boolean.
+func genExample(fields []*ast.BodyField) map[string]interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+	example := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		example[f.Key] = exampleValue(f)
+	}
+	return example
+}
+
+func exampleValue(f *ast.BodyField) interface{} {
+	switch v := f.Value.(type) {
+	case []*ast.BodyField:
+		if nested := genExample(v); nested != nil {
+			return nested
+		}
+		return map[string]interface{}{}
+	case ast.BodyList:
+		list := make([]interface{}, 0, len(v))
+		for _, elem := range v {
+			list = append(list, exampleValue(elem))
+		}
+		return list
+	case string:
+		if f.IsString {
+			return braceEscapes.Replace(v)
+		}
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return n
+		}
+		if v == "true" || v == "false" {
+			return v == "true"
+		}
+	}
+	return nil // null
 }
 
-func genCache(dir *ast.Directive) *ir.Cache {
+// genString returns a string literal with placeholders as an ir.Template,
+// and any other as a plain string.
+func genString(lit string, refs []string) interface{} {
+	if len(refs) == 0 {
+		return braceEscapes.Replace(lit)
+	}
+	t := &ir.Template{Template: lit}
+	seen := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		if !seen[ref] {
+			seen[ref] = true
+			t.Refs = append(t.Refs, ref)
+		}
+	}
+	return t
+}
+
+// braceEscapes turns the \{ and \} escapes of a body string into literal
+// braces, for text that must not be taken as a {name} placeholder.
+var braceEscapes = strings.NewReplacer(`\{`, "{", `\}`, "}")
+
+// refRoot returns the first segment of a dotted reference.
+func refRoot(expr string) string {
+	if idx := strings.Index(expr, "."); idx != -1 {
+		return expr[:idx]
+	}
+	return expr
+}
+
+func (g *generator) genCache(dir *ast.Directive) *ir.Cache {
 	c := &ir.Cache{}
 	for _, arg := range dir.Args {
+		if name, on, ok := g.flagArg(arg, "no-cache", "no-store"); ok {
+			if name == "no-cache" {
+				c.NoCache = boolPtr(on)
+			} else {
+				c.NoStore = boolPtr(on)
+			}
+			continue
+		}
 		switch arg.Name {
 		case "max-age":
 			if v, err := strconv.Atoi(arg.Value.IntVal); err == nil {
@@ -434,10 +1220,6 @@ func genCache(dir *ast.Directive) *ir.Cache {
 				c.Visibility = "public"
 			case "private":
 				c.Visibility = "private"
-			case "no-cache":
-				c.NoCache = boolPtr(true)
-			case "no-store":
-				c.NoStore = boolPtr(true)
 			}
 		}
 	}
@@ -445,6 +1227,10 @@ func genCache(dir *ast.Directive) *ir.Cache {
 }
 
 func genCacheExpr(expr ast.Expr) interface{} {
+	if expr.Kind == ast.ExprList {
+		// [user.id, user.updated_at] → {from: ["user.id", "user.updated_at"]}
+		return &ir.ETagFields{From: expr.ListVal}
+	}
 	if expr.Kind == ast.ExprFuncCall {
 		// Parse "hash(user)" → {fn: "hash", from: "user"}
 		s := expr.StrVal
@@ -457,12 +1243,249 @@ func genCacheExpr(expr ast.Expr) interface{} {
 	return expr.StrVal
 }
 
-func genCORS(dir *ast.Directive) *ir.CORS {
+// declaresVary reports whether the cache directive that applies to route,
+// its own or a group's, lists vary.
+func declaresVary(route *ast.Route) bool {
+	declared := false
+	for _, dir := range route.EffectiveDirectives() {
+		if dir.Name != "cache" {
+			continue
+		}
+		declared = false
+		for _, arg := range dir.Args {
+			if arg.Name == "vary" {
+				declared = true
+			}
+		}
+	}
+	return declared
+}
+
+// inferVary adds the request headers a cached response of r depends on to
+// its Vary list: Authorization when the route authenticates, Accept when it
+// negotiates the content type with accepts, and Accept-Encoding when it
+// compresses.
+func inferVary(r *ir.Route) {
+	if r.Auth != nil && !slices.Contains(r.Cache.Vary, "Authorization") {
+		r.Cache.Vary = append(r.Cache.Vary, "Authorization")
+	}
+	if len(r.Accepts) > 0 && !slices.Contains(r.Cache.Vary, "Accept") {
+		r.Cache.Vary = append(r.Cache.Vary, "Accept")
+	}
+	if r.Compress != nil && !slices.Contains(r.Cache.Vary, "Accept-Encoding") {
+		r.Cache.Vary = append(r.Cache.Vary, "Accept-Encoding")
+	}
+}
+
+func genRetry(dir *ast.Directive) *ir.Retry {
+	r := &ir.Retry{}
+	for _, arg := range dir.Args {
+		switch arg.Name {
+		case "attempts":
+			if v, err := strconv.Atoi(arg.Value.IntVal); err == nil {
+				r.Attempts = v
+			}
+		case "backoff":
+			r.BackoffMs = durationMs(arg.Value)
+		}
+	}
+	return r
+}
+
+func genPaginate(dir *ast.Directive) *ir.Pagination {
+	p := &ir.Pagination{}
+	for _, arg := range dir.Args {
+		switch arg.Name {
+		case "limit":
+			if v, err := strconv.Atoi(arg.Value.IntVal); err == nil {
+				p.DefaultLimit = v
+			}
+		case "max":
+			if v, err := strconv.Atoi(arg.Value.IntVal); err == nil {
+				p.MaxLimit = v
+			}
+		case "cursor":
+			p.CursorFrom = arg.Value.StrVal
+		}
+	}
+	return p
+}
+
+// compressEncodings are the content codings compress(...) accepts.
+var compressEncodings = map[string]bool{
+	"gzip":    true,
+	"br":      true,
+	"deflate": true,
+	"zstd":    true,
+}
+
+// genCompress collects the encodings of compress(gzip, br, min: 1024), in
+// the order written, which is the order of preference.
+func (g *generator) genCompress(dir *ast.Directive) *ir.Compress {
+	c := &ir.Compress{}
+	for _, arg := range dir.Args {
+		switch arg.Name {
+		case "min":
+			v, err := strconv.Atoi(arg.Value.IntVal)
+			if arg.Value.Kind != ast.ExprInt || err != nil {
+				g.addError(arg.Pos, "compress min must be a size in bytes, e.g. min: 1024")
+				continue
+			}
+			c.MinBytes = v
+		case "":
+			enc := arg.Value.StrVal
+			if arg.Value.Kind != ast.ExprIdent || !compressEncodings[enc] {
+				g.addError(arg.Pos, fmt.Sprintf("compress: unknown encoding %q: expected gzip, br, deflate or zstd", enc))
+				continue
+			}
+			if slices.Contains(c.Encodings, enc) {
+				g.addError(arg.Pos, fmt.Sprintf("compress: duplicate encoding %q", enc))
+				continue
+			}
+			c.Encodings = append(c.Encodings, enc)
+		default:
+			g.addError(arg.Pos, fmt.Sprintf("compress has no argument %q", arg.Name))
+		}
+	}
+	if !slices.ContainsFunc(dir.Args, func(arg *ast.Arg) bool { return arg.Name == "" }) {
+		g.addError(dir.Pos, "compress requires at least one encoding")
+	}
+	return c
+}
+
+// genAccepts collects the media types of accepts("application/json", ...).
+// Each must be a type/subtype pair; "*/*" and "type/*" are allowed.
+func (g *generator) genAccepts(dir *ast.Directive) []string {
+	if len(dir.Args) == 0 {
+		g.addError(dir.Pos, "accepts requires at least one media type")
+		return nil
+	}
+	var types []string
+	for _, arg := range dir.Args {
+		if arg.Name != "" || arg.Value.Kind != ast.ExprString {
+			g.addError(arg.Pos, "accepts takes media type strings, e.g. accepts(\"application/json\")")
+			continue
+		}
+		if !isMediaType(arg.Value.StrVal) {
+			g.addError(arg.Pos, fmt.Sprintf("accepts: %q is not a media type (want type/subtype)", arg.Value.StrVal))
+			continue
+		}
+		types = append(types, arg.Value.StrVal)
+	}
+	return types
+}
+
+// isMediaType reports whether s looks like a media range: two tokens
+// separated by a slash, with "*" allowed as the subtype (or as both).
+func isMediaType(s string) bool {
+	typ, sub, ok := strings.Cut(s, "/")
+	if !ok || !isMediaToken(typ) || !isMediaToken(sub) {
+		return false
+	}
+	return typ != "*" || sub == "*"
+}
+
+// isMediaToken reports whether s is a non-empty RFC 9110 token.
+func isMediaToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// durationMs normalizes a duration (200ms, 2s) to milliseconds. A bare
+// integer is already in milliseconds.
+func durationMs(expr ast.Expr) int {
+	switch expr.Kind {
+	case ast.ExprInt:
+		if v, err := strconv.Atoi(expr.IntVal); err == nil {
+			return v
+		}
+	case ast.ExprDuration:
+		if d, err := time.ParseDuration(expr.StrVal); err == nil {
+			return int(d.Milliseconds())
+		}
+	}
+	return 0
+}
+
+// envValue returns an ir.EnvRef for an env("NAME") call, which the parser
+// keeps in its source form, and the value of any other expression as is.
+func envValue(e ast.Expr) interface{} {
+	if e.Kind != ast.ExprFuncCall {
+		return e.StrVal
+	}
+	if arg, ok := strings.CutPrefix(e.StrVal, "env("); ok && strings.HasSuffix(arg, ")") {
+		if name, err := strconv.Unquote(strings.TrimSuffix(arg, ")")); err == nil {
+			return ir.EnvRef{Env: name}
+		}
+	}
+	return e.StrVal
+}
+
+// originValue returns the IR form of a cors origin: an ir.OriginPattern
+// for a /regex/ or for a wildcard string such as "https://*.example.com",
+// an ir.EnvRef for env("NAME") and the string itself otherwise.
+func (g *generator) originValue(arg *ast.Arg, origin ast.Expr) interface{} {
+	switch origin.Kind {
+	case ast.ExprRegex:
+		// Checked as it will run, with its flags folded in.
+		re := origin.StrVal
+		if origin.Flags != "" {
+			re = "(?" + origin.Flags + ")" + re
+		}
+		if _, err := regexp.Compile(re); err != nil {
+			g.addError(arg.Pos, fmt.Sprintf("invalid regex /%s/%s: %v", origin.StrVal, origin.Flags, err))
+			return nil
+		}
+		return ir.OriginPattern{Pattern: re}
+	case ast.ExprFuncCall:
+		return envValue(origin)
+	case ast.ExprString:
+		if origin.StrVal != "*" && strings.Contains(origin.StrVal, "*") {
+			return ir.OriginPattern{Pattern: wildcardPattern(origin.StrVal)}
+		}
+	}
+	return origin.StrVal
+}
+
+// wildcardPattern turns a wildcard origin into an anchored regex in which
+// each * matches one host label. An origin without a scheme matches both
+// http and https.
+func wildcardPattern(origin string) string {
+	parts := strings.Split(origin, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re := strings.Join(parts, `[^./:]+`)
+	if !strings.Contains(origin, "://") {
+		re = "https?://" + re
+	}
+	return "^" + re + "$"
+}
+
+func (g *generator) genCORS(dir *ast.Directive) *ir.CORS {
 	c := &ir.CORS{}
 	for _, arg := range dir.Args {
+		if _, on, ok := g.flagArg(arg, "credentials"); ok {
+			c.Credentials = boolPtr(on)
+			continue
+		}
 		switch arg.Name {
 		case "origins":
-			c.Origins = arg.Value.ListVal
+			for _, origin := range arg.Value.Items {
+				if v := g.originValue(arg, origin); v != nil {
+					c.Origins = append(c.Origins, v)
+				}
+			}
 		case "methods":
 			c.Methods = arg.Value.ListVal
 		case "headers":
@@ -473,30 +1496,56 @@ func genCORS(dir *ast.Directive) *ir.CORS {
 			if v, err := strconv.Atoi(arg.Value.IntVal); err == nil {
 				c.MaxAge = intPtr(v)
 			}
-		case "":
-			if arg.Value.StrVal == "credentials" {
-				c.Credentials = boolPtr(true)
-			}
 		}
 	}
 	return c
 }
 
-func genAuth(dir *ast.Directive) *ir.Auth {
+// apiKeyLocations are the accepted values of auth(apikey, in: ...).
+var apiKeyLocations = map[string]bool{
+	"header": true,
+	"query":  true,
+	"cookie": true,
+}
+
+func (g *generator) genAuth(dir *ast.Directive) *ir.Auth {
 	a := &ir.Auth{}
+	var apiKeyArgs []*ast.Arg
 	for _, arg := range dir.Args {
+		if _, on, ok := g.flagArg(arg, "optional"); ok {
+			a.Optional = boolPtr(on)
+			continue
+		}
 		switch arg.Name {
 		case "roles":
 			a.Roles = arg.Value.ListVal
 		case "permissions":
 			a.Permissions = arg.Value.ListVal
+		case "scopes":
+			a.Scopes = arg.Value.ListVal
+		case "realm":
+			a.Realm = envValue(arg.Value)
+		case "in":
+			a.In = arg.Value.StrVal
+			if !apiKeyLocations[a.In] {
+				g.addError(arg.Pos, fmt.Sprintf("auth in must be header, query or cookie, got %q", a.In))
+			}
+			apiKeyArgs = append(apiKeyArgs, arg)
+		case "name":
+			a.Name = arg.Value.StrVal
+			apiKeyArgs = append(apiKeyArgs, arg)
 		case "":
-			// First positional arg is the method
+			// The first positional arg is the method.
 			if a.Method == "" {
 				a.Method = arg.Value.StrVal
 			}
 		}
 	}
+	if a.Method != "apikey" && a.Method != "api-key" {
+		for _, arg := range apiKeyArgs {
+			g.addError(arg.Pos, fmt.Sprintf("%s is only valid for apikey auth, not %s", arg.Name, a.Method))
+		}
+	}
 	if dir.Bind != "" {
 		a.Bind = dir.Bind
 	}
@@ -512,5 +1561,24 @@ func isNoneDirective(dir *ast.Directive) bool {
 	return false
 }
 
+// flagArg reports whether arg sets one of flags, the boolean flags of its
+// directive, and to what: a bare name, as in cors(credentials), sets the
+// flag, and name: true or name: false sets it explicitly. Every directive
+// takes its flags this way. ok is false for any other argument.
+func (g *generator) flagArg(arg *ast.Arg, flags ...string) (name string, on, ok bool) {
+	if arg.Name == "" {
+		name = arg.Value.StrVal
+		return name, true, arg.Value.Kind == ast.ExprIdent && slices.Contains(flags, name)
+	}
+	if !slices.Contains(flags, arg.Name) {
+		return "", false, false
+	}
+	if v := arg.Value.StrVal; arg.Value.Kind != ast.ExprIdent || v != "true" && v != "false" {
+		g.addError(arg.Pos, fmt.Sprintf("%s must be true or false, or written alone to set it", arg.Name))
+		return "", false, false
+	}
+	return arg.Name, arg.Value.StrVal == "true", true
+}
+
 func intPtr(v int) *int    { return &v }
 func boolPtr(v bool) *bool { return &v }
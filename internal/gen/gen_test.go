@@ -4,8 +4,11 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/polidog/reverhttp/internal/ast"
 	"github.com/polidog/reverhttp/internal/ir"
 	"github.com/polidog/reverhttp/internal/lexer"
 	"github.com/polidog/reverhttp/internal/parser"
@@ -50,15 +53,15 @@ GET /users/{id}
 	}
 
 	// Check input
-	if r.Input["id"].From != "path.id" {
-		t.Fatalf("expected input id from 'path.id', got %q", r.Input["id"].From)
+	if r.Input.Get("id").From != "path.id" {
+		t.Fatalf("expected input id from 'path.id', got %q", r.Input.Get("id").From)
 	}
 
 	// Check validate
 	if r.Validate == nil {
 		t.Fatal("expected validate")
 	}
-	idRule := r.Validate.Rules["id"]
+	idRule := r.Validate.Rules.Get("id")
 	if idRule.Type != "int" {
 		t.Fatalf("expected validate type 'int', got %q", idRule.Type)
 	}
@@ -70,8 +73,8 @@ GET /users/{id}
 	}
 
 	// Check transform
-	if r.TransformIn["id"].Cast != "int" {
-		t.Fatalf("expected transform cast 'int', got %q", r.TransformIn["id"].Cast)
+	if r.TransformIn.Get("id").Cast != "int" {
+		t.Fatalf("expected transform cast 'int', got %q", r.TransformIn.Get("id").Cast)
 	}
 
 	// Check process
@@ -113,11 +116,11 @@ POST /users
 	r := root.Routes[0]
 
 	// Check transform: trim should be fn, not cast
-	if r.TransformIn["name"].Fn != "trim" {
-		t.Fatalf("expected transform fn 'trim', got fn=%q cast=%q", r.TransformIn["name"].Fn, r.TransformIn["name"].Cast)
+	if r.TransformIn.Get("name").Fn != "trim" {
+		t.Fatalf("expected transform fn 'trim', got fn=%q cast=%q", r.TransformIn.Get("name").Fn, r.TransformIn.Get("name").Cast)
 	}
-	if r.TransformIn["email"].Fn != "lower" {
-		t.Fatalf("expected transform fn 'lower', got %q", r.TransformIn["email"].Fn)
+	if r.TransformIn.Get("email").Fn != "lower" {
+		t.Fatalf("expected transform fn 'lower', got %q", r.TransformIn.Get("email").Fn)
 	}
 
 	// Check process steps count: fetch + guard + create = 3
@@ -161,6 +164,1904 @@ func TestGenerateRespondNoBody(t *testing.T) {
 	}
 }
 
+func TestGenerateRespondStatusRef(t *testing.T) {
+	input := `GET /proxy
+  |> upstream() as res
+  |> respond res.status { ...res.body }
+GET /ok
+  |> respond 200`
+
+	root := parseAndGenerate(input)
+	data, _ := json.Marshal(root.Routes[0].Output)
+	if want := `{"status_ref":"res.status","content_type":"application/json","body":{"$spread":"res.body"}}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+	if o := root.Routes[1].Output; o.Status != 200 || o.StatusRef != "" {
+		t.Errorf("expected literal status 200, got %+v", o)
+	}
+}
+
+func TestGenerateRespondSchema(t *testing.T) {
+	input := `type User {
+  id: int
+}
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> respond 200: User { id: id }`
+
+	root := parseAndGenerate(input)
+	data, _ := json.Marshal(root.Routes[0].Output)
+	if want := `{"status":200,"content_type":"application/json","schema":"User","body":{"id":"id"}}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+}
+
+func TestGenerateRespondExample(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> respond 200 example { id: 1, name: "Ada", admin: false, team: { id: 7, lead: null }, tags: ["a", 2] } { id: id }`
+
+	root := parseAndGenerate(input)
+	data, _ := json.Marshal(root.Routes[0].Output)
+	want := `{"status":200,"content_type":"application/json","body":{"id":"id"},` +
+		`"example":{"admin":false,"id":1,"name":"Ada","tags":["a",2],"team":{"id":7,"lead":null}}}`
+	if string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+}
+
+func TestGenerateCatchAllPaths(t *testing.T) {
+	input := `GET /files/{path*}
+  |> input(path: path.path)
+  |> respond 200
+GET /assets/*
+  |> respond 200
+GET /users/{id}
+  |> input(id: path.id)
+  |> respond 200`
+
+	root := parseAndGenerate(input)
+	tests := []string{
+		`{"method":"GET","path":"/files/{path*}","catch_all":true}`,
+		`{"method":"GET","path":"/assets/*","catch_all":true}`,
+		`{"method":"GET","path":"/users/{id}"}`,
+	}
+	for i, want := range tests {
+		if data, _ := json.Marshal(root.Routes[i].RouteInfo); string(data) != want {
+			t.Errorf("route %d: expected %s, got %s", i, want, data)
+		}
+	}
+}
+
+func TestGenerateRespondContentType(t *testing.T) {
+	input := `GET /a
+  |> respond 200 json { id: user.id }
+GET /b
+  |> respond 200 html """<h1>Hello</h1>"""
+GET /c
+  |> respond 200 text "ok"
+GET /d
+  |> respond 200 { id: user.id }
+GET /e
+  |> respond 204`
+
+	root := parseAndGenerate(input)
+	tests := []struct {
+		contentType string
+		text        string
+	}{
+		{"application/json", ""},
+		{"text/html", "<h1>Hello</h1>"},
+		{"text/plain", "ok"},
+		{"application/json", ""},
+		{"", ""},
+	}
+	for i, tt := range tests {
+		o := root.Routes[i].Output
+		if o.ContentType != tt.contentType {
+			t.Errorf("route %d: expected content type %q, got %q", i, tt.contentType, o.ContentType)
+		}
+		if tt.text != "" && (o.Text == nil || *o.Text != tt.text) {
+			t.Errorf("route %d: expected text %q, got %v", i, tt.text, o.Text)
+		}
+	}
+
+	data, _ := json.Marshal(root.Routes[2].Output)
+	if string(data) != `{"status":200,"content_type":"text/plain","text":"ok"}` {
+		t.Fatalf("unexpected JSON: %s", data)
+	}
+}
+
+func TestGenerateNestedBody(t *testing.T) {
+	input := `GET /users/{id}
+  |> respond 200 { user: { id: user.id, name: user.name }, ok: "true" }`
+
+	root := parseAndGenerate(input)
+
+	data, _ := json.Marshal(root.Routes[0].Output.Body)
+	if string(data) != `{"ok":"true","user":{"id":"user.id","name":"user.name"}}` {
+		t.Fatalf("unexpected body: %s", data)
+	}
+}
+
+func TestGenerateTransformChain(t *testing.T) {
+	input := `GET /test
+  |> transform(email: lower(trim(email)), name: trim(name), id: int(id))
+  |> respond 200 { email: email }`
+
+	root := parseAndGenerate(input)
+	tr := root.Routes[0].TransformIn
+
+	data, _ := json.Marshal(tr.Get("email"))
+	if string(data) != `{"chain":["trim","lower"],"from":"email"}` {
+		t.Fatalf("unexpected chained transform: %s", data)
+	}
+	data, _ = json.Marshal(tr.Get("name"))
+	if string(data) != `{"fn":"trim","from":"name"}` {
+		t.Fatalf("unexpected single transform: %s", data)
+	}
+	if tr.Get("id").Cast != "int" || tr.Get("id").Chain != nil {
+		t.Fatalf("expected cast int, got %+v", tr.Get("id"))
+	}
+}
+
+func TestGenerateTransformSourceAndArgs(t *testing.T) {
+	input := `GET /test
+  |> transform(name: trim(body.name), role: default(role, "user"), page: clamp(page, 1, 100))
+  |> respond 200 { name: name }`
+
+	tr := parseAndGenerate(input).Routes[0].TransformIn
+
+	tests := map[string]string{
+		"name": `{"fn":"trim","from":"body.name"}`,
+		"role": `{"fn":"default","from":"role","args":["user"]}`,
+		"page": `{"fn":"clamp","from":"page","args":[1,100]}`,
+	}
+	for name, want := range tests {
+		data, _ := json.Marshal(tr.Get(name))
+		if string(data) != want {
+			t.Errorf("%s: expected %s, got %s", name, want, data)
+		}
+	}
+}
+
+func TestGenerateBodyList(t *testing.T) {
+	input := `GET /users/{id}
+  |> respond 200 { ids: [user.id, other.id], tags: ["a", "b"], empty: [] }`
+
+	root := parseAndGenerate(input)
+
+	data, _ := json.Marshal(root.Routes[0].Output.Body)
+	if string(data) != `{"empty":[],"ids":["user.id","other.id"],"tags":["a","b"]}` {
+		t.Fatalf("unexpected body: %s", data)
+	}
+}
+
+func TestGenerateBodySpread(t *testing.T) {
+	input := `GET /users/{id}
+  |> fetch(User, id) as user             ~> 404 { ...fallback, error: "user not found" }
+  |> respond 200 { ...user, extra: "x" }`
+
+	root := parseAndGenerate(input)
+
+	data, _ := json.Marshal(root.Routes[0].Output.Body)
+	if string(data) != `{"$spread":"user","extra":"x"}` {
+		t.Fatalf("unexpected body: %s", data)
+	}
+	step := root.Routes[0].Process.Steps[0].(*ir.PkgStep)
+	if step.Error.Body[ir.SpreadKey] != "fallback" || step.Error.Body["error"] != "user not found" {
+		t.Fatalf("expected spread in error body, got %v", step.Error.Body)
+	}
+}
+
+func TestGenerateErrorBodyRefs(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> validate(id: int & min(1))  ~> 400 { error: "invalid", details: errors, id: path.id, note: "errors" }
+  |> fetch(User, id) as user     ~> 404 { errors, user: user.id, list: [query.page, user.name] }
+  |> respond 200 { details: errors }`
+
+	r := parseAndGenerate(input).Routes[0]
+
+	data, _ := json.Marshal(r.Validate.Error.Body)
+	if want := `{"details":{"$ref":"errors"},"error":"invalid","id":{"$ref":"path.id"},"note":"errors"}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+	step := r.Process.Steps[0].(*ir.PkgStep)
+	data, _ = json.Marshal(step.Error.Body)
+	if want := `{"errors":{"$ref":"errors"},"list":[{"$ref":"query.page"},"user.name"],"user":"user.id"}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+	// Outside error flows errors is an ordinary name.
+	if r.Output.Body["details"] != "errors" {
+		t.Fatalf("expected plain string in respond body, got %#v", r.Output.Body["details"])
+	}
+}
+
+func TestGenerateInputDefault(t *testing.T) {
+	input := `GET /accounts
+  |> input(role: header.x-role ?? "guest", admin: query.admin ?? false, id: query.id)
+  |> respond 200`
+
+	r := parseAndGenerate(input).Routes[0]
+	data, _ := json.Marshal(r.Input)
+	expected := `{"role":{"from":"header.x-role","default":"guest"},"admin":{"from":"query.admin","default":false},"id":{"from":"query.id"}}`
+	if string(data) != expected {
+		t.Fatalf("unexpected input:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestGenerateInputHeaderMap(t *testing.T) {
+	input := `GET /proxy
+  |> input(headers: header.*, role: header.x-role)
+  |> respond 200`
+
+	r := parseAndGenerate(input).Routes[0]
+	data, _ := json.Marshal(r.Input)
+	expected := `{"headers":{"from":"header","wildcard":true},"role":{"from":"header.x-role"}}`
+	if string(data) != expected {
+		t.Fatalf("unexpected input:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestGenerateEscapedBraces(t *testing.T) {
+	input := `GET /template
+  |> respond 200 { tpl: "\{id\}", nested: { text: "use \{id\} here" }, list: ["\{"], raw: "{ id }" }`
+
+	r := parseAndGenerate(input).Routes[0]
+	data, _ := json.Marshal(r.Output.Body)
+	expected := `{"list":["{"],"nested":{"text":"use {id} here"},"raw":"{ id }","tpl":"{id}"}`
+	if string(data) != expected {
+		t.Fatalf("unexpected body:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestGenerateInterpolatedStrings(t *testing.T) {
+	input := `import redis-cache = github.com/reverhttp/std-redis-cache@0.1.0
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> redis-cache(key: "user:{id}", ttl: "1h") as user
+  |> respond 200 { url: "/users/{user.id}", label: "{user.name} ({user.id}) \{x\}", tags: ["{id}"], plain: "user" }`
+
+	r := parseAndGenerate(input).Routes[0]
+	data, _ := json.Marshal(r.Process.Steps[0].(*ir.PkgStep).Input)
+	expected := `{"key":{"template":"user:{id}","refs":["id"]},"ttl":"1h"}`
+	if string(data) != expected {
+		t.Errorf("unexpected pkg input:\n%s\nexpected:\n%s", data, expected)
+	}
+	data, _ = json.Marshal(r.Output.Body)
+	expected = `{"label":{"template":"{user.name} ({user.id}) \\{x\\}","refs":["user.name","user.id"]},` +
+		`"plain":"user","tags":[{"template":"{id}","refs":["id"]}],"url":{"template":"/users/{user.id}","refs":["user.id"]}}`
+	if string(data) != expected {
+		t.Errorf("unexpected body:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestGenerateCookies(t *testing.T) {
+	input := `POST /login
+  |> input(session: cookie.sid)
+  |> respond 200 with cookies { sid: token, theme: "dark", path: "/", max-age: 3600, secure, http-only, same-site: "Lax" }`
+
+	r := parseAndGenerate(input).Routes[0]
+
+	if r.Input.Get("session").From != "cookie.sid" {
+		t.Fatalf("expected cookie input, got %+v", r.Input.Get("session"))
+	}
+	data, _ := json.Marshal(r.Output.Cookies)
+	attrs := `"path":"/","max_age":3600,"secure":true,"http_only":true,"same_site":"Lax"`
+	expected := `[{"name":"sid","value":"token",` + attrs + `},{"name":"theme","value":"dark",` + attrs + `}]`
+	if string(data) != expected {
+		t.Fatalf("unexpected cookies:\n%s\nexpected:\n%s", data, expected)
+	}
+	if r.Output.Cookies[0].MaxAge == r.Output.Cookies[1].MaxAge {
+		t.Fatal("expected cookies not to share attribute pointers")
+	}
+}
+
+func TestGenerateRouteRetry(t *testing.T) {
+	input := `GET /users/{id}
+  retry(attempts: 3, backoff: 2s)
+  |> input(id: path.id)
+  |> respond 200 { id: id }`
+
+	root := parseAndGenerate(input)
+	r := root.Routes[0]
+
+	if r.Retry == nil {
+		t.Fatal("expected route retry")
+	}
+	if r.Retry.Attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", r.Retry.Attempts)
+	}
+	if r.Retry.BackoffMs != 2000 {
+		t.Fatalf("expected backoff 2000ms, got %d", r.Retry.BackoffMs)
+	}
+}
+
+func TestGenerateStepRetry(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) retry(attempts: 5, backoff: 200ms) as user
+  |> fetch(Post, id) retry(attempts: 2, backoff: 150) as post
+  |> fetch(Tag, id) as tag
+  |> respond 200 { id: user.id }`
+
+	root := parseAndGenerate(input)
+	steps := root.Routes[0].Process.Steps
+
+	tests := []struct {
+		attempts int
+		backoff  int
+	}{
+		{5, 200},
+		{2, 150},
+	}
+	for i, tt := range tests {
+		ps := steps[i].(*ir.PkgStep)
+		if ps.Retry == nil || ps.Retry.Attempts != tt.attempts || ps.Retry.BackoffMs != tt.backoff {
+			t.Fatalf("step %d: expected retry %d/%dms, got %+v", i, tt.attempts, tt.backoff, ps.Retry)
+		}
+	}
+	if ps := steps[2].(*ir.PkgStep); ps.Retry != nil {
+		t.Fatalf("expected no retry on plain step, got %+v", ps.Retry)
+	}
+	if root.Routes[0].Retry != nil {
+		t.Fatal("expected no route-level retry")
+	}
+}
+
+func TestGenerateGuardElse(t *testing.T) {
+	input := `import create = github.com/reverhttp/std-create@0.1.0
+
+GET /test
+  |> guard user.active  ~> 403 { error: "forbidden" } else respond 200 { status: "inactive" }
+  |> guard existing else create(User, { name })
+  |> guard ok           ~> 400 { error: "bad" }
+  |> respond 200 { ok: "true" }`
+
+	root := parseAndGenerate(input)
+	steps := root.Routes[0].Process.Steps
+
+	first := steps[0].(*ir.GuardStep)
+	if first.Error == nil || first.Error.Status != 403 {
+		t.Fatalf("expected guard error 403, got %+v", first.Error)
+	}
+	out, ok := first.Else.(*ir.Output)
+	if !ok || out.Status != 200 || out.Body["status"] != "inactive" {
+		t.Fatalf("expected respond else, got %#v", first.Else)
+	}
+
+	ps, ok := steps[1].(*ir.GuardStep).Else.(*ir.PkgStep)
+	if !ok || ps.Use != "create" {
+		t.Fatalf("expected package call else, got %#v", steps[1].(*ir.GuardStep).Else)
+	}
+
+	plain := steps[2].(*ir.GuardStep)
+	if plain.Else != nil {
+		t.Fatalf("expected no else, got %#v", plain.Else)
+	}
+	data, _ := json.Marshal(plain)
+	if strings.Contains(string(data), "else") {
+		t.Fatalf("expected no else key in JSON, got %s", data)
+	}
+}
+
+func TestGenerateMap(t *testing.T) {
+	input := `GET /users
+  |> fetch(User) as users
+  |> map(users, { id: it.id, profile: { name: it.name } }) as result
+  |> respond 200 { users: result }`
+
+	steps := parseAndGenerate(input).Routes[0].Process.Steps
+	if len(steps) != 2 {
+		t.Fatalf("expected 2 process steps, got %d", len(steps))
+	}
+	ms, ok := steps[1].(*ir.MapStep)
+	if !ok {
+		t.Fatalf("expected *ir.MapStep, got %T", steps[1])
+	}
+	data, _ := json.Marshal(ms)
+	if want := `{"bind":"result","map":"users","body":{"id":"it.id","profile":{"name":"it.name"}}}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+}
+
+func TestGeneratePipelineUse(t *testing.T) {
+	input := `pipeline userInput {
+  |> input(name: body.name)
+  |> validate(name: string)
+  |> use normalize
+}
+
+pipeline normalize {
+  |> transform(name: trim(name))
+}
+
+POST /users
+  |> use userInput
+  |> create(User, name) as user
+  |> respond 201 { id: user.id }
+
+PUT /users/{id}
+  |> use userInput
+  |> respond 200`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(root.Routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(root.Routes))
+	}
+	for _, r := range root.Routes {
+		if r.Input.Get("name") == nil || r.Validate.Rules.Get("name") == nil || r.TransformIn.Get("name") == nil {
+			t.Fatalf("%s: expected the pipeline's input, validate and transform, got %+v", r.RouteInfo.Method, r)
+		}
+	}
+	post := root.Routes[0]
+	if len(post.Process.Steps) != 1 {
+		t.Fatalf("expected the route's own step after the pipeline, got %d steps", len(post.Process.Steps))
+	}
+	if data, _ := json.Marshal(root); strings.Contains(string(data), "normalize") {
+		t.Fatalf("expected pipelines not to be emitted, got %s", data)
+	}
+}
+
+func TestGeneratePipelineUseErrors(t *testing.T) {
+	input := `pipeline loop {
+  |> use loop
+}
+
+pipeline loop {
+  |> log(x)
+}
+
+GET /a
+  |> use missing
+  |> use loop
+  |> respond 200`
+
+	_, errs := GenerateWithErrors(parse(t, input))
+	want := []string{
+		`duplicate pipeline "loop" (first declared at line 1)`,
+		`unknown pipeline "missing"`,
+		`pipeline "loop" uses itself`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), errs)
+	}
+	for i, msg := range want {
+		if errs[i].Message != msg {
+			t.Errorf("error %d: expected %q, got %q", i, msg, errs[i].Message)
+		}
+	}
+	if errs[1].Pos.Line != 10 || errs[1].Pos.Column != 10 {
+		t.Errorf("expected unknown pipeline at 10:10, got %d:%d", errs[1].Pos.Line, errs[1].Pos.Column)
+	}
+}
+
+func TestGenerateGroup(t *testing.T) {
+	input := `group /api/v1 {
+  cache(max-age: 60)
+  group /users {
+    auth(bearer) as user
+    GET /
+      |> respond 200
+    DELETE /{id}
+      cache(no-store)
+      auth(none)
+      |> respond 204
+  }
+}`
+
+	root := parseAndGenerate(input)
+	if len(root.Routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(root.Routes))
+	}
+	list, del := root.Routes[0], root.Routes[1]
+	if list.RouteInfo.Path != "/api/v1/users" || del.RouteInfo.Path != "/api/v1/users/{id}" {
+		t.Fatalf("unexpected paths %q, %q", list.RouteInfo.Path, del.RouteInfo.Path)
+	}
+	if list.Cache == nil || list.Cache.MaxAge == nil || *list.Cache.MaxAge != 60 {
+		t.Fatalf("expected cache inherited from the outer group, got %+v", list.Cache)
+	}
+	if auth := list.Auth; auth == nil || auth.Method != "bearer" || auth.Bind != "user" {
+		t.Fatalf("expected auth inherited from the inner group, got %#v", list.Auth)
+	}
+	if del.Cache == nil || del.Cache.NoStore == nil || !*del.Cache.NoStore || del.Cache.MaxAge != nil {
+		t.Fatalf("expected the route's own cache, got %+v", del.Cache)
+	}
+	if del.Auth != nil {
+		t.Fatalf("expected auth(none) to opt out of the group auth, got %#v", del.Auth)
+	}
+}
+
+func TestGenerateEnrich(t *testing.T) {
+	input := `GET /users/{id}
+  |> fetch(User, id) as user
+  |> fetch(Profile, id) as profile
+  |> enrich(user, profile) as full
+  |> respond 200 { user: full }`
+
+	steps := parseAndGenerate(input).Routes[0].Process.Steps
+	es, ok := steps[2].(*ir.EnrichStep)
+	if !ok {
+		t.Fatalf("expected *ir.EnrichStep, got %T", steps[2])
+	}
+	data, _ := json.Marshal(es)
+	if want := `{"bind":"full","enrich":["user","profile"]}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+}
+
+func TestGenerateForward(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> forward("http://users-svc/users/{id}") as upstream  ~> 502 { error: "unavailable" }
+  |> respond upstream.status { ...upstream.body }`
+
+	steps := parseAndGenerate(input).Routes[0].Process.Steps
+	fs, ok := steps[0].(*ir.ForwardStep)
+	if !ok {
+		t.Fatalf("expected *ir.ForwardStep, got %T", steps[0])
+	}
+	data, _ := json.Marshal(fs)
+	if want := `{"bind":"upstream","forward":{"url":"http://users-svc/users/{id}"},"error":{"status":502,"body":{"error":"unavailable"}}}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+}
+
+func TestGenerateMatchArmWhen(t *testing.T) {
+	input := `GET /accounts
+  |> match role {
+       "user" when account.active: fetch(User, id)
+       "user" when !account.active: ~> 403 { error: "inactive" }
+       "admin": fetch(Admin, id)
+     } as account
+  |> respond 200 { id: account.id }`
+
+	root := parseAndGenerate(input)
+	arms := root.Routes[0].Process.Steps[0].(*ir.MatchProcessStep).Match.Arms
+
+	if arms[0].When != "account.active" {
+		t.Fatalf("expected when 'account.active', got %#v", arms[0].When)
+	}
+	not, ok := arms[1].When.(map[string]string)
+	if !ok || not["not"] != "account.active" {
+		t.Fatalf("expected negated when, got %#v", arms[1].When)
+	}
+	if arms[2].When != nil {
+		t.Fatalf("expected plain arm without when, got %#v", arms[2].When)
+	}
+	data, _ := json.Marshal(arms[2])
+	if strings.Contains(string(data), "when") {
+		t.Fatalf("expected no when key in JSON, got %s", data)
+	}
+}
+
+func TestGenerateMatchArmRespond(t *testing.T) {
+	input := `GET /items/{id}
+  |> input(id: path.id)
+  |> fetch(Item, id) as item
+  |> match item.status {
+       404: respond 404 { error: "gone" }
+       _:   respond 200 { ok: true }
+     }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("expected a match whose arms all respond to end the route, got %v", errs)
+	}
+	if root.Routes[0].Output != nil {
+		t.Errorf("expected no route output, got %+v", root.Routes[0].Output)
+	}
+	match := root.Routes[0].Process.Steps[1].(*ir.MatchProcessStep).Match
+
+	data, _ := json.Marshal(match.Arms)
+	expected := `[{"pattern":{"value":404},"output":{"status":404,"content_type":"application/json","body":{"error":"gone"}}}]`
+	if string(data) != expected {
+		t.Fatalf("unexpected arms:\n%s\nexpected:\n%s", data, expected)
+	}
+	data, _ = json.Marshal(match.Default)
+	expected = `{"pattern":null,"output":{"status":200,"content_type":"application/json","body":{"ok":"true"}}}`
+	if string(data) != expected {
+		t.Fatalf("unexpected default:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestGenerateMatchArmRespondNeedsWildcard(t *testing.T) {
+	input := `GET /items/{id}
+  |> input(id: path.id)
+  |> match id {
+       404: respond 404 { error: "gone" }
+     }`
+
+	_, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 1 || errs[0].Message != "route GET /items/{id} has no response" {
+		t.Fatalf("expected a match without a wildcard to need a respond, got %v", errs)
+	}
+}
+
+func TestGenerateMatchNegativeNumbers(t *testing.T) {
+	input := `GET /accounts
+  |> match balance {
+       -1: fetch(Overdrawn, id)
+       -100..0: fetch(Low, id)
+     } as account
+  |> respond 200 { id: account.id }`
+
+	root := parseAndGenerate(input)
+	arms := root.Routes[0].Process.Steps[0].(*ir.MatchProcessStep).Match.Arms
+
+	want := []string{`{"value":-1}`, `{"range":{"min":-100,"max":0}}`}
+	for i, w := range want {
+		data, _ := json.Marshal(arms[i].Pattern)
+		if string(data) != w {
+			t.Errorf("arm %d: expected pattern %s, got %s", i, w, data)
+		}
+	}
+}
+
+func TestGenerateRegexFlags(t *testing.T) {
+	input := `GET /accounts
+  |> match role {
+       /^admin$/i: fetch(Admin, id)
+       /^user/: fetch(User, id)
+     } as account
+  |> respond 200 { id: account.id }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	arms := root.Routes[0].Process.Steps[0].(*ir.MatchProcessStep).Match.Arms
+
+	re := arms[0].Pattern.(*ir.PatternRegex)
+	if re.Regex != "^admin$" || re.Flags != "i" {
+		t.Fatalf("expected regex ^admin$ with flags i, got %+v", re)
+	}
+	data, _ := json.Marshal(arms[1].Pattern)
+	if string(data) != `{"regex":"^user"}` {
+		t.Fatalf("expected regex without flags, got %s", data)
+	}
+}
+
+func TestGenerateInvalidRegex(t *testing.T) {
+	input := `GET /accounts
+  |> match role {
+       /^(admin/: fetch(Admin, id)
+     } as account
+  |> respond 200 { id: account.id }`
+
+	_, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	if errs[0].Pos.Line != 3 || !strings.Contains(errs[0].Message, "invalid regex /^(admin/") {
+		t.Fatalf("unexpected error %v", errs[0])
+	}
+	if !strings.HasPrefix(errs[0].Error(), "test.rever:3:8: ") {
+		t.Fatalf("expected formatted position, got %q", errs[0].Error())
+	}
+}
+
+func TestGenerateValidateOneOf(t *testing.T) {
+	input := `POST /users
+  |> input(role: body.role, plan: body.plan)
+  |> validate(role: string & oneOf("user", "admin", "guest"), plan: string & oneOf("free"))  ~> 400 { error: "invalid" }
+  |> respond 201 { role: role }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	rules := root.Routes[0].Validate.Rules
+
+	role := rules.Get("role")
+	if role.Type != "string" || len(role.Enum) != 3 || role.Enum[0] != "user" || role.Enum[2] != "guest" {
+		t.Fatalf("expected role enum [user admin guest], got %+v", role)
+	}
+	if plan := rules.Get("plan"); len(plan.Enum) != 1 || plan.Enum[0] != "free" {
+		t.Fatalf("expected single-value enum [free], got %+v", plan)
+	}
+
+	data, _ := json.Marshal(role)
+	if !strings.Contains(string(data), `"enum":["user","admin","guest"]`) {
+		t.Fatalf("expected enum array in JSON, got %s", data)
+	}
+}
+
+func TestGenerateValidateOneOfNonString(t *testing.T) {
+	input := `POST /users
+  |> input(level: body.level)
+  |> validate(level: int & oneOf(1, 2))  ~> 400 { error: "invalid" }
+  |> respond 201 { level: level }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 1 || !strings.Contains(errs[0].Message, "oneOf accepts only string literals") {
+		t.Fatalf("expected oneOf error, got %v", errs)
+	}
+	if errs[0].Pos.Line != 3 || errs[0].Pos.Column != 28 {
+		t.Fatalf("expected error at 3:28, got %d:%d", errs[0].Pos.Line, errs[0].Pos.Column)
+	}
+	if enum := root.Routes[0].Validate.Rules.Get("level").Enum; enum != nil {
+		t.Fatalf("expected no enum, got %v", enum)
+	}
+}
+
+func TestGenerateValidateBetween(t *testing.T) {
+	input := `POST /users
+  |> input(age: body.age)
+  |> validate(age: int & between(1, 100))  ~> 400 { error: "invalid" }
+  |> respond 201 { age: age }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	data, _ := json.Marshal(root.Routes[0].Validate.Rules.Get("age"))
+	if want := `{"type":"int","min":1,"max":100}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+}
+
+func TestGenerateValidateBetweenErrors(t *testing.T) {
+	tests := []struct {
+		constraint string
+		message    string
+	}{
+		{"between(1)", "between requires two integer arguments: between(min, max)"},
+		{"between(1, 2, 3)", "between requires two integer arguments: between(min, max)"},
+		{`between(1, "9")`, "between requires two integer arguments: between(min, max)"},
+		{"between(10, 1)", "between min 10 is greater than max 1"},
+	}
+	for _, tt := range tests {
+		input := "POST /users\n  |> input(age: body.age)\n  |> validate(age: int & " + tt.constraint + ")\n  |> respond 201"
+		root, errs := GenerateWithErrors(parse(t, input))
+		if len(errs) != 1 || errs[0].Message != tt.message {
+			t.Errorf("%s: expected %q, got %v", tt.constraint, tt.message, errs)
+			continue
+		}
+		if errs[0].Pos.Line != 3 || errs[0].Pos.Column != 26 {
+			t.Errorf("%s: expected error at 3:26, got %d:%d", tt.constraint, errs[0].Pos.Line, errs[0].Pos.Column)
+		}
+		if rule := root.Routes[0].Validate.Rules.Get("age"); rule.Min != nil || rule.Max != nil {
+			t.Errorf("%s: expected no bounds, got %+v", tt.constraint, rule)
+		}
+	}
+}
+
+func TestGenerateValidateLength(t *testing.T) {
+	tests := []struct {
+		constraint string
+		want       string
+	}{
+		{"minLength(3)", `{"type":"string","min_length":3}`},
+		{"minLength(3) & maxLength(20)", `{"type":"string","min_length":3,"max_length":20}`},
+		{"length(4)", `{"type":"string","min_length":4,"max_length":4}`},
+	}
+	for _, tt := range tests {
+		input := "POST /users\n  |> input(name: body.name)\n  |> validate(name: string & " + tt.constraint + ")\n  |> respond 201"
+		root, errs := GenerateWithErrors(parse(t, input))
+		if len(errs) != 0 {
+			t.Fatalf("%s: unexpected errors: %v", tt.constraint, errs)
+		}
+		data, _ := json.Marshal(root.Routes[0].Validate.Rules.Get("name"))
+		if string(data) != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.constraint, tt.want, data)
+		}
+	}
+}
+
+func TestGenerateValidateLengthErrors(t *testing.T) {
+	tests := []struct {
+		constraint string
+		message    string
+	}{
+		{`minLength("3")`, "minLength requires a single integer argument"},
+		{"maxLength()", "maxLength requires a single integer argument"},
+		{"length(1, 2)", "length requires a single integer argument"},
+	}
+	for _, tt := range tests {
+		input := "POST /users\n  |> input(name: body.name)\n  |> validate(name: string & " + tt.constraint + ")\n  |> respond 201"
+		_, errs := GenerateWithErrors(parse(t, input))
+		if len(errs) != 1 || errs[0].Message != tt.message {
+			t.Errorf("%s: expected %q, got %v", tt.constraint, tt.message, errs)
+		}
+	}
+}
+
+func TestGenerateValidateConstraintArgErrors(t *testing.T) {
+	tests := []struct {
+		rule    string
+		message string
+	}{
+		{`id: int & min("abc")`, "min() expects an integer, got string"},
+		{"id: int & max(limit)", "max() expects an integer, got ident"},
+		{"id: int & min()", "min() expects one argument, got 0"},
+		{"id: int & max(9.5)", "max() expects an integer, got float"},
+		{`id: float & min("abc")`, "min() expects a number, got string"},
+		{"id: float & between(2.5, 1)", "between min 2.5 is greater than max 1"},
+		{"id: string & format(123)", "format() expects an identifier, got int"},
+		{`id: string & format("email")`, "format() expects an identifier, got string"},
+	}
+	for _, tt := range tests {
+		input := "POST /users\n  |> input(id: body.id)\n  |> validate(" + tt.rule + ")\n  |> respond 201"
+		root, errs := GenerateWithErrors(parse(t, input))
+		if len(errs) != 1 || errs[0].Message != tt.message {
+			t.Errorf("%s: expected %q, got %v", tt.rule, tt.message, errs)
+			continue
+		}
+		if errs[0].Pos.Line != 3 || errs[0].Pos.Column != 15+strings.Index(tt.rule, "& ")+2 {
+			t.Errorf("%s: expected the error at the constraint, got %d:%d", tt.rule, errs[0].Pos.Line, errs[0].Pos.Column)
+		}
+		if rule := root.Routes[0].Validate.Rules.Get("id"); rule.Min != nil || rule.Max != nil || rule.MinF != nil || rule.MaxF != nil || rule.Format != "" {
+			t.Errorf("%s: expected the constraint to be dropped, got %+v", tt.rule, rule)
+		}
+	}
+}
+
+func TestGenerateValidateFloatBounds(t *testing.T) {
+	input := `POST /places
+  |> input(lat: body.lat, lng: body.lng, rating: body.rating, count: body.count)
+  |> validate(lat: float & min(-90.0) & max(90.0), lng: between(-180, 180.5) & float, rating: float & min(0), count: int & min(1) & max(10))
+  |> respond 201`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	rules := root.Routes[0].Validate.Rules
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"lat", `{"type":"float","min_float":-90,"max_float":90}`},
+		{"lng", `{"type":"float","min_float":-180,"max_float":180.5}`},
+		{"rating", `{"type":"float","min_float":0}`},
+		{"count", `{"type":"int","min":1,"max":10}`},
+	}
+	for _, tt := range tests {
+		if data, _ := json.Marshal(rules.Get(tt.field)); string(data) != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.field, tt.want, data)
+		}
+	}
+}
+
+func TestGenerateValidateFormat(t *testing.T) {
+	input := "POST /users\n  |> input(email: body.email)\n  |> validate(email: string & format(email))\n  |> respond 201"
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if f := root.Routes[0].Validate.Rules.Get("email").Format; f != "email" {
+		t.Errorf("expected format email, got %q", f)
+	}
+
+	input = "POST /users\n  |> input(code: body.code)\n  |> validate(code: string & format(isbn))\n  |> respond 201"
+	root, errs = GenerateWithErrors(parse(t, input))
+	want := `unknown format "isbn": expected one of email, uuid, url, date, datetime, ipv4, ipv6 or hostname`
+	if len(errs) != 1 || !errs[0].Warning || errs[0].Message != want {
+		t.Fatalf("expected the warning %q, got %v", want, errs)
+	}
+	if got := errs[0].Error(); got != "test.rever:3:30: warning: "+want {
+		t.Errorf("expected the warning to be labelled, got %q", got)
+	}
+	if f := root.Routes[0].Validate.Rules.Get("code").Format; f != "isbn" {
+		t.Errorf("expected the unknown format to pass through, got %q", f)
+	}
+}
+
+func TestGenerateMinMaxOnStringWarns(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("..", "..", "testdata", "string_length.rever"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, errs := GenerateWithErrors(parse(t, string(input)))
+	want := []string{
+		`test.rever:6:27: warning: min on string field "nickname" bounds a number, not the length; use minLength`,
+		`test.rever:6:36: warning: max on string field "nickname" bounds a number, not the length; use maxLength`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d warnings, got %v", len(want), errs)
+	}
+	for i, e := range errs {
+		if e.Error() != want[i] {
+			t.Errorf("expected %q, got %q", want[i], e.Error())
+		}
+	}
+	// The bounds are still written; the warning only flags the likely mistake.
+	if rule := root.Routes[0].Validate.Rules.Get("nickname"); rule.Min == nil || *rule.Min != 1 || rule.MinLength != nil {
+		t.Errorf("expected min 1 to be kept, got %+v", rule)
+	}
+}
+
+func TestGenerateDuplicateFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		line  int
+		col   int
+	}{
+		{
+			name: "input",
+			input: `GET /users/{id}
+  |> input(id: path.id, id: query.id)
+  |> respond 200 { id: id }`,
+			want: `duplicate input field "id" (first declared at line 2)`,
+			line: 2, col: 25,
+		},
+		{
+			name: "validate",
+			input: `POST /users
+  |> input(name: body.name)
+  |> validate(name: string & minLength(1), name: string & maxLength(50))  ~> 400 { error: "invalid" }
+  |> respond 201 { name: name }`,
+			want: `duplicate validate field "name" (first declared at line 3)`,
+			line: 3, col: 44,
+		},
+		{
+			name: "transform",
+			input: `POST /users
+  |> input(name: body.name)
+  |> transform(name: trim(name), name: lower(name))
+  |> respond 201 { name: name }`,
+			want: `duplicate transform target "name" (first declared at line 3)`,
+			line: 3, col: 34,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := GenerateWithErrors(parse(t, tt.input))
+			if len(errs) != 1 || errs[0].Message != tt.want {
+				t.Fatalf("expected %q, got %v", tt.want, errs)
+			}
+			if errs[0].Pos.Line != tt.line || errs[0].Pos.Column != tt.col {
+				t.Fatalf("expected error at %d:%d, got %d:%d", tt.line, tt.col, errs[0].Pos.Line, errs[0].Pos.Column)
+			}
+		})
+	}
+}
+
+func TestGenerateValidateOptional(t *testing.T) {
+	input := `PATCH /users/{id}
+  |> input(id: path.id, name: body.name)
+  |> validate(id: int, name?: string & min(1))  ~> 400 { error: "invalid" }
+  |> respond 200 { id: id }`
+
+	rules := parseAndGenerate(input).Routes[0].Validate.Rules
+	if rules.Get("id").Optional {
+		t.Fatal("expected id rule to be required")
+	}
+	name := rules.Get("name")
+	if !name.Optional || name.Min == nil || *name.Min != 1 {
+		t.Fatalf("expected optional name rule with min 1, got %+v", name)
+	}
+
+	data, _ := json.Marshal(rules)
+	if want := `{"id":{"type":"int"},"name":{"optional":true,"type":"string","min":1}}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+}
+
+func TestGenerateValidateType(t *testing.T) {
+	input := `enum Role { user, admin }
+
+type User {
+  name: string
+  age: int
+  role: Role = "user"
+}
+
+POST /users
+  |> input(name: body.name, age: body.age, role: body.role)
+  |> validate(User)  ~> 400 { error: "invalid" }
+  |> respond 201 { name: name }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	v := root.Routes[0].Validate
+	data, _ := json.Marshal(v.Rules)
+	want := `{"name":{"type":"string"},"age":{"type":"int"},"role":{"optional":true,"type":"string","enum":["user","admin"]}}`
+	if string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+	if v.Error == nil || v.Error.Status != 400 {
+		t.Errorf("expected the error response, got %+v", v.Error)
+	}
+
+	_, errs = GenerateWithErrors(parse(t, "POST /users\n  |> validate(Account)\n  |> respond 201"))
+	if len(errs) != 1 || errs[0].Message != `unknown type "Account" in validate` {
+		t.Errorf("expected an unknown type error, got %v", errs)
+	}
+}
+
+func TestGenerateRouteWithoutRespond(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user  ~> 404 { error: "not found" }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 1 || errs[0].Message != "route GET /users/{id} has no response" {
+		t.Fatalf("expected no-response error, got %v", errs)
+	}
+	if errs[0].Pos.Line != 1 || errs[0].Pos.Column != 1 {
+		t.Fatalf("expected error at 1:1, got %d:%d", errs[0].Pos.Line, errs[0].Pos.Column)
+	}
+	if root.Routes[0].Output != nil {
+		t.Fatalf("expected no output, got %+v", root.Routes[0].Output)
+	}
+}
+
+func TestGenerateValidatePattern(t *testing.T) {
+	input := `POST /posts
+  |> input(slug: body.slug, code: body.code)
+  |> validate(slug: string & pattern(/^[a-z-]+$/), code: string & pattern(/^[a-f0-9]+$/i))  ~> 400 { error: "invalid" }
+  |> respond 201 { slug: slug }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	rules := root.Routes[0].Validate.Rules
+	if got := rules.Get("slug").Pattern; got != "^[a-z-]+$" {
+		t.Fatalf("expected pattern ^[a-z-]+$, got %q", got)
+	}
+	if got := rules.Get("code").Pattern; got != "(?i)^[a-f0-9]+$" {
+		t.Fatalf("expected pattern (?i)^[a-f0-9]+$, got %q", got)
+	}
+}
+
+func TestGenerateValidatePatternInvalid(t *testing.T) {
+	input := `POST /posts
+  |> input(slug: body.slug)
+  |> validate(slug: string & pattern(/([a-z/))  ~> 400 { error: "invalid" }
+  |> respond 201 { slug: slug }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 1 || !strings.Contains(errs[0].Message, "invalid regex /([a-z/") {
+		t.Fatalf("expected invalid regex error, got %v", errs)
+	}
+	if errs[0].Pos.Line != 3 {
+		t.Fatalf("expected error on line 3, got %d", errs[0].Pos.Line)
+	}
+	if got := root.Routes[0].Validate.Rules.Get("slug").Pattern; got != "" {
+		t.Fatalf("expected no pattern, got %q", got)
+	}
+}
+
+func TestGenerateAuthScopesOptional(t *testing.T) {
+	input := `GET /me
+  auth(bearer, scopes: ["read:user"], realm: "api", optional) as viewer
+  |> respond 200 { ok: "true" }
+
+GET /admin
+  auth(bearer, roles: ["admin"])
+  |> respond 200 { ok: "true" }`
+
+	root := parseAndGenerate(input)
+
+	data, _ := json.Marshal(root.Routes[0].Auth)
+	if want := `{"method":"bearer","scopes":["read:user"],"realm":"api","optional":true,"bind":"viewer"}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+	if admin := root.Routes[1].Auth; admin.Optional != nil || admin.Scopes != nil {
+		t.Fatalf("expected required auth without scopes, got %+v", admin)
+	}
+}
+
+func TestGenerateEnvArgs(t *testing.T) {
+	input := `GET /me
+  auth(bearer, realm: env("AUTH_REALM"))
+  cors(origins: ["https://app.example.com", env("ADMIN_ORIGIN")])
+  |> respond 200`
+
+	root, errs := GenerateWithOptions(parse(t, input), Options{GenerateCORSPreflight: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	data, _ := json.Marshal(root.Routes[0].Auth)
+	if want := `{"method":"bearer","realm":{"$env":"AUTH_REALM"}}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+	data, _ = json.Marshal(root.Routes[0].CORS)
+	if want := `{"origins":["https://app.example.com",{"$env":"ADMIN_ORIGIN"}]}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+	// The preflight cannot spell out an origin known only at serve time.
+	if h := root.Routes[1].Output.Headers; h["Access-Control-Allow-Origin"] != "" || h["Vary"] != "Origin" || h["Access-Control-Allow-Methods"] != "GET" {
+		t.Errorf("expected a preflight without a static origin, got %v", h)
+	}
+
+	// A string that reads like a call is still a string.
+	root, errs = GenerateWithErrors(parse(t, `GET /me
+  auth(bearer, realm: """env("AUTH_REALM")""")
+  cors(origins: ["""env("ADMIN_ORIGIN")"""])
+  |> respond 200`))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	data, _ = json.Marshal(root.Routes[0].Auth)
+	if want := `{"method":"bearer","realm":"env(\"AUTH_REALM\")"}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+	data, _ = json.Marshal(root.Routes[0].CORS)
+	if want := `{"origins":["env(\"ADMIN_ORIGIN\")"]}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+}
+
+func TestGenerateCORSOriginPatterns(t *testing.T) {
+	input := `GET /a
+  cors(origins: ["https://app.example.com"])
+  |> respond 200
+
+GET /b
+  cors(origins: ["https://*.example.com", "*.example.org"])
+  |> respond 200
+
+GET /c
+  cors(origins: [/^https:\/\/(www|app)\.example\.com$/, /^https:\/\/.*\.test$/i])
+  |> respond 200`
+
+	root, errs := GenerateWithOptions(parse(t, input), Options{GenerateCORSPreflight: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	wants := []string{
+		`{"origins":["https://app.example.com"]}`,
+		`{"origins":[{"pattern":"^https://[^./:]+\\.example\\.com$"},{"pattern":"^https?://[^./:]+\\.example\\.org$"}]}`,
+		`{"origins":[{"pattern":"^https:\\/\\/(www|app)\\.example\\.com$"},{"pattern":"(?i)^https:\\/\\/.*\\.test$"}]}`,
+	}
+	for i, want := range wants {
+		data, _ := json.Marshal(root.Routes[i].CORS)
+		if string(data) != want {
+			t.Errorf("route %d: expected %s, got %s", i, want, data)
+		}
+	}
+	// A pattern cannot be spelled out as a preflight's allowed origin.
+	if h := root.Routes[4].Output.Headers; root.Routes[4].RouteInfo.Path != "/b" || h["Access-Control-Allow-Origin"] != "" {
+		t.Errorf("expected the /b preflight without a static origin, got %s %v", root.Routes[4].RouteInfo.Path, h)
+	}
+
+	_, errs = GenerateWithErrors(parse(t, `GET /d
+  cors(origins: [/^https:\/\/(app$/])
+  |> respond 200`))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "test.rever:2:8: invalid regex /^https:\\/\\/(app$/") {
+		t.Errorf("expected an invalid regex origin to be an error, got %v", errs)
+	}
+
+	// A quoted string is an origin however much it looks like a regex.
+	root, errs = GenerateWithErrors(parse(t, `GET /e
+  cors(origins: ["/app/", /^https:\/\/app$/])
+  |> respond 200`))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	data, _ := json.Marshal(root.Routes[0].CORS)
+	if want := `{"origins":["/app/",{"pattern":"^https:\\/\\/app$"}]}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+}
+
+func TestGenerateDirectiveFlags(t *testing.T) {
+	tests := []struct {
+		directive string
+		want      string
+	}{
+		{`cors(origins: ["*"], credentials)`, `{"origins":["*"],"credentials":true}`},
+		{`cors(origins: ["*"], credentials: true)`, `{"origins":["*"],"credentials":true}`},
+		{`cors(origins: ["*"], credentials: false)`, `{"origins":["*"],"credentials":false}`},
+		{`cache(max-age: 60, private)`, `{"max_age":60,"visibility":"private"}`},
+		{`cache(no-cache, no-store: false)`, `{"no_cache":true,"no_store":false}`},
+		{`auth(bearer, optional)`, `{"method":"bearer","optional":true}`},
+		{`auth(bearer, optional: false)`, `{"method":"bearer","optional":false}`},
+	}
+	for _, tt := range tests {
+		input := "GET /users\n  " + tt.directive + "\n  |> respond 200"
+		root, errs := GenerateWithErrors(parse(t, input))
+		if len(errs) != 0 {
+			t.Errorf("%s: unexpected errors: %v", tt.directive, errs)
+			continue
+		}
+		r := root.Routes[0]
+		var got interface{} = r.CORS
+		switch {
+		case r.Cache != nil:
+			got = r.Cache
+		case r.Auth != nil:
+			got = r.Auth
+		}
+		if data, _ := json.Marshal(got); string(data) != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.directive, tt.want, data)
+		}
+	}
+
+	_, errs := GenerateWithErrors(parse(t, "GET /users\n  cors(credentials: 1)\n  |> respond 200"))
+	if len(errs) != 1 || errs[0].Error() != "test.rever:2:8: credentials must be true or false, or written alone to set it" {
+		t.Errorf("expected a non-boolean flag value to be an error, got %v", errs)
+	}
+}
+
+func TestGenerateAuthAPIKey(t *testing.T) {
+	input := `GET /data
+  auth(apikey, in: header, name: "X-API-Key")
+  |> respond 200 { ok: "true" }
+
+GET /legacy
+  auth(basic, realm: "legacy")
+  |> respond 200 { ok: "true" }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	data, _ := json.Marshal(root.Routes[0].Auth)
+	if want := `{"method":"apikey","in":"header","name":"X-API-Key"}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+	data, _ = json.Marshal(root.Routes[1].Auth)
+	if want := `{"method":"basic","realm":"legacy"}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+}
+
+func TestGenerateAuthAPIKeyErrors(t *testing.T) {
+	input := `GET /data
+  auth(bearer, in: header, name: "X-API-Key")
+  |> respond 200 { ok: "true" }
+
+GET /other
+  auth(apikey, in: body)
+  |> respond 200 { ok: "true" }`
+
+	_, errs := GenerateWithErrors(parse(t, input))
+	want := []string{
+		"in is only valid for apikey auth, not bearer",
+		"name is only valid for apikey auth, not bearer",
+		`auth in must be header, query or cookie, got "body"`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %v", len(want), errs)
+	}
+	for i, msg := range want {
+		if errs[i].Message != msg {
+			t.Errorf("error %d: expected %q, got %q", i, msg, errs[i].Message)
+		}
+	}
+	if errs[0].Pos.Line != 2 || errs[0].Pos.Column != 16 {
+		t.Errorf("expected first error at 2:16, got %d:%d", errs[0].Pos.Line, errs[0].Pos.Column)
+	}
+}
+
+func TestGenerateInlineDefaults(t *testing.T) {
+	input := `defaults
+  cors(origins: ["https://app.example.com"], credentials)
+  auth(bearer, roles: ["user"])
+
+GET /api/users
+  |> respond 200 { ok: "true" }
+
+GET /public/health
+  cors(none)
+  auth(none)
+  |> respond 200 { ok: "true" }
+
+GET /api/admin
+  cors(origins: ["https://admin.example.com"])
+  |> respond 200 { ok: "true" }
+
+GET /api/teams
+  |> respond 200 { ok: "true" }`
+
+	root, errs := GenerateWithOptions(parse(t, input), Options{InlineDefaults: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	users, health, admin, teams := root.Routes[0], root.Routes[1], root.Routes[2], root.Routes[3]
+
+	// No cors: inherits the defaults.
+	cors := users.CORS
+	if cors == nil || len(cors.Origins) != 1 || cors.Origins[0] != "https://app.example.com" {
+		t.Fatalf("expected inherited cors, got %#v", users.CORS)
+	}
+	if cors == root.Defaults.CORS {
+		t.Fatal("expected inherited cors to be a copy")
+	}
+	if users.Auth == nil || users.Auth.Method != "bearer" {
+		t.Fatalf("expected inherited auth, got %+v", users.Auth)
+	}
+
+	// cors(none) and auth(none): suppressed.
+	if data, _ := json.Marshal(health); !strings.Contains(string(data), `"cors":null`) {
+		t.Fatalf("expected cors null, got %s", data)
+	}
+	if health.Auth != nil {
+		t.Fatalf("expected no auth, got %+v", health.Auth)
+	}
+
+	// Own cors: kept.
+	if own := admin.CORS; len(own.Origins) != 1 || own.Origins[0] != "https://admin.example.com" || own.Credentials != nil {
+		t.Fatalf("expected route's own cors, got %+v", own)
+	}
+
+	// Routes never share inherited values with each other or the defaults.
+	cors.Origins[0] = "changed"
+	users.Auth.Roles[0] = "changed"
+	if root.Defaults.CORS.Origins[0] != "https://app.example.com" || teams.CORS.Origins[0] != "https://app.example.com" {
+		t.Fatal("expected cors to be deep-copied")
+	}
+	if root.Defaults.Auth.Roles[0] != "user" || teams.Auth.Roles[0] != "user" {
+		t.Fatal("expected auth to be deep-copied")
+	}
+}
+
+func TestGeneratePaginate(t *testing.T) {
+	input := `defaults
+  paginate(limit: 50, max: 200)
+
+GET /users
+  paginate(limit: 20, max: 100, cursor: query.cursor)
+  |> respond 200 { ok: "true" }
+
+GET /teams
+  |> respond 200 { ok: "true" }`
+
+	root, errs := GenerateWithOptions(parse(t, input), Options{InlineDefaults: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	data, _ := json.Marshal(root.Routes[0].Pagination)
+	if want := `{"default_limit":20,"max_limit":100,"cursor_from":"query.cursor"}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+
+	inherited := root.Routes[1].Pagination
+	if inherited == nil || inherited.DefaultLimit != 50 || inherited.MaxLimit != 200 {
+		t.Fatalf("expected pagination inherited from defaults, got %+v", inherited)
+	}
+	if inherited == root.Defaults.Pagination {
+		t.Fatal("expected inherited pagination to be a copy")
+	}
+}
+
+func TestGenerateVersion(t *testing.T) {
+	if v := parseAndGenerate("version 0.2\n\nGET /users\n  |> respond 200").Version; v != "0.2" {
+		t.Errorf("expected the declared version 0.2, got %q", v)
+	}
+	if v := parseAndGenerate("GET /users\n  |> respond 200").Version; v != ir.DefaultVersion {
+		t.Errorf("expected the default version %s, got %q", ir.DefaultVersion, v)
+	}
+}
+
+func TestGenerateMetaBase(t *testing.T) {
+	input := `meta { title: "User API", version: "1.2.0", base: "/api/", public: true }
+
+GET /
+  |> respond 200
+
+group /v1 {
+  GET /users/{id}
+    |> input(id: path.id)
+    |> respond 200 { id: id }
+}`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	data, _ := json.Marshal(root.Meta)
+	if want := `{"base":"/api/","public":true,"title":"User API","version":"1.2.0"}`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+	var paths []string
+	for _, r := range root.Routes {
+		paths = append(paths, r.RouteInfo.Path)
+	}
+	if want := []string{"/api", "/api/v1/users/{id}"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected paths %v, got %v", want, paths)
+	}
+}
+
+func TestGenerateMetaErrors(t *testing.T) {
+	tests := []struct {
+		meta    string
+		message string
+	}{
+		{`base: "api"`, `meta base must be a path starting with "/"`},
+		{"base: 1", `meta base must be a path starting with "/"`},
+		{`base: "/{tenant}"`, "meta base must not contain path parameters or wildcards"},
+		{"title: api.name", "meta title must be a string, integer or boolean literal"},
+		{`title: "a", title: "b"`, `duplicate meta field "title" (first declared at line 1)`},
+	}
+	for _, tt := range tests {
+		input := "meta { " + tt.meta + " }\n\nGET /users\n  |> respond 200"
+		root, errs := GenerateWithErrors(parse(t, input))
+		if len(errs) != 1 || errs[0].Message != tt.message {
+			t.Errorf("%s: expected %q, got %v", tt.meta, tt.message, errs)
+			continue
+		}
+		if path := root.Routes[0].RouteInfo.Path; path != "/users" {
+			t.Errorf("%s: expected the path to stay /users, got %s", tt.meta, path)
+		}
+	}
+}
+
+func TestGenerateAccepts(t *testing.T) {
+	input := `GET /users
+  accepts("application/json")
+  |> respond 200 { ok: "true" }
+
+GET /reports
+  accepts("application/json", "text/csv", "*/*")
+  |> respond 200 { ok: "true" }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	data, _ := json.Marshal(root.Routes[0].Accepts)
+	if want := `["application/json"]`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+	data, _ = json.Marshal(root.Routes[1].Accepts)
+	if want := `["application/json","text/csv","*/*"]`; string(data) != want {
+		t.Fatalf("expected %s, got %s", want, data)
+	}
+}
+
+func TestGenerateAcceptsErrors(t *testing.T) {
+	tests := []struct {
+		directive string
+		message   string
+	}{
+		{"accepts", "accepts requires at least one media type"},
+		{`accepts("json")`, `accepts: "json" is not a media type (want type/subtype)`},
+		{`accepts("*/json")`, `accepts: "*/json" is not a media type (want type/subtype)`},
+		{`accepts("text/html; charset=utf-8")`, `accepts: "text/html; charset=utf-8" is not a media type (want type/subtype)`},
+		{"accepts(json)", `accepts takes media type strings, e.g. accepts("application/json")`},
+	}
+	for _, tt := range tests {
+		input := "GET /users\n  " + tt.directive + "\n  |> respond 200"
+		_, errs := GenerateWithErrors(parse(t, input))
+		if len(errs) != 1 || errs[0].Message != tt.message {
+			t.Errorf("%s: expected %q, got %v", tt.directive, tt.message, errs)
+		}
+	}
+}
+
+func TestGenerateCompress(t *testing.T) {
+	input := `defaults
+  compress(gzip)
+
+GET /reports
+  compress(br, gzip, min: 1024)
+  cache(max-age: 60)
+  |> respond 200 { ok: "true" }
+
+GET /health
+  cache(max-age: 5)
+  |> respond 200 { ok: "true" }`
+
+	root, errs := GenerateWithOptions(parse(t, input), Options{InlineDefaults: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	data, _ := json.Marshal(root.Routes[0].Compress)
+	if want := `{"encodings":["br","gzip"],"min_bytes":1024}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+	if vary := root.Routes[0].Cache.Vary; !reflect.DeepEqual(vary, []string{"Accept-Encoding"}) {
+		t.Errorf("expected a compressed cached route to vary on Accept-Encoding, got %v", vary)
+	}
+
+	inherited := root.Routes[1].Compress
+	if inherited == nil || !reflect.DeepEqual(inherited.Encodings, []string{"gzip"}) || inherited.MinBytes != 0 {
+		t.Fatalf("expected compression inherited from defaults, got %+v", inherited)
+	}
+	if inherited == root.Defaults.Compress {
+		t.Error("expected inherited compression to be a copy")
+	}
+	if vary := root.Routes[1].Cache.Vary; !reflect.DeepEqual(vary, []string{"Accept-Encoding"}) {
+		t.Errorf("expected inherited compression to add Accept-Encoding, got %v", vary)
+	}
+}
+
+func TestGenerateCompressErrors(t *testing.T) {
+	tests := []struct {
+		directive string
+		message   string
+	}{
+		{"compress", "compress requires at least one encoding"},
+		{"compress(min: 512)", "compress requires at least one encoding"},
+		{"compress(lzma)", `compress: unknown encoding "lzma": expected gzip, br, deflate or zstd`},
+		{`compress("gzip")`, `compress: unknown encoding "gzip": expected gzip, br, deflate or zstd`},
+		{"compress(gzip, gzip)", `compress: duplicate encoding "gzip"`},
+		{`compress(gzip, min: "1kb")`, "compress min must be a size in bytes, e.g. min: 1024"},
+		{"compress(gzip, level: 9)", `compress has no argument "level"`},
+	}
+	for _, tt := range tests {
+		input := "GET /users\n  " + tt.directive + "\n  |> respond 200"
+		_, errs := GenerateWithErrors(parse(t, input))
+		if len(errs) != 1 || errs[0].Message != tt.message {
+			t.Errorf("%s: expected %q, got %v", tt.directive, tt.message, errs)
+		}
+	}
+}
+
+func TestGenerateETagFields(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+defaults
+  cache(max-age: 60, etag: [user.id, user.updated_at])
+
+GET /users/{id}
+  cache(etag: hash(user))
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { user }
+
+GET /users/{id}/profile
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { user }`
+
+	root, errs := GenerateWithOptions(parse(t, input), Options{InlineDefaults: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	data, _ := json.Marshal(root.Defaults.Cache.ETag)
+	if want := `{"from":["user.id","user.updated_at"]}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+	data, _ = json.Marshal(root.Routes[0].Cache.ETag)
+	if want := `{"fn":"hash","from":"user"}`; string(data) != want {
+		t.Errorf("expected hash(user) to stay a function etag %s, got %s", want, data)
+	}
+	inlined := root.Routes[1].Cache.ETag.(*ir.ETagFields)
+	if inlined == root.Defaults.Cache.ETag {
+		t.Error("expected the inlined etag to be a copy of the defaults'")
+	}
+	if !reflect.DeepEqual(inlined.From, []string{"user.id", "user.updated_at"}) {
+		t.Errorf("expected the defaults' etag fields, got %v", inlined.From)
+	}
+}
+
+func TestGenerateInferredVary(t *testing.T) {
+	input := `GET /me
+  auth(bearer)
+  cache(max-age: 60, private)
+  |> respond 200 { ok: "true" }
+
+GET /reports
+  auth(bearer)
+  accepts("application/json", "text/csv")
+  cache(max-age: 60, private)
+  |> respond 200 { ok: "true" }
+
+GET /profile
+  auth(bearer)
+  cache(max-age: 60, private, vary: [Cookie])
+  |> respond 200 { ok: "true" }
+
+GET /public
+  accepts("application/json")
+  |> respond 200 { ok: "true" }`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/me", []string{"Authorization"}},
+		{"/reports", []string{"Authorization", "Accept"}},
+		{"/profile", []string{"Cookie"}},
+	}
+	for i, tt := range tests {
+		if got := root.Routes[i].Cache.Vary; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected vary %v, got %v", tt.path, tt.want, got)
+		}
+	}
+	if root.Routes[3].Cache != nil {
+		t.Errorf("expected no cache without a cache directive, got %+v", root.Routes[3].Cache)
+	}
+}
+
+func TestGenerateInferredVaryFromDefaults(t *testing.T) {
+	input := `defaults
+  auth(bearer)
+  cache(max-age: 60, private)
+
+GET /me
+  |> respond 200 { ok: "true" }
+
+GET /health
+  auth(none)
+  |> respond 200 { ok: "true" }`
+
+	root, errs := GenerateWithOptions(parse(t, input), Options{InlineDefaults: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if got := root.Routes[0].Cache.Vary; !reflect.DeepEqual(got, []string{"Authorization"}) {
+		t.Errorf("expected the inherited auth to add Authorization, got %v", got)
+	}
+	if got := root.Routes[1].Cache.Vary; got != nil {
+		t.Errorf("expected no vary without auth, got %v", got)
+	}
+	if root.Defaults.Cache.Vary != nil {
+		t.Errorf("expected the defaults to be left alone, got %v", root.Defaults.Cache.Vary)
+	}
+}
+
+func TestGenerateDefaultsNotInlinedByDefault(t *testing.T) {
+	input := `defaults
+  cors(origins: ["*"])
+
+GET /api/users
+  |> respond 200 { ok: "true" }`
+
+	root := Generate(parse(t, input))
+	if root.Routes[0].CORS != nil {
+		t.Fatalf("expected no cors on route, got %#v", root.Routes[0].CORS)
+	}
+}
+
+func TestGenerateCORSStates(t *testing.T) {
+	input := `GET /unset
+  |> respond 200
+
+GET /configured
+  cors(origins: ["https://app.example.com"])
+  |> respond 200
+
+GET /disabled
+  cors(none)
+  |> respond 200`
+
+	root, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	tests := []struct {
+		path string
+		cors string // the cors entry, or "" for none
+	}{
+		{"/unset", ""},
+		{"/configured", `{"origins":["https://app.example.com"]}`},
+		{"/disabled", "null"},
+	}
+	for i, tt := range tests {
+		data, err := json.Marshal(root.Routes[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatal(err)
+		}
+		cors, ok := fields["cors"]
+		switch {
+		case tt.cors == "" && ok:
+			t.Errorf("%s: expected no cors key, got %s", tt.path, cors)
+		case tt.cors != "" && string(cors) != tt.cors:
+			t.Errorf("%s: expected cors %s, got %s", tt.path, tt.cors, data)
+		}
+	}
+	if r := root.Routes[2]; !r.CORSDisabled || r.CORS != nil || root.Routes[0].CORSDisabled {
+		t.Errorf("expected only cors(none) to disable CORS, got %+v", r)
+	}
+}
+
+func TestGenerateMethodDefaults(t *testing.T) {
+	input := `defaults
+  auth(none)
+  cache(max-age: 60)
+
+defaults for POST, DELETE { auth(bearer) }
+
+GET /users
+  |> respond 200
+
+POST /users
+  |> respond 201
+
+DELETE /users/{id}
+  auth(apikey)
+  |> respond 204`
+
+	root, errs := GenerateWithOptions(parse(t, input), Options{InlineDefaults: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if d := root.MethodDefaults["POST"]; d == nil || d.Auth == nil || d.Auth.Method != "bearer" || root.MethodDefaults["DELETE"] != d {
+		t.Fatalf("expected bearer defaults for POST and DELETE, got %+v", root.MethodDefaults)
+	}
+	if _, ok := root.MethodDefaults["GET"]; ok {
+		t.Fatal("expected no method defaults for GET")
+	}
+
+	get, post, del := root.Routes[0], root.Routes[1], root.Routes[2]
+	if get.Auth != nil {
+		t.Fatalf("expected GET to keep auth(none) from the defaults, got %+v", get.Auth)
+	}
+	if post.Auth == nil || post.Auth.Method != "bearer" {
+		t.Fatalf("expected POST to inherit bearer auth, got %+v", post.Auth)
+	}
+	if del.Auth == nil || del.Auth.Method != "apikey" {
+		t.Fatalf("expected DELETE to keep its own auth, got %+v", del.Auth)
+	}
+	for _, r := range root.Routes {
+		if r.Cache == nil || *r.Cache.MaxAge != 60 {
+			t.Fatalf("expected %s to inherit the unscoped cache, got %+v", r.RouteInfo.Method, r.Cache)
+		}
+	}
+
+	data, _ := json.Marshal(root)
+	if !strings.Contains(string(data), `"method_defaults":{"DELETE":{"auth":{"method":"bearer"}},"POST":{"auth":{"method":"bearer"}}}`) {
+		t.Fatalf("expected method_defaults in JSON, got %s", data)
+	}
+}
+
+func TestGenerateDuplicateMethodDefaults(t *testing.T) {
+	input := `defaults for POST { auth(bearer) }
+defaults for PUT, POST { auth(apikey) }
+
+POST /users
+  |> respond 201`
+
+	_, errs := GenerateWithErrors(parse(t, input))
+	if len(errs) != 1 || errs[0].Message != `duplicate defaults for "POST" (first declared at line 1)` {
+		t.Fatalf("expected duplicate defaults error, got %v", errs)
+	}
+	if errs[0].Pos.Line != 2 || errs[0].Pos.Column != 19 {
+		t.Fatalf("expected error at 2:19, got %d:%d", errs[0].Pos.Line, errs[0].Pos.Column)
+	}
+}
+
+func TestGenerateCORSPreflight(t *testing.T) {
+	input := `GET /api/users
+  cors(origins: ["https://app.example.com"], headers: ["Authorization"], max-age: 600, credentials)
+  |> respond 200 { ok: "true" }
+
+POST /api/users
+  cors(origins: ["https://app.example.com"])
+  |> respond 201 { ok: "true" }
+
+GET /api/teams
+  cors(origins: ["*"], methods: ["GET"])
+  |> respond 200 { ok: "true" }
+
+OPTIONS /api/teams
+  |> respond 204
+
+GET /public/health
+  |> respond 200 { ok: "true" }`
+
+	root, errs := GenerateWithOptions(parse(t, input), Options{GenerateCORSPreflight: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(root.Routes) != 6 {
+		t.Fatalf("expected 1 synthesized route, got %d routes", len(root.Routes))
+	}
+
+	pre := root.Routes[5]
+	if pre.RouteInfo.Method != "OPTIONS" || pre.RouteInfo.Path != "/api/users" {
+		t.Fatalf("expected OPTIONS /api/users, got %s %s", pre.RouteInfo.Method, pre.RouteInfo.Path)
+	}
+	if pre.Output.Status != 204 {
+		t.Fatalf("expected status 204, got %d", pre.Output.Status)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Methods":     "GET, POST",
+		"Access-Control-Allow-Headers":     "Authorization",
+		"Access-Control-Max-Age":           "600",
+		"Access-Control-Allow-Credentials": "true",
+	}
+	if !reflect.DeepEqual(pre.Output.Headers, want) {
+		t.Fatalf("expected headers %v, got %v", want, pre.Output.Headers)
+	}
+}
+
+func TestGenerateCORSPreflightSeveralOrigins(t *testing.T) {
+	input := `GET /a
+  cors(origins: ["https://a.com", "https://b.com"])
+  |> respond 200`
+
+	root, errs := GenerateWithOptions(parse(t, input), Options{GenerateCORSPreflight: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	// The header holds one origin; the runtime echoes the allowed one.
+	want := map[string]string{
+		"Vary":                         "Origin",
+		"Access-Control-Allow-Methods": "GET",
+	}
+	if h := root.Routes[1].Output.Headers; !reflect.DeepEqual(h, want) {
+		t.Errorf("expected headers %v, got %v", want, h)
+	}
+}
+
+func TestGenerateCORSPreflightFromDefaults(t *testing.T) {
+	input := `defaults
+  cors(origins: ["*"], methods: ["GET", "POST"])
+
+GET /api/users
+  |> respond 200 { ok: "true" }
+
+GET /public/health
+  cors(none)
+  |> respond 200 { ok: "true" }`
+
+	root, _ := GenerateWithOptions(parse(t, input), Options{GenerateCORSPreflight: true})
+	if len(root.Routes) != 3 {
+		t.Fatalf("expected 3 routes, got %d", len(root.Routes))
+	}
+	pre := root.Routes[2]
+	if pre.RouteInfo.Path != "/api/users" || pre.Output.Headers["Access-Control-Allow-Methods"] != "GET, POST" {
+		t.Fatalf("expected preflight for /api/users from defaults, got %s %v", pre.RouteInfo.Path, pre.Output.Headers)
+	}
+}
+
+func TestGenerateDeriveHEAD(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> find(User, id) as user
+  |> respond 200 { id: user.id, name: user.name } with headers { x-user: user.id }
+
+GET /health
+  |> respond 200 { ok: "true" }
+
+HEAD /health
+  |> respond 204
+
+POST /users
+  |> respond 201`
+
+	root, errs := GenerateWithOptions(parse(t, input), Options{DeriveHEAD: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(root.Routes) != 5 {
+		t.Fatalf("expected 1 derived route, got %d routes", len(root.Routes))
+	}
+
+	get, head := root.Routes[0], root.Routes[4]
+	if head.RouteInfo.Method != "HEAD" || head.RouteInfo.Path != "/users/{id}" {
+		t.Fatalf("expected HEAD /users/{id}, got %s %s", head.RouteInfo.Method, head.RouteInfo.Path)
+	}
+	if get.RouteInfo.Method != "GET" {
+		t.Errorf("expected the GET route to be left alone, got %s", get.RouteInfo.Method)
+	}
+	if head.Input != get.Input || head.Process != get.Process {
+		t.Error("expected HEAD to share the GET pipeline")
+	}
+	if head.Output.Status != 200 || head.Output.Body != nil || head.Output.Headers["x-user"] != "user.id" {
+		t.Errorf("expected a 200 with headers and no body, got %+v", head.Output)
+	}
+	if get.Output.Body == nil {
+		t.Error("expected the GET route to keep its body")
+	}
+	if !reflect.DeepEqual(root.PathMethods["/users/{id}"], []string{"GET", "HEAD"}) {
+		t.Errorf("expected the derived HEAD in path_methods, got %v", root.PathMethods["/users/{id}"])
+	}
+
+	if root, _ := GenerateWithOptions(parse(t, input), Options{}); len(root.Routes) != 4 {
+		t.Errorf("expected no HEAD routes without the option, got %d routes", len(root.Routes))
+	}
+}
+
 func TestGenerateTypes(t *testing.T) {
 	input := `type User {
   id: int
@@ -179,6 +2080,32 @@ func TestGenerateTypes(t *testing.T) {
 	}
 }
 
+func TestGenerateTypeFieldDefaults(t *testing.T) {
+	input := `type User {
+  id: int
+  role: string = "user"
+  active: bool = true
+  age: int = 18
+}`
+
+	root := parseAndGenerate(input)
+
+	data, _ := json.Marshal(root.Types["User"])
+	expected := `{"active":{"type":"bool","default":true},"age":{"type":"int","default":18},"id":"int","role":{"type":"string","default":"user"}}`
+	if string(data) != expected {
+		t.Fatalf("unexpected fields:\n%s\nexpected:\n%s", data, expected)
+	}
+}
+
+func TestGenerateEnums(t *testing.T) {
+	root := parseAndGenerate(`enum Role { user, admin, guest }`)
+
+	data, _ := json.Marshal(root.Enums)
+	if string(data) != `{"Role":["user","admin","guest"]}` {
+		t.Fatalf("unexpected enums: %s", data)
+	}
+}
+
 func TestGenerateJSONOutput(t *testing.T) {
 	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
 
@@ -214,6 +2141,61 @@ GET /users/{id}
 	}
 }
 
+func TestGenerateFieldOrder(t *testing.T) {
+	input := `POST /users
+  |> input(b: body.b, a: body.a)
+  |> validate(b: string, a: string)  ~> 400 { error: "invalid" }
+  |> transform(b: trim(b), a: lower(a))
+  |> respond 201 { a: a }`
+
+	r := parseAndGenerate(input).Routes[0]
+
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"input", r.Input, `{"b":{"from":"body.b"},"a":{"from":"body.a"}}`},
+		{"validate", r.Validate.Rules, `{"b":{"type":"string"},"a":{"type":"string"}}`},
+		{"transform", r.TransformIn, `{"b":{"fn":"trim","from":"b"},"a":{"fn":"lower","from":"a"}}`},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.v)
+		if err != nil {
+			t.Fatalf("%s: JSON marshal error: %v", tt.name, err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, data)
+		}
+	}
+}
+
+func TestGeneratePathMethods(t *testing.T) {
+	input := `POST /users
+  |> respond 201
+
+GET /users
+  |> respond 200
+
+GET /users/{id}
+  cors(origins: ["*"])
+  |> input(id: path.id)
+  |> respond 200`
+
+	file := parse(t, input)
+	root, errs := GenerateWithOptions(file, Options{GenerateCORSPreflight: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	want := map[string][]string{
+		"/users":      {"GET", "POST"},
+		"/users/{id}": {"GET", "OPTIONS"},
+	}
+	if !reflect.DeepEqual(root.PathMethods, want) {
+		t.Fatalf("expected %v, got %v", want, root.PathMethods)
+	}
+}
+
 func TestGoldenFile(t *testing.T) {
 	testdataDir := filepath.Join("..", "..", "testdata")
 	entries, err := os.ReadDir(testdataDir)
@@ -267,6 +2249,59 @@ func TestGoldenFile(t *testing.T) {
 	}
 }
 
+func parse(t *testing.T, input string) *ast.File {
+	t.Helper()
+	p := parser.New(lexer.New(input, "test.rever"))
+	file := p.ParseFile()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	return file
+}
+
+func TestGenerateRouteDescription(t *testing.T) {
+	input := `# Get a user
+GET /users/{id}
+  |> respond 200 { ok: "true" }`
+
+	l := lexer.New(input, "test.rever")
+	l.SetCaptureComments(true)
+	root := Generate(parser.New(l).ParseFile())
+
+	if got := root.Routes[0].RouteInfo.Description; got != "Get a user" {
+		t.Fatalf("expected description %q, got %q", "Get a user", got)
+	}
+	data, _ := json.Marshal(root.Routes[0].RouteInfo)
+	if !strings.Contains(string(data), `"description":"Get a user"`) {
+		t.Fatalf("expected description in JSON, got %s", data)
+	}
+
+	// Without comment capture the comment is ignored.
+	if got := parseAndGenerate(input).Routes[0].RouteInfo.Description; got != "" {
+		t.Fatalf("expected no description without capture, got %q", got)
+	}
+}
+
+func TestGenerateAnnotations(t *testing.T) {
+	input := `@doc "Returns the user"
+@deprecated "use /v2/users"
+GET /users/{id}
+  |> respond 200 { ok: "true" }
+
+GET /health
+  |> respond 200`
+
+	root := parseAndGenerate(input)
+	data, _ := json.Marshal(root.Routes[0].RouteInfo)
+	expected := `{"method":"GET","path":"/users/{id}","annotations":{"deprecated":"use /v2/users","doc":"Returns the user"}}`
+	if string(data) != expected {
+		t.Fatalf("unexpected route info:\n%s\nexpected:\n%s", data, expected)
+	}
+	if root.Routes[1].RouteInfo.Annotations != nil {
+		t.Errorf("expected no annotations on an unannotated route, got %v", root.Routes[1].RouteInfo.Annotations)
+	}
+}
+
 func parseAndGenerate(input string) *ir.Root {
 	l := lexer.New(input, "test.rever")
 	p := parser.New(l)
@@ -0,0 +1,45 @@
+package gen
+
+import (
+	"maps"
+
+	"github.com/polidog/reverhttp/internal/ir"
+)
+
+// headRoutes synthesizes a HEAD route for every GET route of routes whose
+// path has no author-written HEAD route. The HEAD route shares the GET
+// route's pipeline and answers with the same status, content type, headers
+// and cookies but no body. Only the first GET of a path is derived from.
+func headRoutes(routes []*ir.Route) []*ir.Route {
+	hasHead := make(map[string]bool)
+	for _, r := range routes {
+		if r.RouteInfo.Method == "HEAD" {
+			hasHead[r.RouteInfo.Path] = true
+		}
+	}
+
+	var result []*ir.Route
+	for _, r := range routes {
+		path := r.RouteInfo.Path
+		if r.RouteInfo.Method != "GET" || hasHead[path] {
+			continue
+		}
+		hasHead[path] = true
+
+		head := *r
+		info := *r.RouteInfo
+		info.Method = "HEAD"
+		head.RouteInfo = &info
+		if r.Output != nil {
+			head.Output = &ir.Output{
+				Status:      r.Output.Status,
+				StatusRef:   r.Output.StatusRef,
+				ContentType: r.Output.ContentType,
+				Headers:     maps.Clone(r.Output.Headers),
+				Cookies:     r.Output.Cookies,
+			}
+		}
+		result = append(result, &head)
+	}
+	return result
+}
@@ -0,0 +1,116 @@
+package gen
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/ir"
+)
+
+// preflightRoutes synthesizes an OPTIONS route for every path whose routes
+// enable CORS, answering 204 with the Access-Control-* headers of the first
+// such route. Paths that already have an author-written OPTIONS route are
+// left alone. A route enables CORS by declaring cors(...) or, when it does
+// not declare cors at all, by inheriting it from the defaults for its method.
+// The generated routes of root line up with routes and carry their final
+// paths.
+func preflightRoutes(routes []*ast.Route, root *ir.Root) []*ir.Route {
+	var paths []string
+	corsByPath := make(map[string]*ir.CORS)
+	methods := make(map[string][]string)
+	hasOptions := make(map[string]bool)
+
+	for i, route := range routes {
+		path := root.Routes[i].RouteInfo.Path
+		if route.Method == "OPTIONS" {
+			hasOptions[path] = true
+			continue
+		}
+		c := effectiveCORS(root.Routes[i], route, routeDefaults(root, route.Method))
+		if c == nil {
+			continue
+		}
+		if _, ok := corsByPath[path]; !ok {
+			paths = append(paths, path)
+			corsByPath[path] = c
+		}
+		methods[path] = append(methods[path], route.Method)
+	}
+
+	var result []*ir.Route
+	for _, path := range paths {
+		if hasOptions[path] {
+			continue
+		}
+		c := corsByPath[path]
+		result = append(result, &ir.Route{
+			RouteInfo: &ir.RouteInfo{Method: "OPTIONS", Path: path},
+			CORS:      copyCORS(c),
+			Output: &ir.Output{
+				Status:  204,
+				Headers: preflightHeaders(c, methods[path]),
+			},
+		})
+	}
+	return result
+}
+
+// effectiveCORS returns the CORS settings that apply to r, or nil when CORS
+// is off (not declared and not inherited, or cors(none)).
+func effectiveCORS(r *ir.Route, route *ast.Route, d *ir.Defaults) *ir.CORS {
+	for _, dir := range route.EffectiveDirectives() {
+		if dir.Name == "cors" {
+			return r.CORS
+		}
+	}
+	if d != nil {
+		return d.CORS
+	}
+	return nil
+}
+
+// literalOrigins returns origins as strings, or false when one of them is
+// only known at serve time (env("NAME")) or is a pattern matched against
+// the request's Origin.
+func literalOrigins(origins []interface{}) ([]string, bool) {
+	strs := make([]string, 0, len(origins))
+	for _, o := range origins {
+		s, ok := o.(string)
+		if !ok {
+			return nil, false
+		}
+		strs = append(strs, s)
+	}
+	return strs, true
+}
+
+// preflightHeaders builds the preflight response headers from c. When c
+// lists no methods, the methods of the routes sharing the path are allowed.
+// Access-Control-Allow-Origin holds a single origin, so it is only written
+// for one literal origin or "*". Otherwise the runtime, which has the
+// route's cors settings, echoes the request's Origin when it is allowed,
+// and the response varies by Origin.
+func preflightHeaders(c *ir.CORS, routeMethods []string) map[string]string {
+	headers := make(map[string]string)
+	if origins, ok := literalOrigins(c.Origins); ok && len(origins) == 1 {
+		headers["Access-Control-Allow-Origin"] = origins[0]
+	} else if len(c.Origins) > 0 {
+		headers["Vary"] = "Origin"
+	}
+	allowed := c.Methods
+	if len(allowed) == 0 {
+		allowed = routeMethods
+	}
+	headers["Access-Control-Allow-Methods"] = strings.Join(allowed, ", ")
+	if len(c.Headers) > 0 {
+		headers["Access-Control-Allow-Headers"] = strings.Join(c.Headers, ", ")
+	}
+	if c.MaxAge != nil {
+		headers["Access-Control-Max-Age"] = strconv.Itoa(*c.MaxAge)
+	}
+	if c.Credentials != nil && *c.Credentials {
+		headers["Access-Control-Allow-Credentials"] = "true"
+	}
+	return headers
+}
@@ -1,16 +1,39 @@
 package ir
 
+import "encoding/json"
+
+// DefaultVersion is the IR version of files without a version pragma.
+const DefaultVersion = "0.1"
+
 // Root is the top-level IR structure for a ReverHTTP application.
 type Root struct {
-	Version  string                `json:"version"`
-	Imports  map[string]*Import    `json:"imports,omitempty"`
-	Types    map[string]TypeFields `json:"types,omitempty"`
-	Defaults *Defaults             `json:"defaults,omitempty"`
-	Routes   []*Route              `json:"routes"`
+	Version  string                 `json:"version"`
+	Meta     map[string]interface{} `json:"meta,omitempty"` // the meta block: literal strings, ints and bools
+	Imports  map[string]*Import     `json:"imports,omitempty"`
+	Types    map[string]TypeFields  `json:"types,omitempty"`
+	Enums    map[string][]string    `json:"enums,omitempty"`
+	Defaults *Defaults              `json:"defaults,omitempty"`
+	// MethodDefaults holds defaults that apply only to routes of the given
+	// HTTP method, overriding Defaults directive by directive.
+	MethodDefaults map[string]*Defaults `json:"method_defaults,omitempty"`
+	// PathMethods lists the methods defined for each route path, for 405
+	// responses and their Allow header.
+	PathMethods map[string][]string `json:"path_methods,omitempty"`
+	Routes      []*Route            `json:"routes"`
+	// SourceHash is the SHA-256 of the compiled sources, set by reverc
+	// -hash so build systems can tell whether the output changed.
+	SourceHash string `json:"source_hash,omitempty"`
 }
 
-// TypeFields maps field names to type names.
-type TypeFields map[string]string
+// TypeFields maps field names to their type: a type name string, or a
+// *TypeField when the field declares a default.
+type TypeFields map[string]interface{}
+
+// TypeField is a type field with a default value.
+type TypeField struct {
+	Type    string      `json:"type"`
+	Default interface{} `json:"default"` // string, int or bool
+}
 
 // Import represents an imported package.
 type Import struct {
@@ -21,28 +44,52 @@ type Import struct {
 
 // Defaults represents default directives applied to all routes.
 type Defaults struct {
-	Cache *Cache `json:"cache,omitempty"`
-	CORS  *CORS  `json:"cors,omitempty"`
-	Auth  *Auth  `json:"auth,omitempty"`
+	Cache      *Cache      `json:"cache,omitempty"`
+	CORS       *CORS       `json:"cors,omitempty"`
+	Auth       *Auth       `json:"auth,omitempty"`
+	Retry      *Retry      `json:"retry,omitempty"`
+	Pagination *Pagination `json:"pagination,omitempty"`
+	Compress   *Compress   `json:"compress,omitempty"`
 }
 
 // Route represents a single route in the IR.
 type Route struct {
-	RouteInfo    *RouteInfo         `json:"route"`
-	Auth         *Auth              `json:"auth,omitempty"`
-	Cache        *Cache             `json:"cache,omitempty"`
-	CORS         interface{}        `json:"cors,omitempty"` // *CORS or nil (null for cors(none))
-	Input        map[string]*Input  `json:"input,omitempty"`
-	Validate     *Validate          `json:"validate,omitempty"`
-	TransformIn  map[string]*Transform `json:"transform_in,omitempty"`
-	Process      *Process           `json:"process,omitempty"`
-	Output       *Output            `json:"output"`
+	RouteInfo    *RouteInfo              `json:"route"`
+	Auth         *Auth                   `json:"auth,omitempty"`
+	Cache        *Cache                  `json:"cache,omitempty"`
+	CORS         *CORS                   `json:"cors,omitempty"`
+	CORSDisabled bool                    `json:"-"` // cors(none); written as "cors": null
+	Retry        *Retry                  `json:"retry,omitempty"`
+	Pagination   *Pagination             `json:"pagination,omitempty"`
+	Accepts      []string                `json:"accepts,omitempty"` // media types the Accept header must allow; any one suffices
+	Compress     *Compress               `json:"compress,omitempty"`
+	Input        *OrderedMap[*Input]     `json:"input,omitempty"`
+	Validate     *Validate               `json:"validate,omitempty"`
+	TransformIn  *OrderedMap[*Transform] `json:"transform_in,omitempty"`
+	Process      *Process                `json:"process,omitempty"`
+	Output       *Output                 `json:"output"`
+}
+
+// MarshalJSON encodes r, writing "cors": null when CORS is disabled and
+// leaving cors out when the route does not set it.
+func (r Route) MarshalJSON() ([]byte, error) {
+	type route Route // without this method, so Marshal does not recurse
+	if !r.CORSDisabled {
+		return json.Marshal(route(r))
+	}
+	return json.Marshal(struct {
+		route
+		CORS *CORS `json:"cors"`
+	}{route: route(r)})
 }
 
 // RouteInfo holds the HTTP method and path.
 type RouteInfo struct {
-	Method string `json:"method"`
-	Path   string `json:"path"`
+	Method      string            `json:"method"`
+	Path        string            `json:"path"`
+	Description string            `json:"description,omitempty"`
+	CatchAll    bool              `json:"catch_all,omitempty"`   // the last path segment (* or {param*}) matches the rest of the path
+	Annotations map[string]string `json:"annotations,omitempty"` // @doc / @deprecated text by name
 }
 
 // Cache represents HTTP cache directives.
@@ -52,7 +99,7 @@ type Cache struct {
 	Visibility   string      `json:"visibility,omitempty"`
 	NoCache      *bool       `json:"no_cache,omitempty"`
 	NoStore      *bool       `json:"no_store,omitempty"`
-	ETag         interface{} `json:"etag,omitempty"`           // string or *ETagFn
+	ETag         interface{} `json:"etag,omitempty"` // string, *ETagFn or *ETagFields
 	LastModified string      `json:"last_modified,omitempty"`
 	Vary         []string    `json:"vary,omitempty"`
 }
@@ -63,90 +110,178 @@ type ETagFn struct {
 	From string `json:"from"`
 }
 
+// ETagFields represents an etag computed from several fields, written as a
+// list like [user.id, user.updated_at].
+type ETagFields struct {
+	From []string `json:"from"`
+}
+
+// EnvRef is a directive value read from the deployment environment when the
+// route is served, written env("NAME").
+type EnvRef struct {
+	Env string `json:"$env"`
+}
+
+// OriginPattern is a cors origin matched against the Origin header as a
+// regex, written /regex/ or as a wildcard such as "https://*.example.com".
+type OriginPattern struct {
+	Pattern string `json:"pattern"`
+}
+
 // CORS represents CORS directives.
 type CORS struct {
-	Origins       []string `json:"origins,omitempty"`
-	Methods       []string `json:"methods,omitempty"`
-	Headers       []string `json:"headers,omitempty"`
-	ExposeHeaders []string `json:"expose_headers,omitempty"`
-	MaxAge        *int     `json:"max_age,omitempty"`
-	Credentials   *bool    `json:"credentials,omitempty"`
+	Origins       []interface{} `json:"origins,omitempty"` // strings, EnvRef or OriginPattern values
+	Methods       []string      `json:"methods,omitempty"`
+	Headers       []string      `json:"headers,omitempty"`
+	ExposeHeaders []string      `json:"expose_headers,omitempty"`
+	MaxAge        *int          `json:"max_age,omitempty"`
+	Credentials   *bool         `json:"credentials,omitempty"`
 }
 
 // Auth represents authentication/authorization directives.
 type Auth struct {
-	Method      string   `json:"method"`
-	Roles       []string `json:"roles,omitempty"`
-	Permissions []string `json:"permissions,omitempty"`
-	Bind        string   `json:"bind,omitempty"`
+	Method      string      `json:"method"`
+	Roles       []string    `json:"roles,omitempty"`
+	Permissions []string    `json:"permissions,omitempty"`
+	Scopes      []string    `json:"scopes,omitempty"`
+	Realm       interface{} `json:"realm,omitempty"`    // string or EnvRef
+	In          string      `json:"in,omitempty"`       // apikey location: header, query or cookie
+	Name        string      `json:"name,omitempty"`     // apikey header, parameter or cookie name
+	Optional    *bool       `json:"optional,omitempty"` // credentials are checked if present but not required
+	Bind        string      `json:"bind,omitempty"`
+}
+
+// Retry represents retry behavior for package calls. On a route it is the
+// default for every package call; on a PkgStep it applies to that call only.
+type Retry struct {
+	Attempts  int `json:"attempts"`
+	BackoffMs int `json:"backoff_ms,omitempty"`
+}
+
+// Pagination represents a list endpoint's paging parameters.
+type Pagination struct {
+	DefaultLimit int    `json:"default_limit,omitempty"`
+	MaxLimit     int    `json:"max_limit,omitempty"`
+	CursorFrom   string `json:"cursor_from,omitempty"` // request source of the cursor, e.g. "query.cursor"
+}
+
+// Compress represents response compression: the encodings a response may
+// be compressed with, in order of preference, and the smallest body size
+// worth compressing.
+type Compress struct {
+	Encodings []string `json:"encodings"`
+	MinBytes  int      `json:"min_bytes,omitempty"`
 }
 
 // Input represents an input field extraction.
 type Input struct {
-	From string `json:"from"`
+	From     string      `json:"from"`
+	Wildcard bool        `json:"wildcard,omitempty"` // bind the whole map named by From (only "header")
+	Default  interface{} `json:"default,omitempty"`  // string, int or bool used when the source is absent
 }
 
 // Validate represents validation rules and error.
 type Validate struct {
-	Rules map[string]*ValidateRule `json:"rules"`
-	Error *ErrorResponse           `json:"error"`
+	Rules *OrderedMap[*ValidateRule] `json:"rules"`
+	Error *ErrorResponse             `json:"error"`
 }
 
 // ValidateRule represents a single validation rule.
 type ValidateRule struct {
-	Type   string `json:"type,omitempty"`
-	Min    *int   `json:"min,omitempty"`
-	Max    *int   `json:"max,omitempty"`
-	Format string `json:"format,omitempty"`
+	Optional  bool     `json:"optional,omitempty"` // skip the rule when the field is absent
+	Type      string   `json:"type,omitempty"`
+	Min       *int     `json:"min,omitempty"` // numeric bounds
+	Max       *int     `json:"max,omitempty"`
+	MinF      *float64 `json:"min_float,omitempty"` // numeric bounds of float rules, instead of Min and Max
+	MaxF      *float64 `json:"max_float,omitempty"`
+	MinLength *int     `json:"min_length,omitempty"` // string length bounds
+	MaxLength *int     `json:"max_length,omitempty"`
+	Format    string   `json:"format,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
 }
 
 // Transform represents a field transformation.
 type Transform struct {
-	Cast string `json:"cast,omitempty"`
-	Fn   string `json:"fn,omitempty"`
-	From string `json:"from"`
+	Cast  string        `json:"cast,omitempty"`
+	Fn    string        `json:"fn,omitempty"`
+	Chain []string      `json:"chain,omitempty"` // nested calls, applied in order; replaces cast/fn
+	From  string        `json:"from"`
+	Args  []interface{} `json:"args,omitempty"` // literal arguments after from
 }
 
 // Process contains the processing steps.
 type Process struct {
-	Steps []interface{} `json:"steps"` // *PkgStep, *GuardStep, *MatchStep
+	Steps []interface{} `json:"steps"` // *PkgStep, *GuardStep, *MatchProcessStep, *MapStep, *EnrichStep, *ForwardStep
 }
 
 // PkgStep represents a package call step in the process.
 type PkgStep struct {
-	Bind  string            `json:"bind,omitempty"`
-	Use   string            `json:"use"`
+	Bind  string                 `json:"bind,omitempty"`
+	Use   string                 `json:"use"`
 	Input map[string]interface{} `json:"input"`
-	Error *ErrorResponse    `json:"error,omitempty"`
+	Retry *Retry                 `json:"retry,omitempty"`
+	Error *ErrorResponse         `json:"error,omitempty"`
 }
 
 // GuardStep represents a guard step in the process.
 type GuardStep struct {
 	Guard interface{}    `json:"guard"` // string or map for {"not": "expr"}
 	Error *ErrorResponse `json:"error"`
+	Else  interface{}    `json:"else,omitempty"` // *Output or *PkgStep
+}
+
+// MapStep represents a map step in the process: body is built once per
+// element of the list named by Map, with the element available as "it".
+type MapStep struct {
+	Bind string                 `json:"bind,omitempty"`
+	Map  string                 `json:"map"`
+	Body map[string]interface{} `json:"body"`
+}
+
+// EnrichStep represents an enrich step in the process: the fields of the
+// bindings in Enrich merged into one object, later ones winning.
+type EnrichStep struct {
+	Bind   string   `json:"bind,omitempty"`
+	Enrich []string `json:"enrich"`
+}
+
+// ForwardStep represents a forward step in the process: the request proxied
+// to Forward.URL, whose {name} placeholders are filled from bindings.
+type ForwardStep struct {
+	Bind    string         `json:"bind,omitempty"`
+	Forward *Forward       `json:"forward"`
+	Error   *ErrorResponse `json:"error,omitempty"`
+}
+
+// Forward is the target of a forward step.
+type Forward struct {
+	URL string `json:"url"`
 }
 
 // MatchProcessStep represents a match step in the process.
 type MatchProcessStep struct {
-	Bind  string      `json:"bind,omitempty"`
-	Match *MatchBlock `json:"match"`
+	Bind  string         `json:"bind,omitempty"`
+	Match *MatchBlock    `json:"match"`
 	Error *ErrorResponse `json:"error,omitempty"`
 }
 
 // MatchBlock represents the match block content.
 type MatchBlock struct {
-	On      string     `json:"on"`
+	On      string      `json:"on"`
 	Arms    []*MatchArm `json:"arms"`
 	Default interface{} `json:"default,omitempty"` // *MatchArmAction or *MatchArmError
 }
 
 // MatchArm represents a single arm in a match block.
 type MatchArm struct {
-	Pattern interface{}        `json:"pattern"` // PatternValue, PatternIn, PatternRange, PatternRegex
-	Use     string             `json:"use,omitempty"`
+	Pattern interface{}            `json:"pattern"`        // PatternValue, PatternIn, PatternRange, PatternRegex
+	When    interface{}            `json:"when,omitempty"` // string or map for {"not": "expr"}
+	Use     string                 `json:"use,omitempty"`
 	Input   map[string]interface{} `json:"input,omitempty"`
-	Error   *ErrorResponse     `json:"error,omitempty"`
-	Ref     string             `json:"ref,omitempty"` // variable reference
+	Error   *ErrorResponse         `json:"error,omitempty"`
+	Ref     string                 `json:"ref,omitempty"`    // variable reference
+	Output  *Output                `json:"output,omitempty"` // response that ends the request
 }
 
 // PatternValue represents a literal match pattern.
@@ -173,6 +308,7 @@ type RangeValue struct {
 // PatternRegex represents a regex match pattern.
 type PatternRegex struct {
 	Regex string `json:"regex"`
+	Flags string `json:"flags,omitempty"`
 }
 
 // MatchDefaultError is used when the default arm is just an error.
@@ -180,15 +316,51 @@ type MatchDefaultError struct {
 	Error *ErrorResponse `json:"error"`
 }
 
+// SpreadKey is the body key whose value names the object spread into the
+// body with ...name. Explicit keys override the spread object's fields.
+const SpreadKey = "$spread"
+
+// Ref is a body value referring to an implicit binding supplied by the
+// runtime, such as the validation errors in an error flow.
+type Ref struct {
+	Ref string `json:"$ref"` // e.g. "errors" or "path.id"
+}
+
+// Template is a string value with {name} placeholders, which the runtime
+// fills from the bindings listed in Refs. Template keeps the literal as
+// written, so \{ and \} still stand for literal braces.
+type Template struct {
+	Template string   `json:"template"` // e.g. "user:{id}"
+	Refs     []string `json:"refs"`     // placeholder names in order of first use
+}
+
 // Output represents the response output.
 type Output struct {
-	Status  int               `json:"status"`
-	Body    map[string]string `json:"body,omitempty"`
-	Headers map[string]string `json:"headers,omitempty"`
+	Status      int                    `json:"status,omitempty"`
+	StatusRef   string                 `json:"status_ref,omitempty"` // bound name holding the status, instead of Status
+	ContentType string                 `json:"content_type,omitempty"`
+	Schema      string                 `json:"schema,omitempty"`  // declared type of the body
+	Body        map[string]interface{} `json:"body,omitempty"`    // values are strings, nested bodies or arrays
+	Example     map[string]interface{} `json:"example,omitempty"` // literal example payload for docs, in JSON types
+	Text        *string                `json:"text,omitempty"`    // string literal body
+	Headers     map[string]string      `json:"headers,omitempty"`
+	Cookies     []*Cookie              `json:"cookies,omitempty"`
+}
+
+// Cookie is a Set-Cookie entry of a response.
+type Cookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	MaxAge   *int   `json:"max_age,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+	HTTPOnly bool   `json:"http_only,omitempty"`
+	SameSite string `json:"same_site,omitempty"`
 }
 
 // ErrorResponse represents an error response.
 type ErrorResponse struct {
-	Status int               `json:"status"`
-	Body   map[string]string `json:"body,omitempty"`
+	Status int                    `json:"status"`
+	Body   map[string]interface{} `json:"body,omitempty"`
 }
@@ -0,0 +1,85 @@
+package ir
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// OrderedMap is a string-keyed map that remembers insertion order and
+// marshals as a JSON object with its keys in that order. It holds the
+// fields of input, validate and transform, whose declaration order matters
+// for error reporting and for tools that generate forms from the IR.
+type OrderedMap[V any] struct {
+	keys   []string
+	values map[string]V
+}
+
+// NewOrderedMap returns an empty OrderedMap.
+func NewOrderedMap[V any]() *OrderedMap[V] {
+	return &OrderedMap[V]{values: make(map[string]V)}
+}
+
+// Set stores value under key. A new key is appended to the order; an
+// existing key keeps its position.
+func (m *OrderedMap[V]) Set(key string, value V) {
+	if m.values == nil {
+		m.values = make(map[string]V)
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Get returns the value stored under key, or the zero value when the key is
+// absent or m is nil.
+func (m *OrderedMap[V]) Get(key string) V {
+	if m == nil {
+		var zero V
+		return zero
+	}
+	return m.values[key]
+}
+
+// Keys returns the keys in insertion order.
+func (m *OrderedMap[V]) Keys() []string {
+	if m == nil {
+		return nil
+	}
+	return append([]string(nil), m.keys...)
+}
+
+// Len returns the number of keys.
+func (m *OrderedMap[V]) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.keys)
+}
+
+// MarshalJSON encodes m as a JSON object with keys in insertion order.
+func (m *OrderedMap[V]) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -1,7 +1,10 @@
 package lexer
 
 import (
+	"fmt"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/polidog/reverhttp/internal/token"
 )
@@ -14,15 +17,44 @@ type Lexer struct {
 	readPos int  // next read position
 	ch      byte // current character
 	line    int
-	col     int
+	col     int // rune column of ch
+	col16   int // UTF-16 column of ch
 
 	// Bracket nesting depth — newlines are suppressed inside brackets.
 	parenDepth   int // ()
 	braceDepth   int // {}
 	bracketDepth int // []
 
+	// blockLine is set while lexing a `group` or `pipeline` line. The `{`
+	// that ends such a line opens a block of routes or steps, which are
+	// newline-sensitive, so it does not count towards braceDepth.
+	blockLine bool
+
 	// regexMode is set by the parser when `/` should be read as regex delimiter.
 	regexMode bool
+
+	// pathMode is set after an HTTP method or `group`: a `/` that follows
+	// starts a route path, read verbatim up to whitespace.
+	pathMode bool
+
+	// Comment capture: comment lines directly above a token become its Doc.
+	captureComments bool
+	docLines        []string
+	lineHasToken    bool
+	lineHasComment  bool
+
+	errors []Error
+}
+
+// Error is a lexical error, such as an unterminated regex literal.
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+// Error formats the error like parser errors: "file:line:col: message".
+func (e Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Pos.File, e.Pos.Line, e.Pos.Column, e.Message)
 }
 
 // New creates a new Lexer for the given input.
@@ -32,11 +64,27 @@ func New(input, file string) *Lexer {
 	return l
 }
 
+// Errors returns the lexical errors found so far.
+func (l *Lexer) Errors() []Error {
+	return l.errors
+}
+
+func (l *Lexer) addError(pos token.Position, msg string) {
+	l.errors = append(l.errors, Error{Pos: pos, Message: msg})
+}
+
 // SetRegexMode enables or disables regex mode. In regex mode, `/` starts a regex literal.
 func (l *Lexer) SetRegexMode(on bool) {
 	l.regexMode = on
 }
 
+// SetCaptureComments enables or disables comment capture. When enabled, a
+// block of whole-line comments directly above a line is attached as Doc to the
+// first token of that line; a blank line between them discards the block.
+func (l *Lexer) SetCaptureComments(on bool) {
+	l.captureComments = on
+}
+
 func (l *Lexer) readChar() {
 	if l.readPos >= len(l.input) {
 		l.ch = 0
@@ -45,7 +93,16 @@ func (l *Lexer) readChar() {
 	}
 	l.pos = l.readPos
 	l.readPos++
+	// Columns advance on the first byte of each UTF-8 sequence only. A
+	// four-byte sequence is a rune outside the BMP: two UTF-16 code units.
+	if !utf8.RuneStart(l.ch) {
+		return
+	}
 	l.col++
+	l.col16++
+	if l.ch >= 0xF0 {
+		l.col16++
+	}
 }
 
 func (l *Lexer) peekChar() byte {
@@ -55,8 +112,15 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.readPos]
 }
 
+// peekLineEnd reports whether the next character ends the line or input, so
+// a trailing backslash cannot escape it.
+func (l *Lexer) peekLineEnd() bool {
+	ch := l.peekChar()
+	return ch == 0 || ch == '\n'
+}
+
 func (l *Lexer) curPos() token.Position {
-	return token.Position{File: l.file, Line: l.line, Column: l.col}
+	return token.Position{File: l.file, Line: l.line, Column: l.col, UTF16Column: l.col16}
 }
 
 func (l *Lexer) newToken(t token.Type, lit string) token.Token {
@@ -65,21 +129,51 @@ func (l *Lexer) newToken(t token.Type, lit string) token.Token {
 
 // NextToken returns the next token from the input.
 func (l *Lexer) NextToken() token.Token {
+	tok := l.nextToken()
+	tok.End = l.curPos()
+	switch tok.Type {
+	case token.GROUP, token.PIPELINE:
+		l.blockLine = true
+	case token.NEWLINE:
+		l.blockLine = false
+	}
+	l.pathMode = tok.Type == token.GROUP || token.IsHTTPMethod(tok.Type)
+	switch tok.Type {
+	case token.NEWLINE, token.EOF:
+	default:
+		if len(l.docLines) > 0 {
+			tok.Doc = strings.Join(l.docLines, "\n")
+			l.docLines = nil
+		}
+		l.lineHasToken = true
+	}
+	return tok
+}
+
+func (l *Lexer) nextToken() token.Token {
 	l.skipWhitespaceAndComments()
 
 	pos := l.curPos()
 
+	if l.pathMode && l.ch == '/' {
+		return l.readPath()
+	}
+
 	switch l.ch {
 	case 0:
 		return token.Token{Type: token.EOF, Literal: "", Pos: pos}
 
 	case '\n':
+		if !l.lineHasToken && !l.lineHasComment {
+			l.docLines = nil // a blank line detaches the comment block
+		}
+		l.lineHasToken, l.lineHasComment = false, false
 		l.line++
-		l.col = 0
+		l.col, l.col16 = 0, 0
 		l.readChar()
 		// Suppress newlines inside brackets
 		if l.insideBrackets() {
-			return l.NextToken()
+			return l.nextToken()
 		}
 		return token.Token{Type: token.NEWLINE, Literal: "\n", Pos: pos}
 
@@ -106,6 +200,12 @@ func (l *Lexer) NextToken() token.Token {
 		return token.Token{Type: token.AMPERSAND, Literal: "&", Pos: pos}
 
 	case '.':
+		if strings.HasPrefix(l.input[l.pos:], "...") {
+			l.readChar()
+			l.readChar()
+			l.readChar()
+			return token.Token{Type: token.SPREAD, Literal: "...", Pos: pos}
+		}
 		if l.peekChar() == '.' {
 			l.readChar()
 			l.readChar()
@@ -130,10 +230,23 @@ func (l *Lexer) NextToken() token.Token {
 		l.readChar()
 		return token.Token{Type: token.ASSIGN, Literal: "=", Pos: pos}
 
+	case '?':
+		if l.peekChar() == '?' {
+			l.readChar()
+			l.readChar()
+			return token.Token{Type: token.COALESCE, Literal: "??", Pos: pos}
+		}
+		l.readChar()
+		return token.Token{Type: token.QUESTION, Literal: "?", Pos: pos}
+
 	case '@':
 		l.readChar()
 		return token.Token{Type: token.AT, Literal: "@", Pos: pos}
 
+	case '*':
+		l.readChar()
+		return token.Token{Type: token.STAR, Literal: "*", Pos: pos}
+
 	case '/':
 		if l.regexMode {
 			return l.readRegex()
@@ -154,7 +267,9 @@ func (l *Lexer) NextToken() token.Token {
 		return token.Token{Type: token.RPAREN, Literal: ")", Pos: pos}
 
 	case '{':
-		l.braceDepth++
+		if !l.blockLine || !l.restOfLineBlank() {
+			l.braceDepth++
+		}
 		l.readChar()
 		return token.Token{Type: token.LBRACE, Literal: "{", Pos: pos}
 
@@ -188,6 +303,14 @@ func (l *Lexer) NextToken() token.Token {
 	case '"':
 		return l.readString()
 
+	case '-':
+		// A minus sign directly before a digit is part of a negative number.
+		if isDigit(l.peekChar()) {
+			return l.readNumber()
+		}
+		l.readChar()
+		return token.Token{Type: token.ILLEGAL, Literal: "-", Pos: pos}
+
 	default:
 		if isDigit(l.ch) {
 			return l.readNumber()
@@ -205,6 +328,21 @@ func (l *Lexer) insideBrackets() bool {
 	return l.parenDepth > 0 || l.braceDepth > 0 || l.bracketDepth > 0
 }
 
+// restOfLineBlank reports whether only whitespace or a comment follows the
+// current character on its line.
+func (l *Lexer) restOfLineBlank() bool {
+	for i := l.readPos; i < len(l.input); i++ {
+		switch l.input[i] {
+		case ' ', '\t', '\r':
+			continue
+		case '\n', '#':
+			return true
+		}
+		return false
+	}
+	return true
+}
+
 func (l *Lexer) skipWhitespaceAndComments() {
 	for {
 		// Skip spaces and tabs (not newlines — they are significant)
@@ -213,15 +351,47 @@ func (l *Lexer) skipWhitespaceAndComments() {
 		}
 		// Skip comments
 		if l.ch == '#' {
+			start := l.pos
 			for l.ch != '\n' && l.ch != 0 {
 				l.readChar()
 			}
+			if l.captureComments && !l.lineHasToken {
+				text := strings.TrimPrefix(l.input[start+1:l.pos], " ")
+				l.docLines = append(l.docLines, strings.TrimRight(text, " \t\r"))
+				l.lineHasComment = true
+			}
+			continue
+		}
+		// A backslash at the end of a line continues it: the newline is
+		// not a token, so a long step can be broken over several lines.
+		if l.ch == '\\' && l.continuesLine() {
+			for l.ch != '\n' {
+				l.readChar()
+			}
+			l.line++
+			l.col, l.col16 = 0, 0
+			l.readChar()
 			continue
 		}
 		break
 	}
 }
 
+// continuesLine reports whether the backslash at l.ch is followed only by
+// blanks up to the end of the line.
+func (l *Lexer) continuesLine() bool {
+	for i := l.readPos; i < len(l.input); i++ {
+		switch l.input[i] {
+		case ' ', '\t', '\r':
+		case '\n':
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
+
 func (l *Lexer) readIdentifier() token.Token {
 	pos := l.curPos()
 	start := l.pos
@@ -253,18 +423,32 @@ func (l *Lexer) readIdentifier() token.Token {
 func (l *Lexer) readNumber() token.Token {
 	pos := l.curPos()
 	start := l.pos
+	if l.ch == '-' {
+		l.readChar()
+	}
+	for isDigit(l.ch) {
+		l.readChar()
+	}
+	// A fraction needs a digit after the dot, so 200..299 stays a range.
+	if l.ch != '.' || !isDigit(l.peekChar()) {
+		return token.Token{Type: token.INT, Literal: l.input[start:l.pos], Pos: pos}
+	}
+	l.readChar() // skip '.'
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return token.Token{Type: token.INT, Literal: l.input[start:l.pos], Pos: pos}
+	return token.Token{Type: token.FLOAT, Literal: l.input[start:l.pos], Pos: pos}
 }
 
 func (l *Lexer) readString() token.Token {
+	if strings.HasPrefix(l.input[l.pos:], `"""`) {
+		return l.readTextBlock()
+	}
 	pos := l.curPos()
 	l.readChar() // skip opening quote
 	start := l.pos
 	for l.ch != '"' && l.ch != 0 && l.ch != '\n' {
-		if l.ch == '\\' {
+		if l.ch == '\\' && !l.peekLineEnd() {
 			l.readChar() // skip escape char
 		}
 		l.readChar()
@@ -276,23 +460,133 @@ func (l *Lexer) readString() token.Token {
 	return token.Token{Type: token.STRING, Literal: lit, Pos: pos}
 }
 
+// readTextBlock reads a """...""" string. It may span lines and is taken
+// verbatim: escapes are not processed.
+func (l *Lexer) readTextBlock() token.Token {
+	pos := l.curPos()
+	for i := 0; i < 3; i++ {
+		l.readChar() // skip opening quotes
+	}
+	start := l.pos
+	for l.ch != 0 && !strings.HasPrefix(l.input[l.pos:], `"""`) {
+		if l.ch == '\n' {
+			l.line++
+			l.col, l.col16 = 0, 0
+		}
+		l.readChar()
+	}
+	lit := l.input[start:l.pos]
+	for i := 0; i < 3 && l.ch == '"'; i++ {
+		l.readChar() // skip closing quotes
+	}
+	return token.Token{Type: token.STRING, Literal: lit, Pos: pos}
+}
+
+// readPath reads a route path as written, dots, hyphens and braces
+// included, up to whitespace or a comment.
+func (l *Lexer) readPath() token.Token {
+	pos := l.curPos()
+	start := l.pos
+	for l.ch != 0 && l.ch != ' ' && l.ch != '\t' && l.ch != '\r' && l.ch != '\n' && l.ch != '#' {
+		l.readChar()
+	}
+	return token.Token{Type: token.PATH, Literal: l.input[start:l.pos], Pos: pos}
+}
+
 func (l *Lexer) readRegex() token.Token {
 	pos := l.curPos()
 	l.readChar() // skip opening /
 	start := l.pos
 	for l.ch != '/' && l.ch != 0 && l.ch != '\n' {
-		if l.ch == '\\' {
+		if l.ch == '\\' && !l.peekLineEnd() {
 			l.readChar() // skip escape
 		}
 		l.readChar()
 	}
 	lit := l.input[start:l.pos]
+	if l.ch != '/' {
+		l.addError(pos, "unterminated regex literal")
+	}
 	if l.ch == '/' {
 		l.readChar() // skip closing /
+		flagStart := l.pos
+		for isRegexFlag(l.ch) {
+			l.readChar()
+		}
+		if l.pos > flagStart {
+			lit += "/" + l.input[flagStart:l.pos]
+		}
 	}
 	return token.Token{Type: token.REGEX, Literal: lit, Pos: pos}
 }
 
+func isRegexFlag(ch byte) bool {
+	return ch == 'i' || ch == 'm' || ch == 's'
+}
+
+// SplitRegex splits a REGEX token literal into its pattern and flags. The
+// lexer appends flags as "/flags"; an escaped "\/" belongs to the pattern.
+func SplitRegex(lit string) (pattern, flags string) {
+	idx := strings.LastIndexByte(lit, '/')
+	if idx == -1 || idx == len(lit)-1 {
+		return lit, ""
+	}
+	backslashes := 0
+	for i := idx - 1; i >= 0 && lit[i] == '\\'; i-- {
+		backslashes++
+	}
+	if backslashes%2 == 1 {
+		return lit, ""
+	}
+	for i := idx + 1; i < len(lit); i++ {
+		if !isRegexFlag(lit[i]) {
+			return lit, ""
+		}
+	}
+	return lit[:idx], lit[idx+1:]
+}
+
+// Placeholder is a {name} or {name.field} reference inside a string literal.
+type Placeholder struct {
+	Offset int    // byte offset of Name in the literal, just after the '{'
+	Name   string // e.g. "id" or "user.id"
+}
+
+// Placeholders returns the placeholders of a STRING token literal, escapes
+// intact. \{ is a literal brace, and braces around anything but a dotted
+// name, such as "{}" or "{ id }", are left as text.
+func Placeholders(lit string) []Placeholder {
+	var out []Placeholder
+	for i := 0; i < len(lit); i++ {
+		switch lit[i] {
+		case '\\':
+			i++ // skip the escaped char
+		case '{':
+			if end := dottedNameEnd(lit, i+1); end > i+1 && end < len(lit) && lit[end] == '}' {
+				out = append(out, Placeholder{Offset: i + 1, Name: lit[i+1 : end]})
+				i = end
+			}
+		}
+	}
+	return out
+}
+
+// dottedNameEnd returns the end of the dotted name starting at start in s,
+// or start if there is none.
+func dottedNameEnd(s string, start int) int {
+	end := start
+	for i := start; i < len(s) && isIdentStart(s[i]); {
+		for i++; i < len(s) && isIdentContinue(s[i]); i++ {
+		}
+		end = i
+		if i+1 >= len(s) || s[i] != '.' {
+			break
+		}
+		i++
+	}
+	return end
+}
+
 func isIdentStart(ch byte) bool {
 	return unicode.IsLetter(rune(ch)) || ch == '_'
 }
@@ -1,13 +1,19 @@
 package lexer
 
 import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/polidog/reverhttp/internal/token"
 )
 
 func TestNextToken_Operators(t *testing.T) {
-	input := `|> ~> & .. : , . ! = @`
+	input := `|> ~> & .. ... : , . ! = ? ?? @ *`
 	l := New(input, "test")
 
 	expected := []struct {
@@ -18,12 +24,16 @@ func TestNextToken_Operators(t *testing.T) {
 		{token.ERROR, "~>"},
 		{token.AMPERSAND, "&"},
 		{token.RANGE, ".."},
+		{token.SPREAD, "..."},
 		{token.COLON, ":"},
 		{token.COMMA, ","},
 		{token.DOT, "."},
 		{token.BANG, "!"},
 		{token.ASSIGN, "="},
+		{token.QUESTION, "?"},
+		{token.COALESCE, "??"},
 		{token.AT, "@"},
+		{token.STAR, "*"},
 		{token.EOF, ""},
 	}
 
@@ -38,6 +48,22 @@ func TestNextToken_Operators(t *testing.T) {
 	}
 }
 
+func TestNextToken_CoalesceAfterSource(t *testing.T) {
+	l := New(`role: header.x-role ?? "guest", name?: string`, "test")
+
+	expected := []token.Type{
+		token.IDENT, token.COLON, token.IDENT, token.DOT, token.IDENT,
+		token.COALESCE, token.STRING, token.COMMA,
+		token.IDENT, token.QUESTION, token.COLON, token.IDENT,
+		token.EOF,
+	}
+	for i, exp := range expected {
+		if tok := l.NextToken(); tok.Type != exp {
+			t.Fatalf("test[%d] - type wrong. expected=%q, got=%q (%q)", i, exp, tok.Type, tok.Literal)
+		}
+	}
+}
+
 func TestNextToken_Brackets(t *testing.T) {
 	input := `( ) { } [ ]`
 	l := New(input, "test")
@@ -58,14 +84,14 @@ func TestNextToken_Brackets(t *testing.T) {
 }
 
 func TestNextToken_Keywords(t *testing.T) {
-	input := `import type defaults as match guard respond input validate transform with headers cache cors auth none`
+	input := `import type defaults meta group pipeline use as match guard respond input validate transform map enrich forward with headers cookies cache cors auth retry paginate accepts compress none else when enum`
 	l := New(input, "test")
 
 	expected := []token.Type{
-		token.IMPORT, token.TYPE, token.DEFAULTS, token.AS,
+		token.IMPORT, token.TYPE, token.DEFAULTS, token.META, token.GROUP, token.PIPELINE, token.USE, token.AS,
 		token.MATCH, token.GUARD, token.RESPOND,
-		token.INPUT, token.VALIDATE, token.TRANSFORM,
-		token.WITH, token.HEADERS, token.CACHE, token.CORS, token.AUTH, token.NONE,
+		token.INPUT, token.VALIDATE, token.TRANSFORM, token.MAP, token.ENRICH, token.FORWARD,
+		token.WITH, token.HEADERS, token.COOKIES, token.CACHE, token.CORS, token.AUTH, token.RETRY, token.PAGINATE, token.ACCEPTS, token.COMPRESS, token.NONE, token.ELSE, token.WHEN, token.ENUM,
 		token.EOF,
 	}
 
@@ -137,11 +163,52 @@ func TestNextToken_StringLiteral(t *testing.T) {
 	}
 }
 
+func TestNextToken_TrailingBackslash(t *testing.T) {
+	tests := []struct {
+		input string
+		regex bool
+		want  token.Token
+	}{
+		{`"\`, false, token.Token{Type: token.STRING, Literal: `\`}},
+		{"\"a\\\nb", false, token.Token{Type: token.STRING, Literal: `a\`}},
+		{`/\`, true, token.Token{Type: token.REGEX, Literal: `\`}},
+	}
+	for _, tt := range tests {
+		l := New(tt.input, "test")
+		l.SetRegexMode(tt.regex)
+		tok := l.NextToken()
+		if tok.Type != tt.want.Type || tok.Literal != tt.want.Literal {
+			t.Errorf("%q: expected %s %q, got %s %q", tt.input, tt.want.Type, tt.want.Literal, tok.Type, tok.Literal)
+		}
+	}
+
+	// The backslash does not swallow the newline.
+	l := New("\"a\\\nb", "test")
+	l.NextToken() // "a\
+	l.NextToken() // NEWLINE
+	if tok := l.NextToken(); tok.Literal != "b" || tok.Pos.Line != 2 {
+		t.Errorf("expected b on line 2, got %q on line %d", tok.Literal, tok.Pos.Line)
+	}
+}
+
+func TestNextToken_TextBlock(t *testing.T) {
+	l := New("\"\"\"<p>\n  \"hi\"\n</p>\"\"\" GET", "test")
+
+	tok := l.NextToken()
+	if tok.Type != token.STRING || tok.Literal != "<p>\n  \"hi\"\n</p>" {
+		t.Fatalf("expected text block literal, got %s %q", tok.Type, tok.Literal)
+	}
+	tok = l.NextToken()
+	if tok.Type != token.GET || tok.Pos.Line != 3 || tok.Pos.Column != 9 {
+		t.Fatalf("expected GET at 3:9, got %s at %d:%d", tok.Type, tok.Pos.Line, tok.Pos.Column)
+	}
+}
+
 func TestNextToken_IntLiteral(t *testing.T) {
-	input := `123 400 200`
+	input := `123 400 200 -5 -100..0`
 	l := New(input, "test")
 
-	for _, exp := range []string{"123", "400", "200"} {
+	for _, exp := range []string{"123", "400", "200", "-5", "-100"} {
 		tok := l.NextToken()
 		if tok.Type != token.INT || tok.Literal != exp {
 			t.Fatalf("expected INT %q, got %s %q", exp, tok.Type, tok.Literal)
@@ -149,6 +216,59 @@ func TestNextToken_IntLiteral(t *testing.T) {
 	}
 }
 
+func TestNextToken_FloatLiteral(t *testing.T) {
+	l := New(`1.5 -90.0 0.25 200..299 0.1.0`, "test")
+
+	expected := []token.Token{
+		{Type: token.FLOAT, Literal: "1.5"},
+		{Type: token.FLOAT, Literal: "-90.0"},
+		{Type: token.FLOAT, Literal: "0.25"},
+		{Type: token.INT, Literal: "200"},
+		{Type: token.RANGE, Literal: ".."},
+		{Type: token.INT, Literal: "299"},
+		{Type: token.FLOAT, Literal: "0.1"},
+		{Type: token.DOT, Literal: "."},
+		{Type: token.INT, Literal: "0"},
+	}
+	for i, exp := range expected {
+		tok := l.NextToken()
+		if tok.Type != exp.Type || tok.Literal != exp.Literal {
+			t.Fatalf("token %d: expected %s %q, got %s %q", i, exp.Type, exp.Literal, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_End(t *testing.T) {
+	l := New("name \"a\\\"b\" \"\"\"\nline\n\"\"\" \"😀\"", "test")
+
+	// The end follows the source text, not the literal: quotes, escapes and
+	// the lines of a text block all count.
+	expected := []struct {
+		literal        string
+		line, col, c16 int
+	}{
+		{"name", 1, 5, 5},
+		{`a\"b`, 1, 12, 12},
+		{"\nline\n", 3, 4, 4},
+		{"😀", 3, 8, 9},
+	}
+	for i, exp := range expected {
+		tok := l.NextToken()
+		if tok.Literal != exp.literal {
+			t.Fatalf("token %d: expected %q, got %q", i, exp.literal, tok.Literal)
+		}
+		if tok.End.Line != exp.line || tok.End.Column != exp.col || tok.End.UTF16Column != exp.c16 {
+			t.Errorf("token %d: expected end %d:%d (UTF-16 %d), got %+v", i, exp.line, exp.col, exp.c16, tok.End)
+		}
+	}
+
+	l = New("/^a/i", "test")
+	l.SetRegexMode(true)
+	if tok := l.NextToken(); tok.Type != token.REGEX || tok.End.Column != 6 {
+		t.Errorf("expected a regex ending at column 6, got %s %+v", tok.Type, tok.End)
+	}
+}
+
 func TestNextToken_RegexMode(t *testing.T) {
 	l := New(`/^admin/`, "test")
 	l.SetRegexMode(true)
@@ -159,6 +279,86 @@ func TestNextToken_RegexMode(t *testing.T) {
 	}
 }
 
+func TestNextToken_UnterminatedRegex(t *testing.T) {
+	l := New("  /^admin\n", "test")
+	l.SetRegexMode(true)
+
+	tok := l.NextToken()
+	if tok.Type != token.REGEX || tok.Literal != "^admin" {
+		t.Fatalf("expected REGEX '^admin', got %s %q", tok.Type, tok.Literal)
+	}
+	errs := l.Errors()
+	if len(errs) != 1 || errs[0].Message != "unterminated regex literal" {
+		t.Fatalf("expected unterminated regex error, got %v", errs)
+	}
+	if errs[0].Pos.Line != 1 || errs[0].Pos.Column != 3 || errs[0].Error() != "test:1:3: unterminated regex literal" {
+		t.Fatalf("expected the error at the opening slash, got %v", errs[0])
+	}
+	if tok := l.NextToken(); tok.Type != token.NEWLINE {
+		t.Fatalf("expected NEWLINE after the regex, got %s", tok.Type)
+	}
+}
+
+func TestNextToken_RegexFlags(t *testing.T) {
+	l := New(`/^admin$/i: /^\/api/ms`, "test")
+	l.SetRegexMode(true)
+
+	tok := l.NextToken()
+	if tok.Type != token.REGEX || tok.Literal != "^admin$/i" {
+		t.Fatalf("expected REGEX '^admin$/i', got %s %q", tok.Type, tok.Literal)
+	}
+	if tok := l.NextToken(); tok.Type != token.COLON {
+		t.Fatalf("expected COLON after flags, got %s %q", tok.Type, tok.Literal)
+	}
+	tok = l.NextToken()
+	if tok.Type != token.REGEX || tok.Literal != `^\/api/ms` {
+		t.Fatalf("expected REGEX with flags ms, got %s %q", tok.Type, tok.Literal)
+	}
+}
+
+func TestSplitRegex(t *testing.T) {
+	tests := []struct {
+		lit, pattern, flags string
+	}{
+		{"^admin", "^admin", ""},
+		{"^admin$/i", "^admin$", "i"},
+		{`^\/api\/v1`, `^\/api\/v1`, ""},
+		{`^\/is`, `^\/is`, ""},
+		{`^\/api/ms`, `^\/api`, "ms"},
+	}
+	for _, tt := range tests {
+		pattern, flags := SplitRegex(tt.lit)
+		if pattern != tt.pattern || flags != tt.flags {
+			t.Errorf("SplitRegex(%q) = %q, %q; want %q, %q", tt.lit, pattern, flags, tt.pattern, tt.flags)
+		}
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	tests := []struct {
+		lit  string
+		want []Placeholder
+	}{
+		{"user:{id}", []Placeholder{{Offset: 6, Name: "id"}}},
+		{"/users/{user.id}/{x-role}", []Placeholder{{Offset: 8, Name: "user.id"}, {Offset: 18, Name: "x-role"}}},
+		{"plain", nil},
+		{`\{id\}`, nil},
+		{`\\{id}`, []Placeholder{{Offset: 3, Name: "id"}}},
+		{"{} { id } {1} {user.} {id", nil},
+	}
+	for _, tt := range tests {
+		if got := Placeholders(tt.lit); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Placeholders(%q) = %v; want %v", tt.lit, got, tt.want)
+		}
+	}
+
+	// The literal of a string token keeps the escapes Placeholders reads.
+	tok := New(`"key:{id} \{id\}"`, "test").NextToken()
+	if got := Placeholders(tok.Literal); len(got) != 1 || got[0].Name != "id" {
+		t.Errorf("expected one placeholder in %q, got %v", tok.Literal, got)
+	}
+}
+
 func TestNextToken_SlashWithoutRegexMode(t *testing.T) {
 	l := New(`/users`, "test")
 
@@ -182,6 +382,36 @@ func TestNextToken_Comment(t *testing.T) {
 	}
 }
 
+func TestNextToken_CaptureComments(t *testing.T) {
+	input := `# detached
+
+# Get a user
+# by id
+GET /users/{id} # trailing
+  |> respond 200
+POST /users`
+	l := New(input, "test")
+	l.SetCaptureComments(true)
+
+	docs := map[string]string{}
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Doc != "" {
+			docs[tok.Literal] = tok.Doc
+		}
+	}
+	if len(docs) != 1 || docs["GET"] != "Get a user\nby id" {
+		t.Fatalf("expected only GET to carry \"Get a user\\nby id\", got %q", docs)
+	}
+}
+
+func TestNextToken_CommentsNotCapturedByDefault(t *testing.T) {
+	l := New("# Get a user\nGET", "test")
+	l.NextToken() // NEWLINE
+	if tok := l.NextToken(); tok.Doc != "" {
+		t.Fatalf("expected no doc without comment capture, got %q", tok.Doc)
+	}
+}
+
 func TestNextToken_NewlineSuppression(t *testing.T) {
 	// Newlines inside parentheses should be suppressed
 	input := "(\n\n)"
@@ -197,6 +427,100 @@ func TestNextToken_NewlineSuppression(t *testing.T) {
 	}
 }
 
+func TestNextToken_GroupBraceKeepsNewlines(t *testing.T) {
+	// The brace ending a group line opens a newline-sensitive block; braces
+	// of path parameters are part of the path.
+	input := "group /orgs/{org} { # members\nGET /\n}\nx { \n }"
+	l := New(input, "test")
+
+	expected := []token.Type{
+		token.GROUP, token.PATH, token.LBRACE, token.NEWLINE,
+		token.GET, token.PATH, token.NEWLINE,
+		token.RBRACE, token.NEWLINE,
+		token.IDENT, token.LBRACE, token.RBRACE,
+		token.EOF,
+	}
+	for i, exp := range expected {
+		tok := l.NextToken()
+		if tok.Type != exp {
+			t.Fatalf("test[%d] - type wrong. expected=%q, got=%q (literal=%q)", i, exp, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestNextToken_LineContinuation(t *testing.T) {
+	// A trailing backslash, optionally followed by blanks, joins the next
+	// line; a backslash elsewhere is still illegal.
+	input := "|> guard user \\\n    else respond 404\n|> x \\  \r\n  y\n\\ z"
+	l := New(input, "test")
+
+	expected := []struct {
+		typ  token.Type
+		line int
+	}{
+		{token.PIPE, 1}, {token.GUARD, 1}, {token.IDENT, 1},
+		{token.ELSE, 2}, {token.RESPOND, 2}, {token.INT, 2}, {token.NEWLINE, 2},
+		{token.PIPE, 3}, {token.IDENT, 3},
+		{token.IDENT, 4}, {token.NEWLINE, 4},
+		{token.ILLEGAL, 5}, {token.IDENT, 5},
+		{token.EOF, 5},
+	}
+	for i, exp := range expected {
+		tok := l.NextToken()
+		if tok.Type != exp.typ || tok.Pos.Line != exp.line {
+			t.Fatalf("test[%d] - expected %s on line %d, got %s (%q) on line %d", i, exp.typ, exp.line, tok.Type, tok.Literal, tok.Pos.Line)
+		}
+	}
+
+	// Columns restart on the continued line.
+	l = New("x \\\n  y", "test")
+	l.NextToken() // x
+	if tok := l.NextToken(); tok.Literal != "y" || tok.Pos.Line != 2 || tok.Pos.Column != 3 {
+		t.Errorf("expected y at 2:3, got %q at %d:%d", tok.Literal, tok.Pos.Line, tok.Pos.Column)
+	}
+}
+
+func TestNextToken_Path(t *testing.T) {
+	tests := []struct {
+		input string
+		path  string
+		next  token.Type
+	}{
+		{"GET /v1.0/users\n", "/v1.0/users", token.NEWLINE},
+		{"GET /a..b", "/a..b", token.EOF},
+		{"GET /users/{id}  # get a user\n", "/users/{id}", token.NEWLINE},
+		{"GET /files/{path*}.json", "/files/{path*}.json", token.EOF},
+		{"group /api/v1-beta {", "/api/v1-beta", token.LBRACE},
+	}
+	for _, tt := range tests {
+		l := New(tt.input, "test")
+		l.NextToken() // method or group
+		tok := l.NextToken()
+		if tok.Type != token.PATH || tok.Literal != tt.path {
+			t.Errorf("%q: expected PATH %q, got %s %q", tt.input, tt.path, tok.Type, tok.Literal)
+			continue
+		}
+		if tok.Pos.Column != len(strings.Fields(tt.input)[0])+2 {
+			t.Errorf("%q: expected the path to start at column %d, got %d", tt.input, len(strings.Fields(tt.input)[0])+2, tok.Pos.Column)
+		}
+		if next := l.NextToken(); next.Type != tt.next {
+			t.Errorf("%q: expected %s after the path, got %s", tt.input, tt.next, next.Type)
+		}
+	}
+
+	// Elsewhere a slash is still an operator, and a method not followed by
+	// a path leaves the next token alone.
+	l := New("defaults for GET, POST { a / b }", "test")
+	var types []token.Type
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		types = append(types, tok.Type)
+	}
+	want := []token.Type{token.DEFAULTS, token.IDENT, token.GET, token.COMMA, token.POST, token.LBRACE, token.IDENT, token.SLASH, token.IDENT, token.RBRACE}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("expected %v, got %v", want, types)
+	}
+}
+
 func TestNextToken_Underscore(t *testing.T) {
 	input := `_`
 	l := New(input, "test")
@@ -218,12 +542,7 @@ func TestNextToken_FullRoute(t *testing.T) {
 		lit string
 	}{
 		{token.GET, "GET"},
-		{token.SLASH, "/"},
-		{token.IDENT, "users"},
-		{token.SLASH, "/"},
-		{token.LBRACE, "{"},
-		{token.IDENT, "id"},
-		{token.RBRACE, "}"},
+		{token.PATH, "/users/{id}"},
 		{token.NEWLINE, "\n"},
 		{token.PIPE, "|>"},
 		{token.INPUT, "input"},
@@ -275,3 +594,116 @@ func TestNextToken_Position(t *testing.T) {
 		t.Fatalf("expected line 2, got line %d", tok.Pos.Line)
 	}
 }
+
+func TestNextToken_PositionAfterMultibyte(t *testing.T) {
+	tests := []struct {
+		input       string
+		column      int
+		utf16Column int
+	}{
+		{`"café" x`, 8, 8},
+		{`"😀" x`, 5, 6},
+		{"\"\"\"\nçà\n\"\"\" x", 5, 5},
+	}
+	for _, tt := range tests {
+		l := New(tt.input, "test")
+		l.NextToken() // string
+		tok := l.NextToken()
+		if tok.Type != token.IDENT || tok.Literal != "x" {
+			t.Fatalf("%q: expected IDENT x, got %s %q", tt.input, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Column != tt.column || tok.Pos.UTF16Column != tt.utf16Column {
+			t.Errorf("%q: expected column %d (UTF-16 %d), got %d (UTF-16 %d)",
+				tt.input, tt.column, tt.utf16Column, tok.Pos.Column, tok.Pos.UTF16Column)
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/lexer")
+
+// TestGoldenTokens tokenizes each testdata/lexer/*.rever file and compares
+// the dump against the matching .tokens file. A first line of the form
+// "# lexer: regex comments" turns the named modes on before lexing, since
+// the parser normally switches them. Run with -update to regenerate.
+func TestGoldenTokens(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "testdata", "lexer", "*.rever"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no golden inputs in testdata/lexer")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			input, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := dumpTokens(t, string(input), filepath.Base(file))
+
+			golden := strings.TrimSuffix(file, ".rever") + ".tokens"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test ./internal/lexer -update to create it)", err)
+			}
+			if got == string(want) {
+				return
+			}
+			gotLines, wantLines := strings.Split(got, "\n"), strings.Split(string(want), "\n")
+			for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+				var g, w string
+				if i < len(gotLines) {
+					g = gotLines[i]
+				}
+				if i < len(wantLines) {
+					w = wantLines[i]
+				}
+				if g != w {
+					t.Fatalf("token dump differs from %s at line %d:\n  want: %s\n  got:  %s", filepath.Base(golden), i+1, w, g)
+				}
+			}
+		})
+	}
+}
+
+// dumpTokens renders one token per line as "line:col TYPE literal", with
+// any doc comment and the lexer errors after the stream.
+func dumpTokens(t *testing.T, input, name string) string {
+	t.Helper()
+	l := New(input, name)
+	if first, _, _ := strings.Cut(input, "\n"); strings.HasPrefix(first, "# lexer:") {
+		for _, mode := range strings.Fields(strings.TrimPrefix(first, "# lexer:")) {
+			switch mode {
+			case "regex":
+				l.SetRegexMode(true)
+			case "comments":
+				l.SetCaptureComments(true)
+			default:
+				t.Fatalf("unknown lexer mode %q", mode)
+			}
+		}
+	}
+
+	var b strings.Builder
+	for {
+		tok := l.NextToken()
+		fmt.Fprintf(&b, "%d:%d %s %q", tok.Pos.Line, tok.Pos.Column, tok.Type, tok.Literal)
+		if tok.Doc != "" {
+			fmt.Fprintf(&b, " doc=%q", tok.Doc)
+		}
+		b.WriteByte('\n')
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+	for _, e := range l.Errors() {
+		fmt.Fprintf(&b, "error %d:%d %s\n", e.Pos.Line, e.Pos.Column, e.Message)
+	}
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package lsp
+
+import (
+	"fmt"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/lexer"
+	"github.com/polidog/reverhttp/internal/sema"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+// stdPackagePrefix is the module path assumed for a missing package alias.
+const stdPackagePrefix = "github.com/reverhttp/std-"
+
+// CodeActions returns the quick fixes for the semantic diagnostics that
+// overlap rng: each unknown package alias gets an action adding its import.
+// Diagnostics are recomputed from text: the copies sent back by the client
+// do not reliably carry their code.
+func CodeActions(uri, text string, rng protocol.Range) []protocol.CodeAction {
+	file, errs := parseDocument(text)
+	if len(errs) > 0 {
+		return nil
+	}
+
+	actions := []protocol.CodeAction{}
+	seen := make(map[string]bool)
+	for _, diag := range semanticDiagnostics(file) {
+		if diag.Code == nil || diag.Code.Value != sema.CodeUnknownPackage || !rangesOverlap(diag.Range, rng) {
+			continue
+		}
+		tok, ok := tokenAt(text, diag.Range.Start)
+		if !ok || seen[tok.Literal] {
+			continue
+		}
+		seen[tok.Literal] = true
+
+		kind := protocol.CodeActionKindQuickFix
+		preferred := true
+		actions = append(actions, protocol.CodeAction{
+			Title:       fmt.Sprintf("Add import for %s", tok.Literal),
+			Kind:        &kind,
+			Diagnostics: []protocol.Diagnostic{diag},
+			IsPreferred: &preferred,
+			Edit: &protocol.WorkspaceEdit{
+				Changes: map[protocol.DocumentUri][]protocol.TextEdit{
+					uri: {importEdit(text, file, tok.Literal)},
+				},
+			},
+		})
+	}
+	return actions
+}
+
+// importEdit inserts an import for alias after the existing imports. When
+// there are none it goes after the version pragma and the meta block, which
+// come first, or at the top of the file; a blank line separates it.
+func importEdit(text string, file *ast.File, alias string) protocol.TextEdit {
+	line := fmt.Sprintf("import %s = %s%s@latest\n", alias, stdPackagePrefix, alias)
+	if len(file.Imports) == 0 {
+		after := file.VersionPos
+		if file.Meta != nil {
+			after = closingBrace(text, file.Meta.Pos)
+		}
+		if after.Line == 0 {
+			return protocol.TextEdit{NewText: line + "\n"}
+		}
+		pos := protocol.Position{Line: toProtocolPosition(after).Line + 1}
+		return protocol.TextEdit{Range: protocol.Range{Start: pos, End: pos}, NewText: "\n" + line}
+	}
+
+	last := file.Imports[0].Pos
+	for _, imp := range file.Imports {
+		if imp.Pos.Line > last.Line {
+			last = imp.Pos
+		}
+	}
+	pos := protocol.Position{Line: toProtocolPosition(last).Line + 1}
+	return protocol.TextEdit{Range: protocol.Range{Start: pos, End: pos}, NewText: line}
+}
+
+// closingBrace returns the position of the first '}' after start, which
+// ends a block without nested braces such as meta.
+func closingBrace(text string, start token.Position) token.Position {
+	l := lexer.New(text, "buffer")
+	for {
+		tok := l.NextToken()
+		switch {
+		case tok.Type == token.EOF:
+			return tok.Pos
+		case tok.Type == token.RBRACE && (tok.Pos.Line > start.Line || tok.Pos.Line == start.Line && tok.Pos.Column > start.Column):
+			return tok.Pos
+		}
+	}
+}
+
+func rangesOverlap(a, b protocol.Range) bool {
+	return !positionLess(a.End, b.Start) && !positionLess(b.End, a.Start)
+}
+
+func positionLess(a, b protocol.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
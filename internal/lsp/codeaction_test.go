@@ -0,0 +1,105 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+func TestCodeActionAddMissingImport(t *testing.T) {
+	text := `GET /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { id: user.id }`
+
+	rng := protocol.Range{
+		Start: protocol.Position{Line: 2, Character: 6},
+		End:   protocol.Position{Line: 2, Character: 6},
+	}
+	actions := CodeActions("file:///test.rever", text, rng)
+	if len(actions) != 1 {
+		t.Fatalf("expected 1 action, got %+v", actions)
+	}
+	action := actions[0]
+	if action.Title != "Add import for fetch" {
+		t.Fatalf("unexpected title %q", action.Title)
+	}
+	if action.Kind == nil || *action.Kind != protocol.CodeActionKindQuickFix {
+		t.Fatalf("expected quickfix kind, got %v", action.Kind)
+	}
+
+	edits := action.Edit.Changes["file:///test.rever"]
+	if len(edits) != 1 {
+		t.Fatalf("expected 1 edit, got %+v", edits)
+	}
+	want := "import fetch = github.com/reverhttp/std-fetch@latest\n\n"
+	if edits[0].NewText != want {
+		t.Fatalf("expected %q, got %q", want, edits[0].NewText)
+	}
+	if edits[0].Range != (protocol.Range{}) {
+		t.Fatalf("expected insertion at start of file, got %+v", edits[0].Range)
+	}
+}
+
+func TestCodeActionImportAfterExisting(t *testing.T) {
+	text := `import create = github.com/reverhttp/std-create@0.1.0
+
+POST /users
+  |> input(name: body.name)
+  |> create(User, { name }) as user
+  |> fetch(User, name) as again
+  |> respond 201 { id: user.id }`
+
+	rng := protocol.Range{End: protocol.Position{Line: 6}}
+	actions := CodeActions("file:///test.rever", text, rng)
+	if len(actions) != 1 {
+		t.Fatalf("expected 1 action, got %+v", actions)
+	}
+	edit := actions[0].Edit.Changes["file:///test.rever"][0]
+	if edit.Range.Start.Line != 1 || edit.NewText != "import fetch = github.com/reverhttp/std-fetch@latest\n" {
+		t.Fatalf("expected import inserted on line 1, got %+v", edit)
+	}
+}
+
+func TestCodeActionImportAfterVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		line uint32
+	}{
+		{"version", "version 0.2\n\nGET /users\n  |> fetch(User) as users\n  |> respond 200 { users }", 1},
+		{"meta", "version 0.2\n\nmeta {\n  title: \"Users}\"\n}\n\nGET /users\n  |> fetch(User) as users\n  |> respond 200 { users }", 5},
+	}
+	for _, tt := range tests {
+		rng := protocol.Range{End: protocol.Position{Line: 20}}
+		actions := CodeActions("file:///test.rever", tt.text, rng)
+		if len(actions) != 1 {
+			t.Fatalf("%s: expected 1 action, got %+v", tt.name, actions)
+		}
+		edit := actions[0].Edit.Changes["file:///test.rever"][0]
+		if edit.Range.Start.Line != tt.line || edit.Range.Start.Character != 0 {
+			t.Fatalf("%s: expected the import inserted on line %d, got %+v", tt.name, tt.line, edit.Range)
+		}
+
+		// The edited file must still parse, with the pragma first.
+		lines := strings.SplitAfter(tt.text, "\n")
+		edited := strings.Join(lines[:tt.line], "") + edit.NewText + strings.Join(lines[tt.line:], "")
+		file, errs := parseDocument(edited)
+		if len(errs) != 0 || len(file.Imports) != 1 || file.Imports[0].Alias != "fetch" {
+			t.Errorf("%s: expected the edited file to parse with the import, got %v in %q", tt.name, errs, edited)
+		}
+	}
+}
+
+func TestCodeActionOutsideRange(t *testing.T) {
+	text := `GET /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { id: user.id }`
+
+	rng := protocol.Range{End: protocol.Position{Line: 0, Character: 3}}
+	if actions := CodeActions("file:///test.rever", text, rng); len(actions) != 0 {
+		t.Fatalf("expected no actions, got %+v", actions)
+	}
+}
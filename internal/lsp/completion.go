@@ -1,9 +1,13 @@
 package lsp
 
 import (
+	"sort"
 	"strings"
 
 	protocol "github.com/tliron/glsp/protocol_3_16"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/token"
 )
 
 func Complete(text string, pos protocol.Position) []protocol.CompletionItem {
@@ -21,6 +25,20 @@ func Complete(text string, pos protocol.Position) []protocol.CompletionItem {
 		for _, kw := range pipelineSteps {
 			items = append(items, protocol.CompletionItem{Label: kw, Kind: &kind})
 		}
+		file, _ := parseDocument(text)
+		moduleKind := protocol.CompletionItemKindModule
+		for _, imp := range file.Imports {
+			detail := imp.Source
+			items = append(items, protocol.CompletionItem{Label: imp.Alias, Kind: &moduleKind, Detail: &detail})
+		}
+	case contextPkgCallType:
+		file, _ := parseDocument(text)
+		classKind := protocol.CompletionItemKindClass
+		for _, td := range file.Types {
+			items = append(items, protocol.CompletionItem{Label: td.Name, Kind: &classKind})
+		}
+	case contextRespondBody:
+		items = respondBodyItems(text, pos)
 	case contextDefaults:
 		for _, kw := range directiveKeywords {
 			items = append(items, protocol.CompletionItem{Label: kw, Kind: &kind})
@@ -41,24 +59,26 @@ const (
 	contextPipeline
 	contextDefaults
 	contextValidate
+	contextPkgCallType
+	contextRespondBody
 )
 
 var topLevelKeywords = []string{
-	"import", "type", "defaults",
+	"meta", "import", "type", "enum", "defaults", "group", "pipeline",
 	"GET", "POST", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS",
 }
 
 var pipelineSteps = []string{
-	"input", "validate", "transform", "guard", "match", "respond",
+	"input", "validate", "transform", "guard", "match", "map", "enrich", "forward", "use", "respond",
 }
 
 var directiveKeywords = []string{
-	"cache", "cors", "auth",
+	"cache", "cors", "auth", "retry", "paginate", "accepts", "compress",
 }
 
 var validateKeywords = []string{
 	"int", "string", "bool", "float", "datetime",
-	"min", "max", "format",
+	"min", "max", "between", "minLength", "maxLength", "length", "format", "oneOf", "pattern",
 }
 
 func detectContext(text string, pos protocol.Position) completionContext {
@@ -68,6 +88,14 @@ func detectContext(text string, pos protocol.Position) completionContext {
 		return contextTopLevel
 	}
 
+	prefix := linePrefix(lines[lineIdx], pos.Character)
+	if isPkgCallTypeArg(prefix) {
+		return contextPkgCallType
+	}
+	if isRespondBodyValue(prefix) {
+		return contextRespondBody
+	}
+
 	// Search backwards from the cursor line to determine context.
 	for i := lineIdx; i >= 0; i-- {
 		line := strings.TrimSpace(lines[i])
@@ -96,3 +124,217 @@ func detectContext(text string, pos protocol.Position) completionContext {
 
 	return contextTopLevel
 }
+
+// linePrefix returns the part of line before the UTF-16 character offset.
+func linePrefix(line string, character uint32) string {
+	n := uint32(0)
+	for i, r := range line {
+		if n >= character {
+			return line[:i]
+		}
+		n++
+		if r > 0xFFFF {
+			n++
+		}
+	}
+	return line
+}
+
+// isPkgCallTypeArg reports whether prefix ends at the first argument of a
+// package call such as "|> fetch(" or `"user": fetch(Us`.
+func isPkgCallTypeArg(prefix string) bool {
+	type open struct {
+		ch     byte
+		pos    int
+		commas int
+	}
+	var stack []open
+	inString := false
+	for i := 0; i < len(prefix); i++ {
+		c := prefix[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '#':
+			return false
+		case '(', '{', '[':
+			stack = append(stack, open{ch: c, pos: i})
+		case ')', '}', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case ',':
+			if len(stack) > 0 {
+				stack[len(stack)-1].commas++
+			}
+		}
+	}
+	if inString || len(stack) == 0 {
+		return false
+	}
+
+	top := stack[len(stack)-1]
+	if top.ch != '(' || top.commas > 0 {
+		return false
+	}
+	// Enclosing brackets may only be match blocks; anything else means we are
+	// inside a builtin step or directive argument list.
+	for _, o := range stack[:len(stack)-1] {
+		if o.ch != '{' {
+			return false
+		}
+	}
+	// The argument typed so far must look like an identifier.
+	arg := strings.TrimSpace(prefix[top.pos+1:])
+	if strings.IndexFunc(arg, func(r rune) bool { return !isIdentRune(r) }) != -1 {
+		return false
+	}
+
+	before := strings.TrimRight(prefix[:top.pos], " \t")
+	start := strings.LastIndexFunc(before, func(r rune) bool { return !isIdentRune(r) }) + 1
+	name := before[start:]
+	if name == "" || token.LookupIdent(name) != token.IDENT {
+		return false
+	}
+
+	lead := strings.TrimSpace(before[:start])
+	return strings.HasSuffix(lead, "|>") || strings.HasSuffix(lead, ":")
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+// isRespondBodyValue reports whether prefix ends in a value position of a
+// respond body, e.g. "|> respond 200 { id: us".
+func isRespondBodyValue(prefix string) bool {
+	idx := strings.LastIndex(prefix, "respond")
+	if idx == -1 {
+		return false
+	}
+
+	depth := 0
+	var lastSep byte
+	inString := false
+	rest := prefix[idx+len("respond"):]
+	for i := 0; i < len(rest); i++ {
+		c := rest[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+			lastSep = c
+		case '}':
+			depth--
+		case ',', ':':
+			lastSep = c
+		}
+	}
+	return !inString && depth > 0 && lastSep == ':'
+}
+
+// respondBodyItems offers the names bound earlier in the enclosing route, or
+// the fields of a bound value's type after "name.".
+func respondBodyItems(text string, pos protocol.Position) []protocol.CompletionItem {
+	lines := strings.Split(text, "\n")
+	before := strings.Join(lines[:pos.Line], "\n")
+	if pos.Line > 0 {
+		before += "\n"
+	}
+	prefix := linePrefix(lines[pos.Line], pos.Character)
+	before += prefix
+
+	file, _ := parseDocument(before)
+	if len(file.Routes) == 0 {
+		return nil
+	}
+	bindings := routeBindings(file.Routes[len(file.Routes)-1])
+
+	var items []protocol.CompletionItem
+
+	word := prefix[strings.LastIndexFunc(prefix, func(r rune) bool { return !isIdentRune(r) && r != '.' })+1:]
+	if dot := strings.Index(word, "."); dot != -1 {
+		typeName, ok := bindings[word[:dot]]
+		if !ok || typeName == "" {
+			return nil
+		}
+		full, _ := parseDocument(text)
+		fieldKind := protocol.CompletionItemKindField
+		for _, td := range full.Types {
+			if td.Name != typeName {
+				continue
+			}
+			for _, f := range td.Fields {
+				detail := f.TypeName
+				items = append(items, protocol.CompletionItem{Label: f.Name, Kind: &fieldKind, Detail: &detail})
+			}
+		}
+		return items
+	}
+
+	names := make([]string, 0, len(bindings))
+	for name := range bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	varKind := protocol.CompletionItemKindVariable
+	for _, name := range names {
+		item := protocol.CompletionItem{Label: name, Kind: &varKind}
+		if typeName := bindings[name]; typeName != "" {
+			detail := typeName
+			item.Detail = &detail
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// routeBindings maps each name bound in the route to its type name, which is
+// empty when the type cannot be inferred.
+func routeBindings(route *ast.Route) map[string]string {
+	bindings := make(map[string]string)
+	for _, d := range route.EffectiveDirectives() {
+		if d.Bind != "" {
+			bindings[d.Bind] = ""
+		}
+	}
+	for _, step := range route.Steps {
+		switch step.Kind {
+		case ast.StepInput:
+			for _, f := range step.Input.Fields {
+				bindings[f.Name] = ""
+			}
+		case ast.StepTransform:
+			for _, f := range step.Transform.Fields {
+				bindings[f.Name] = ""
+			}
+		}
+		if step.Bind == "" {
+			continue
+		}
+		typeName := ""
+		if step.Kind == ast.StepPkgCall && len(step.PkgCall.Args) > 0 && step.PkgCall.Args[0].Value.Kind == ast.ExprType {
+			typeName = step.PkgCall.Args[0].Value.StrVal
+		}
+		bindings[step.Bind] = typeName
+	}
+	return bindings
+}
@@ -0,0 +1,137 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+const completionSource = `import fetch = github.com/reverhttp/std-fetch@0.1.0
+import create = github.com/reverhttp/std-create@0.1.0
+
+type User { id: int }
+type Admin { id: int }
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> `
+
+// completeAt completes at the end of the given 0-based line of text.
+func completeAt(text string, line int) []protocol.CompletionItem {
+	lines := strings.Split(text, "\n")
+	return Complete(text, protocol.Position{Line: uint32(line), Character: uint32(len(lines[line]))})
+}
+
+func completionLabels(items []protocol.CompletionItem) map[string]protocol.CompletionItemKind {
+	labels := make(map[string]protocol.CompletionItemKind)
+	for _, item := range items {
+		labels[item.Label] = *item.Kind
+	}
+	return labels
+}
+
+func TestCompleteTypeNamesInPkgCall(t *testing.T) {
+	text := completionSource + "fetch("
+	labels := completionLabels(completeAt(text, 8))
+
+	for _, name := range []string{"User", "Admin"} {
+		if kind, ok := labels[name]; !ok || kind != protocol.CompletionItemKindClass {
+			t.Fatalf("expected %s as class completion, got %v", name, labels)
+		}
+	}
+	if _, ok := labels["respond"]; ok {
+		t.Fatalf("expected no step keywords inside pkg call, got %v", labels)
+	}
+}
+
+func TestCompleteTypeNamesInMatchArm(t *testing.T) {
+	text := completionSource + "match role {\n       \"admin\": fetch(Ad"
+	labels := completionLabels(completeAt(text, 9))
+
+	if _, ok := labels["Admin"]; !ok {
+		t.Fatalf("expected Admin completion in match arm, got %v", labels)
+	}
+}
+
+func TestCompleteNoTypeNamesAfterFirstArg(t *testing.T) {
+	text := completionSource + "fetch(User, "
+	labels := completionLabels(completeAt(text, 8))
+
+	if _, ok := labels["User"]; ok {
+		t.Fatalf("expected no type completion after first arg, got %v", labels)
+	}
+}
+
+func TestCompleteNoTypeNamesInConstraint(t *testing.T) {
+	text := completionSource + "validate(id: int & min("
+	labels := completionLabels(completeAt(text, 8))
+
+	if _, ok := labels["User"]; ok {
+		t.Fatalf("expected no type completion in constraint args, got %v", labels)
+	}
+}
+
+func TestCompleteImportAliasesAtStepStart(t *testing.T) {
+	labels := completionLabels(completeAt(completionSource, 8))
+
+	for _, alias := range []string{"fetch", "create"} {
+		if kind, ok := labels[alias]; !ok || kind != protocol.CompletionItemKindModule {
+			t.Fatalf("expected alias %s as module completion, got %v", alias, labels)
+		}
+	}
+	if _, ok := labels["respond"]; !ok {
+		t.Fatalf("expected step keywords to remain, got %v", labels)
+	}
+}
+
+const respondSource = `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+type User { id: int, name: string }
+
+GET /admins/{id}
+  |> input(admin_id: path.id)
+  |> fetch(User, admin_id) as admin
+  |> respond 200 { id: admin.id }
+
+GET /users/{id}
+  auth(bearer) as current_user
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { id: `
+
+func TestCompleteBoundVariablesInRespondBody(t *testing.T) {
+	labels := completionLabels(completeAt(respondSource, 13))
+
+	for _, name := range []string{"user", "current_user", "id"} {
+		if kind, ok := labels[name]; !ok || kind != protocol.CompletionItemKindVariable {
+			t.Fatalf("expected %s as variable completion, got %v", name, labels)
+		}
+	}
+	if _, ok := labels["admin"]; ok {
+		t.Fatalf("expected admin (bound in another route) not to be offered, got %v", labels)
+	}
+	if _, ok := labels["respond"]; ok {
+		t.Fatalf("expected no step keywords in respond body, got %v", labels)
+	}
+}
+
+func TestCompleteBoundFieldsInRespondBody(t *testing.T) {
+	labels := completionLabels(completeAt(respondSource+"user.", 13))
+
+	for _, field := range []string{"id", "name"} {
+		if kind, ok := labels[field]; !ok || kind != protocol.CompletionItemKindField {
+			t.Fatalf("expected field %s completion, got %v", field, labels)
+		}
+	}
+	if _, ok := labels["current_user"]; ok {
+		t.Fatalf("expected only fields after 'user.', got %v", labels)
+	}
+}
+
+func TestCompleteRespondBodyKeyPosition(t *testing.T) {
+	labels := completionLabels(completeAt(respondSource+"id, ", 13))
+	if _, ok := labels["user"]; ok {
+		t.Fatalf("expected no variable completion in key position, got %v", labels)
+	}
+}
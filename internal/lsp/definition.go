@@ -0,0 +1,97 @@
+package lsp
+
+import (
+	"net/url"
+	"path/filepath"
+	"unicode/utf16"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/resolve"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+type symbolKind int
+
+const (
+	symbolType symbolKind = iota
+	symbolImport
+)
+
+// symbol is a top-level declaration that references can resolve to.
+type symbol struct {
+	Name       string
+	Kind       symbolKind
+	Range      protocol.Range
+	TypeDecl   *ast.TypeDecl
+	ImportDecl *ast.ImportDecl
+}
+
+// buildSymbols collects type and import declarations keyed by name.
+func buildSymbols(file *ast.File) map[string]*symbol {
+	symbols := make(map[string]*symbol)
+	for _, td := range file.Types {
+		symbols[td.Name] = &symbol{
+			Name:     td.Name,
+			Kind:     symbolType,
+			Range:    nameRange(td.NamePos, td.Name),
+			TypeDecl: td,
+		}
+	}
+	for _, imp := range file.Imports {
+		symbols[imp.Alias] = &symbol{
+			Name:       imp.Alias,
+			Kind:       symbolImport,
+			Range:      nameRange(imp.AliasPos, imp.Alias),
+			ImportDecl: imp,
+		}
+	}
+	return symbols
+}
+
+// nameRange is the range of a name declared at pos, which has no delimiters.
+func nameRange(pos token.Position, name string) protocol.Range {
+	start := toProtocolPosition(pos)
+	end := start
+	end.Character += uint32(len(utf16.Encode([]rune(name))))
+	return protocol.Range{Start: start, End: end}
+}
+
+// Definition resolves the type or import reference at pos to its declaration.
+func Definition(uri, text string, pos protocol.Position) []protocol.Location {
+	tok, ok := tokenAt(text, pos)
+	if !ok {
+		return nil
+	}
+
+	file, _ := parseDocument(text)
+	sym, ok := buildSymbols(file)[tok.Literal]
+	if !ok {
+		return nil
+	}
+
+	if sym.Kind == symbolImport && sym.ImportDecl.Local {
+		if target := resolveLocalImport(uri, sym.ImportDecl.Source); target != "" {
+			return []protocol.Location{{URI: target}}
+		}
+	}
+
+	return []protocol.Location{{URI: uri, Range: sym.Range}}
+}
+
+// resolveLocalImport maps an "@/path" import to a file URI using the same
+// rules as reverc (see resolve.LocalPath). It returns "" when the target does
+// not exist.
+func resolveLocalImport(docURI, source string) string {
+	u, err := url.Parse(docURI)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+
+	target, err := resolve.LocalPath(filepath.FromSlash(u.Path), source)
+	if err != nil {
+		return ""
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(target)}).String()
+}
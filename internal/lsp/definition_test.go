@@ -0,0 +1,74 @@
+package lsp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+func TestDefinitionTypeReference(t *testing.T) {
+	// hoverSource declares "type User {" on line 2; "User" starts at character 5.
+	locs := Definition("file:///api.rever", hoverSource, protocol.Position{Line: 9, Character: 12})
+	if len(locs) != 1 {
+		t.Fatalf("expected 1 location, got %d", len(locs))
+	}
+
+	want := protocol.Range{
+		Start: protocol.Position{Line: 2, Character: 5},
+		End:   protocol.Position{Line: 2, Character: 9},
+	}
+	if locs[0].URI != "file:///api.rever" || locs[0].Range != want {
+		t.Fatalf("expected %+v in same document, got %+v", want, locs[0])
+	}
+}
+
+func TestDefinitionImportAlias(t *testing.T) {
+	locs := Definition("file:///api.rever", hoverSource, protocol.Position{Line: 9, Character: 6})
+	if len(locs) != 1 {
+		t.Fatalf("expected 1 location, got %d", len(locs))
+	}
+	if locs[0].Range.Start.Line != 0 || locs[0].Range.Start.Character != 7 {
+		t.Fatalf("expected import alias at 0:7, got %+v", locs[0].Range.Start)
+	}
+}
+
+func TestDefinitionLocalImport(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "rever.lock.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stepDir := filepath.Join(dir, "steps", "custom-fetch")
+	if err := os.MkdirAll(stepDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(stepDir, "step.rever"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "routes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	text := `import fetch = @/steps/custom-fetch
+
+GET /users/{id}
+  |> fetch(User, id) as user
+  |> respond 200 { id: user.id }`
+	uri := "file://" + filepath.ToSlash(filepath.Join(dir, "routes", "users.rever"))
+
+	locs := Definition(uri, text, protocol.Position{Line: 3, Character: 6})
+	if len(locs) != 1 {
+		t.Fatalf("expected 1 location, got %d", len(locs))
+	}
+	if !strings.HasSuffix(locs[0].URI, "/steps/custom-fetch/step.rever") {
+		t.Fatalf("expected step.rever location, got %q", locs[0].URI)
+	}
+}
+
+func TestDefinitionUnknown(t *testing.T) {
+	if locs := Definition("file:///api.rever", hoverSource, protocol.Position{Line: 9, Character: 17}); locs != nil {
+		t.Fatalf("expected no definition for bound name, got %+v", locs)
+	}
+}
@@ -1,19 +1,13 @@
 package lsp
 
 import (
-	"regexp"
-	"strconv"
-
 	"github.com/tliron/glsp"
 	protocol "github.com/tliron/glsp/protocol_3_16"
 
-	"github.com/polidog/reverhttp/internal/lexer"
-	"github.com/polidog/reverhttp/internal/parser"
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/sema"
 )
 
-// errorPattern matches parser error format: "file:line:column: message"
-var errorPattern = regexp.MustCompile(`^[^:]+:(\d+):(\d+): (.+)$`)
-
 func publishDiagnostics(ctx *glsp.Context, uri, text string) {
 	diags := diagnose(text)
 	ctx.Notify(protocol.ServerTextDocumentPublishDiagnostics, &protocol.PublishDiagnosticsParams{
@@ -22,43 +16,59 @@ func publishDiagnostics(ctx *glsp.Context, uri, text string) {
 	})
 }
 
+// diagnose reports parse errors, or semantic diagnostics once the buffer
+// parses cleanly (a partial AST would yield spurious unbound-name errors).
 func diagnose(text string) []protocol.Diagnostic {
-	l := lexer.New(text, "buffer")
-	p := parser.New(l)
-	p.ParseFile()
+	file, errs := parseDocument(text)
+	if len(errs) == 0 {
+		return semanticDiagnostics(file)
+	}
 
-	errs := p.Errors()
 	diags := make([]protocol.Diagnostic, 0, len(errs))
 	source := serverName
 	severity := protocol.DiagnosticSeverityError
 
 	for _, e := range errs {
-		m := errorPattern.FindStringSubmatch(e)
-		if m == nil {
-			diags = append(diags, protocol.Diagnostic{
-				Range:    protocol.Range{},
-				Severity: &severity,
-				Source:   &source,
-				Message:  e,
-			})
-			continue
-		}
-
-		line, _ := strconv.Atoi(m[1])
-		col, _ := strconv.Atoi(m[2])
-		// Parser positions are 1-based; LSP is 0-based.
-		pos := protocol.Position{
-			Line:      uint32(line - 1),
-			Character: uint32(col - 1),
-		}
-
+		pos := toProtocolPosition(e.Pos)
 		diags = append(diags, protocol.Diagnostic{
 			Range:    protocol.Range{Start: pos, End: pos},
 			Severity: &severity,
 			Source:   &source,
-			Message:  m[3],
+			Message:  e.Message,
 		})
 	}
 
 	return diags
 }
+
+func semanticDiagnostics(file *ast.File) []protocol.Diagnostic {
+	checked := sema.Check(file)
+	diags := make([]protocol.Diagnostic, 0, len(checked))
+	source := serverName
+
+	for _, d := range checked {
+		severity := diagnosticSeverity(d.Severity)
+		diag := protocol.Diagnostic{
+			Range:    protocol.Range{Start: toProtocolPosition(d.Pos), End: toProtocolPosition(d.End)},
+			Severity: &severity,
+			Source:   &source,
+			Message:  d.Message,
+		}
+		if d.Code != "" {
+			diag.Code = &protocol.IntegerOrString{Value: d.Code}
+		}
+		diags = append(diags, diag)
+	}
+
+	return diags
+}
+
+func diagnosticSeverity(s sema.Severity) protocol.DiagnosticSeverity {
+	switch s {
+	case sema.SeverityWarning:
+		return protocol.DiagnosticSeverityWarning
+	case sema.SeverityHint:
+		return protocol.DiagnosticSeverityHint
+	}
+	return protocol.DiagnosticSeverityError
+}
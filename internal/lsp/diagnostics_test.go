@@ -0,0 +1,81 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+func TestDiagnoseParseError(t *testing.T) {
+	diags := diagnose("GET /users\n  |> 123")
+	if len(diags) == 0 {
+		t.Fatal("expected parse diagnostics")
+	}
+	if *diags[0].Severity != protocol.DiagnosticSeverityError {
+		t.Fatalf("expected error severity, got %v", *diags[0].Severity)
+	}
+}
+
+func TestDiagnoseUnboundReference(t *testing.T) {
+	text := `GET /users/{id}
+  |> input(id: path.id)
+  |> respond 200 { id: user.id }`
+
+	diags := diagnose(text)
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %+v", diags)
+	}
+	d := diags[0]
+	if !strings.Contains(d.Message, `"user"`) {
+		t.Fatalf("expected message about user, got %q", d.Message)
+	}
+	if *d.Severity != protocol.DiagnosticSeverityError {
+		t.Fatalf("expected error severity, got %v", *d.Severity)
+	}
+	want := protocol.Range{
+		Start: protocol.Position{Line: 2, Character: 23},
+		End:   protocol.Position{Line: 2, Character: 27},
+	}
+	if d.Range != want {
+		t.Fatalf("expected range %+v, got %+v", want, d.Range)
+	}
+}
+
+func TestDiagnoseColumnsAfterMultibyte(t *testing.T) {
+	// The parse error is at "oops", after an emoji (two UTF-16 code units).
+	diags := diagnose("GET /x\n  |> respond 200 { a: \"😀\" } oops")
+	if len(diags) == 0 {
+		t.Fatal("expected parse diagnostics")
+	}
+	if got := diags[0].Range.Start; got.Line != 1 || got.Character != 29 {
+		t.Fatalf("expected 1:29, got %d:%d", got.Line, got.Character)
+	}
+
+	text := `GET /x
+  |> input(label: query.label)
+  |> respond 200 { a: "😀", b: user.id }`
+	diags = diagnose(text)
+	want := protocol.Range{
+		Start: protocol.Position{Line: 2, Character: 31},
+		End:   protocol.Position{Line: 2, Character: 35},
+	}
+	if len(diags) != 1 || diags[0].Range != want {
+		t.Fatalf("expected one diagnostic at %+v, got %+v", want, diags)
+	}
+}
+
+func TestDiagnoseUnreachableArmIsWarning(t *testing.T) {
+	text := `GET /accounts
+  |> input(role: header.x-role)
+  |> match role {
+       _:       ~> 400 { error: "unknown role" }
+       "admin": ~> 403 { error: "forbidden" }
+     }
+  |> respond 200 { role: role }`
+
+	diags := diagnose(text)
+	if len(diags) != 1 || *diags[0].Severity != protocol.DiagnosticSeverityWarning {
+		t.Fatalf("expected one warning, got %+v", diags)
+	}
+}
@@ -1,6 +1,11 @@
 package lsp
 
-import "sync"
+import (
+	"strings"
+	"sync"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
 
 type DocumentStore struct {
 	mu   sync.RWMutex
@@ -23,6 +28,30 @@ func (s *DocumentStore) Update(uri, text string) {
 	s.docs[uri] = text
 }
 
+// Apply applies a didChange content change to the stored text: ranged edits
+// replace their range, whole-document changes replace everything.
+func (s *DocumentStore) Apply(uri string, change any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch c := change.(type) {
+	case protocol.TextDocumentContentChangeEventWhole:
+		s.docs[uri] = c.Text
+	case protocol.TextDocumentContentChangeEvent:
+		if c.Range == nil {
+			s.docs[uri] = c.Text
+			return
+		}
+		text := s.docs[uri]
+		start := offsetAt(text, c.Range.Start)
+		end := offsetAt(text, c.Range.End)
+		if end < start {
+			start, end = end, start
+		}
+		s.docs[uri] = text[:start] + c.Text + text[end:]
+	}
+}
+
 func (s *DocumentStore) Close(uri string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -34,3 +63,22 @@ func (s *DocumentStore) Get(uri string) string {
 	defer s.mu.RUnlock()
 	return s.docs[uri]
 }
+
+// offsetAt converts an LSP position (UTF-16 character offset) to a byte offset
+// into text, clamping positions past the end of a line or of the document.
+func offsetAt(text string, pos protocol.Position) int {
+	offset := 0
+	for line := uint32(0); line < pos.Line; line++ {
+		idx := strings.IndexByte(text[offset:], '\n')
+		if idx == -1 {
+			return len(text)
+		}
+		offset += idx + 1
+	}
+
+	line := text[offset:]
+	if idx := strings.IndexByte(line, '\n'); idx != -1 {
+		line = line[:idx]
+	}
+	return offset + len(linePrefix(line, pos.Character))
+}
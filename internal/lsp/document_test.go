@@ -0,0 +1,79 @@
+package lsp
+
+import (
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+const documentURI = "file:///test.rever"
+
+func applyRange(s *DocumentStore, startLine, startChar, endLine, endChar uint32, text string) {
+	s.Apply(documentURI, protocol.TextDocumentContentChangeEvent{
+		Range: &protocol.Range{
+			Start: protocol.Position{Line: startLine, Character: startChar},
+			End:   protocol.Position{Line: endLine, Character: endChar},
+		},
+		Text: text,
+	})
+}
+
+func TestDocumentApplySingleLineInsert(t *testing.T) {
+	s := NewDocumentStore()
+	s.Open(documentURI, "GET /users\n  |> respond 200")
+
+	applyRange(s, 1, 16, 1, 16, " { ok: true }")
+
+	want := "GET /users\n  |> respond 200 { ok: true }"
+	if got := s.Get(documentURI); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestDocumentApplyMultiLineReplace(t *testing.T) {
+	s := NewDocumentStore()
+	s.Open(documentURI, "GET /users\n  |> input(id: path.id)\n  |> respond 200\n")
+
+	applyRange(s, 0, 4, 2, 5, "/items\n  |> guard ok\n  |> ")
+
+	want := "GET /items\n  |> guard ok\n  |> respond 200\n"
+	if got := s.Get(documentURI); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestDocumentApplyDeletion(t *testing.T) {
+	s := NewDocumentStore()
+	s.Open(documentURI, "GET /users\n  # note\n  |> respond 200")
+
+	applyRange(s, 1, 0, 2, 0, "")
+
+	want := "GET /users\n  |> respond 200"
+	if got := s.Get(documentURI); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestDocumentApplyUTF16Offsets(t *testing.T) {
+	s := NewDocumentStore()
+	// "é" is one UTF-16 unit but two bytes; "𝄞" is two units and four bytes.
+	s.Open(documentURI, `  |> respond 200 { msg: "é𝄞x" }`)
+
+	applyRange(s, 0, 28, 0, 29, "y")
+
+	want := `  |> respond 200 { msg: "é𝄞y" }`
+	if got := s.Get(documentURI); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestDocumentApplyWholeDocument(t *testing.T) {
+	s := NewDocumentStore()
+	s.Open(documentURI, "GET /users")
+
+	s.Apply(documentURI, protocol.TextDocumentContentChangeEventWhole{Text: "POST /users"})
+
+	if got := s.Get(documentURI); got != "POST /users" {
+		t.Fatalf("expected whole-document replacement, got %q", got)
+	}
+}
@@ -0,0 +1,38 @@
+package lsp
+
+import (
+	"strings"
+	"unicode/utf16"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+
+	"github.com/polidog/reverhttp/internal/format"
+)
+
+// Format returns the edits that rewrite text into canonical form. A buffer
+// with parse errors yields no edits so a half-typed file is never rewritten.
+func Format(text string) []protocol.TextEdit {
+	if _, errs := parseDocument(text); len(errs) > 0 {
+		return nil
+	}
+
+	formatted := format.Source(text)
+	if formatted == text {
+		return nil
+	}
+
+	return []protocol.TextEdit{{
+		Range:   protocol.Range{Start: protocol.Position{}, End: endPosition(text)},
+		NewText: formatted,
+	}}
+}
+
+// endPosition returns the LSP position just past the last character of text.
+func endPosition(text string) protocol.Position {
+	lines := strings.Split(text, "\n")
+	last := lines[len(lines)-1]
+	return protocol.Position{
+		Line:      uint32(len(lines) - 1),
+		Character: uint32(len(utf16.Encode([]rune(last)))),
+	}
+}
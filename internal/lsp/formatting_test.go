@@ -0,0 +1,42 @@
+package lsp
+
+import (
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+func TestFormatReindentsRoute(t *testing.T) {
+	text := `GET /users/{id}
+|> input(id: path.id)
+      |> respond 200 { id: id }`
+
+	edits := Format(text)
+	if len(edits) != 1 {
+		t.Fatalf("expected 1 edit, got %d", len(edits))
+	}
+
+	want := "GET /users/{id}\n  |> input(id: path.id)\n  |> respond 200 { id: id }\n"
+	if edits[0].NewText != want {
+		t.Fatalf("expected %q, got %q", want, edits[0].NewText)
+	}
+
+	end := protocol.Position{Line: 2, Character: 31}
+	if edits[0].Range.Start != (protocol.Position{}) || edits[0].Range.End != end {
+		t.Fatalf("expected full-document range ending at %+v, got %+v", end, edits[0].Range)
+	}
+}
+
+func TestFormatCanonicalNoEdits(t *testing.T) {
+	text := "GET /health\n  |> respond 200 { status: \"ok\" }\n"
+	if edits := Format(text); edits != nil {
+		t.Fatalf("expected no edits, got %+v", edits)
+	}
+}
+
+func TestFormatParseErrorNoEdits(t *testing.T) {
+	text := "GET /users\n|> 123\n"
+	if edits := Format(text); edits != nil {
+		t.Fatalf("expected no edits for invalid buffer, got %+v", edits)
+	}
+}
@@ -0,0 +1,111 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+// keywordDocs holds short descriptions shown when hovering over a keyword.
+var keywordDocs = map[token.Type]string{
+	token.META:      "File metadata: `meta { title: \"User API\", base: \"/api\" }`; base prefixes every route path.",
+	token.IMPORT:    "Imports a package step: `import <alias> = <source>@<version>`.",
+	token.TYPE:      "Declares a type: `type Name { field: type }`.",
+	token.ENUM:      "Declares an enum: `enum Name { a, b, c }`.",
+	token.DEFAULTS:  "Directives applied to every route unless overridden.",
+	token.GROUP:     "Groups routes under a shared path prefix: `group /api/v1 { ... }`.",
+	token.INPUT:     "Extracts request values: `input(name: path.id, ...)`.",
+	token.VALIDATE:  "Validates fields against constraints: `validate(id: int & min(1))`.",
+	token.TRANSFORM: "Casts or transforms fields: `transform(id: int(id))`.",
+	token.GUARD:     "Stops the pipeline when the expression is falsy: `guard !existing ~> 409`.",
+	token.ELSE:      "Alternative step when a guard fails: `guard expr else respond 200 { ... }`.",
+	token.MATCH:     "Branches on a value: `match expr { pattern: step, _: ... }`.",
+	token.WHEN:      "Guards a match arm: `\"user\" when account.active: step`.",
+	token.MAP:       "Shapes each element of a list: `map(users, { id: it.id }) as result`.",
+	token.ENRICH:    "Merges bindings into one object: `enrich(user, profile) as full`.",
+	token.FORWARD:   "Proxies the request: `forward(\"http://users-svc/{id}\") as upstream`; placeholders name bindings.",
+	token.PIPELINE:  "Declares reusable steps: `pipeline name { |> step ... }`.",
+	token.USE:       "Inlines the steps of a pipeline declaration: `use name`.",
+	token.RESPOND:   "Terminates the pipeline with a response: `respond 200 { ... }`.",
+	token.CACHE:     "HTTP cache directive: `cache(max-age: 3600, public, etag: hash(x))`.",
+	token.CORS:      "CORS directive: `cors(origins: [...], methods: [...])`.",
+	token.AUTH:      "Authentication directive: `auth(bearer, roles: [...]) as user`.",
+	token.RETRY:     "Retries package calls: `retry(attempts: 3, backoff: 200ms)` on a route or after a call.",
+	token.PAGINATE:  "Pagination directive: `paginate(limit: 20, max: 100, cursor: query.cursor)`.",
+	token.ACCEPTS:   "Accept guard: `accepts(\"application/json\")` responds 406 unless the Accept header allows one of the types.",
+	token.COMPRESS:  "Compression directive: `compress(gzip, br, min: 1024)`.",
+	token.AS:        "Binds the step result to a name.",
+	token.ERROR:     "Error flow: responds with the given status when the step fails.",
+	token.PIPE:      "Pipes the result into the next step.",
+}
+
+// Hover returns hover information for the token at pos.
+func Hover(text string, pos protocol.Position) *protocol.Hover {
+	tok, ok := tokenAt(text, pos)
+	if !ok {
+		return nil
+	}
+
+	content, ok := keywordDocs[tok.Type]
+	if !ok {
+		file, _ := parseDocument(text)
+		content = identHover(file, tok.Literal)
+	}
+	if content == "" {
+		return nil
+	}
+
+	r := tokenRange(tok)
+	return &protocol.Hover{
+		Contents: protocol.MarkupContent{Kind: protocol.MarkupKindMarkdown, Value: content},
+		Range:    &r,
+	}
+}
+
+// identHover describes a declared type or import alias named name.
+func identHover(file *ast.File, name string) string {
+	sym, ok := buildSymbols(file)[name]
+	if !ok {
+		return ""
+	}
+	if sym.Kind == symbolType {
+		return formatTypeHover(sym.TypeDecl)
+	}
+	return formatImportHover(sym.ImportDecl)
+}
+
+func formatTypeHover(td *ast.TypeDecl) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "```rever\ntype %s {\n", td.Name)
+	for _, f := range td.Fields {
+		fmt.Fprintf(&b, "  %s: %s", f.Name, f.TypeName)
+		switch {
+		case f.Default == nil:
+		case f.Default.Kind == ast.ExprString:
+			fmt.Fprintf(&b, " = %q", f.Default.StrVal)
+		case f.Default.Kind == ast.ExprInt:
+			fmt.Fprintf(&b, " = %s", f.Default.IntVal)
+		default:
+			fmt.Fprintf(&b, " = %s", f.Default.StrVal)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("}\n```")
+	return b.String()
+}
+
+func formatImportHover(imp *ast.ImportDecl) string {
+	if imp.Local {
+		return fmt.Sprintf("```rever\nimport %s = %s\n```\nLocal import.", imp.Alias, imp.Source)
+	}
+	version := imp.Version
+	if version == "" {
+		version = "latest"
+	}
+	return fmt.Sprintf("```rever\nimport %s = %s@%s\n```\nSource: `%s`  \nVersion: `%s`",
+		imp.Alias, imp.Source, version, imp.Source, version)
+}
@@ -0,0 +1,71 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+const hoverSource = `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+type User {
+  id: int
+  name: string
+}
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { id: user.id }`
+
+func hoverValue(t *testing.T, h *protocol.Hover) string {
+	t.Helper()
+	if h == nil {
+		t.Fatal("expected hover, got nil")
+	}
+	mc, ok := h.Contents.(protocol.MarkupContent)
+	if !ok {
+		t.Fatalf("expected MarkupContent, got %T", h.Contents)
+	}
+	return mc.Value
+}
+
+func TestHoverTypeReference(t *testing.T) {
+	// "  |> fetch(User, id) as user" — User starts at character 11
+	h := Hover(hoverSource, protocol.Position{Line: 9, Character: 12})
+	value := hoverValue(t, h)
+
+	for _, want := range []string{"type User", "id: int", "name: string"} {
+		if !strings.Contains(value, want) {
+			t.Fatalf("expected hover to contain %q, got %q", want, value)
+		}
+	}
+	if h.Range == nil || h.Range.Start.Character != 11 || h.Range.End.Character != 15 {
+		t.Fatalf("expected range 11-15, got %+v", h.Range)
+	}
+}
+
+func TestHoverImportAlias(t *testing.T) {
+	h := Hover(hoverSource, protocol.Position{Line: 9, Character: 6})
+	value := hoverValue(t, h)
+
+	if !strings.Contains(value, "github.com/reverhttp/std-fetch") || !strings.Contains(value, "0.1.0") {
+		t.Fatalf("expected import source and version, got %q", value)
+	}
+}
+
+func TestHoverKeyword(t *testing.T) {
+	h := Hover(hoverSource, protocol.Position{Line: 8, Character: 5})
+	value := hoverValue(t, h)
+
+	if !strings.Contains(value, "input(") {
+		t.Fatalf("expected input description, got %q", value)
+	}
+}
+
+func TestHoverNothing(t *testing.T) {
+	if h := Hover(hoverSource, protocol.Position{Line: 1, Character: 0}); h != nil {
+		t.Fatalf("expected no hover on blank line, got %+v", h)
+	}
+}
@@ -0,0 +1,134 @@
+package lsp
+
+import (
+	"strings"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/lexer"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+// declaringCalls are the builtin steps whose "name:" keys declare or refer to
+// pipeline variables rather than naming an argument or body key.
+var declaringCalls = map[token.Type]bool{
+	token.INPUT:     true,
+	token.VALIDATE:  true,
+	token.TRANSFORM: true,
+}
+
+// PrepareRename returns the range of the renameable symbol at pos, or nil.
+func PrepareRename(text string, pos protocol.Position) *protocol.Range {
+	tok, occurrences := renameTarget(text, pos)
+	if occurrences == nil {
+		return nil
+	}
+	r := tokenRange(tok)
+	return &r
+}
+
+// Rename renames the type or bound variable at pos. Types are renamed across
+// the whole document; bound variables only within their route.
+func Rename(uri, text string, pos protocol.Position, newName string) *protocol.WorkspaceEdit {
+	_, occurrences := renameTarget(text, pos)
+	if occurrences == nil {
+		return nil
+	}
+
+	edits := make([]protocol.TextEdit, 0, len(occurrences))
+	for _, tok := range occurrences {
+		edits = append(edits, protocol.TextEdit{Range: tokenRange(tok), NewText: newName})
+	}
+	return &protocol.WorkspaceEdit{
+		Changes: map[protocol.DocumentUri][]protocol.TextEdit{uri: edits},
+	}
+}
+
+// renameTarget resolves the token at pos and every occurrence of the symbol it
+// names. It returns nil occurrences when the token is not renameable.
+func renameTarget(text string, pos protocol.Position) (token.Token, []token.Token) {
+	tok, ok := tokenAt(text, pos)
+	if !ok || tok.Type != token.IDENT {
+		return tok, nil
+	}
+
+	file, _ := parseDocument(text)
+	lines := strings.Count(text, "\n") + 1
+	fromLine, toLine := 1, lines
+
+	if sym, ok := buildSymbols(file)[tok.Literal]; !ok || sym.Kind != symbolType {
+		route := routeAt(file, tok.Pos.Line, lines)
+		if route == nil {
+			return tok, nil
+		}
+		if _, bound := routeBindings(route)[tok.Literal]; !bound {
+			return tok, nil
+		}
+		fromLine, toLine = route.Pos.Line, declEnd(declStarts(file), route.Pos.Line, lines)
+	}
+
+	occurrences := referenceTokens(text, tok.Literal, fromLine, toLine)
+	for _, occ := range occurrences {
+		if occ.Pos == tok.Pos {
+			return tok, occurrences
+		}
+	}
+	// The cursor is on a key or member that merely shares the name.
+	return tok, nil
+}
+
+// routeAt returns the route whose declaration spans the 1-based line.
+func routeAt(file *ast.File, line, lastLine int) *ast.Route {
+	starts := declStarts(file)
+	for _, r := range file.Routes {
+		if line >= r.Pos.Line && line <= declEnd(starts, r.Pos.Line, lastLine) {
+			return r
+		}
+	}
+	return nil
+}
+
+// referenceTokens lists the IDENT tokens named name between the 1-based lines
+// that refer to a symbol: declarations ("as name", type names, input fields)
+// and references (pkg-call args, the root of dotted values, guard and match
+// expressions). Body keys, named-argument keys and members after "." are
+// skipped; route paths are single PATH tokens and never match.
+func referenceTokens(text, name string, fromLine, toLine int) []token.Token {
+	type frame struct {
+		opener token.Type
+		owner  token.Type
+	}
+
+	var (
+		result []token.Token
+		stack  []frame
+		prev   token.Token
+	)
+
+	l := lexer.New(text, "buffer")
+	tok := l.NextToken()
+	for tok.Type != token.EOF {
+		next := l.NextToken()
+
+		switch {
+		case tok.Type == token.LPAREN || tok.Type == token.LBRACE || tok.Type == token.LBRACKET:
+			stack = append(stack, frame{opener: tok.Type, owner: prev.Type})
+		case tok.Type == token.RPAREN || tok.Type == token.RBRACE || tok.Type == token.RBRACKET:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case tok.Type == token.IDENT && tok.Literal == name && prev.Type != token.DOT:
+			isKey := next.Type == token.COLON &&
+				(len(stack) == 0 || !declaringCalls[stack[len(stack)-1].owner] || stack[len(stack)-1].opener != token.LPAREN)
+			if !isKey && tok.Pos.Line >= fromLine && tok.Pos.Line <= toLine {
+				result = append(result, tok)
+			}
+		}
+
+		prev = tok
+		tok = next
+	}
+
+	return result
+}
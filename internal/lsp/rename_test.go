@@ -0,0 +1,92 @@
+package lsp
+
+import (
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+const renameSource = `type User { id: int, name: string }
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user          ~> 404 { error: "not found" }
+  |> guard user.active                ~> 403 { user: "inactive" }
+  |> respond 200 { id: user.id, name: user.name }
+
+GET /others/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { id: user.id }`
+
+func renamedPositions(t *testing.T, edit *protocol.WorkspaceEdit) []protocol.Position {
+	t.Helper()
+	if edit == nil {
+		t.Fatal("expected workspace edit, got nil")
+	}
+	var positions []protocol.Position
+	for _, e := range edit.Changes["file:///api.rever"] {
+		positions = append(positions, e.Range.Start)
+	}
+	return positions
+}
+
+func TestRenameBoundVariable(t *testing.T) {
+	// Cursor on "user" in "as user" (line 4, character 24).
+	edit := Rename("file:///api.rever", renameSource, protocol.Position{Line: 4, Character: 25}, "account")
+	got := renamedPositions(t, edit)
+
+	want := []protocol.Position{
+		{Line: 4, Character: 24}, // as user
+		{Line: 5, Character: 11}, // guard user.active
+		{Line: 6, Character: 23}, // user.id
+		{Line: 6, Character: 38}, // user.name
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d edits, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("edit[%d]: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+	for _, e := range edit.Changes["file:///api.rever"] {
+		if e.NewText != "account" {
+			t.Fatalf("expected new text 'account', got %q", e.NewText)
+		}
+	}
+}
+
+func TestRenameInputVariable(t *testing.T) {
+	// "id" in input(id: path.id): the declaration and the fetch argument, but
+	// not path.id, the {id} path segment or the body key.
+	edit := Rename("file:///api.rever", renameSource, protocol.Position{Line: 3, Character: 11}, "user_id")
+	got := renamedPositions(t, edit)
+
+	want := []protocol.Position{
+		{Line: 3, Character: 11},
+		{Line: 4, Character: 17},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestRenameType(t *testing.T) {
+	edit := Rename("file:///api.rever", renameSource, protocol.Position{Line: 0, Character: 6}, "Account")
+	if got := renamedPositions(t, edit); len(got) != 3 {
+		t.Fatalf("expected declaration plus 2 references, got %+v", got)
+	}
+}
+
+func TestPrepareRename(t *testing.T) {
+	r := PrepareRename(renameSource, protocol.Position{Line: 4, Character: 25})
+	if r == nil || r.Start != (protocol.Position{Line: 4, Character: 24}) || r.End.Character != 28 {
+		t.Fatalf("expected range of 'user', got %+v", r)
+	}
+
+	// Body key "error" is not a symbol.
+	if r := PrepareRename(renameSource, protocol.Position{Line: 4, Character: 45}); r != nil {
+		t.Fatalf("expected no rename on body key, got %+v", r)
+	}
+}
@@ -0,0 +1,111 @@
+package lsp
+
+import (
+	"strings"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/lexer"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+// Indices into semanticTokenTypes; the order is the legend sent to the client.
+const (
+	semKeyword = iota
+	semOperator
+	semString
+	semNumber
+	semType
+	semVariable
+)
+
+var semanticTokenTypes = []string{
+	string(protocol.SemanticTokenTypeKeyword),
+	string(protocol.SemanticTokenTypeOperator),
+	string(protocol.SemanticTokenTypeString),
+	string(protocol.SemanticTokenTypeNumber),
+	string(protocol.SemanticTokenTypeType),
+	string(protocol.SemanticTokenTypeVariable),
+}
+
+// SemanticTokensLegend is the token legend advertised in the server capabilities.
+func SemanticTokensLegend() protocol.SemanticTokensLegend {
+	return protocol.SemanticTokensLegend{
+		TokenTypes:     semanticTokenTypes,
+		TokenModifiers: []string{},
+	}
+}
+
+// SemanticTokens tokenizes text and returns the delta-encoded token data.
+func SemanticTokens(text string) *protocol.SemanticTokens {
+	file, _ := parseDocument(text)
+	bound := boundNames(file)
+
+	lines := strings.Split(text, "\n")
+	data := []protocol.UInteger{}
+	prevLine, prevChar := uint32(0), uint32(0)
+
+	l := lexer.New(text, "buffer")
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF {
+			break
+		}
+
+		kind, ok := semanticKind(tok, bound)
+		if !ok {
+			continue
+		}
+
+		for _, r := range lineRanges(lines, tok) {
+			deltaLine := r.Start.Line - prevLine
+			deltaChar := r.Start.Character
+			if deltaLine == 0 {
+				deltaChar -= prevChar
+			}
+			data = append(data, deltaLine, deltaChar, r.End.Character-r.Start.Character, uint32(kind), 0)
+			prevLine, prevChar = r.Start.Line, r.Start.Character
+		}
+	}
+
+	return &protocol.SemanticTokens{Data: data}
+}
+
+func semanticKind(tok token.Token, bound map[string]bool) (int, bool) {
+	switch tok.Type {
+	case token.STRING:
+		return semString, true
+	case token.INT, token.FLOAT:
+		return semNumber, true
+	case token.PIPE, token.ERROR, token.AMPERSAND, token.RANGE, token.SPREAD, token.BANG, token.ASSIGN, token.QUESTION, token.COALESCE:
+		return semOperator, true
+	case token.IDENT:
+		if isUpperCase(tok.Literal) {
+			return semType, true
+		}
+		if bound[tok.Literal] {
+			return semVariable, true
+		}
+		return 0, false
+	}
+	if token.IsKeyword(tok.Type) {
+		return semKeyword, true
+	}
+	return 0, false
+}
+
+// boundNames collects every name introduced in any route of the document.
+func boundNames(file *ast.File) map[string]bool {
+	names := make(map[string]bool)
+	for _, r := range file.Routes {
+		for name := range routeBindings(r) {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func isUpperCase(s string) bool {
+	return s != "" && s[0] >= 'A' && s[0] <= 'Z'
+}
@@ -0,0 +1,81 @@
+package lsp
+
+import (
+	"reflect"
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+func TestSemanticTokensFirstLines(t *testing.T) {
+	text := `GET /users/{id}
+  |> fetch(User, id) as user ~> 404`
+
+	got := SemanticTokens(text).Data
+
+	// Each token is [deltaLine, deltaStart, length, type, modifiers].
+	want := []protocol.UInteger{
+		0, 0, 3, semKeyword, 0, // GET
+		1, 2, 2, semOperator, 0, // |>
+		0, 9, 4, semType, 0, // User
+		0, 10, 2, semKeyword, 0, // as
+		0, 3, 4, semVariable, 0, // user
+		0, 5, 2, semOperator, 0, // ~>
+		0, 3, 3, semNumber, 0, // 404
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected token data\nexpected %v\ngot      %v", want, got)
+	}
+}
+
+func TestSemanticTokensTextBlock(t *testing.T) {
+	text := "GET /a\n  |> respond 200 text \"\"\"\n<p>\n\n  \"ok\" \"\"\" |> x(\"é\\\"\")"
+
+	// Decode the deltas to absolute [line, start, length] of each string.
+	data := SemanticTokens(text).Data
+	var got []protocol.UInteger
+	line, start := protocol.UInteger(0), protocol.UInteger(0)
+	for i := 0; i+4 < len(data); i += 5 {
+		if data[i] > 0 {
+			start = 0
+		}
+		line, start = line+data[i], start+data[i+1]
+		if data[i+3] == semString {
+			got = append(got, line, start, data[i+2])
+		}
+	}
+
+	// The text block gets one token per non-empty line it covers, delimiters
+	// included, and an escaped quote counts as written.
+	want := []protocol.UInteger{
+		1, 22, 3, // """
+		2, 0, 3, // <p>
+		4, 0, 10, //   "ok" """
+		4, 16, 5, // "é\""
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected string tokens\nexpected %v\ngot      %v", want, got)
+	}
+}
+
+func TestTokenAtTextBlock(t *testing.T) {
+	text := "GET /a\n  |> respond 200 text \"\"\"\n<p>\n\"\"\" |> x"
+
+	for _, pos := range []protocol.Position{{Line: 1, Character: 22}, {Line: 2, Character: 1}, {Line: 3, Character: 2}} {
+		tok, ok := tokenAt(text, pos)
+		if !ok || tok.Literal != "\n<p>\n" {
+			t.Errorf("%+v: expected the text block, got %+v", pos, tok)
+		}
+	}
+	if tok, ok := tokenAt(text, protocol.Position{Line: 3, Character: 4}); !ok || tok.Literal != "|>" {
+		t.Errorf("expected the pipe after the text block, got %+v", tok)
+	}
+}
+
+func TestSemanticTokensLegend(t *testing.T) {
+	legend := SemanticTokensLegend()
+	if legend.TokenTypes[semKeyword] != "keyword" || legend.TokenTypes[semVariable] != "variable" {
+		t.Fatalf("unexpected legend %v", legend.TokenTypes)
+	}
+}
@@ -15,8 +15,25 @@ func NewServer() *server.Server {
 
 	handler.Initialize = func(context *glsp.Context, params *protocol.InitializeParams) (any, error) {
 		capabilities := handler.CreateServerCapabilities()
-		capabilities.TextDocumentSync = protocol.TextDocumentSyncKindFull
+		capabilities.TextDocumentSync = protocol.TextDocumentSyncKindIncremental
 		capabilities.CompletionProvider = &protocol.CompletionOptions{}
+		capabilities.HoverProvider = true
+		capabilities.SignatureHelpProvider = &protocol.SignatureHelpOptions{
+			TriggerCharacters:   []string{"("},
+			RetriggerCharacters: []string{","},
+		}
+		capabilities.DefinitionProvider = true
+		capabilities.DocumentFormattingProvider = true
+		capabilities.CodeActionProvider = protocol.CodeActionOptions{
+			CodeActionKinds: []protocol.CodeActionKind{protocol.CodeActionKindQuickFix},
+		}
+		capabilities.DocumentSymbolProvider = true
+		prepareRename := true
+		capabilities.RenameProvider = protocol.RenameOptions{PrepareProvider: &prepareRename}
+		capabilities.SemanticTokensProvider = protocol.SemanticTokensOptions{
+			Legend: SemanticTokensLegend(),
+			Full:   true,
+		}
 
 		version := serverVersion
 		return protocol.InitializeResult{
@@ -46,9 +63,7 @@ func NewServer() *server.Server {
 	handler.TextDocumentDidChange = func(context *glsp.Context, params *protocol.DidChangeTextDocumentParams) error {
 		uri := params.TextDocument.URI
 		for _, change := range params.ContentChanges {
-			if c, ok := change.(protocol.TextDocumentContentChangeEventWhole); ok {
-				store.Update(uri, c.Text)
-			}
+			store.Apply(uri, change)
 		}
 		publishDiagnostics(context, uri, store.Get(uri))
 		return nil
@@ -70,5 +85,62 @@ func NewServer() *server.Server {
 		return Complete(text, params.Position), nil
 	}
 
+	handler.TextDocumentHover = func(context *glsp.Context, params *protocol.HoverParams) (*protocol.Hover, error) {
+		uri := params.TextDocument.URI
+		text := store.Get(uri)
+		return Hover(text, params.Position), nil
+	}
+
+	handler.TextDocumentSignatureHelp = func(context *glsp.Context, params *protocol.SignatureHelpParams) (*protocol.SignatureHelp, error) {
+		uri := params.TextDocument.URI
+		text := store.Get(uri)
+		return SignatureHelp(text, params.Position), nil
+	}
+
+	handler.TextDocumentDefinition = func(context *glsp.Context, params *protocol.DefinitionParams) (any, error) {
+		uri := params.TextDocument.URI
+		text := store.Get(uri)
+		return Definition(uri, text, params.Position), nil
+	}
+
+	handler.TextDocumentFormatting = func(context *glsp.Context, params *protocol.DocumentFormattingParams) ([]protocol.TextEdit, error) {
+		uri := params.TextDocument.URI
+		text := store.Get(uri)
+		return Format(text), nil
+	}
+
+	handler.TextDocumentCodeAction = func(context *glsp.Context, params *protocol.CodeActionParams) (any, error) {
+		uri := params.TextDocument.URI
+		text := store.Get(uri)
+		return CodeActions(uri, text, params.Range), nil
+	}
+
+	handler.TextDocumentDocumentSymbol = func(context *glsp.Context, params *protocol.DocumentSymbolParams) (any, error) {
+		uri := params.TextDocument.URI
+		text := store.Get(uri)
+		return DocumentSymbols(text), nil
+	}
+
+	handler.TextDocumentSemanticTokensFull = func(context *glsp.Context, params *protocol.SemanticTokensParams) (*protocol.SemanticTokens, error) {
+		uri := params.TextDocument.URI
+		text := store.Get(uri)
+		return SemanticTokens(text), nil
+	}
+
+	handler.TextDocumentPrepareRename = func(context *glsp.Context, params *protocol.PrepareRenameParams) (any, error) {
+		uri := params.TextDocument.URI
+		text := store.Get(uri)
+		if r := PrepareRename(text, params.Position); r != nil {
+			return r, nil
+		}
+		return nil, nil
+	}
+
+	handler.TextDocumentRename = func(context *glsp.Context, params *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
+		uri := params.TextDocument.URI
+		text := store.Get(uri)
+		return Rename(uri, text, params.Position, params.NewName), nil
+	}
+
 	return server.NewServer(handler, serverName, false)
 }
@@ -0,0 +1,232 @@
+package lsp
+
+import (
+	"strings"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+// callSignature describes the arguments accepted by a directive, builtin step
+// or validation constraint.
+type callSignature struct {
+	params []string
+	doc    string
+}
+
+var callSignatures = map[string]callSignature{
+	"cache": {
+		params: []string{"max-age: int", "s-maxage: int", "public", "private", "no-cache", "no-store", "etag: expr | [field]", "last-modified: field", "vary: [header]"},
+		doc:    "HTTP cache directive.",
+	},
+	"cors": {
+		params: []string{"origins: [string | /regex/]", "methods: [string]", "headers: [string]", "expose-headers: [string]", "max-age: int", "credentials"},
+		doc:    "CORS directive. `cors(none)` disables CORS for the route.",
+	},
+	"auth": {
+		params: []string{"method", "roles: [string]", "permissions: [string]", "scopes: [string]", "realm: string", "in: header|query|cookie", "name: string", "optional"},
+		doc:    "Authentication directive. `auth(none)` disables authentication for the route.",
+	},
+	"retry": {
+		params: []string{"attempts: int", "backoff: duration"},
+		doc:    "Retries package calls; backoff accepts 200ms, 2s or plain milliseconds.",
+	},
+	"paginate": {
+		params: []string{"limit: int", "max: int", "cursor: source.field"},
+		doc:    "Paginates a list endpoint: default and maximum page size, and where the cursor comes from.",
+	},
+	"accepts": {
+		params: []string{"media type: string", "media type, ..."},
+		doc:    "Requires the Accept header to allow one of the media types; otherwise the route responds 406.",
+	},
+	"compress": {
+		params: []string{"encoding", "encoding, ...", "min: int"},
+		doc:    "Compresses responses with gzip, br, deflate or zstd, in order of preference; min is the smallest body size in bytes worth compressing.",
+	},
+	"input": {
+		params: []string{"name: source.field"},
+		doc:    "Extracts request values from path, query, body or header.",
+	},
+	"validate": {
+		params: []string{"field: type & constraint"},
+		doc:    "Validates fields; constraints are min(n), max(n), between(min, max), minLength(n), maxLength(n), length(n), format(name), oneOf(values) and pattern(/regex/).",
+	},
+	"transform": {
+		params: []string{"field: fn(source)"},
+		doc:    "Casts (int, string, ...) or transforms (trim, lower, ...) fields.",
+	},
+	"map": {
+		params: []string{"list", "{ body }"},
+		doc:    "Builds the body once per element of list; `it` is the current element.",
+	},
+	"forward": {
+		params: []string{"url: string"},
+		doc:    "Proxies the request to the URL; {name} placeholders are replaced by bindings.",
+	},
+	"enrich": {
+		params: []string{"source", "source, ..."},
+		doc:    "Merges the fields of the bindings into one object; later sources win.",
+	},
+	"min": {
+		params: []string{"n: number"},
+		doc:    "Minimum numeric value; use minLength for strings.",
+	},
+	"max": {
+		params: []string{"n: number"},
+		doc:    "Maximum numeric value; use maxLength for strings.",
+	},
+	"minLength": {
+		params: []string{"n: int"},
+		doc:    "Minimum string length.",
+	},
+	"maxLength": {
+		params: []string{"n: int"},
+		doc:    "Maximum string length.",
+	},
+	"length": {
+		params: []string{"n: int"},
+		doc:    "Exact string length.",
+	},
+	"between": {
+		params: []string{"min: number", "max: number"},
+		doc:    "Shorthand for min(min) & max(max).",
+	},
+	"oneOf": {
+		params: []string{"values: string, ..."},
+		doc:    "Restricts the field to one of the given string literals.",
+	},
+	"pattern": {
+		params: []string{"regex: /pattern/flags"},
+		doc:    "Requires the string to match the regex literal; flags are i, m and s.",
+	},
+	"format": {
+		params: []string{"name"},
+		doc:    "Named format such as email.",
+	},
+}
+
+// SignatureHelp returns the signature of the call enclosing pos, if known.
+func SignatureHelp(text string, pos protocol.Position) *protocol.SignatureHelp {
+	name, current, ok := enclosingCall(textBefore(text, pos))
+	if !ok {
+		return nil
+	}
+	sig, ok := callSignatures[name]
+	if !ok {
+		return nil
+	}
+
+	info := protocol.SignatureInformation{
+		Label:         name + "(" + strings.Join(sig.params, ", ") + ")",
+		Documentation: sig.doc,
+	}
+	for _, p := range sig.params {
+		info.Parameters = append(info.Parameters, protocol.ParameterInformation{Label: p})
+	}
+
+	help := &protocol.SignatureHelp{Signatures: []protocol.SignatureInformation{info}}
+	if idx, ok := activeParameter(sig.params, current); ok {
+		active := protocol.UInteger(idx)
+		help.ActiveParameter = &active
+	}
+	return help
+}
+
+// textBefore returns the part of text preceding pos.
+func textBefore(text string, pos protocol.Position) string {
+	lines := strings.Split(text, "\n")
+	if int(pos.Line) >= len(lines) {
+		return text
+	}
+	before := strings.Join(lines[:pos.Line], "\n")
+	if pos.Line > 0 {
+		before += "\n"
+	}
+	return before + linePrefix(lines[pos.Line], pos.Character)
+}
+
+// enclosingCall finds the innermost unmatched "(" of the current pipeline step
+// in prefix and returns the name preceding it along with the argument text
+// typed so far.
+func enclosingCall(prefix string) (name, current string, ok bool) {
+	var opens []int
+	inString, inComment := false, false
+	for i := 0; i < len(prefix); i++ {
+		c := prefix[i]
+		switch {
+		case inComment:
+			inComment = c != '\n'
+		case inString:
+			if c == '\\' {
+				i++
+			} else if c == '"' || c == '\n' {
+				inString = false
+			}
+		case c == '#':
+			inComment = true
+		case c == '"':
+			inString = true
+		case c == '|' && i+1 < len(prefix) && prefix[i+1] == '>':
+			// A new pipeline step starts; anything left open was never closed.
+			opens = opens[:0]
+		case c == '(':
+			opens = append(opens, i)
+		case c == ')':
+			if len(opens) > 0 {
+				opens = opens[:len(opens)-1]
+			}
+		}
+	}
+	if inString || inComment || len(opens) == 0 {
+		return "", "", false
+	}
+
+	open := opens[len(opens)-1]
+	before := strings.TrimRight(prefix[:open], " \t")
+	start := strings.LastIndexFunc(before, func(r rune) bool { return !isIdentRune(r) }) + 1
+	name = before[start:]
+	if name == "" {
+		return "", "", false
+	}
+
+	// Only the argument after the last top-level comma is being typed.
+	args := prefix[open+1:]
+	depth := 0
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				current = args[i+1:]
+			}
+		}
+	}
+	if current == "" && !strings.Contains(args, ",") {
+		current = args
+	}
+	return name, strings.TrimSpace(current), true
+}
+
+// activeParameter matches the argument being typed to a parameter by its
+// "name:" key or, for positional flags, by the word typed so far.
+func activeParameter(params []string, current string) (int, bool) {
+	key := current
+	if idx := strings.Index(current, ":"); idx != -1 {
+		key = strings.TrimSpace(current[:idx])
+	}
+	if key == "" {
+		return 0, false
+	}
+	for i, p := range params {
+		name := p
+		if idx := strings.Index(p, ":"); idx != -1 {
+			name = p[:idx]
+		}
+		if name == key {
+			return i, true
+		}
+	}
+	return 0, false
+}
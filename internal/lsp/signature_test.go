@@ -0,0 +1,60 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+const signatureSource = `GET /users
+  cache(max-age: 60, public)
+  |> validate(age: int & min(
+  |> respond 200 { ok: true }`
+
+func TestSignatureHelpCache(t *testing.T) {
+	help := SignatureHelp(signatureSource, protocol.Position{Line: 1, Character: 8})
+	if help == nil || len(help.Signatures) != 1 {
+		t.Fatalf("expected one signature, got %+v", help)
+	}
+	sig := help.Signatures[0]
+	if !strings.HasPrefix(sig.Label, "cache(") {
+		t.Fatalf("expected cache signature, got %q", sig.Label)
+	}
+	for _, want := range []string{"max-age", "s-maxage", "public", "etag"} {
+		if !strings.Contains(sig.Label, want) {
+			t.Fatalf("expected %q in label %q", want, sig.Label)
+		}
+	}
+	if help.ActiveParameter != nil {
+		t.Fatalf("expected no active parameter before typing, got %d", *help.ActiveParameter)
+	}
+}
+
+func TestSignatureHelpCacheActiveParameter(t *testing.T) {
+	// "  cache(max-age: 60, public)" — cursor after "public"
+	help := SignatureHelp(signatureSource, protocol.Position{Line: 1, Character: 27})
+	if help == nil || help.ActiveParameter == nil {
+		t.Fatalf("expected active parameter, got %+v", help)
+	}
+	label := help.Signatures[0].Parameters[*help.ActiveParameter].Label
+	if label != "public" {
+		t.Fatalf("expected active parameter public, got %v", label)
+	}
+}
+
+func TestSignatureHelpMinConstraint(t *testing.T) {
+	help := SignatureHelp(signatureSource, protocol.Position{Line: 2, Character: 29})
+	if help == nil || len(help.Signatures) != 1 {
+		t.Fatalf("expected one signature, got %+v", help)
+	}
+	if got := help.Signatures[0].Label; got != "min(n: number)" {
+		t.Fatalf("expected min(n: number), got %q", got)
+	}
+}
+
+func TestSignatureHelpOutsideCall(t *testing.T) {
+	if help := SignatureHelp(signatureSource, protocol.Position{Line: 3, Character: 15}); help != nil {
+		t.Fatalf("expected no signature outside a call, got %+v", help)
+	}
+}
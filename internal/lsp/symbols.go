@@ -0,0 +1,211 @@
+package lsp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+// DocumentSymbols returns the outline of the document: one node per import,
+// type, enum, pipeline and route, with the pipeline steps of pipelines and
+// routes nested as children.
+func DocumentSymbols(text string) []protocol.DocumentSymbol {
+	file, _ := parseDocument(text)
+	lines := strings.Split(text, "\n")
+
+	starts := declStarts(file)
+	declRange := func(pos token.Position) protocol.Range {
+		return spanRange(lines, pos, declEnd(starts, pos.Line, len(lines)))
+	}
+
+	symbols := []protocol.DocumentSymbol{}
+
+	for _, imp := range file.Imports {
+		detail := imp.Source
+		if imp.Version != "" {
+			detail += "@" + imp.Version
+		}
+		symbols = append(symbols, protocol.DocumentSymbol{
+			Name:           imp.Alias,
+			Detail:         &detail,
+			Kind:           protocol.SymbolKindModule,
+			Range:          declRange(imp.Pos),
+			SelectionRange: nameRange(imp.AliasPos, imp.Alias),
+		})
+	}
+
+	for _, td := range file.Types {
+		symbols = append(symbols, protocol.DocumentSymbol{
+			Name:           td.Name,
+			Kind:           protocol.SymbolKindStruct,
+			Range:          declRange(td.Pos),
+			SelectionRange: nameRange(td.NamePos, td.Name),
+		})
+	}
+
+	for _, ed := range file.Enums {
+		detail := strings.Join(ed.Values, ", ")
+		symbols = append(symbols, protocol.DocumentSymbol{
+			Name:           ed.Name,
+			Detail:         &detail,
+			Kind:           protocol.SymbolKindEnum,
+			Range:          declRange(ed.Pos),
+			SelectionRange: nameRange(ed.NamePos, ed.Name),
+		})
+	}
+
+	for _, pd := range file.Pipelines {
+		sym := protocol.DocumentSymbol{
+			Name:           pd.Name,
+			Kind:           protocol.SymbolKindFunction,
+			Range:          declRange(pd.Pos),
+			SelectionRange: nameRange(pd.NamePos, pd.Name),
+		}
+		sym.Children = stepSymbols(lines, pd.Steps)
+		symbols = append(symbols, sym)
+	}
+
+	for _, r := range file.Routes {
+		name := r.Method + " " + r.Path
+		sym := protocol.DocumentSymbol{
+			Name:           name,
+			Kind:           protocol.SymbolKindMethod,
+			Range:          declRange(r.Pos),
+			SelectionRange: nameRange(r.Pos, name),
+		}
+		sym.Children = stepSymbols(lines, r.Steps)
+		symbols = append(symbols, sym)
+	}
+
+	return symbols
+}
+
+// stepSymbols returns one child symbol per pipeline step.
+func stepSymbols(lines []string, steps []*ast.PipelineStep) []protocol.DocumentSymbol {
+	var children []protocol.DocumentSymbol
+	for _, step := range steps {
+		stepRange := lineRange(lines, toProtocolPosition(step.Pos).Line)
+		child := protocol.DocumentSymbol{
+			Name:           stepLabel(step),
+			Kind:           protocol.SymbolKindFunction,
+			Range:          stepRange,
+			SelectionRange: stepRange,
+		}
+		if step.Bind != "" {
+			detail := "as " + step.Bind
+			child.Detail = &detail
+		}
+		children = append(children, child)
+	}
+	return children
+}
+
+// declStarts returns the sorted 1-based start lines of all top-level declarations.
+func declStarts(file *ast.File) []int {
+	var starts []int
+	for _, imp := range file.Imports {
+		starts = append(starts, imp.Pos.Line)
+	}
+	for _, td := range file.Types {
+		starts = append(starts, td.Pos.Line)
+	}
+	for _, ed := range file.Enums {
+		starts = append(starts, ed.Pos.Line)
+	}
+	if file.Defaults != nil {
+		starts = append(starts, file.Defaults.Pos.Line)
+	}
+	for _, d := range file.MethodDefaults {
+		starts = append(starts, d.Pos.Line)
+	}
+	for _, pd := range file.Pipelines {
+		starts = append(starts, pd.Pos.Line)
+	}
+	for _, g := range file.Groups {
+		starts = append(starts, g.Pos.Line)
+	}
+	for _, r := range file.Routes {
+		starts = append(starts, r.Pos.Line)
+	}
+	sort.Ints(starts)
+	return starts
+}
+
+// declEnd returns the last 1-based line of the declaration starting at line:
+// every declaration extends until the line before the next one.
+func declEnd(starts []int, line, lastLine int) int {
+	for _, s := range starts {
+		if s > line {
+			return s - 1
+		}
+	}
+	return lastLine
+}
+
+// stepLabel returns a short display name for a pipeline step.
+func stepLabel(step *ast.PipelineStep) string {
+	switch step.Kind {
+	case ast.StepInput:
+		return "input"
+	case ast.StepValidate:
+		return "validate"
+	case ast.StepTransform:
+		return "transform"
+	case ast.StepGuard:
+		return "guard"
+	case ast.StepMatch:
+		return "match " + step.Match.On
+	case ast.StepMap:
+		return "map " + step.Map.Source
+	case ast.StepEnrich:
+		return "enrich"
+	case ast.StepForward:
+		return "forward"
+	case ast.StepUse:
+		return "use " + step.Use.Name
+	case ast.StepPkgCall:
+		return step.PkgCall.Pkg
+	case ast.StepRespond:
+		if step.Respond.StatusRef != "" {
+			return "respond " + step.Respond.StatusRef
+		}
+		return fmt.Sprintf("respond %s", step.Respond.Status)
+	}
+	return "step"
+}
+
+// spanRange covers from pos to the last non-blank, non-comment line at or
+// before the 1-based line end.
+func spanRange(lines []string, pos token.Position, end int) protocol.Range {
+	start := toProtocolPosition(pos)
+	last := int(start.Line)
+	for i := end - 1; i > last && i < len(lines); i-- {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			last = i
+			break
+		}
+	}
+	return protocol.Range{Start: start, End: lineEnd(lines, last)}
+}
+
+// lineRange covers the whole of the given 0-based line.
+func lineRange(lines []string, line uint32) protocol.Range {
+	return protocol.Range{Start: protocol.Position{Line: line}, End: lineEnd(lines, int(line))}
+}
+
+func lineEnd(lines []string, line int) protocol.Position {
+	if line >= len(lines) {
+		return protocol.Position{Line: uint32(line)}
+	}
+	return protocol.Position{
+		Line:      uint32(line),
+		Character: uint32(len(utf16.Encode([]rune(lines[line])))),
+	}
+}
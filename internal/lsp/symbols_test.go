@@ -0,0 +1,60 @@
+package lsp
+
+import (
+	"testing"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+)
+
+func TestDocumentSymbols(t *testing.T) {
+	text := `type User {
+  id: int
+  name: string
+}
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { id: user.id }
+
+DELETE /users/{id}
+  |> input(id: path.id)
+  |> respond 204
+`
+
+	symbols := DocumentSymbols(text)
+	if len(symbols) != 3 {
+		t.Fatalf("expected 3 symbols, got %d", len(symbols))
+	}
+
+	expected := []struct {
+		name     string
+		kind     protocol.SymbolKind
+		children int
+		endLine  uint32
+	}{
+		{"User", protocol.SymbolKindStruct, 0, 3},
+		{"GET /users/{id}", protocol.SymbolKindMethod, 3, 8},
+		{"DELETE /users/{id}", protocol.SymbolKindMethod, 2, 12},
+	}
+	for i, exp := range expected {
+		sym := symbols[i]
+		if sym.Name != exp.name || sym.Kind != exp.kind {
+			t.Fatalf("symbol[%d]: expected %q kind %d, got %q kind %d", i, exp.name, exp.kind, sym.Name, sym.Kind)
+		}
+		if len(sym.Children) != exp.children {
+			t.Fatalf("symbol[%d]: expected %d children, got %d", i, exp.children, len(sym.Children))
+		}
+		if sym.Range.End.Line != exp.endLine {
+			t.Fatalf("symbol[%d]: expected range to end on line %d, got %d", i, exp.endLine, sym.Range.End.Line)
+		}
+	}
+
+	fetch := symbols[1].Children[1]
+	if fetch.Name != "fetch" || fetch.Detail == nil || *fetch.Detail != "as user" {
+		t.Fatalf("expected fetch step bound as user, got %+v", fetch)
+	}
+	if fetch.Range.Start.Line != 7 {
+		t.Fatalf("expected fetch step on line 7, got %d", fetch.Range.Start.Line)
+	}
+}
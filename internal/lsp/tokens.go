@@ -0,0 +1,83 @@
+package lsp
+
+import (
+	"strings"
+	"unicode/utf16"
+
+	protocol "github.com/tliron/glsp/protocol_3_16"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/lexer"
+	"github.com/polidog/reverhttp/internal/parser"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+// parseDocument parses the buffer text and returns the AST along with any parse errors.
+func parseDocument(text string) (*ast.File, []parser.Error) {
+	return parser.Parse(text, "buffer")
+}
+
+// tokenAt returns the token covering the given LSP position, if any. A
+// """ text block covers every line it spans.
+func tokenAt(text string, pos protocol.Position) (token.Token, bool) {
+	l := lexer.New(text, "buffer")
+	for {
+		tok := l.NextToken()
+		if tok.Type == token.EOF || toProtocolPosition(tok.Pos).Line > pos.Line {
+			return token.Token{}, false
+		}
+		if tok.Type == token.NEWLINE {
+			continue
+		}
+		r := tokenRange(tok)
+		if !positionLess(pos, r.Start) && positionLess(pos, r.End) {
+			return tok, true
+		}
+	}
+}
+
+// tokenRange converts a token's source span, delimiters included, to an LSP
+// range. Only text blocks end on a later line than they start.
+func tokenRange(tok token.Token) protocol.Range {
+	return protocol.Range{Start: toProtocolPosition(tok.Pos), End: toProtocolPosition(tok.End)}
+}
+
+// lineRanges splits the range of tok into one range per line it covers, as
+// semantic tokens cannot span lines. lines is the text split at '\n'.
+func lineRanges(lines []string, tok token.Token) []protocol.Range {
+	r := tokenRange(tok)
+	var ranges []protocol.Range
+	start := r.Start
+	for line := r.Start.Line; line < r.End.Line && int(line) < len(lines); line++ {
+		end := protocol.Position{Line: line, Character: uint32(len(utf16.Encode([]rune(strings.TrimSuffix(lines[line], "\r")))))}
+		if end.Character > start.Character {
+			ranges = append(ranges, protocol.Range{Start: start, End: end})
+		}
+		start = protocol.Position{Line: line + 1}
+	}
+	if r.End.Character > start.Character {
+		ranges = append(ranges, protocol.Range{Start: start, End: r.End})
+	}
+	return ranges
+}
+
+// toProtocolPosition converts a 1-based source position to a 0-based LSP position.
+func toProtocolPosition(pos token.Position) protocol.Position {
+	line, col := pos.Line-1, utf16Column(pos)-1
+	if line < 0 {
+		line = 0
+	}
+	if col < 0 {
+		col = 0
+	}
+	return protocol.Position{Line: uint32(line), Character: uint32(col)}
+}
+
+// utf16Column returns the 1-based UTF-16 column of pos, falling back to the
+// rune column for positions not produced by the lexer.
+func utf16Column(pos token.Position) int {
+	if pos.UTF16Column > 0 {
+		return pos.UTF16Column
+	}
+	return pos.Column
+}
@@ -2,8 +2,11 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/polidog/reverhttp/internal/ast"
 	"github.com/polidog/reverhttp/internal/lexer"
@@ -12,10 +15,27 @@ import (
 
 // Parser is a recursive descent parser for ReverHTTP DSL.
 type Parser struct {
-	l      *lexer.Lexer
-	cur    token.Token
-	peek   token.Token
-	errors []string
+	l         *lexer.Lexer
+	cur       token.Token
+	peek      token.Token
+	errors    []Error
+	lexErrors int // lexer errors already copied into errors
+}
+
+// Error is a lexical or syntax error at Pos.
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+// Error formats the error as "file:line:col: message".
+func (e Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Pos.File, e.Pos.Line, e.Pos.Column, e.Message)
+}
+
+// String is the same as Error.
+func (e Error) String() string {
+	return e.Error()
 }
 
 // New creates a new Parser.
@@ -27,23 +47,47 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
-// Errors returns the list of parse errors.
-func (p *Parser) Errors() []string {
+// Parse parses src as the file filename, capturing doc comments, and returns
+// the AST along with the lexical and parse errors. The AST is returned even
+// when there are errors, for tools that work on partial input.
+func Parse(src, filename string) (*ast.File, []Error) {
+	l := lexer.New(src, filename)
+	l.SetCaptureComments(true)
+	p := New(l)
+	file := p.ParseFile()
+	return file, p.Errors()
+}
+
+// Errors returns the lexical and parse errors in the order they were found.
+func (p *Parser) Errors() []Error {
 	return p.errors
 }
 
+// ErrorStrings returns the errors formatted as "file:line:col: message".
+func (p *Parser) ErrorStrings() []string {
+	strs := make([]string, len(p.errors))
+	for i, e := range p.errors {
+		strs[i] = e.Error()
+	}
+	return strs
+}
+
 func (p *Parser) addError(msg string) {
-	pos := p.cur.Pos
-	p.errors = append(p.errors, fmt.Sprintf("%s:%d:%d: %s", pos.File, pos.Line, pos.Column, msg))
+	p.addErrorAt(p.cur.Pos, msg)
 }
 
 func (p *Parser) addErrorAt(pos token.Position, msg string) {
-	p.errors = append(p.errors, fmt.Sprintf("%s:%d:%d: %s", pos.File, pos.Line, pos.Column, msg))
+	p.errors = append(p.errors, Error{Pos: pos, Message: msg})
 }
 
 func (p *Parser) nextToken() {
 	p.cur = p.peek
 	p.peek = p.l.NextToken()
+	errs := p.l.Errors()
+	for _, e := range errs[p.lexErrors:] {
+		p.addErrorAt(e.Pos, e.Message)
+	}
+	p.lexErrors = len(errs)
 }
 
 func (p *Parser) curIs(t token.Type) bool {
@@ -79,14 +123,85 @@ func (p *Parser) skipToNextStatement() {
 	}
 }
 
+// syncToPipelineBoundary skips the rest of a malformed pipeline step, across
+// lines if needed, up to the next "|>" or "~>" or a declaration that starts
+// at column 1. Stopping at NEWLINE like skipToNextStatement would leave a
+// multi-line step half-consumed and end the route early.
+func (p *Parser) syncToPipelineBoundary() {
+	for !p.curIs(token.EOF) {
+		if p.curIs(token.PIPE) || p.curIs(token.ERROR) || p.atDeclStart() {
+			return
+		}
+		p.nextToken()
+	}
+}
+
+// atDeclStart reports whether cur begins a top-level declaration or closes
+// a top-level block.
+func (p *Parser) atDeclStart() bool {
+	if p.cur.Pos.Column != 1 {
+		return false
+	}
+	switch p.cur.Type {
+	case token.META, token.IMPORT, token.TYPE, token.ENUM, token.DEFAULTS, token.GROUP, token.PIPELINE, token.RBRACE, token.AT:
+		return true
+	}
+	return token.IsHTTPMethod(p.cur.Type)
+}
+
+// skipStepRemainder handles tokens left over after a step. Unless the step
+// already reported an error, the first leftover token is reported; then the
+// parser resyncs, discarding any error flow that followed the garbage when
+// the step has already been completed.
+func (p *Parser) skipStepRemainder(errCount int, completed bool) {
+	if p.curIs(token.NEWLINE) || p.curIs(token.EOF) || p.curIs(token.PIPE) || p.curIs(token.RBRACE) {
+		return
+	}
+	if !completed && (p.curIs(token.ERROR) || p.curIs(token.ELSE)) {
+		return
+	}
+	if len(p.errors) == errCount {
+		p.addError(fmt.Sprintf("unexpected %s (%q) after step", p.cur.Type, p.cur.Literal))
+	}
+	p.syncToPipelineBoundary()
+	for completed && p.curIs(token.ERROR) {
+		p.parseErrorFlow()
+		p.syncToPipelineBoundary()
+	}
+}
+
+// stuck guards a list loop whose cases only consume the tokens they
+// recognise. It reports whether the iteration that began at start consumed
+// nothing; the token is then reported, unless the list already reported an
+// error, and skipped so that the loop always advances.
+func (p *Parser) stuck(start token.Position, errCount int, what string) bool {
+	if p.cur.Pos != start || p.curIs(token.EOF) {
+		return false
+	}
+	if len(p.errors) == errCount {
+		p.addError(fmt.Sprintf("unexpected %s (%q) in %s", p.cur.Type, p.cur.Literal, what))
+	}
+	p.nextToken()
+	return true
+}
+
 // ParseFile parses a complete .rever file.
 func (p *Parser) ParseFile() *ast.File {
 	file := &ast.File{}
 
 	p.skipNewlines()
 
-	for !p.curIs(token.EOF) {
+	for first := true; !p.curIs(token.EOF); first = false {
 		switch {
+		case p.curIs(token.IDENT) && p.cur.Literal == "version":
+			p.parseVersion(file, first)
+		case p.curIs(token.META):
+			block := p.parseMeta()
+			if file.Meta != nil {
+				p.addErrorAt(block.Pos, fmt.Sprintf("duplicate meta block (first declared at line %d)", file.Meta.Pos.Line))
+			} else {
+				file.Meta = block
+			}
 		case p.curIs(token.IMPORT):
 			imp := p.parseImport()
 			if imp != nil {
@@ -97,13 +212,34 @@ func (p *Parser) ParseFile() *ast.File {
 			if td != nil {
 				file.Types = append(file.Types, td)
 			}
+		case p.curIs(token.ENUM):
+			ed := p.parseEnum()
+			if ed != nil {
+				file.Enums = append(file.Enums, ed)
+			}
 		case p.curIs(token.DEFAULTS):
-			file.Defaults = p.parseDefaults()
+			block := p.parseDefaults()
+			if len(block.Methods) > 0 {
+				file.MethodDefaults = append(file.MethodDefaults, block)
+			} else {
+				file.Defaults = block
+			}
+		case p.curIs(token.PIPELINE):
+			decl := p.parsePipelineDecl()
+			if decl != nil {
+				file.Pipelines = append(file.Pipelines, decl)
+			}
+		case p.curIs(token.GROUP):
+			p.parseGroup(file, nil)
 		case token.IsHTTPMethod(p.cur.Type):
-			route := p.parseRoute()
+			route := p.parseRoute(nil)
 			if route != nil {
 				file.Routes = append(file.Routes, route)
 			}
+		case p.curIs(token.AT):
+			if route := p.parseAnnotatedRoute(nil); route != nil {
+				file.Routes = append(file.Routes, route)
+			}
 		default:
 			p.addError(fmt.Sprintf("unexpected token %s (%q)", p.cur.Type, p.cur.Literal))
 			p.nextToken()
@@ -114,6 +250,56 @@ func (p *Parser) ParseFile() *ast.File {
 	return file
 }
 
+// parseVersion parses the IR version pragma, which must open the file:
+//
+//	version 0.2
+func (p *Parser) parseVersion(file *ast.File, first bool) {
+	pos := p.cur.Pos
+	p.nextToken() // skip 'version'
+
+	// 0.2 is lexed as a float and any further parts as ".3".
+	version := ""
+	for p.curIs(token.INT) || p.curIs(token.FLOAT) {
+		version += p.cur.Literal
+		p.nextToken()
+		if !p.curIs(token.DOT) || !p.peekIs(token.INT) {
+			break
+		}
+		version += "."
+		p.nextToken() // skip '.'
+	}
+	switch {
+	case version == "":
+		p.addError(fmt.Sprintf("expected a version number such as 0.2 after 'version', got %s", p.cur.Type))
+		p.skipToNextStatement()
+		return
+	case !first:
+		p.addErrorAt(pos, "version must come before all other declarations")
+	default:
+		file.Version, file.VersionPos = version, pos
+	}
+	if !p.curIs(token.NEWLINE) && !p.curIs(token.EOF) {
+		p.addError(fmt.Sprintf("unexpected %s after version", p.cur.Type))
+		p.skipToNextStatement()
+	}
+}
+
+// parseMeta parses:
+//
+//	meta { title: "User API", version: "1.2.0", base: "/api" }
+func (p *Parser) parseMeta() *ast.MetaBlock {
+	block := &ast.MetaBlock{Pos: p.cur.Pos}
+	p.nextToken() // skip 'meta'
+
+	if !p.curIs(token.LBRACE) {
+		p.addError(fmt.Sprintf("expected '{' after 'meta', got %s", p.cur.Type))
+		p.skipToNextStatement()
+		return block
+	}
+	block.Fields = p.parseFlatFields("meta")
+	return block
+}
+
 // parseImport parses:
 //
 //	import <alias> = <source>@<version>
@@ -129,6 +315,7 @@ func (p *Parser) parseImport() *ast.ImportDecl {
 	}
 
 	alias := p.cur.Literal
+	aliasPos := p.cur.Pos
 	p.nextToken()
 
 	if !p.curIs(token.ASSIGN) {
@@ -138,7 +325,7 @@ func (p *Parser) parseImport() *ast.ImportDecl {
 	}
 	p.nextToken() // skip '='
 
-	decl := &ast.ImportDecl{Pos: pos, Alias: alias}
+	decl := &ast.ImportDecl{Pos: pos, AliasPos: aliasPos, Alias: alias}
 
 	// Check for local import: @/path
 	if p.curIs(token.AT) && p.peekIs(token.SLASH) {
@@ -194,6 +381,7 @@ func joinSourceParts(parts []string) string {
 //	type User { id: int, name: string }
 func (p *Parser) parseType() *ast.TypeDecl {
 	pos := p.cur.Pos
+	doc := p.cur.Doc
 	p.nextToken() // skip 'type'
 
 	if !p.curIs(token.IDENT) {
@@ -203,6 +391,7 @@ func (p *Parser) parseType() *ast.TypeDecl {
 	}
 
 	name := p.cur.Literal
+	namePos := p.cur.Pos
 	p.nextToken()
 
 	if !p.curIs(token.LBRACE) {
@@ -213,14 +402,21 @@ func (p *Parser) parseType() *ast.TypeDecl {
 	p.nextToken() // skip '{'
 	p.skipNewlines()
 
-	td := &ast.TypeDecl{Pos: pos, Name: name}
+	td := &ast.TypeDecl{Pos: pos, NamePos: namePos, Name: name, Doc: doc}
 
 	for !p.curIs(token.RBRACE) && !p.curIs(token.EOF) {
 		p.skipNewlines()
 		if p.curIs(token.RBRACE) {
 			break
 		}
+		if !p.curIs(token.IDENT) && !token.IsKeyword(p.cur.Type) {
+			// A stray separator such as ",," or a leading ","
+			p.addError(fmt.Sprintf("expected field name in type %s, got %s", name, p.cur.Type))
+			p.nextToken()
+			continue
+		}
 
+		fieldPos := p.cur.Pos
 		fieldName := p.cur.Literal
 		p.nextToken()
 
@@ -234,9 +430,15 @@ func (p *Parser) parseType() *ast.TypeDecl {
 		typeName := p.cur.Literal
 		p.nextToken()
 
-		td.Fields = append(td.Fields, &ast.Field{Name: fieldName, TypeName: typeName})
+		field := &ast.Field{Pos: fieldPos, Name: fieldName, TypeName: typeName}
+		if p.curIs(token.ASSIGN) {
+			p.nextToken() // skip '='
+			field.Default = p.parseDefaultValue()
+		}
+		td.Fields = append(td.Fields, field)
 
-		// Skip optional comma or newline
+		// Fields are separated by a comma, a newline or both; a trailing
+		// comma before '}' is allowed.
 		if p.curIs(token.COMMA) {
 			p.nextToken()
 		}
@@ -250,19 +452,117 @@ func (p *Parser) parseType() *ast.TypeDecl {
 	return td
 }
 
+// parseDefaultValue parses the literal default of a type field: a string,
+// an integer, true or false.
+func (p *Parser) parseDefaultValue() *ast.Expr {
+	var e *ast.Expr
+	switch {
+	case p.curIs(token.STRING):
+		e = &ast.Expr{Kind: ast.ExprString, StrVal: p.cur.Literal}
+	case p.curIs(token.INT):
+		e = &ast.Expr{Kind: ast.ExprInt, IntVal: p.cur.Literal}
+	case p.curIs(token.IDENT) && (p.cur.Literal == "true" || p.cur.Literal == "false"):
+		e = &ast.Expr{Kind: ast.ExprBool, StrVal: p.cur.Literal}
+	default:
+		p.addError(fmt.Sprintf("expected string, integer or boolean default, got %s", p.cur.Type))
+		p.nextToken()
+		return nil
+	}
+	p.nextToken()
+	return e
+}
+
+// parseEnum parses enum Name { a, b, c }. Values may also be separated by
+// newlines.
+func (p *Parser) parseEnum() *ast.EnumDecl {
+	pos := p.cur.Pos
+	doc := p.cur.Doc
+	p.nextToken() // skip 'enum'
+
+	if !p.curIs(token.IDENT) {
+		p.addError("expected enum name after 'enum'")
+		p.skipToNextStatement()
+		return nil
+	}
+
+	ed := &ast.EnumDecl{Pos: pos, NamePos: p.cur.Pos, Name: p.cur.Literal, Doc: doc}
+	p.nextToken()
+
+	if !p.curIs(token.LBRACE) {
+		p.addError("expected '{' after enum name")
+		p.skipToNextStatement()
+		return nil
+	}
+	p.nextToken() // skip '{'
+
+	seen := make(map[string]bool)
+	for !p.curIs(token.RBRACE) && !p.curIs(token.EOF) {
+		if !p.curIs(token.IDENT) {
+			p.addError(fmt.Sprintf("expected enum value, got %s", p.cur.Type))
+			p.nextToken()
+			continue
+		}
+		if seen[p.cur.Literal] {
+			p.addError(fmt.Sprintf("duplicate enum value %q", p.cur.Literal))
+		}
+		seen[p.cur.Literal] = true
+		ed.Values = append(ed.Values, p.cur.Literal)
+		p.nextToken()
+
+		if p.curIs(token.COMMA) {
+			p.nextToken()
+		}
+	}
+
+	if p.curIs(token.RBRACE) {
+		p.nextToken() // skip '}'
+	}
+
+	if len(ed.Values) == 0 {
+		p.addErrorAt(ed.NamePos, fmt.Sprintf("enum %s has no values", ed.Name))
+	}
+
+	return ed
+}
+
 // parseDefaults parses:
 //
-//	defaults
+//	defaults [for <method>, ...]
 //	  cors(...)
 //	  auth(...)
+//
+// The directives may also be wrapped in braces: defaults for POST { auth(bearer) }.
 func (p *Parser) parseDefaults() *ast.DefaultsBlock {
 	pos := p.cur.Pos
 	p.nextToken() // skip 'defaults'
-	p.skipNewlines()
 
 	block := &ast.DefaultsBlock{Pos: pos}
 
-	for p.curIs(token.CACHE) || p.curIs(token.CORS) || p.curIs(token.AUTH) {
+	// "for" is only special here, so it is not reserved as a keyword.
+	if p.curIs(token.IDENT) && p.cur.Literal == "for" {
+		p.nextToken() // skip 'for'
+		for {
+			if !token.IsHTTPMethod(p.cur.Type) {
+				p.addError(fmt.Sprintf("expected HTTP method in defaults for, got %s (%q)", p.cur.Type, p.cur.Literal))
+				break
+			}
+			block.Methods = append(block.Methods, p.cur.Literal)
+			block.MethodPos = append(block.MethodPos, p.cur.Pos)
+			p.nextToken()
+			if !p.curIs(token.COMMA) {
+				break
+			}
+			p.nextToken() // skip ','
+		}
+	}
+
+	braced := p.curIs(token.LBRACE)
+	if braced {
+		p.nextToken() // skip '{'
+	}
+	p.skipNewlines()
+
+	for p.curIsDirective() {
 		d := p.parseDirective()
 		if d != nil {
 			block.Directives = append(block.Directives, d)
@@ -270,11 +570,39 @@ func (p *Parser) parseDefaults() *ast.DefaultsBlock {
 		p.skipNewlines()
 	}
 
+	if braced {
+		if !p.curIs(token.RBRACE) {
+			p.addError(fmt.Sprintf("expected '}' to close defaults, got %s (%q)", p.cur.Type, p.cur.Literal))
+			return block
+		}
+		p.nextToken() // skip '}'
+	}
+
 	return block
 }
 
-// parseDirective parses a route-level directive: cache(...), cors(...), auth(...)
+func (p *Parser) curIsDirective() bool {
+	return p.curIs(token.CACHE) || p.curIs(token.CORS) || p.curIs(token.AUTH) || p.curIs(token.RETRY) || p.curIs(token.PAGINATE) || p.curIs(token.ACCEPTS) || p.curIs(token.COMPRESS)
+}
+
+// parseDirective parses a route-level directive: cache(...), cors(...), auth(...), retry(...), paginate(...), accepts(...), compress(...)
 func (p *Parser) parseDirective() *ast.Directive {
+	d := p.parseDirectiveCall()
+
+	// Check for "as <name>" (used by auth)
+	if p.curIs(token.AS) {
+		p.nextToken() // skip 'as'
+		if p.curIs(token.IDENT) {
+			d.Bind = p.cur.Literal
+			p.nextToken()
+		}
+	}
+
+	return d
+}
+
+// parseDirectiveCall parses a directive name and its optional argument list.
+func (p *Parser) parseDirectiveCall() *ast.Directive {
 	pos := p.cur.Pos
 	name := p.cur.Literal
 	p.nextToken() // skip directive name
@@ -286,71 +614,82 @@ func (p *Parser) parseDirective() *ast.Directive {
 	}
 	p.nextToken() // skip '('
 
-	d.Args = p.parseDirectiveArgs()
+	d.Args = p.parseArgList("directive arguments")
 
 	if p.curIs(token.RPAREN) {
 		p.nextToken() // skip ')'
 	}
 
-	// Check for "as <name>" (used by auth)
-	if p.curIs(token.AS) {
-		p.nextToken() // skip 'as'
-		if p.curIs(token.IDENT) {
-			d.Bind = p.cur.Literal
-			p.nextToken()
-		}
-	}
-
 	return d
 }
 
-func (p *Parser) parseDirectiveArgs() []*ast.Arg {
+// parseArgList parses the arguments of a call up to its ')': named args
+// (name: value), positional values, lists, { a, b } objects and calls such
+// as env("NAME"). Directives, package calls and constraints all use it, so
+// an argument means the same wherever it is written. An uppercase
+// positional name is a type, and none is a flag, as in cors(none).
+func (p *Parser) parseArgList(what string) []*ast.Arg {
 	var args []*ast.Arg
+	errCount := len(p.errors)
 
 	for !p.curIs(token.RPAREN) && !p.curIs(token.EOF) {
-		arg := &ast.Arg{}
+		start := p.cur.Pos
+		arg := &ast.Arg{Pos: p.cur.Pos}
 
-		// Check if this is a named arg or keyword
-		if p.curIs(token.NONE) {
-			// none keyword (e.g., cors(none), auth(none))
+		switch {
+		case p.curIs(token.NONE):
 			arg.Name = "none"
 			arg.Value = ast.Expr{Kind: ast.ExprBool, StrVal: "true"}
-			args = append(args, arg)
 			p.nextToken()
-			if p.curIs(token.COMMA) {
-				p.nextToken()
-			}
-			continue
-		}
-
-		if (p.curIs(token.IDENT) || p.isHyphenatedKeyword()) && p.peekIs(token.COLON) {
-			// Named arg: name: value
+		case (p.curIs(token.IDENT) && !isUpperCase(p.cur.Literal) || token.IsKeyword(p.cur.Type)) && p.peekIs(token.COLON):
+			// Named arg: name: value. Names such as "max-age" are single
+			// identifiers, and keywords such as "headers" are plain names.
 			arg.Name = p.cur.Literal
+			if arg.Name == "origins" {
+				// cors origins may be regex literals. The lexer reads one
+				// token ahead, so the mode is set while the name is current.
+				p.l.SetRegexMode(true)
+			}
 			p.nextToken() // skip name
 			p.nextToken() // skip ':'
-
-			arg.Value = p.parseExprValue()
-			args = append(args, arg)
-		} else if p.curIs(token.IDENT) || p.curIs(token.INT) || p.curIs(token.STRING) {
-			// Positional arg: could be a keyword flag like "public", "private", "bearer"
-			// or a value like "credentials"
-			arg.Value = p.parseExprValue()
-			args = append(args, arg)
-		} else {
+			p.parseArgValue(arg, what)
+			p.l.SetRegexMode(false)
+		case p.curIs(token.IDENT), p.curIs(token.INT), p.curIs(token.FLOAT), p.curIs(token.STRING),
+			p.curIs(token.LBRACKET), p.curIs(token.LBRACE), p.curIs(token.REGEX):
+			p.parseArgValue(arg, what)
+		case p.curIs(token.COMMA):
+			// A leading or doubled comma separates nothing.
+			p.addError("unexpected ','")
 			p.nextToken()
+			continue
 		}
 
 		if p.curIs(token.COMMA) {
 			p.nextToken()
 		}
+		if p.stuck(start, errCount, what) {
+			continue
+		}
+		args = append(args, arg)
 	}
 
 	return args
 }
 
-func (p *Parser) isHyphenatedKeyword() bool {
-	// Check for hyphenated identifiers like "max-age", "expose-headers"
-	return p.curIs(token.IDENT)
+// parseArgValue parses the value of arg, recording the placeholders of a
+// string.
+func (p *Parser) parseArgValue(arg *ast.Arg, what string) {
+	switch {
+	case p.curIs(token.LBRACE):
+		arg.Value = p.parseObjectExpr(what)
+		return
+	case p.curIs(token.STRING):
+		arg.Refs, arg.RefPos = stringPlaceholders(p.cur)
+	}
+	arg.Value = p.parseExprValue()
+	if v := arg.Value; arg.Name == "" && v.Kind == ast.ExprIdent && isUpperCase(v.StrVal) && !strings.Contains(v.StrVal, ".") {
+		arg.Value.Kind = ast.ExprType
+	}
 }
 
 func (p *Parser) parseExprValue() ast.Expr {
@@ -361,20 +700,47 @@ func (p *Parser) parseExprValue() ast.Expr {
 		return ast.Expr{Kind: ast.ExprString, StrVal: val}
 	case p.curIs(token.INT):
 		val := p.cur.Literal
+		line, end := p.cur.Pos.Line, p.cur.Pos.Column+len(val)
 		p.nextToken()
+		// Duration like 200ms: a unit immediately follows the number
+		if p.curIs(token.IDENT) && p.cur.Pos.Line == line && p.cur.Pos.Column == end {
+			val += p.cur.Literal
+			p.nextToken()
+			return ast.Expr{Kind: ast.ExprDuration, StrVal: val}
+		}
 		return ast.Expr{Kind: ast.ExprInt, IntVal: val}
+	case p.curIs(token.FLOAT):
+		val := p.cur.Literal
+		line, end := p.cur.Pos.Line, p.cur.Pos.Column+len(val)
+		p.nextToken()
+		// Duration like 1.5s
+		if p.curIs(token.IDENT) && p.cur.Pos.Line == line && p.cur.Pos.Column == end {
+			val += p.cur.Literal
+			p.nextToken()
+			return ast.Expr{Kind: ast.ExprDuration, StrVal: val}
+		}
+		return ast.Expr{Kind: ast.ExprFloat, StrVal: val}
 	case p.curIs(token.LBRACKET):
 		return p.parseListExpr()
+	case p.curIs(token.REGEX):
+		pattern, flags := lexer.SplitRegex(p.cur.Literal)
+		p.nextToken()
+		return ast.Expr{Kind: ast.ExprRegex, StrVal: pattern, Flags: flags}
 	case p.curIs(token.IDENT):
 		name := p.cur.Literal
 		p.nextToken()
-		// Check for function call like hash(user)
+		// Check for function call like hash(user) or env("NAME"); a string
+		// argument stays quoted so it can be told apart from a reference.
 		if p.curIs(token.LPAREN) {
 			p.nextToken() // skip '('
 			argVal := ""
-			if p.curIs(token.IDENT) {
+			switch {
+			case p.curIs(token.IDENT):
 				argVal = p.cur.Literal
 				p.nextToken()
+			case p.curIs(token.STRING):
+				argVal = strconv.Quote(p.cur.Literal)
+				p.nextToken()
 			}
 			if p.curIs(token.RPAREN) {
 				p.nextToken() // skip ')'
@@ -386,7 +752,7 @@ func (p *Parser) parseExprValue() ast.Expr {
 			parts := []string{name}
 			for p.curIs(token.DOT) {
 				p.nextToken() // skip '.'
-				if p.curIs(token.IDENT) {
+				if p.curIsMember() {
 					parts = append(parts, p.cur.Literal)
 					p.nextToken()
 				}
@@ -402,14 +768,40 @@ func (p *Parser) parseExprValue() ast.Expr {
 
 func (p *Parser) parseListExpr() ast.Expr {
 	p.nextToken() // skip '['
-	var items []string
+	list := ast.Expr{Kind: ast.ExprList}
 	for !p.curIs(token.RBRACKET) && !p.curIs(token.EOF) {
-		if p.curIs(token.STRING) {
-			items = append(items, p.cur.Literal)
-		} else {
-			items = append(items, p.cur.Literal)
+		var item ast.Expr
+		text := p.cur.Literal
+		switch {
+		case p.curIs(token.IDENT) && p.peekIs(token.LPAREN):
+			// A call such as env("ORIGIN") is kept as its source form.
+			item = p.parseExprValue()
+			text = item.StrVal
+		case p.curIs(token.IDENT) && p.peekIs(token.DOT):
+			text = p.parseDottedName()
+			item = ast.Expr{Kind: ast.ExprIdent, StrVal: text}
+		case p.curIs(token.REGEX):
+			// So is a regex, as /pattern/flags.
+			pattern, flags := lexer.SplitRegex(p.cur.Literal)
+			item = ast.Expr{Kind: ast.ExprRegex, StrVal: pattern, Flags: flags}
+			text = "/" + pattern + "/" + flags
+			p.nextToken()
+		case p.curIs(token.STRING):
+			item = ast.Expr{Kind: ast.ExprString, StrVal: text}
+			p.nextToken()
+		case p.curIs(token.INT):
+			item = ast.Expr{Kind: ast.ExprInt, IntVal: text}
+			p.nextToken()
+		case p.curIs(token.FLOAT):
+			item = ast.Expr{Kind: ast.ExprFloat, StrVal: text}
+			p.nextToken()
+		default:
+			// Bare names such as Accept in vary: [Accept].
+			item = ast.Expr{Kind: ast.ExprIdent, StrVal: text}
+			p.nextToken()
 		}
-		p.nextToken()
+		list.ListVal = append(list.ListVal, text)
+		list.Items = append(list.Items, item)
 		if p.curIs(token.COMMA) {
 			p.nextToken()
 		}
@@ -417,63 +809,303 @@ func (p *Parser) parseListExpr() ast.Expr {
 	if p.curIs(token.RBRACKET) {
 		p.nextToken()
 	}
-	return ast.Expr{Kind: ast.ExprList, ListVal: items}
+	return list
 }
 
-// parseRoute parses a route definition.
-func (p *Parser) parseRoute() *ast.Route {
-	pos := p.cur.Pos
-	method := p.cur.Literal
-	p.nextToken() // skip HTTP method
+// parseObjectExpr parses the { name, email } shorthand of a package call,
+// which passes each name as the field of the same name.
+func (p *Parser) parseObjectExpr(what string) ast.Expr {
+	p.nextToken() // skip '{'
+	errCount := len(p.errors)
+	var names []string
+	for !p.curIs(token.RBRACE) && !p.curIs(token.EOF) {
+		start := p.cur.Pos
+		if p.curIs(token.IDENT) {
+			names = append(names, p.cur.Literal)
+			p.nextToken()
+		}
+		if p.curIs(token.COMMA) {
+			p.nextToken()
+		}
+		p.stuck(start, errCount, what)
+	}
+	if p.curIs(token.RBRACE) {
+		p.nextToken()
+	}
+	return ast.Expr{Kind: ast.ExprObject, ListVal: names}
+}
 
-	// Parse path: /users/{id}
-	path := p.parsePath()
+// parsePipelineDecl parses a named pipeline:
+//
+//	pipeline <name> {
+//	  |> step ...
+//	}
+func (p *Parser) parsePipelineDecl() *ast.PipelineDecl {
+	pos := p.cur.Pos
+	p.nextToken() // skip 'pipeline'
 
-	route := &ast.Route{Pos: pos, Method: method, Path: path}
+	if !p.curIs(token.IDENT) {
+		p.addError(fmt.Sprintf("expected pipeline name after 'pipeline', got %s (%q)", p.cur.Type, p.cur.Literal))
+		p.skipToNextStatement()
+		return nil
+	}
 
-	p.skipNewlines()
+	decl := &ast.PipelineDecl{Pos: pos, NamePos: p.cur.Pos, Name: p.cur.Literal}
+	p.nextToken()
 
-	// Parse optional directives before first |>
-	for p.curIs(token.CACHE) || p.curIs(token.CORS) || p.curIs(token.AUTH) {
-		d := p.parseDirective()
-		if d != nil {
-			route.Directives = append(route.Directives, d)
-		}
-		p.skipNewlines()
+	if !p.curIs(token.LBRACE) {
+		p.addError("expected '{' after pipeline name")
+		p.skipToNextStatement()
+		return nil
 	}
+	p.nextToken() // skip '{'
+	p.skipNewlines()
 
-	// Parse pipeline steps
 	for p.curIs(token.PIPE) {
 		step := p.parsePipelineStep()
 		if step != nil {
-			route.Steps = append(route.Steps, step)
+			decl.Steps = append(decl.Steps, step)
 		}
 		p.skipNewlines()
 	}
 
-	p.validateRoute(route)
+	if !p.curIs(token.RBRACE) {
+		p.addError(fmt.Sprintf("expected '}' to close pipeline %s, got %s (%q)", decl.Name, p.cur.Type, p.cur.Literal))
+		return decl
+	}
+	p.nextToken() // skip '}'
 
-	return route
+	if len(decl.Steps) == 0 {
+		p.addErrorAt(decl.NamePos, fmt.Sprintf("pipeline %s has no steps", decl.Name))
+	}
+
+	return decl
 }
 
-func (p *Parser) parsePath() string {
-	var parts []string
-	for !p.curIs(token.NEWLINE) && !p.curIs(token.EOF) {
-		parts = append(parts, p.cur.Literal)
-		p.nextToken()
+// parseUse parses use <pipeline>.
+func (p *Parser) parseUse() *ast.UseStep {
+	p.nextToken() // skip 'use'
+
+	if !p.curIs(token.IDENT) {
+		p.addError(fmt.Sprintf("expected pipeline name after 'use', got %s (%q)", p.cur.Type, p.cur.Literal))
+		return &ast.UseStep{Pos: p.cur.Pos}
 	}
-	return strings.Join(parts, "")
+	u := &ast.UseStep{Pos: p.cur.Pos, Name: p.cur.Literal}
+	p.nextToken()
+	return u
 }
 
-func (p *Parser) parsePipelineStep() *ast.PipelineStep {
+// parseGroup parses a route group, appending its routes to file:
+//
+//	group /api/v1 {
+//	  <directives>
+//	  <routes and nested groups>
+//	}
+func (p *Parser) parseGroup(file *ast.File, parent *ast.Group) {
 	pos := p.cur.Pos
-	p.nextToken() // skip '|>'
-
-	step := &ast.PipelineStep{Pos: pos}
+	p.nextToken() // skip 'group'
 
-	switch {
-	case p.curIs(token.INPUT):
-		step.Kind = ast.StepInput
+	prefix := ""
+	if p.curIs(token.PATH) {
+		prefix = p.cur.Literal
+		p.nextToken()
+	}
+	if !p.curIs(token.LBRACE) || !(p.peekIs(token.NEWLINE) || p.peekIs(token.EOF)) {
+		p.addErrorAt(pos, "expected '{' at the end of the group line")
+		for !p.curIs(token.NEWLINE) && !p.curIs(token.EOF) {
+			p.nextToken()
+		}
+		return
+	}
+	p.nextToken() // skip '{'
+
+	g := &ast.Group{Pos: pos, Prefix: strings.TrimSuffix(prefix, "/"), Parent: parent}
+	if parent != nil {
+		g.Prefix = joinPath(parent.Prefix, g.Prefix)
+	}
+	file.Groups = append(file.Groups, g)
+
+	p.skipNewlines()
+
+	for p.curIsDirective() {
+		d := p.parseDirective()
+		if d != nil {
+			g.Directives = append(g.Directives, d)
+		}
+		p.skipNewlines()
+	}
+
+	for !p.curIs(token.RBRACE) && !p.curIs(token.EOF) {
+		switch {
+		case p.curIs(token.GROUP):
+			p.parseGroup(file, g)
+		case token.IsHTTPMethod(p.cur.Type):
+			route := p.parseRoute(g)
+			if route != nil {
+				file.Routes = append(file.Routes, route)
+			}
+		case p.curIs(token.AT):
+			if route := p.parseAnnotatedRoute(g); route != nil {
+				file.Routes = append(file.Routes, route)
+			}
+		default:
+			p.addError(fmt.Sprintf("unexpected token %s (%q) in group", p.cur.Type, p.cur.Literal))
+			p.nextToken()
+		}
+		p.skipNewlines()
+	}
+
+	if !p.curIs(token.RBRACE) {
+		p.addErrorAt(pos, fmt.Sprintf("expected '}' to close group %s", g.Prefix))
+		return
+	}
+	p.nextToken() // skip '}'
+}
+
+// joinPath appends path to a group prefix (which has no trailing slash), so
+// that "/api" and "/users" give "/api/users" and a bare "/" refers to the
+// prefix itself.
+func joinPath(prefix, path string) string {
+	if path == "/" && prefix != "" {
+		return prefix
+	}
+	return prefix + path
+}
+
+// parseAnnotatedRoute parses the annotations above a route, then the route:
+//
+//	@doc "Returns the user"
+//	@deprecated "use /v2/users"
+//	GET /users/{id}
+//
+// A comment block above the annotations is the route's Doc.
+func (p *Parser) parseAnnotatedRoute(group *ast.Group) *ast.Route {
+	doc := p.cur.Doc
+	var anns []*ast.Annotation
+	for p.curIs(token.AT) {
+		if a := p.parseAnnotation(); a != nil {
+			for _, prev := range anns {
+				if prev.Name == a.Name {
+					p.addErrorAt(a.Pos, fmt.Sprintf("duplicate @%s annotation (first at line %d)", a.Name, prev.Pos.Line))
+				}
+			}
+			anns = append(anns, a)
+		}
+		p.skipNewlines()
+	}
+
+	if !token.IsHTTPMethod(p.cur.Type) {
+		p.addError(fmt.Sprintf("expected a route after annotations, got %q", p.cur.Literal))
+		return nil
+	}
+	route := p.parseRoute(group)
+	route.Annotations = anns
+	if route.Doc == "" {
+		route.Doc = doc
+	}
+	return route
+}
+
+// parseAnnotation parses one @name ["text"] line. Only @deprecated may
+// leave out the text.
+func (p *Parser) parseAnnotation() *ast.Annotation {
+	a := &ast.Annotation{Pos: p.cur.Pos}
+	p.nextToken() // skip '@'
+
+	if !p.curIs(token.IDENT) || !ast.AnnotationNames[p.cur.Literal] {
+		p.addError(fmt.Sprintf("unknown annotation @%s: expected @doc or @deprecated", p.cur.Literal))
+		p.skipToNextStatement()
+		return nil
+	}
+	a.Name = p.cur.Literal
+	p.nextToken()
+
+	if p.curIs(token.STRING) {
+		a.Value = p.cur.Literal
+		p.nextToken()
+	} else if a.Name == "doc" {
+		p.addError("expected a string after @doc")
+	}
+	if !p.curIs(token.NEWLINE) && !p.curIs(token.EOF) {
+		p.addError(fmt.Sprintf("unexpected %s after @%s", p.cur.Type, a.Name))
+		p.skipToNextStatement()
+	}
+	return a
+}
+
+// parseRoute parses a route definition. Routes inside a group get the
+// group's prefix prepended to their path.
+func (p *Parser) parseRoute(group *ast.Group) *ast.Route {
+	pos := p.cur.Pos
+	method := p.cur.Literal
+	doc := p.cur.Doc
+	p.nextToken() // skip HTTP method
+
+	// Parse path: /users/{id}
+	path := p.parsePath(method)
+	if group != nil {
+		path = joinPath(group.Prefix, path)
+	}
+
+	route := &ast.Route{Pos: pos, Method: method, Path: path, Doc: doc, Group: group}
+
+	p.skipNewlines()
+
+	// Parse optional directives before first |>
+	for p.curIsDirective() {
+		d := p.parseDirective()
+		if d != nil {
+			route.Directives = append(route.Directives, d)
+		}
+		p.skipNewlines()
+	}
+
+	// Parse pipeline steps
+	if !p.curIs(token.PIPE) {
+		p.addErrorAt(pos, fmt.Sprintf("route %s %s has no pipeline steps", method, path))
+	}
+	for p.curIs(token.PIPE) {
+		step := p.parsePipelineStep()
+		if step != nil {
+			route.Steps = append(route.Steps, step)
+		}
+		p.skipNewlines()
+	}
+
+	p.validateRoute(route)
+
+	return route
+}
+
+// parsePath reads the route path, which the lexer delivers as a single PATH
+// token, and skips anything else on the route line.
+func (p *Parser) parsePath(method string) string {
+	path := ""
+	if p.curIs(token.PATH) {
+		path = p.cur.Literal
+		p.nextToken()
+	} else {
+		p.addError(fmt.Sprintf("expected path starting with '/' after %s, got %s (%q)", method, p.cur.Type, p.cur.Literal))
+	}
+	if !p.curIs(token.NEWLINE) && !p.curIs(token.EOF) && path != "" {
+		p.addError(fmt.Sprintf("unexpected %s (%q) after route path", p.cur.Type, p.cur.Literal))
+	}
+	for !p.curIs(token.NEWLINE) && !p.curIs(token.EOF) {
+		p.nextToken()
+	}
+	return path
+}
+
+func (p *Parser) parsePipelineStep() *ast.PipelineStep {
+	pos := p.cur.Pos
+	errCount := len(p.errors)
+	p.nextToken() // skip '|>'
+
+	step := &ast.PipelineStep{Pos: pos}
+
+	switch {
+	case p.curIs(token.INPUT):
+		step.Kind = ast.StepInput
 		step.Input = p.parseInput()
 	case p.curIs(token.VALIDATE):
 		step.Kind = ast.StepValidate
@@ -487,6 +1119,18 @@ func (p *Parser) parsePipelineStep() *ast.PipelineStep {
 	case p.curIs(token.MATCH):
 		step.Kind = ast.StepMatch
 		step.Match = p.parseMatch()
+	case p.curIs(token.MAP):
+		step.Kind = ast.StepMap
+		step.Map = p.parseMap()
+	case p.curIs(token.ENRICH):
+		step.Kind = ast.StepEnrich
+		step.Enrich = p.parseEnrich()
+	case p.curIs(token.FORWARD):
+		step.Kind = ast.StepForward
+		step.Forward = p.parseForward()
+	case p.curIs(token.USE):
+		step.Kind = ast.StepUse
+		step.Use = p.parseUse()
 	case p.curIs(token.RESPOND):
 		step.Kind = ast.StepRespond
 		step.Respond = p.parseRespond()
@@ -496,57 +1140,123 @@ func (p *Parser) parsePipelineStep() *ast.PipelineStep {
 		step.PkgCall = p.parsePkgCall()
 	default:
 		p.addError(fmt.Sprintf("expected step keyword, got %s (%q)", p.cur.Type, p.cur.Literal))
-		p.skipToNextStatement()
+		p.skipStepRemainder(errCount, true)
 		return nil
 	}
 
-	// Check for "as <name>"
+	// Check for "retry(...)" and "as <name>", in either order
+	p.parseStepRetry(step)
 	if p.curIs(token.AS) {
 		p.nextToken() // skip 'as'
 		if p.curIs(token.IDENT) {
-			step.Bind = p.cur.Literal
+			step.Bind, step.BindPos = p.cur.Literal, p.cur.Pos
 			p.nextToken()
 		}
 	}
+	p.parseStepRetry(step)
+	p.skipStepRemainder(errCount, false)
 
 	// Check for error flow: ~> status { body }
 	if p.curIs(token.ERROR) {
 		step.ErrorFlow = p.parseErrorFlow()
 	}
 
+	// Check for guard alternative: else <step>, possibly on the next line
+	if step.Kind == ast.StepGuard {
+		if p.curIs(token.NEWLINE) && p.peekIs(token.ELSE) {
+			p.nextToken() // skip newline
+		}
+		if p.curIs(token.ELSE) {
+			step.Guard.Else = p.parseGuardElse()
+		}
+	}
+	p.skipStepRemainder(errCount, true)
+
+	return step
+}
+
+// parseGuardElse parses the step after 'else': respond ... or a package call.
+func (p *Parser) parseGuardElse() *ast.PipelineStep {
+	p.nextToken() // skip 'else'
+
+	step := &ast.PipelineStep{Pos: p.cur.Pos}
+
+	switch {
+	case p.curIs(token.RESPOND):
+		step.Kind = ast.StepRespond
+		step.Respond = p.parseRespond()
+	case p.curIs(token.IDENT):
+		step.Kind = ast.StepPkgCall
+		step.PkgCall = p.parsePkgCall()
+		if p.curIs(token.ERROR) {
+			step.ErrorFlow = p.parseErrorFlow()
+		}
+	default:
+		p.addError(fmt.Sprintf("expected respond or package call after 'else', got %s (%q)", p.cur.Type, p.cur.Literal))
+		p.skipToNextStatement()
+		return nil
+	}
+
 	return step
 }
 
+// parseStepRetry parses a retry(...) suffix, which only package calls accept.
+func (p *Parser) parseStepRetry(step *ast.PipelineStep) {
+	if !p.curIs(token.RETRY) {
+		return
+	}
+	if step.Kind != ast.StepPkgCall {
+		p.addError("retry is only allowed after a package call")
+	}
+	d := p.parseDirectiveCall()
+	if step.Kind == ast.StepPkgCall {
+		step.Retry = d
+	}
+}
+
 // parseInput parses input(id: path.id, name: body.name)
 func (p *Parser) parseInput() *ast.InputStep {
+	pos := p.cur.Pos
 	p.nextToken() // skip 'input'
 	if !p.curIs(token.LPAREN) {
 		p.addError("expected '(' after 'input'")
-		return &ast.InputStep{}
+		return &ast.InputStep{Pos: pos}
 	}
 	p.nextToken() // skip '('
 
-	input := &ast.InputStep{}
+	input := &ast.InputStep{Pos: pos}
+	errCount := len(p.errors)
 
 	for !p.curIs(token.RPAREN) && !p.curIs(token.EOF) {
-		field := &ast.InputField{}
+		start := p.cur.Pos
+		field := &ast.InputField{NamePos: p.cur.Pos}
 
-		if p.curIs(token.IDENT) {
+		// Keywords such as "headers" are plain names here.
+		if p.curIs(token.IDENT) || (token.IsKeyword(p.cur.Type) && p.peekIs(token.COLON)) {
 			field.Name = p.cur.Literal
 			p.nextToken()
 		}
 
 		if p.curIs(token.COLON) {
 			p.nextToken() // skip ':'
-			field.Pos = p.cur.Pos
-			field.From = p.parseDottedName()
+			p.parseInputSource(field)
 		}
 
-		input.Fields = append(input.Fields, field)
+		if p.curIs(token.COALESCE) {
+			p.nextToken() // skip '??'
+			if field.Wildcard {
+				p.addErrorAt(field.Pos, "the whole header map cannot have a default")
+			}
+			field.Default = p.parseDefaultValue()
+		}
 
 		if p.curIs(token.COMMA) {
 			p.nextToken()
 		}
+		if p.stuck(start, errCount, "input") {
+			continue
+		}
+		input.Fields = append(input.Fields, field)
 	}
 
 	if p.curIs(token.RPAREN) {
@@ -556,35 +1266,78 @@ func (p *Parser) parseInput() *ast.InputStep {
 	return input
 }
 
+// parseInputSource parses the source of an input field: a dotted name such
+// as path.id, or header.* (also written headers) for the whole header map.
+func (p *Parser) parseInputSource(field *ast.InputField) {
+	field.Pos = p.cur.Pos
+	if p.curIs(token.HEADERS) {
+		p.nextToken() // skip 'headers'
+		field.From, field.Wildcard = "header", true
+		return
+	}
+	field.From = p.parseDottedName()
+	// parseDottedName stops at the '*' of header.*, right after the dot.
+	if !p.curIs(token.STAR) || p.cur.Pos.Line != field.Pos.Line || p.cur.Pos.Column != field.Pos.Column+len(field.From)+1 {
+		return
+	}
+	if field.From != "header" {
+		p.addError(fmt.Sprintf("only header.* can bind a whole map, got %s.*", field.From))
+	}
+	field.Wildcard = true
+	p.nextToken() // skip '*'
+}
+
 // parseValidate parses validate(id: int & min(1), name: string & min(1) & max(100))
 func (p *Parser) parseValidate() *ast.ValidateStep {
+	pos := p.cur.Pos
 	p.nextToken() // skip 'validate'
 	if !p.curIs(token.LPAREN) {
 		p.addError("expected '(' after 'validate'")
-		return &ast.ValidateStep{}
+		return &ast.ValidateStep{Pos: pos}
 	}
 	p.nextToken() // skip '('
 
-	v := &ast.ValidateStep{}
+	v := &ast.ValidateStep{Pos: pos}
+	errCount := len(p.errors)
+
+	// validate(User): the rules come from the fields of a declared type.
+	if p.curIs(token.IDENT) && isUpperCase(p.cur.Literal) && p.peekIs(token.RPAREN) {
+		v.TypePos = p.cur.Pos
+		v.Type = p.cur.Literal
+		p.nextToken()
+		p.nextToken() // skip ')'
+		return v
+	}
 
 	for !p.curIs(token.RPAREN) && !p.curIs(token.EOF) {
-		rule := &ast.ValidateRule{}
+		start := p.cur.Pos
+		rule := &ast.ValidateRule{Pos: p.cur.Pos}
 
 		if p.curIs(token.IDENT) {
+			if isUpperCase(p.cur.Literal) && !p.peekIs(token.COLON) && !p.peekIs(token.QUESTION) {
+				p.addError(fmt.Sprintf("type %s must be the only argument of validate", p.cur.Literal))
+			}
 			rule.Field = p.cur.Literal
 			p.nextToken()
 		}
 
+		if p.curIs(token.QUESTION) {
+			rule.Optional = true
+			p.nextToken() // skip '?'
+		}
+
 		if p.curIs(token.COLON) {
 			p.nextToken() // skip ':'
 			rule.Constraints = p.parseConstraints()
 		}
 
-		v.Rules = append(v.Rules, rule)
-
 		if p.curIs(token.COMMA) {
 			p.nextToken()
 		}
+		if p.stuck(start, errCount, "validate") {
+			continue
+		}
+		v.Rules = append(v.Rules, rule)
 	}
 
 	if p.curIs(token.RPAREN) {
@@ -618,31 +1371,24 @@ func (p *Parser) parseSingleConstraint() *ast.Constraint {
 		return nil
 	}
 
-	c := &ast.Constraint{Name: p.cur.Literal}
+	c := &ast.Constraint{Pos: p.cur.Pos, Name: p.cur.Literal}
+	// The lexer reads one token ahead, so the token after '(' is lexed when
+	// '(' becomes current: regex mode covers exactly pattern's argument.
+	if c.Name == "pattern" && p.peekIs(token.LPAREN) {
+		p.l.SetRegexMode(true)
+	}
 	p.nextToken()
 
-	// Check for args: min(1), max(100), format(email)
+	// Check for args: min(1), max(100), format(email), oneOf("a", "b"), pattern(/re/)
 	if p.curIs(token.LPAREN) {
+		p.l.SetRegexMode(false)
 		p.nextToken() // skip '('
-		for !p.curIs(token.RPAREN) && !p.curIs(token.EOF) {
-			arg := ast.Expr{}
-			switch {
-			case p.curIs(token.INT):
-				arg = ast.Expr{Kind: ast.ExprInt, IntVal: p.cur.Literal}
-				p.nextToken()
-			case p.curIs(token.STRING):
-				arg = ast.Expr{Kind: ast.ExprString, StrVal: p.cur.Literal}
-				p.nextToken()
-			case p.curIs(token.IDENT):
-				arg = ast.Expr{Kind: ast.ExprIdent, StrVal: p.cur.Literal}
-				p.nextToken()
-			default:
-				p.nextToken()
-			}
-			c.Args = append(c.Args, arg)
-			if p.curIs(token.COMMA) {
-				p.nextToken()
+		for _, arg := range p.parseArgList("constraint arguments") {
+			if arg.Name != "" {
+				p.addErrorAt(arg.Pos, fmt.Sprintf("%s takes no named arguments", c.Name))
+				continue
 			}
+			c.Args = append(c.Args, arg.Value)
 		}
 		if p.curIs(token.RPAREN) {
 			p.nextToken()
@@ -654,17 +1400,20 @@ func (p *Parser) parseSingleConstraint() *ast.Constraint {
 
 // parseTransform parses transform(id: int(id), name: trim(name))
 func (p *Parser) parseTransform() *ast.TransformStep {
+	pos := p.cur.Pos
 	p.nextToken() // skip 'transform'
 	if !p.curIs(token.LPAREN) {
 		p.addError("expected '(' after 'transform'")
-		return &ast.TransformStep{}
+		return &ast.TransformStep{Pos: pos}
 	}
 	p.nextToken() // skip '('
 
-	t := &ast.TransformStep{}
+	t := &ast.TransformStep{Pos: pos}
+	errCount := len(p.errors)
 
 	for !p.curIs(token.RPAREN) && !p.curIs(token.EOF) {
-		field := &ast.TransformField{}
+		start := p.cur.Pos
+		field := &ast.TransformField{Pos: p.cur.Pos}
 
 		if p.curIs(token.IDENT) {
 			field.Name = p.cur.Literal
@@ -673,28 +1422,20 @@ func (p *Parser) parseTransform() *ast.TransformStep {
 
 		if p.curIs(token.COLON) {
 			p.nextToken() // skip ':'
-			// Parse function call: int(id), trim(name), lower(email)
+			// Parse function call: int(id), trim(name), lower(trim(email))
 			if p.curIs(token.IDENT) {
-				field.Func = p.cur.Literal
-				p.nextToken()
-				if p.curIs(token.LPAREN) {
-					p.nextToken() // skip '('
-					if p.curIs(token.IDENT) {
-						field.From = p.cur.Literal
-						p.nextToken()
-					}
-					if p.curIs(token.RPAREN) {
-						p.nextToken() // skip ')'
-					}
-				}
+				p.parseTransformCall(field)
+				field.Func = field.Chain[len(field.Chain)-1]
 			}
 		}
 
-		t.Fields = append(t.Fields, field)
-
 		if p.curIs(token.COMMA) {
 			p.nextToken()
 		}
+		if p.stuck(start, errCount, "transform") {
+			continue
+		}
+		t.Fields = append(t.Fields, field)
 	}
 
 	if p.curIs(token.RPAREN) {
@@ -704,6 +1445,37 @@ func (p *Parser) parseTransform() *ast.TransformStep {
 	return t
 }
 
+// parseTransformCall parses fn(arg, literals...) where arg is a source name
+// or another call, appending to field.Chain after the inner calls so the
+// chain lists functions in the order they apply.
+func (p *Parser) parseTransformCall(field *ast.TransformField) {
+	fn := p.cur.Literal
+	p.nextToken()
+	if p.curIs(token.LPAREN) {
+		p.nextToken() // skip '('
+		innermost := true
+		if p.curIs(token.IDENT) {
+			if p.peekIs(token.LPAREN) {
+				p.parseTransformCall(field)
+				innermost = false
+			} else {
+				field.From = p.parseDottedName()
+			}
+		}
+		for p.curIs(token.COMMA) {
+			p.nextToken() // skip ','
+			if !innermost {
+				p.addError(fmt.Sprintf("extra arguments to %s: only the innermost call may take literal arguments", fn))
+			}
+			field.Args = append(field.Args, p.parseExprValue())
+		}
+		if p.curIs(token.RPAREN) {
+			p.nextToken() // skip ')'
+		}
+	}
+	field.Chain = append(field.Chain, fn)
+}
+
 // parseGuard parses guard <expr> or guard !<expr>
 func (p *Parser) parseGuard() *ast.GuardStep {
 	p.nextToken() // skip 'guard'
@@ -716,11 +1488,12 @@ func (p *Parser) parseGuard() *ast.GuardStep {
 	}
 
 	if p.curIs(token.IDENT) {
+		g.Pos = p.cur.Pos
 		parts := []string{p.cur.Literal}
 		p.nextToken()
 		for p.curIs(token.DOT) {
 			p.nextToken() // skip '.'
-			if p.curIs(token.IDENT) {
+			if p.curIsMember() {
 				parts = append(parts, p.cur.Literal)
 				p.nextToken()
 			}
@@ -731,13 +1504,193 @@ func (p *Parser) parseGuard() *ast.GuardStep {
 	return g
 }
 
+// parseMap parses map(<source>, { body }).
+func (p *Parser) parseMap() *ast.MapStep {
+	p.nextToken() // skip 'map'
+
+	m := &ast.MapStep{}
+
+	if !p.curIs(token.LPAREN) {
+		p.addError("expected '(' after 'map'")
+		return m
+	}
+	p.nextToken() // skip '('
+
+	m.Pos = p.cur.Pos
+	if !p.curIs(token.IDENT) {
+		p.addError(fmt.Sprintf("expected list to map over, got %s (%q)", p.cur.Type, p.cur.Literal))
+		return m
+	}
+	m.Source = p.parseDottedName()
+
+	if !p.curIs(token.COMMA) {
+		p.addError("expected ',' after map source")
+		return m
+	}
+	p.nextToken() // skip ','
+
+	if !p.curIs(token.LBRACE) {
+		p.addError(fmt.Sprintf("expected '{' element body in map, got %s (%q)", p.cur.Type, p.cur.Literal))
+		return m
+	}
+	m.Body = p.parseBodyFields()
+
+	if !p.curIs(token.RPAREN) {
+		p.addError("expected ')' to close map")
+		return m
+	}
+	p.nextToken() // skip ')'
+
+	return m
+}
+
+// parseEnrich parses enrich(<source>, <source>, ...).
+func (p *Parser) parseEnrich() *ast.EnrichStep {
+	pos := p.cur.Pos
+	p.nextToken() // skip 'enrich'
+
+	e := &ast.EnrichStep{Pos: pos}
+
+	if !p.curIs(token.LPAREN) {
+		p.addError("expected '(' after 'enrich'")
+		return e
+	}
+	p.nextToken() // skip '('
+
+	for !p.curIs(token.RPAREN) && !p.curIs(token.EOF) {
+		if !p.curIs(token.IDENT) {
+			p.addError(fmt.Sprintf("expected name to enrich, got %s (%q)", p.cur.Type, p.cur.Literal))
+			return e
+		}
+		e.SourcePos = append(e.SourcePos, p.cur.Pos)
+		e.Sources = append(e.Sources, p.parseDottedName())
+
+		if p.curIs(token.COMMA) {
+			p.nextToken()
+		} else if !p.curIs(token.RPAREN) {
+			p.addError(fmt.Sprintf("expected ',' or ')' in enrich, got %s (%q)", p.cur.Type, p.cur.Literal))
+			return e
+		}
+	}
+
+	if !p.curIs(token.RPAREN) {
+		p.addError("expected ')' to close enrich")
+		return e
+	}
+	p.nextToken() // skip ')'
+
+	if len(e.Sources) < 2 {
+		p.addErrorAt(pos, "enrich needs at least two sources")
+	}
+
+	return e
+}
+
+// parseForward parses forward("<url>"), where the URL may contain {name}
+// placeholders.
+func (p *Parser) parseForward() *ast.ForwardStep {
+	p.nextToken() // skip 'forward'
+
+	f := &ast.ForwardStep{}
+
+	if !p.curIs(token.LPAREN) {
+		p.addError("expected '(' after 'forward'")
+		return f
+	}
+	p.nextToken() // skip '('
+
+	if !p.curIs(token.STRING) {
+		p.addError(fmt.Sprintf("expected URL string in forward, got %s (%q)", p.cur.Type, p.cur.Literal))
+		return f
+	}
+	f.Pos = p.cur.Pos
+	f.URL = p.cur.Literal
+	p.parseURLPlaceholders(f)
+	p.nextToken()
+
+	if !p.curIs(token.RPAREN) {
+		p.addError("expected ')' to close forward")
+		return f
+	}
+	p.nextToken() // skip ')'
+
+	return f
+}
+
+// parseURLPlaceholders records the {name} placeholders of the forward URL.
+func (p *Parser) parseURLPlaceholders(f *ast.ForwardStep) {
+	for i := 0; i < len(f.URL); {
+		open := strings.IndexByte(f.URL[i:], '{')
+		if open == -1 {
+			return
+		}
+		open += i
+		end := strings.IndexByte(f.URL[open:], '}')
+		if end == -1 {
+			p.addErrorAt(stringPos(f.Pos, f.URL[:open]), "unclosed '{' in forward URL")
+			return
+		}
+		end += open
+		name := f.URL[open+1 : end]
+		pos := stringPos(f.Pos, f.URL[:open+1])
+		if isDottedName(name) {
+			f.Params = append(f.Params, name)
+			f.ParamPos = append(f.ParamPos, pos)
+		} else {
+			p.addErrorAt(pos, fmt.Sprintf("invalid placeholder {%s} in forward URL: expected a name", name))
+		}
+		i = end + 1
+	}
+}
+
+// stringPlaceholders returns the {name} placeholders of the STRING token
+// tok and their positions.
+func stringPlaceholders(tok token.Token) (refs []string, pos []token.Position) {
+	for _, ph := range lexer.Placeholders(tok.Literal) {
+		refs = append(refs, ph.Name)
+		pos = append(pos, stringPos(tok.Pos, tok.Literal[:ph.Offset]))
+	}
+	return refs, pos
+}
+
+// stringPos returns the position within the string literal at pos that
+// follows prefix, which must not span lines or contain escapes.
+func stringPos(pos token.Position, prefix string) token.Position {
+	pos.Column += 1 + utf8.RuneCountInString(prefix) // 1 for the opening quote
+	if pos.UTF16Column > 0 {
+		pos.UTF16Column += 1 + len(utf16.Encode([]rune(prefix)))
+	}
+	return pos
+}
+
+// isDottedName reports whether s is a name such as id or user.id.
+func isDottedName(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if part == "" {
+			return false
+		}
+		for i, c := range part {
+			if !(c == '_' || unicode.IsLetter(c) || (i > 0 && unicode.IsDigit(c))) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // parseMatch parses match <expr> { arms... }
 func (p *Parser) parseMatch() *ast.MatchStep {
-	p.nextToken() // skip 'match'
+	// Regex patterns must be lexed in regex mode, and the lexer reads one
+	// token ahead: enable it now so everything after the subject's first
+	// token is lexed with `/` as a regex delimiter.
+	p.l.SetRegexMode(true)
+	defer p.l.SetRegexMode(false)
 
-	m := &ast.MatchStep{}
+	m := &ast.MatchStep{Pos: p.cur.Pos}
+	p.nextToken() // skip 'match'
 
 	if p.curIs(token.IDENT) {
+		m.OnPos = p.cur.Pos
 		m.On = p.parseDottedName()
 	}
 
@@ -749,6 +1702,11 @@ func (p *Parser) parseMatch() *ast.MatchStep {
 	p.skipNewlines()
 
 	for !p.curIs(token.RBRACE) && !p.curIs(token.EOF) {
+		if p.curIs(token.PIPE) || token.IsHTTPMethod(p.cur.Type) {
+			// Error recovery stopped at the next step or route.
+			p.addError("expected '}' to close match")
+			return m
+		}
 		arm := p.parseMatchArm()
 		if arm != nil {
 			m.Arms = append(m.Arms, arm)
@@ -756,6 +1714,7 @@ func (p *Parser) parseMatch() *ast.MatchStep {
 		p.skipNewlines()
 	}
 
+	p.l.SetRegexMode(false)
 	if p.curIs(token.RBRACE) {
 		p.nextToken() // skip '}'
 	}
@@ -764,7 +1723,7 @@ func (p *Parser) parseMatch() *ast.MatchStep {
 }
 
 func (p *Parser) parseMatchArm() *ast.MatchArm {
-	arm := &ast.MatchArm{}
+	arm := &ast.MatchArm{Pos: p.cur.Pos}
 
 	// Parse pattern
 	if p.curIs(token.UNDERSCORE) {
@@ -775,6 +1734,23 @@ func (p *Parser) parseMatchArm() *ast.MatchArm {
 		arm.Pattern = p.parsePattern()
 	}
 
+	// Optional arm guard: when <expr> / when !<expr>
+	if p.curIs(token.WHEN) {
+		if arm.IsDefault {
+			p.addError("wildcard arm cannot have a 'when' clause")
+		}
+		p.nextToken() // skip 'when'
+		if p.curIs(token.BANG) {
+			arm.WhenNegated = true
+			p.nextToken() // skip '!'
+		}
+		arm.WhenPos = p.cur.Pos
+		if !p.curIs(token.IDENT) {
+			p.addError(fmt.Sprintf("expected expression after 'when', got %s (%q)", p.cur.Type, p.cur.Literal))
+		}
+		arm.When = p.parseDottedName()
+	}
+
 	if !p.curIs(token.COLON) {
 		p.addError("expected ':' after match pattern")
 		p.skipToNextStatement()
@@ -782,13 +1758,22 @@ func (p *Parser) parseMatchArm() *ast.MatchArm {
 	}
 	p.nextToken() // skip ':'
 
-	// After colon: could be a step, a variable reference, ~> error, or empty (just whitespace then ~>)
+	// After colon: could be a step, a respond, a variable reference, ~> error, or empty (just whitespace then ~>)
 	if p.curIs(token.ERROR) {
 		arm.ErrorOnly = true
 		arm.ErrorFlow = p.parseErrorFlow()
 		return arm
 	}
 
+	if p.curIs(token.RESPOND) {
+		arm.Respond = p.parseRespond()
+		if p.curIs(token.ERROR) {
+			p.addError("a match arm that responds cannot have an error flow")
+			arm.ErrorFlow = p.parseErrorFlow()
+		}
+		return arm
+	}
+
 	if p.curIs(token.IDENT) {
 		name := p.cur.Literal
 		// Check if it's a package call (has parentheses after)
@@ -810,16 +1795,12 @@ func (p *Parser) parseMatchArm() *ast.MatchArm {
 }
 
 func (p *Parser) parsePattern() ast.Pattern {
-	// Enable regex mode for pattern parsing
-	p.l.SetRegexMode(true)
-	defer p.l.SetRegexMode(false)
-
 	pat := ast.Pattern{}
 
 	switch {
 	case p.curIs(token.REGEX):
 		pat.Kind = ast.PatternRegex
-		pat.Regex = p.cur.Literal
+		pat.Regex, pat.RegexFlags = lexer.SplitRegex(p.cur.Literal)
 		p.nextToken()
 		return pat
 
@@ -880,84 +1861,17 @@ func (p *Parser) parsePattern() ast.Pattern {
 
 // parsePkgCall parses: fetch(User, id) or redis-cache(key: "user:{id}")
 func (p *Parser) parsePkgCall() *ast.PkgCallStep {
+	pos := p.cur.Pos
 	pkg := p.cur.Literal
 	p.nextToken() // skip package name
 
-	call := &ast.PkgCallStep{Pkg: pkg}
+	call := &ast.PkgCallStep{Pos: pos, Pkg: pkg}
 
 	if !p.curIs(token.LPAREN) {
 		return call
 	}
 	p.nextToken() // skip '('
-
-	for !p.curIs(token.RPAREN) && !p.curIs(token.EOF) {
-		arg := &ast.PkgArg{}
-
-		// Check for named arg: key: "value"
-		if p.curIs(token.IDENT) && p.peekIs(token.COLON) {
-			// But first check if the identifier is uppercase (type name convention)
-			// Named args: the key is lowercase
-			if !isUpperCase(p.cur.Literal) {
-				arg.Name = p.cur.Literal
-				p.nextToken() // skip name
-				p.nextToken() // skip ':'
-				arg.Value = p.cur.Literal
-				p.nextToken()
-				call.Args = append(call.Args, arg)
-				if p.curIs(token.COMMA) {
-					p.nextToken()
-				}
-				continue
-			}
-		}
-
-		// Check for object literal: { name, email }
-		if p.curIs(token.LBRACE) {
-			p.nextToken() // skip '{'
-			var fields []string
-			for !p.curIs(token.RBRACE) && !p.curIs(token.EOF) {
-				if p.curIs(token.IDENT) {
-					fields = append(fields, p.cur.Literal)
-					p.nextToken()
-				}
-				if p.curIs(token.COMMA) {
-					p.nextToken()
-				}
-			}
-			if p.curIs(token.RBRACE) {
-				p.nextToken()
-			}
-			arg.ObjectArgs = fields
-			call.Args = append(call.Args, arg)
-			if p.curIs(token.COMMA) {
-				p.nextToken()
-			}
-			continue
-		}
-
-		// Positional arg
-		if p.curIs(token.IDENT) {
-			arg.Value = p.cur.Literal
-			if isUpperCase(p.cur.Literal) {
-				arg.IsType = true
-			}
-			p.nextToken()
-		} else if p.curIs(token.INT) {
-			arg.Value = p.cur.Literal
-			p.nextToken()
-		} else if p.curIs(token.STRING) {
-			arg.Value = p.cur.Literal
-			p.nextToken()
-		} else {
-			p.nextToken()
-		}
-
-		call.Args = append(call.Args, arg)
-
-		if p.curIs(token.COMMA) {
-			p.nextToken()
-		}
-	}
+	call.Args = p.parseArgList(pkg + " arguments")
 
 	if p.curIs(token.RPAREN) {
 		p.nextToken() // skip ')'
@@ -968,48 +1882,182 @@ func (p *Parser) parsePkgCall() *ast.PkgCallStep {
 
 // parseRespond parses respond <status> [{ body }] [with headers { ... }]
 func (p *Parser) parseRespond() *ast.RespondStep {
+	r := &ast.RespondStep{Pos: p.cur.Pos}
 	p.nextToken() // skip 'respond'
 
-	r := &ast.RespondStep{}
-
-	if p.curIs(token.INT) {
+	switch {
+	case p.curIs(token.INT):
+		r.StatusPos = p.cur.Pos
 		r.Status = p.cur.Literal
 		p.nextToken()
+	case p.curIs(token.IDENT) && ast.ContentTypes[p.cur.Literal] == "" && !p.curIsExample():
+		// A name that is not a content type is a bound status, as when
+		// passing an upstream response through.
+		r.StatusPos = p.cur.Pos
+		r.StatusRef = p.parseDottedName()
 	}
 
-	// Optional body: { key: value, ... }
-	if p.curIs(token.LBRACE) {
+	// Optional body type: respond 200: User { ... }
+	if p.curIs(token.COLON) {
+		p.nextToken() // skip ':'
+		if !p.curIs(token.IDENT) {
+			p.addError(fmt.Sprintf("expected type name after ':' in respond, got %s", p.cur.Type))
+		} else {
+			r.SchemaPos = p.cur.Pos
+			r.Schema = p.cur.Literal
+			p.nextToken()
+		}
+	}
+
+	// Optional content type: json, html, text
+	if p.curIs(token.IDENT) && !p.curIsExample() {
+		if _, ok := ast.ContentTypes[p.cur.Literal]; !ok {
+			p.addError(fmt.Sprintf("unknown content type %q (expected json, html or text)", p.cur.Literal))
+		}
+		r.ContentType = p.cur.Literal
+		p.nextToken()
+	}
+
+	// Optional example payload for docs: example { id: 1, name: "Ada" }
+	if p.curIsExample() {
+		r.ExamplePos = p.cur.Pos
+		p.nextToken() // skip 'example'
+		r.Example = p.parseBodyFields()
+		p.checkExampleFields(r.Example)
+	}
+
+	// Optional body: { key: value, ... } or a string literal
+	switch {
+	case p.curIs(token.LBRACE):
 		r.Body = p.parseBodyFields()
+	case p.curIs(token.STRING):
+		r.Text, r.HasText = p.cur.Literal, true
+		p.nextToken()
 	}
 
-	// Optional: with headers { ... }
-	if p.curIs(token.WITH) {
+	// Optional: with headers { ... }, with cookies { ... }
+	for p.curIs(token.WITH) {
 		p.nextToken() // skip 'with'
-		if p.curIs(token.HEADERS) {
+		switch {
+		case p.curIs(token.HEADERS):
 			p.nextToken() // skip 'headers'
 			if p.curIs(token.LBRACE) {
-				r.Headers = p.parseBodyFields()
+				r.Headers = p.parseFlatFields("headers")
+			}
+		case p.curIs(token.COOKIES):
+			p.nextToken() // skip 'cookies'
+			if p.curIs(token.LBRACE) {
+				r.Cookies = p.parseFlatFields("cookies")
 			}
+		default:
+			p.addError(fmt.Sprintf("expected 'headers' or 'cookies' after 'with', got %s", p.cur.Type))
+			return r
 		}
 	}
 
 	return r
 }
 
+// curIsExample reports whether the current token starts the example of a
+// respond step. example is only a keyword there, before a '{'.
+func (p *Parser) curIsExample() bool {
+	return p.curIs(token.IDENT) && p.cur.Literal == "example" && p.peekIs(token.LBRACE)
+}
+
+// checkExampleFields reports the values of an example payload that are not
+// literals: an example shows a response, so it cannot refer to bindings.
+func (p *Parser) checkExampleFields(fields []*ast.BodyField) {
+	for _, f := range fields {
+		if f.Spread {
+			p.addErrorAt(f.Pos, "spread is not allowed in example")
+			continue
+		}
+		switch v := f.Value.(type) {
+		case []*ast.BodyField:
+			p.checkExampleFields(v)
+		case ast.BodyList:
+			p.checkExampleFields(v)
+		case string:
+			if !f.IsString && !isExampleLiteral(v) {
+				p.addErrorAt(f.Pos, fmt.Sprintf("example values must be literals, got %q", v))
+			}
+		default:
+			p.addErrorAt(f.KeyPos, fmt.Sprintf("example field %q needs a literal value", f.Key))
+		}
+	}
+}
+
+// isExampleLiteral reports whether an unquoted example value is a literal:
+// a number, true, false or null.
+func isExampleLiteral(v string) bool {
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return true
+	}
+	return v == "true" || v == "false" || v == "null"
+}
+
+// parseFlatFields parses a { key: value } block whose values must be plain
+// strings or references; what names the block in errors.
+func (p *Parser) parseFlatFields(what string) []*ast.BodyField {
+	fields := p.parseBodyFields()
+	for _, f := range fields {
+		if f.Spread {
+			p.addErrorAt(f.Pos, "spread is not allowed in "+what)
+		}
+		switch f.Value.(type) {
+		case []*ast.BodyField:
+			p.addErrorAt(f.Pos, "nested objects are not allowed in "+what)
+		case ast.BodyList:
+			p.addErrorAt(f.Pos, "lists are not allowed in "+what)
+		}
+	}
+	return fields
+}
+
 func (p *Parser) parseBodyFields() []*ast.BodyField {
 	p.nextToken() // skip '{'
 	var fields []*ast.BodyField
 
 	for !p.curIs(token.RBRACE) && !p.curIs(token.EOF) {
-		field := &ast.BodyField{}
+		field := &ast.BodyField{Pos: p.cur.Pos}
 
-		if p.curIs(token.IDENT) {
+		switch {
+		case p.curIs(token.SPREAD):
+			// ...user: all fields of user; later keys win, so the spread
+			// must precede the fields that override it.
+			p.nextToken() // skip '...'
+			field.Pos = p.cur.Pos
+			field.Spread = true
+			source := p.parseDottedName()
+			field.Value = source
+			switch {
+			case source == "":
+				p.addErrorAt(field.Pos, "expected name after '...'")
+			case len(fields) > 0 && fields[0].Spread:
+				p.addErrorAt(field.Pos, "only one spread is allowed per body")
+			case len(fields) > 0:
+				p.addErrorAt(field.Pos, "spread must come before other fields")
+			}
+		case p.curIs(token.IDENT) || token.IsKeyword(p.cur.Type) && p.peekIs(token.COLON):
+			// Keywords such as "group" are plain keys before a ':'.
+			field.KeyPos = p.cur.Pos
 			field.Key = p.cur.Literal
 			p.nextToken()
+		case !p.curIs(token.COLON):
+			// Includes a stray ',' (leading or doubled); a trailing comma
+			// is consumed after the previous field.
+			p.addError(fmt.Sprintf("unexpected %s in body", p.cur.Type))
+			p.nextToken()
+			continue
 		}
 
-		if p.curIs(token.COLON) {
+		if !field.Spread && p.curIs(token.COLON) {
 			p.nextToken() // skip ':'
+			field.Pos = p.cur.Pos
+			field.IsString = p.curIs(token.STRING)
+			if field.IsString {
+				field.Refs, field.RefPos = stringPlaceholders(p.cur)
+			}
 			field.Value = p.parseFieldValue()
 		}
 
@@ -1027,8 +2075,16 @@ func (p *Parser) parseBodyFields() []*ast.BodyField {
 	return fields
 }
 
-func (p *Parser) parseFieldValue() string {
-	if p.curIs(token.STRING) {
+// parseFieldValue parses a body value: a string or integer literal, a dotted name, a
+// nested { ... } object returned as []*ast.BodyField, or a [ ... ] list.
+func (p *Parser) parseFieldValue() interface{} {
+	if p.curIs(token.LBRACE) {
+		return p.parseBodyFields()
+	}
+	if p.curIs(token.LBRACKET) {
+		return p.parseBodyList()
+	}
+	if p.curIs(token.STRING) || p.curIs(token.INT) || p.curIs(token.FLOAT) {
 		val := p.cur.Literal
 		p.nextToken()
 		return val
@@ -1038,17 +2094,53 @@ func (p *Parser) parseFieldValue() string {
 	return p.parseDottedName()
 }
 
+// parseBodyList parses [value, ...] where each value is anything
+// parseFieldValue accepts.
+func (p *Parser) parseBodyList() ast.BodyList {
+	p.nextToken() // skip '['
+	list := ast.BodyList{}
+
+	for !p.curIs(token.RBRACKET) && !p.curIs(token.EOF) {
+		switch p.cur.Type {
+		case token.STRING, token.INT, token.FLOAT, token.IDENT, token.LBRACE, token.LBRACKET:
+			elem := &ast.BodyField{Pos: p.cur.Pos, IsString: p.curIs(token.STRING)}
+			if elem.IsString {
+				elem.Refs, elem.RefPos = stringPlaceholders(p.cur)
+			}
+			elem.Value = p.parseFieldValue()
+			list = append(list, elem)
+		default:
+			p.addError(fmt.Sprintf("unexpected %s in list", p.cur.Type))
+			p.nextToken()
+			continue
+		}
+
+		if p.curIs(token.COMMA) {
+			p.nextToken()
+		} else if !p.curIs(token.RBRACKET) {
+			p.addError("expected ',' or ']' in list")
+		}
+	}
+
+	if p.curIs(token.RBRACKET) {
+		p.nextToken() // skip ']'
+	}
+
+	return list
+}
+
 func (p *Parser) parseDottedName() string {
 	var parts []string
 
-	if p.curIs(token.IDENT) {
+	// input(group: ...) may bind a keyword, so one can start a name too.
+	if p.curIsMember() {
 		parts = append(parts, p.cur.Literal)
 		p.nextToken()
 	}
 
 	for p.curIs(token.DOT) {
 		p.nextToken() // skip '.'
-		if p.curIs(token.IDENT) {
+		if p.curIsMember() {
 			parts = append(parts, p.cur.Literal)
 			p.nextToken()
 		}
@@ -1057,6 +2149,12 @@ func (p *Parser) parseDottedName() string {
 	return strings.Join(parts, ".")
 }
 
+// curIsMember reports whether the current token can follow a '.' in a dotted
+// name. Keywords such as "group" are plain field names there.
+func (p *Parser) curIsMember() bool {
+	return p.curIs(token.IDENT) || token.IsKeyword(p.cur.Type)
+}
+
 // parseErrorFlow parses ~> <status> [{ body }]
 func (p *Parser) parseErrorFlow() *ast.ErrorFlow {
 	pos := p.cur.Pos
@@ -1065,6 +2163,7 @@ func (p *Parser) parseErrorFlow() *ast.ErrorFlow {
 	ef := &ast.ErrorFlow{Pos: pos}
 
 	if p.curIs(token.INT) {
+		ef.StatusPos = p.cur.Pos
 		ef.Status = p.cur.Literal
 		p.nextToken()
 	}
@@ -1084,10 +2183,29 @@ func isUpperCase(s string) bool {
 }
 
 // validateRoute checks that path.* references in input steps refer to parameters
-// actually defined in the route path (e.g., {id}, {slug}).
+// actually defined in the route path (e.g., {id}, {slug}), and that respond is
+// the last step.
 func (p *Parser) validateRoute(route *ast.Route) {
-	pathParams := extractPathParams(route.Path)
-	for _, step := range route.Steps {
+	pathParams := make(map[string]bool)
+	for _, name := range ast.PathParams(route.Path) {
+		pathParams[name] = true
+	}
+	if strings.Contains(route.Path, "*") && (!ast.IsCatchAll(route.Path) || strings.Count(route.Path, "*") > 1) {
+		p.addErrorAt(route.Pos, fmt.Sprintf("catch-all segment must be the last segment of route path %q", route.Path))
+	}
+	terminated := false
+	for i, step := range route.Steps {
+		ends := step.Kind == ast.StepRespond || step.Kind == ast.StepMatch && step.Match.Responds()
+		if ends && i < len(route.Steps)-1 && !terminated {
+			// Everything after the first respond is dead, including further
+			// responds. So is everything after a match whose arms all respond.
+			what := "respond"
+			if step.Kind == ast.StepMatch {
+				what = "match"
+			}
+			p.addErrorAt(route.Steps[i+1].Pos, fmt.Sprintf("unreachable steps after %s at line %d", what, step.Pos.Line))
+			terminated = true
+		}
 		if step.Kind == ast.StepInput && step.Input != nil {
 			for _, field := range step.Input.Fields {
 				if strings.HasPrefix(field.From, "path.") {
@@ -1102,24 +2220,3 @@ func (p *Parser) validateRoute(route *ast.Route) {
 		}
 	}
 }
-
-// extractPathParams extracts parameter names from a route path like "/users/{id}/posts/{slug}".
-func extractPathParams(path string) map[string]bool {
-	params := make(map[string]bool)
-	for {
-		start := strings.Index(path, "{")
-		if start == -1 {
-			break
-		}
-		end := strings.Index(path[start:], "}")
-		if end == -1 {
-			break
-		}
-		name := path[start+1 : start+end]
-		if name != "" {
-			params[name] = true
-		}
-		path = path[start+end+1:]
-	}
-	return params
-}
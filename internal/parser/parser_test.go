@@ -1,11 +1,17 @@
 package parser
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/polidog/reverhttp/internal/ast"
 	"github.com/polidog/reverhttp/internal/lexer"
+	"github.com/polidog/reverhttp/internal/token"
 )
 
 func parse(input string) *ast.File {
@@ -19,7 +25,80 @@ func parseWithErrors(t *testing.T, input string) (*ast.File, []string) {
 	l := lexer.New(input, "test.rever")
 	p := New(l)
 	f := p.ParseFile()
-	return f, p.Errors()
+	return f, p.ErrorStrings()
+}
+
+func TestParseConvenience(t *testing.T) {
+	f, errs := Parse("# List users\nGET /users\n  |> respond 200", "users.rever")
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(f.Routes) != 1 || f.Routes[0].Doc != "List users" {
+		t.Fatalf("expected one route with its doc comment, got %+v", f.Routes)
+	}
+
+	f, errs = Parse("GET /users\n  |> respond 200\n\nPOST /users\n  |> 42", "users.rever")
+	if len(errs) == 0 || !strings.HasPrefix(errs[0].Error(), "users.rever:5:6: ") {
+		t.Fatalf("expected an error in users.rever at 5:6, got %v", errs)
+	}
+	if f == nil || len(f.Routes) != 2 {
+		t.Fatalf("expected the partial AST alongside the errors, got %+v", f)
+	}
+}
+
+func TestParseErrorPositions(t *testing.T) {
+	input := "GET /users\n  |> respond 200 { name: \"😀\" } 42\n  |> match x {\n    /^a => respond 200\n  }"
+	p := New(lexer.New(input, "test.rever"))
+	p.ParseFile()
+	errs := p.Errors()
+	want := []Error{
+		{Pos: token.Position{File: "test.rever", Line: 2, Column: 32, UTF16Column: 33}, Message: `unexpected INT ("42") after step`},
+		{Pos: token.Position{File: "test.rever", Line: 4, Column: 5, UTF16Column: 5}, Message: "unterminated regex literal"},
+	}
+	if len(errs) < len(want) {
+		t.Fatalf("expected at least %d errors, got %v", len(want), errs)
+	}
+	for i, w := range want {
+		if errs[i] != w {
+			t.Errorf("error %d: expected %#v, got %#v", i, w, errs[i])
+		}
+	}
+	if s := p.ErrorStrings()[1]; s != "test.rever:4:5: unterminated regex literal" || s != errs[1].String() {
+		t.Errorf("expected formatted error, got %q", s)
+	}
+}
+
+func TestParseNodePositions(t *testing.T) {
+	input := `GET /accounts
+  |> input(role: header.x-role)
+  |> match role {
+       "admin": respond 200 { role: role, tags: ["a"] }
+       _:       ~> 403 { error: "forbidden" }
+     }
+  |> respond 200 { id }`
+
+	f := parse(input)
+	steps := f.Routes[0].Steps
+	tests := []struct {
+		name      string
+		got       token.Position
+		line, col int
+	}{
+		{"input", steps[0].Input.Pos, 2, 6},
+		{"match", steps[1].Match.Pos, 3, 6},
+		{"first arm", steps[1].Match.Arms[0].Pos, 4, 8},
+		{"default arm", steps[1].Match.Arms[1].Pos, 5, 8},
+		{"arm respond", steps[1].Match.Arms[0].Respond.Pos, 4, 17},
+		{"arm body value", steps[1].Match.Arms[0].Respond.Body[0].Pos, 4, 37},
+		{"arm body key", steps[1].Match.Arms[0].Respond.Body[0].KeyPos, 4, 31},
+		{"list element", steps[1].Match.Arms[0].Respond.Body[1].Value.(ast.BodyList)[0].Pos, 4, 50},
+		{"shorthand field", steps[2].Respond.Body[0].Pos, 7, 20},
+	}
+	for _, tt := range tests {
+		if tt.got.Line != tt.line || tt.got.Column != tt.col {
+			t.Errorf("%s: expected %d:%d, got %d:%d", tt.name, tt.line, tt.col, tt.got.Line, tt.got.Column)
+		}
+	}
 }
 
 func TestParseImport(t *testing.T) {
@@ -116,6 +195,121 @@ func TestParseType(t *testing.T) {
 	}
 }
 
+func TestParseTypeFieldDefaults(t *testing.T) {
+	input := `type User {
+  id: int
+  role: string = "user"
+  active: bool = true
+  age: int = 18
+}`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	fields := f.Types[0].Fields
+	if len(fields) != 4 {
+		t.Fatalf("expected 4 fields, got %d", len(fields))
+	}
+	if fields[0].Default != nil {
+		t.Fatalf("expected no default for id, got %+v", fields[0].Default)
+	}
+	if d := fields[1].Default; d == nil || d.Kind != ast.ExprString || d.StrVal != "user" {
+		t.Fatalf("expected string default, got %+v", d)
+	}
+	if d := fields[2].Default; d == nil || d.Kind != ast.ExprBool || d.StrVal != "true" {
+		t.Fatalf("expected bool default, got %+v", d)
+	}
+	if d := fields[3].Default; d == nil || d.Kind != ast.ExprInt || d.IntVal != "18" || fields[3].TypeName != "int" {
+		t.Fatalf("expected int default, got %+v", d)
+	}
+}
+
+func TestParseTypeFieldDefaultInvalid(t *testing.T) {
+	_, errs := parseWithErrors(t, `type User {
+  role: string = admin
+}`)
+	if len(errs) != 1 || !strings.Contains(errs[0], "expected string, integer or boolean default, got IDENT") {
+		t.Fatalf("expected default error, got %v", errs)
+	}
+}
+
+func TestParseTypeSeparators(t *testing.T) {
+	inputs := []string{
+		"type User { id: int, name: string, }",
+		"type User {\n  id: int,\n  name: string,\n}",
+		"type User {\n  id: int, name: string\n}",
+		"type User {\n  id: int\n  name: string,\n}",
+	}
+	for _, input := range inputs {
+		f, errs := parseWithErrors(t, input)
+		if len(errs) != 0 {
+			t.Errorf("%q: unexpected errors: %v", input, errs)
+			continue
+		}
+		fields := f.Types[0].Fields
+		if len(fields) != 2 || fields[0].Name != "id" || fields[1].Name != "name" || fields[1].TypeName != "string" {
+			t.Errorf("%q: expected fields id and name, got %+v", input, fields)
+		}
+	}
+}
+
+func TestParseTypeStrayComma(t *testing.T) {
+	for _, input := range []string{"type User { id: int,, name: string }", "type User { , id: int }"} {
+		f, errs := parseWithErrors(t, input)
+		if len(errs) != 1 || !strings.Contains(errs[0], "expected field name in type User, got ,") {
+			t.Errorf("%q: expected a stray comma error, got %v", input, errs)
+		}
+		if len(f.Types) != 1 || len(f.Types[0].Fields) == 0 {
+			t.Errorf("%q: expected the other fields to be kept, got %+v", input, f.Types)
+		}
+	}
+}
+
+func TestParseEnum(t *testing.T) {
+	input := `enum Role { user, admin, guest }
+
+enum Plan {
+  free
+  pro
+}`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(f.Enums) != 2 {
+		t.Fatalf("expected 2 enums, got %d", len(f.Enums))
+	}
+	role := f.Enums[0]
+	if role.Name != "Role" || strings.Join(role.Values, ",") != "user,admin,guest" {
+		t.Fatalf("expected Role { user, admin, guest }, got %+v", role)
+	}
+	if role.NamePos.Column != 6 {
+		t.Fatalf("expected name at column 6, got %d", role.NamePos.Column)
+	}
+	if plan := f.Enums[1]; strings.Join(plan.Values, ",") != "free,pro" {
+		t.Fatalf("expected newline-separated values, got %+v", plan.Values)
+	}
+}
+
+func TestParseEnumErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`enum Role { user, admin, user }`, `duplicate enum value "user"`},
+		{`enum Role { }`, "enum Role has no values"},
+		{`enum Role { "user" }`, "expected enum value, got STRING"},
+	}
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, tt.input)
+		if len(errs) == 0 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
 func TestParseSimpleGETRoute(t *testing.T) {
 	input := `GET /users/{id}
   |> input(id: path.id)
@@ -203,6 +397,191 @@ func TestParseValidate(t *testing.T) {
 	}
 }
 
+func TestParseValidateOptional(t *testing.T) {
+	input := `PATCH /users/{id}
+  |> validate(id: int, name?: string & min(1))  ~> 400 { error: "invalid" }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	rules := f.Routes[0].Steps[0].Validate.Rules
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(rules))
+	}
+	if rules[0].Field != "id" || rules[0].Optional {
+		t.Fatalf("expected required rule id, got %+v", rules[0])
+	}
+	if rules[1].Field != "name" || !rules[1].Optional {
+		t.Fatalf("expected optional rule name, got %+v", rules[1])
+	}
+	if len(rules[1].Constraints) != 2 || rules[1].Constraints[1].Name != "min" {
+		t.Fatalf("expected constraints string & min, got %+v", rules[1].Constraints)
+	}
+}
+
+func TestParseValidateType(t *testing.T) {
+	input := `POST /users
+  |> validate(User)  ~> 400 { error: "invalid" }
+  |> respond 201`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	step := f.Routes[0].Steps[0]
+	if step.Validate.Type != "User" || len(step.Validate.Rules) != 0 {
+		t.Fatalf("expected validate(User) without rules, got %+v", step.Validate)
+	}
+	if pos := step.Validate.TypePos; pos.Line != 2 || pos.Column != 15 {
+		t.Errorf("expected the type at 2:15, got %d:%d", pos.Line, pos.Column)
+	}
+	if step.ErrorFlow == nil || step.ErrorFlow.Status != "400" {
+		t.Errorf("expected the error flow after validate(User), got %+v", step.ErrorFlow)
+	}
+
+	_, errs = parseWithErrors(t, "POST /users\n  |> validate(User, id: int)\n  |> respond 201")
+	if len(errs) != 1 || errs[0] != "test.rever:2:15: type User must be the only argument of validate" {
+		t.Errorf("expected a type mixed with rules to be rejected, got %v", errs)
+	}
+}
+
+func TestParseValidatePattern(t *testing.T) {
+	input := `POST /test
+  |> validate(slug: string & pattern(/^[a-z-]+$/i), name: string & min(1))  ~> 400 { error: "invalid" }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	rules := f.Routes[0].Steps[0].Validate.Rules
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(rules))
+	}
+
+	c := rules[0].Constraints[1]
+	if c.Name != "pattern" || len(c.Args) != 1 {
+		t.Fatalf("expected pattern constraint with 1 arg, got %+v", c)
+	}
+	if c.Args[0].Kind != ast.ExprRegex || c.Args[0].StrVal != "^[a-z-]+$" || c.Args[0].Flags != "i" {
+		t.Fatalf("expected regex ^[a-z-]+$ with flag i, got %+v", c.Args[0])
+	}
+	if rules[1].Field != "name" || rules[1].Constraints[1].Name != "min" {
+		t.Fatalf("expected rule after pattern to parse, got %+v", rules[1])
+	}
+}
+
+func TestParseValidatePatternMalformed(t *testing.T) {
+	// A malformed regex is still a regex literal to the parser; gen rejects it.
+	input := `POST /test
+  |> validate(slug: string & pattern(/([a-z/))  ~> 400 { error: "invalid" }
+  |> respond 200 { slug: slug }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	r := f.Routes[0]
+	if len(r.Steps) != 2 {
+		t.Fatalf("expected 2 steps, got %d", len(r.Steps))
+	}
+	arg := r.Steps[0].Validate.Rules[0].Constraints[1].Args[0]
+	if arg.Kind != ast.ExprRegex || arg.StrVal != "([a-z" {
+		t.Fatalf("expected regex ([a-z, got %+v", arg)
+	}
+}
+
+func TestParseDocComments(t *testing.T) {
+	input := `# A registered user
+type User {
+  id: int
+}
+
+# Get a user
+GET /users/{id}
+  |> respond 200
+
+GET /health
+  |> respond 200`
+
+	l := lexer.New(input, "test.rever")
+	l.SetCaptureComments(true)
+	f := New(l).ParseFile()
+
+	if f.Types[0].Doc != "A registered user" {
+		t.Fatalf("expected type doc, got %q", f.Types[0].Doc)
+	}
+	if f.Routes[0].Doc != "Get a user" {
+		t.Fatalf("expected route doc, got %q", f.Routes[0].Doc)
+	}
+	if f.Routes[1].Doc != "" {
+		t.Fatalf("expected no doc on undocumented route, got %q", f.Routes[1].Doc)
+	}
+}
+
+func TestParseAnnotations(t *testing.T) {
+	input := `# Get a user
+@doc "Returns the user"
+@deprecated "use /v2/users"
+GET /users/{id}
+  |> respond 200
+
+group /v1 {
+  @deprecated
+  GET /health
+    |> respond 200
+}`
+
+	l := lexer.New(input, "test.rever")
+	l.SetCaptureComments(true)
+	p := New(l)
+	f := p.ParseFile()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(f.Routes) != 2 {
+		t.Fatalf("expected 2 routes, got %d", len(f.Routes))
+	}
+
+	r := f.Routes[0]
+	if len(r.Annotations) != 2 {
+		t.Fatalf("expected 2 annotations, got %+v", r.Annotations)
+	}
+	if a := r.Annotations[0]; a.Name != "doc" || a.Value != "Returns the user" || a.Pos.Line != 2 || a.Pos.Column != 1 {
+		t.Errorf("unexpected @doc: %+v", a)
+	}
+	if a := r.Annotations[1]; a.Name != "deprecated" || a.Value != "use /v2/users" {
+		t.Errorf("unexpected @deprecated: %+v", a)
+	}
+	if r.Doc != "Get a user" {
+		t.Errorf("expected the comment above the annotations as doc, got %q", r.Doc)
+	}
+
+	r = f.Routes[1]
+	if r.Path != "/v1/health" || len(r.Annotations) != 1 || r.Annotations[0].Name != "deprecated" || r.Annotations[0].Value != "" {
+		t.Errorf("expected a bare @deprecated on the group route, got %s %+v", r.Path, r.Annotations)
+	}
+}
+
+func TestParseAnnotationErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"@since \"1.0\"\nGET /a\n  |> respond 200", "test.rever:1:2: unknown annotation @since: expected @doc or @deprecated"},
+		{"@doc\nGET /a\n  |> respond 200", "test.rever:1:5: expected a string after @doc"},
+		{"@doc \"a\"\n@doc \"b\"\nGET /a\n  |> respond 200", "test.rever:2:1: duplicate @doc annotation (first at line 1)"},
+		{"@doc \"a\"\ntype User { id: int }", "test.rever:2:1: expected a route after annotations, got \"type\""},
+		{"@doc \"a\" 42\nGET /a\n  |> respond 200", "test.rever:1:10: unexpected INT after @doc"},
+	}
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, tt.input)
+		if len(errs) != 1 || errs[0] != tt.want {
+			t.Errorf("%q: expected [%s], got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
 func TestParseTransform(t *testing.T) {
 	input := `GET /test
   |> transform(id: int(id), name: trim(name), email: lower(email))`
@@ -237,6 +616,53 @@ func TestParseTransform(t *testing.T) {
 	}
 }
 
+func TestParseTransformChain(t *testing.T) {
+	input := `GET /test
+  |> transform(email: lower(trim(email)), name: trim(name))`
+
+	fields := parse(input).Routes[0].Steps[0].Transform.Fields
+
+	if got := strings.Join(fields[0].Chain, ","); got != "trim,lower" {
+		t.Fatalf("expected chain trim,lower, got %q", got)
+	}
+	if fields[0].Func != "lower" || fields[0].From != "email" {
+		t.Fatalf("expected outer func lower from email, got %+v", fields[0])
+	}
+	if len(fields[1].Chain) != 1 || fields[1].Func != "trim" || fields[1].From != "name" {
+		t.Fatalf("expected single trim(name), got %+v", fields[1])
+	}
+}
+
+func TestParseTransformSourceAndArgs(t *testing.T) {
+	input := `GET /test
+  |> transform(name: trim(body.name), role: default(role, "user"), page: clamp(page, 1, 100))`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	fields := f.Routes[0].Steps[0].Transform.Fields
+
+	if fields[0].Func != "trim" || fields[0].From != "body.name" || len(fields[0].Args) != 0 {
+		t.Fatalf("expected trim(body.name), got %+v", fields[0])
+	}
+	role := fields[1]
+	if role.Func != "default" || role.From != "role" || len(role.Args) != 1 || role.Args[0].Kind != ast.ExprString || role.Args[0].StrVal != "user" {
+		t.Fatalf("expected default(role, \"user\"), got %+v", role)
+	}
+	if page := fields[2]; len(page.Args) != 2 || page.Args[0].IntVal != "1" || page.Args[1].IntVal != "100" {
+		t.Fatalf("expected clamp(page, 1, 100), got %+v", page)
+	}
+}
+
+func TestParseTransformArgsOnOuterCall(t *testing.T) {
+	_, errs := parseWithErrors(t, `GET /test
+  |> transform(role: default(trim(role), "user"))`)
+	if len(errs) != 1 || !strings.Contains(errs[0], "extra arguments to default") {
+		t.Fatalf("expected extra arguments error, got %v", errs)
+	}
+}
+
 func TestParseGuard(t *testing.T) {
 	input := `GET /test
   |> guard !existing  ~> 409 { error: "already exists" }`
@@ -258,6 +684,80 @@ func TestParseGuard(t *testing.T) {
 	}
 }
 
+func TestParseGuardElse(t *testing.T) {
+	input := `GET /test
+  |> guard user.active  ~> 403 { error: "forbidden" } else respond 200 { status: "inactive" }
+  |> guard existing
+       else create(User, { name })
+  |> guard ok
+  |> respond 200 { ok: "true" }`
+
+	f := parse(input)
+	steps := f.Routes[0].Steps
+	if len(steps) != 4 {
+		t.Fatalf("expected 4 steps, got %d", len(steps))
+	}
+
+	first := steps[0]
+	if first.ErrorFlow == nil || first.ErrorFlow.Status != "403" {
+		t.Fatal("expected error flow with status 403")
+	}
+	alt := first.Guard.Else
+	if alt == nil || alt.Kind != ast.StepRespond {
+		t.Fatalf("expected respond else, got %+v", alt)
+	}
+	if alt.Respond.Status != "200" || alt.Respond.Body[0].Value != "inactive" {
+		t.Fatalf("unexpected else respond %+v", alt.Respond)
+	}
+
+	second := steps[1].Guard.Else
+	if second == nil || second.Kind != ast.StepPkgCall || second.PkgCall.Pkg != "create" {
+		t.Fatalf("expected package call else on the next line, got %+v", second)
+	}
+
+	if steps[2].Guard.Else != nil {
+		t.Fatalf("expected no else, got %+v", steps[2].Guard.Else)
+	}
+}
+
+func TestParseLineContinuation(t *testing.T) {
+	input := `POST /users
+  |> input(name: body.name, email: body.email)
+  |> validate(name: string & minLength(1), email: string & format(email)) \
+       ~> 400 { error: "invalid input" }
+  |> create(User, { name, email }) \
+       as user \
+       ~> 500 { error: "failed" }
+  |> respond 201 \
+       { id: user.id }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	steps := f.Routes[0].Steps
+	if len(steps) != 4 {
+		t.Fatalf("expected 4 steps, got %d", len(steps))
+	}
+	if steps[1].ErrorFlow == nil || steps[1].ErrorFlow.Status != "400" || steps[1].ErrorFlow.Pos.Line != 4 {
+		t.Errorf("expected the 400 error flow from line 4, got %+v", steps[1].ErrorFlow)
+	}
+	if steps[2].Bind != "user" || steps[2].ErrorFlow == nil || steps[2].ErrorFlow.Status != "500" {
+		t.Errorf("expected the call bound as user with a 500 error flow, got %+v", steps[2])
+	}
+	if r := steps[3].Respond; r.Status != "201" || len(r.Body) != 1 || r.Body[0].Pos.Line != 9 {
+		t.Errorf("expected the body from line 9, got %+v", r)
+	}
+}
+
+func TestParseGuardElseInvalid(t *testing.T) {
+	_, errs := parseWithErrors(t, `GET /test
+  |> guard ok else input(id: path.id)`)
+	if len(errs) == 0 || !strings.Contains(errs[0], "expected respond or package call after 'else'") {
+		t.Fatalf("expected else error, got %v", errs)
+	}
+}
+
 func TestParsePkgCallWithBind(t *testing.T) {
 	input := `GET /test
   |> fetch(User, id) as user  ~> 404 { error: "not found" }`
@@ -282,11 +782,11 @@ func TestParsePkgCallWithBind(t *testing.T) {
 	if len(args) != 2 {
 		t.Fatalf("expected 2 args, got %d", len(args))
 	}
-	if !args[0].IsType || args[0].Value != "User" {
+	if args[0].Value.Kind != ast.ExprType || args[0].Value.StrVal != "User" {
 		t.Fatalf("expected first arg to be type 'User', got %+v", args[0])
 	}
-	if args[1].Value != "id" {
-		t.Fatalf("expected second arg 'id', got %q", args[1].Value)
+	if args[1].Value.Kind != ast.ExprIdent || args[1].Value.StrVal != "id" {
+		t.Fatalf("expected second arg 'id', got %+v", args[1].Value)
 	}
 }
 
@@ -331,67 +831,1371 @@ func TestParseMatch(t *testing.T) {
 	}
 }
 
-func TestParseRespondWithHeaders(t *testing.T) {
-	input := `GET /test
-  |> respond 301 with headers { location: "/new" }`
-
-	f := parse(input)
-	step := f.Routes[0].Steps[0]
+func TestParseMatchNegativeNumbers(t *testing.T) {
+	input := `GET /accounts/{id}
+  |> match balance {
+       -1: fetch(Overdrawn, id)
+       -100..0: fetch(Low, id)
+       -5..-1: fetch(Warn, id)
+       _: fetch(Account, id)
+     } as account
+  |> respond 200 { id: account.id }`
 
-	if step.Kind != ast.StepRespond {
-		t.Fatalf("expected StepRespond, got %d", step.Kind)
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
 	}
-	if step.Respond.Status != "301" {
-		t.Fatalf("expected status 301, got %q", step.Respond.Status)
+	arms := f.Routes[0].Steps[0].Match.Arms
+	if len(arms) != 4 {
+		t.Fatalf("expected 4 arms, got %d", len(arms))
 	}
-	if len(step.Respond.Headers) != 1 {
-		t.Fatalf("expected 1 header, got %d", len(step.Respond.Headers))
+	if p := arms[0].Pattern; p.Kind != ast.PatternLiteral || p.Value != "-1" {
+		t.Fatalf("expected literal -1, got %+v", p)
 	}
-	if step.Respond.Headers[0].Key != "location" {
-		t.Fatalf("expected header key 'location', got %q", step.Respond.Headers[0].Key)
+	if p := arms[1].Pattern; p.Kind != ast.PatternRange || p.RangeMin != "-100" || p.RangeMax != "0" {
+		t.Fatalf("expected range -100..0, got %+v", p)
+	}
+	if p := arms[2].Pattern; p.Kind != ast.PatternRange || p.RangeMin != "-5" || p.RangeMax != "-1" {
+		t.Fatalf("expected range -5..-1, got %+v", p)
 	}
 }
 
-func TestParseDefaults(t *testing.T) {
-	input := `defaults
-  cors(origins: ["*"])
-  auth(bearer)`
+func TestParseMatchWithComments(t *testing.T) {
+	input := "GET /accounts/{id}\n" +
+		"  |> match role {   # roles/kinds\n" +
+		"    # users / members\n" +
+		"    \"user\", \"member\": fetch(User, id)\n" +
+		"    \n" +
+		"    # admins\t\n" +
+		"    /^adm/i: fetch(Admin, id)   # regex / arm\n" +
+		"\t\n" +
+		"    # fallback\n" +
+		"    _: ~> 400 { error: \"unknown role\" }\n" +
+		"    # end\n" +
+		"  } as account\n" +
+		"  |> respond 200 { id: account.id }"
+
+	for _, capture := range []bool{false, true} {
+		l := lexer.New(input, "test.rever")
+		l.SetCaptureComments(capture)
+		p := New(l)
+		f := p.ParseFile()
+		if errs := p.Errors(); len(errs) != 0 {
+			t.Fatalf("capture=%v: unexpected errors: %v", capture, errs)
+		}
 
-	f := parse(input)
+		steps := f.Routes[0].Steps
+		if len(steps) != 2 {
+			t.Fatalf("capture=%v: expected 2 steps, got %d", capture, len(steps))
+		}
+		arms := steps[0].Match.Arms
+		if len(arms) != 3 {
+			t.Fatalf("capture=%v: expected 3 arms, got %d", capture, len(arms))
+		}
+		if arms[1].Pattern.Kind != ast.PatternRegex {
+			t.Fatalf("capture=%v: expected regex arm, got %+v", capture, arms[1].Pattern)
+		}
+		if !arms[2].IsDefault || !arms[2].ErrorOnly {
+			t.Fatalf("capture=%v: expected default error arm, got %+v", capture, arms[2])
+		}
+		if steps[0].Bind != "account" {
+			t.Fatalf("capture=%v: expected bind account, got %q", capture, steps[0].Bind)
+		}
+	}
+}
 
-	if f.Defaults == nil {
-		t.Fatal("expected defaults block")
+func TestParseMap(t *testing.T) {
+	input := `GET /users
+  |> fetch(User) as users
+  |> map(users, { id: it.id, name: it.name, tags: [it.role] }) as result
+  |> respond 200 { users: result }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
 	}
-	if len(f.Defaults.Directives) != 2 {
-		t.Fatalf("expected 2 directives, got %d", len(f.Defaults.Directives))
+	step := f.Routes[0].Steps[1]
+	if step.Kind != ast.StepMap {
+		t.Fatalf("expected StepMap, got %d", step.Kind)
 	}
-	if f.Defaults.Directives[0].Name != "cors" {
-		t.Fatalf("expected directive name 'cors', got %q", f.Defaults.Directives[0].Name)
+	if step.Map.Source != "users" || step.Bind != "result" {
+		t.Fatalf("expected map over users as result, got source=%q bind=%q", step.Map.Source, step.Bind)
 	}
-	if f.Defaults.Directives[1].Name != "auth" {
-		t.Fatalf("expected directive name 'auth', got %q", f.Defaults.Directives[1].Name)
+	if step.Map.Pos.Line != 3 || step.Map.Pos.Column != 10 {
+		t.Fatalf("expected source at 3:10, got %d:%d", step.Map.Pos.Line, step.Map.Pos.Column)
+	}
+	if len(step.Map.Body) != 3 || step.Map.Body[1].Key != "name" || step.Map.Body[1].Value != "it.name" {
+		t.Fatalf("expected 3 body fields, got %+v", step.Map.Body)
 	}
 }
 
-func TestParseRouteWithDirectives(t *testing.T) {
-	input := `GET /users/{id}
-  cache(max-age: 3600, public, etag: hash(user))
-  |> input(id: path.id)
-  |> respond 200 { id: user.id }`
-
-	f := parse(input)
-	r := f.Routes[0]
-
-	if len(r.Directives) != 1 {
-		t.Fatalf("expected 1 directive, got %d", len(r.Directives))
+func TestParseMapErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`|> map users`, `expected '(' after 'map'`},
+		{`|> map(users)`, `expected ',' after map source`},
+		{`|> map(users, id)`, `expected '{' element body in map, got IDENT ("id")`},
 	}
-	if r.Directives[0].Name != "cache" {
-		t.Fatalf("expected directive 'cache', got %q", r.Directives[0].Name)
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, "GET /users\n  "+tt.input+"\n  |> respond 200")
+		if len(errs) == 0 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.input, tt.want, errs)
+		}
 	}
 }
 
-func TestParseFullSpec6Example(t *testing.T) {
-	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+func TestParsePipelineDecl(t *testing.T) {
+	input := `pipeline userInput {
+  |> input(name: body.name, role: body.role)
+  |> validate(name: string & min(1))
+  |> transform(name: trim(name))
+}
+
+pipeline audit { |> log(action) |> guard actor ~> 401 }
+
+POST /users
+  |> use userInput
+  |> create(User, name, role) as user
+  |> respond 201 { id: user.id }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(f.Pipelines) != 2 {
+		t.Fatalf("expected 2 pipelines, got %d", len(f.Pipelines))
+	}
+	userInput, audit := f.Pipelines[0], f.Pipelines[1]
+	if userInput.Name != "userInput" || userInput.NamePos.Column != 10 || len(userInput.Steps) != 3 {
+		t.Fatalf("expected userInput with 3 steps, got %+v", userInput)
+	}
+	if userInput.Steps[2].Kind != ast.StepTransform {
+		t.Fatalf("expected transform as the last step, got %d", userInput.Steps[2].Kind)
+	}
+	if len(audit.Steps) != 2 || audit.Steps[1].Kind != ast.StepGuard || audit.Steps[1].ErrorFlow == nil {
+		t.Fatalf("expected one-line audit pipeline with a guard, got %+v", audit.Steps)
+	}
+
+	use := f.Routes[0].Steps[0]
+	if use.Kind != ast.StepUse || use.Use.Name != "userInput" {
+		t.Fatalf("expected use userInput, got %+v", use)
+	}
+	if use.Use.Pos.Line != 10 || use.Use.Pos.Column != 10 {
+		t.Fatalf("expected name at 10:10, got %d:%d", use.Use.Pos.Line, use.Use.Pos.Column)
+	}
+}
+
+func TestParsePipelineDeclErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"pipeline {\n  |> log(x)\n}", `expected pipeline name after 'pipeline', got { ("{")`},
+		{"pipeline audit\n  |> log(x)", `expected '{' after pipeline name`},
+		{"pipeline audit {\n}", `test.rever:1:10: pipeline audit has no steps`},
+		{"pipeline audit {\n  |> log(x)\n  GET /x\n}", `expected '}' to close pipeline audit, got GET ("GET")`},
+		{"GET /x\n  |> use 42\n  |> respond 200", `expected pipeline name after 'use', got INT ("42")`},
+	}
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, tt.input)
+		if len(errs) == 0 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
+func TestParseGroup(t *testing.T) {
+	input := `group /api/v1 {
+  auth(bearer) as user
+
+  GET /users
+    |> respond 200
+
+  POST /users
+    cors(origins: ["*"])
+    |> respond 201
+}
+
+GET /health
+  |> respond 200`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(f.Groups) != 1 || f.Groups[0].Prefix != "/api/v1" {
+		t.Fatalf("expected group /api/v1, got %+v", f.Groups)
+	}
+	var paths []string
+	for _, r := range f.Routes {
+		paths = append(paths, r.Method+" "+r.Path)
+	}
+	if want := []string{"GET /api/v1/users", "POST /api/v1/users", "GET /health"}; !reflect.DeepEqual(paths, want) {
+		t.Fatalf("expected routes %v, got %v", want, paths)
+	}
+	if f.Routes[0].Group != f.Groups[0] || f.Routes[2].Group != nil {
+		t.Fatalf("expected only grouped routes to point at the group")
+	}
+	dirs := f.Routes[1].EffectiveDirectives()
+	if len(dirs) != 2 || dirs[0].Name != "cors" || dirs[1].Name != "auth" || dirs[1].Bind != "user" {
+		t.Fatalf("expected own cors then group auth, got %+v", dirs)
+	}
+}
+
+func TestParseNestedGroup(t *testing.T) {
+	input := `group /orgs/{org} {
+  auth(bearer) as user
+  group /admin/ {
+    auth(apikey) as key
+    GET /
+      |> respond 200
+    DELETE /members/{id}
+      |> input(org: path.org, id: path.id)
+      |> respond 204
+  }
+}`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(f.Groups) != 2 || f.Groups[1].Prefix != "/orgs/{org}/admin" || f.Groups[1].Parent != f.Groups[0] {
+		t.Fatalf("expected nested group /orgs/{org}/admin, got %+v", f.Groups)
+	}
+	if f.Routes[0].Path != "/orgs/{org}/admin" || f.Routes[1].Path != "/orgs/{org}/admin/members/{id}" {
+		t.Fatalf("unexpected paths %q, %q", f.Routes[0].Path, f.Routes[1].Path)
+	}
+	dirs := f.Routes[1].EffectiveDirectives()
+	if len(dirs) != 1 || dirs[0].Bind != "key" {
+		t.Fatalf("expected the inner group's auth to win, got %+v", dirs)
+	}
+}
+
+func TestParseGroupErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"group /api\n  GET /users\n    |> respond 200", `test.rever:1:1: expected '{' at the end of the group line`},
+		{"group /api {\n  GET /users\n    |> respond 200", `test.rever:1:1: expected '}' to close group /api`},
+		{"group /api {\n  type User { id: int }\n}", `unexpected token type ("type") in group`},
+	}
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, tt.input)
+		if len(errs) == 0 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
+func TestParseEnrich(t *testing.T) {
+	input := `GET /users/{id}
+  |> fetch(User, id) as user
+  |> fetch(Profile, id) as profile
+  |> enrich(user, profile) as full
+  |> respond 200 { user: full }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	step := f.Routes[0].Steps[2]
+	if step.Kind != ast.StepEnrich {
+		t.Fatalf("expected StepEnrich, got %d", step.Kind)
+	}
+	if !reflect.DeepEqual(step.Enrich.Sources, []string{"user", "profile"}) || step.Bind != "full" {
+		t.Fatalf("expected enrich(user, profile) as full, got %v as %q", step.Enrich.Sources, step.Bind)
+	}
+	if pos := step.Enrich.SourcePos[1]; pos.Line != 4 || pos.Column != 19 {
+		t.Fatalf("expected second source at 4:19, got %d:%d", pos.Line, pos.Column)
+	}
+}
+
+func TestParseEnrichErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`|> enrich user`, `expected '(' after 'enrich'`},
+		{`|> enrich(user)`, `enrich needs at least two sources`},
+		{`|> enrich(user, "x")`, `expected name to enrich, got STRING ("x")`},
+		{`|> enrich(user profile)`, `expected ',' or ')' in enrich, got IDENT ("profile")`},
+	}
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, "GET /users\n  "+tt.input+"\n  |> respond 200")
+		if len(errs) == 0 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
+func TestParseForward(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> forward("http://users-svc/users/{id}?org={org.id}") as upstream  ~> 502 { error: "unavailable" }
+  |> respond upstream.status { ...upstream.body }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	step := f.Routes[0].Steps[1]
+	if step.Kind != ast.StepForward || step.Bind != "upstream" || step.ErrorFlow == nil {
+		t.Fatalf("expected forward(...) as upstream with an error flow, got %+v", step)
+	}
+	if step.Forward.URL != "http://users-svc/users/{id}?org={org.id}" {
+		t.Errorf("expected the URL template to be kept, got %q", step.Forward.URL)
+	}
+	if !reflect.DeepEqual(step.Forward.Params, []string{"id", "org.id"}) {
+		t.Fatalf("expected placeholders id and org.id, got %v", step.Forward.Params)
+	}
+	if pos := step.Forward.ParamPos[1]; pos.Line != 3 || pos.Column != 48 {
+		t.Errorf("expected second placeholder at 3:48, got %d:%d", pos.Line, pos.Column)
+	}
+}
+
+func TestParseForwardErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`|> forward "http://svc"`, `expected '(' after 'forward'`},
+		{`|> forward(url)`, `expected URL string in forward, got IDENT ("url")`},
+		{`|> forward("http://svc/{id")`, `2:26: unclosed '{' in forward URL`},
+		{`|> forward("http://svc/{}")`, `2:27: invalid placeholder {} in forward URL: expected a name`},
+		{`|> forward("http://svc/{a b}")`, `invalid placeholder {a b} in forward URL: expected a name`},
+	}
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, "GET /users\n  "+tt.input+"\n  |> respond 200")
+		if len(errs) == 0 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
+func TestParseMatchArmWhen(t *testing.T) {
+	input := `GET /test
+  |> match role {
+       "user" when account.active: fetch(User, id)
+       "user" when !account.active: ~> 403 { error: "inactive" }
+       "admin": fetch(Admin, id)
+     } as account`
+
+	f := parse(input)
+	arms := f.Routes[0].Steps[0].Match.Arms
+	if len(arms) != 3 {
+		t.Fatalf("expected 3 arms, got %d", len(arms))
+	}
+
+	if arms[0].When != "account.active" || arms[0].WhenNegated {
+		t.Fatalf("expected when 'account.active', got %q (negated=%v)", arms[0].When, arms[0].WhenNegated)
+	}
+	if arms[0].Step == nil || arms[0].Step.Pkg != "fetch" {
+		t.Fatalf("expected fetch step after when, got %+v", arms[0].Step)
+	}
+	if arms[1].When != "account.active" || !arms[1].WhenNegated || !arms[1].ErrorOnly {
+		t.Fatalf("expected negated when with error-only arm, got %+v", arms[1])
+	}
+	if arms[2].When != "" {
+		t.Fatalf("expected plain arm without when, got %q", arms[2].When)
+	}
+}
+
+func TestParseMatchArmRespond(t *testing.T) {
+	input := `GET /items/{id}
+  |> input(id: path.id)
+  |> fetch(Item, id) as item
+  |> match item.status {
+       404: respond 404 { error: "gone" }
+       _:   respond 200 { ok: true }
+     }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if !f.Routes[0].Steps[2].Match.Responds() {
+		t.Error("expected a match whose arms all respond to end the route")
+	}
+	arms := f.Routes[0].Steps[2].Match.Arms
+	if len(arms) != 2 {
+		t.Fatalf("expected 2 arms, got %d", len(arms))
+	}
+	if r := arms[0].Respond; r == nil || r.Status != "404" || len(r.Body) != 1 || r.Body[0].Key != "error" {
+		t.Fatalf("expected the first arm to respond 404 with an error body, got %+v", r)
+	}
+	if r := arms[1].Respond; !arms[1].IsDefault || r == nil || r.Status != "200" {
+		t.Fatalf("expected the wildcard arm to respond 200, got %+v", arms[1])
+	}
+}
+
+func TestParseStepsAfterRespondingMatch(t *testing.T) {
+	_, errs := parseWithErrors(t, `GET /test
+  |> match role {
+       "admin": respond 200
+       _:       respond 403
+     }
+  |> respond 200`)
+	if len(errs) != 1 || errs[0] != "test.rever:6:3: unreachable steps after match at line 2" {
+		t.Fatalf("expected the trailing respond to be unreachable, got %v", errs)
+	}
+
+	// Without a wildcard, or with an arm that does not respond, the
+	// pipeline may continue.
+	for _, arms := range []string{`"admin": respond 200`, `"admin": respond 200
+       _:       role`} {
+		_, errs := parseWithErrors(t, "GET /test\n  |> match role {\n       "+arms+"\n     }\n  |> respond 200")
+		if len(errs) != 0 {
+			t.Errorf("%q: unexpected errors: %v", arms, errs)
+		}
+	}
+}
+
+func TestParseMatchArmRespondErrorFlow(t *testing.T) {
+	_, errs := parseWithErrors(t, `GET /test
+  |> match role {
+       "admin": respond 200 ~> 500
+     }
+  |> respond 200`)
+	if len(errs) != 1 || !strings.HasSuffix(errs[0], "3:29: a match arm that responds cannot have an error flow") {
+		t.Fatalf("expected an error flow error, got %v", errs)
+	}
+}
+
+func TestParseMatchWildcardWhen(t *testing.T) {
+	_, errs := parseWithErrors(t, `GET /test
+  |> match role {
+       _ when ok: ~> 400 { error: "bad" }
+     }`)
+	if len(errs) == 0 || !strings.Contains(errs[0], "wildcard arm cannot have a 'when' clause") {
+		t.Fatalf("expected wildcard when error, got %v", errs)
+	}
+}
+
+func TestParseInputDefault(t *testing.T) {
+	input := `GET /accounts
+  |> input(role: header.x-role ?? "guest", page: query.page ?? 1, id: query.id)
+  |> respond 200`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	fields := f.Routes[0].Steps[0].Input.Fields
+	if len(fields) != 3 {
+		t.Fatalf("expected 3 input fields, got %d", len(fields))
+	}
+	if d := fields[0].Default; fields[0].From != "header.x-role" || d == nil || d.Kind != ast.ExprString || d.StrVal != "guest" {
+		t.Errorf("expected header.x-role with default \"guest\", got %+v (default %+v)", fields[0], d)
+	}
+	if d := fields[1].Default; d == nil || d.Kind != ast.ExprInt || d.IntVal != "1" {
+		t.Errorf("expected default 1, got %+v", d)
+	}
+	if fields[2].From != "query.id" || fields[2].Default != nil {
+		t.Errorf("expected a plain input without default, got %+v", fields[2])
+	}
+}
+
+func TestParseInputDefaultNotLiteral(t *testing.T) {
+	_, errs := parseWithErrors(t, `GET /accounts
+  |> input(role: header.x-role ?? other)
+  |> respond 200`)
+	if len(errs) != 1 || !strings.Contains(errs[0], "2:35: expected string, integer or boolean default, got IDENT") {
+		t.Fatalf("expected a literal default error, got %v", errs)
+	}
+}
+
+func TestParseInputHeaderMap(t *testing.T) {
+	input := `GET /proxy
+  |> input(headers: header.*, h: headers, role: header.x-role)
+  |> respond 200`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	fields := f.Routes[0].Steps[0].Input.Fields
+	if len(fields) != 3 {
+		t.Fatalf("expected 3 input fields, got %d", len(fields))
+	}
+	for _, field := range fields[:2] {
+		if field.From != "header" || !field.Wildcard {
+			t.Errorf("expected %s to bind the header map, got %+v", field.Name, field)
+		}
+	}
+	if fields[0].Name != "headers" || fields[1].Name != "h" {
+		t.Errorf("expected names headers and h, got %q and %q", fields[0].Name, fields[1].Name)
+	}
+	if fields[2].From != "header.x-role" || fields[2].Wildcard {
+		t.Errorf("expected a single header input, got %+v", fields[2])
+	}
+}
+
+func TestParseInputHeaderMapErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"GET /x\n  |> input(q: query.*)\n  |> respond 200", "2:21: only header.* can bind a whole map, got query.*"},
+		{"GET /x\n  |> input(h: header.* ?? \"\")\n  |> respond 200", "2:15: the whole header map cannot have a default"},
+	}
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, tt.input)
+		if len(errs) != 1 || !strings.HasSuffix(errs[0], tt.want) {
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
+func TestParseCatchAllPaths(t *testing.T) {
+	input := `GET /files/{path*}
+  |> input(path: path.path)
+  |> respond 200 { path: path }
+
+GET /assets/*
+  |> respond 200`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if f.Routes[0].Path != "/files/{path*}" || f.Routes[1].Path != "/assets/*" {
+		t.Fatalf("expected catch-all paths to be kept, got %q and %q", f.Routes[0].Path, f.Routes[1].Path)
+	}
+	if !ast.IsCatchAll(f.Routes[0].Path) || !ast.IsCatchAll(f.Routes[1].Path) || ast.IsCatchAll("/users/{id}") {
+		t.Error("expected only the catch-all paths to be reported as such")
+	}
+	if params := ast.PathParams(f.Routes[0].Path); !reflect.DeepEqual(params, []string{"path"}) {
+		t.Errorf("expected the catch-all param to be named path, got %q", params)
+	}
+}
+
+func TestParseCatchAllNotLast(t *testing.T) {
+	for _, path := range []string{"/files/*/meta", "/files/{path*}/meta", "/files/a*", "/*/{rest*}"} {
+		_, errs := parseWithErrors(t, "GET "+path+"\n  |> respond 200")
+		want := fmt.Sprintf("test.rever:1:1: catch-all segment must be the last segment of route path %q", path)
+		if len(errs) != 1 || errs[0] != want {
+			t.Errorf("%s: expected %q, got %v", path, want, errs)
+		}
+	}
+}
+
+func TestParseRespondWithHeaders(t *testing.T) {
+	input := `GET /test
+  |> respond 301 with headers { location: "/new" }`
+
+	f := parse(input)
+	step := f.Routes[0].Steps[0]
+
+	if step.Kind != ast.StepRespond {
+		t.Fatalf("expected StepRespond, got %d", step.Kind)
+	}
+	if step.Respond.Status != "301" {
+		t.Fatalf("expected status 301, got %q", step.Respond.Status)
+	}
+	if len(step.Respond.Headers) != 1 {
+		t.Fatalf("expected 1 header, got %d", len(step.Respond.Headers))
+	}
+	if step.Respond.Headers[0].Key != "location" {
+		t.Fatalf("expected header key 'location', got %q", step.Respond.Headers[0].Key)
+	}
+}
+
+func TestParseKeywordsAsFieldNames(t *testing.T) {
+	words := []string{"group", "map", "pipeline", "use", "meta", "enrich", "forward", "cookies",
+		"retry", "paginate", "accepts", "compress", "else", "when", "enum"}
+	for _, w := range words {
+		input := fmt.Sprintf(`GET /a
+  |> input(%[1]s: query.%[1]s, user: body.user)
+  |> guard user.%[1]s else respond 404
+  |> respond 200 { %[1]s: user.%[1]s, nested: { %[1]s: "x" }, bound: %[1]s }`, w)
+
+		f, errs := parseWithErrors(t, input)
+		if len(errs) != 0 {
+			t.Errorf("%s: unexpected errors: %v", w, errs)
+			continue
+		}
+		steps := f.Routes[0].Steps
+		if field := steps[0].Input.Fields[0]; field.Name != w || field.From != "query."+w {
+			t.Errorf("%s: expected input %s from query.%s, got %+v", w, w, w, field)
+		}
+		if g := steps[1].Guard; g.Expr != "user."+w {
+			t.Errorf("%s: expected guard on user.%s, got %q", w, w, g.Expr)
+		}
+		body := steps[2].Respond.Body
+		if len(body) != 3 || body[0].Key != w || body[0].Value != "user."+w {
+			t.Fatalf("%s: expected the body key %s from user.%s, got %+v", w, w, w, body)
+		}
+		if nested := body[1].Value.([]*ast.BodyField); nested[0].Key != w {
+			t.Errorf("%s: expected the nested key %s, got %+v", w, w, nested[0])
+		}
+		if body[2].Value != w {
+			t.Errorf("%s: expected the binding %s as a value, got %+v", w, w, body[2])
+		}
+	}
+}
+
+func TestParseRespondTrailingComma(t *testing.T) {
+	inputs := []string{
+		"GET /u\n  |> respond 200 { id: \"1\", tags: [\"a\", \"b\",], }",
+		"GET /u\n  |> respond 200 {\n    id: \"1\",\n    tags: [\"a\", \"b\"],\n  }",
+		"GET /u\n  |> respond 200 {\n    id: \"1\"\n    tags: [\"a\", \"b\"]\n  }",
+	}
+	for _, input := range inputs {
+		f, errs := parseWithErrors(t, input)
+		if len(errs) != 0 {
+			t.Errorf("%q: unexpected errors: %v", input, errs)
+			continue
+		}
+		body := f.Routes[0].Steps[0].Respond.Body
+		if len(body) != 2 || body[0].Key != "id" || body[1].Key != "tags" {
+			t.Errorf("%q: expected fields id and tags, got %+v", input, body)
+			continue
+		}
+		if list, ok := body[1].Value.(ast.BodyList); !ok || len(list) != 2 {
+			t.Errorf("%q: expected a two-element list, got %+v", input, body[1].Value)
+		}
+	}
+
+	_, errs := parseWithErrors(t, "GET /u\n  |> respond 200 { id: \"1\",, name: \"a\" }")
+	if len(errs) != 1 || !strings.Contains(errs[0], "2:28: unexpected , in body") {
+		t.Errorf("expected a doubled comma to be reported, got %v", errs)
+	}
+}
+
+func TestParseRespondContentType(t *testing.T) {
+	input := `GET /a
+  |> respond 200 json { id: user.id }
+GET /b
+  |> respond 200 html """<h1>Hello</h1>
+<p>world</p>"""
+GET /c
+  |> respond 200 text "ok" with headers { x-id: "1" }
+GET /d
+  |> respond 200 { id: user.id }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	tests := []struct {
+		contentType string
+		text        string
+		hasText     bool
+		body        int
+	}{
+		{"json", "", false, 1},
+		{"html", "<h1>Hello</h1>\n<p>world</p>", true, 0},
+		{"text", "ok", true, 0},
+		{"", "", false, 1},
+	}
+	for i, tt := range tests {
+		r := f.Routes[i].Steps[0].Respond
+		if r.ContentType != tt.contentType || r.Text != tt.text || r.HasText != tt.hasText || len(r.Body) != tt.body {
+			t.Errorf("route %d: expected %+v, got %+v", i, tt, r)
+		}
+	}
+	if h := f.Routes[2].Steps[0].Respond.Headers; len(h) != 1 || h[0].Key != "x-id" {
+		t.Fatalf("expected headers after text body, got %+v", h)
+	}
+	if f.Routes[3].Pos.Line != 8 {
+		t.Fatalf("expected text block to keep line numbers, got route at line %d", f.Routes[3].Pos.Line)
+	}
+}
+
+func TestParseRespondStatusRef(t *testing.T) {
+	input := `GET /proxy/{id}
+  |> input(id: path.id)
+  |> upstream(id) as res
+  |> respond res.status json { ...res.body }
+GET /ok
+  |> respond 200 json { ok: true }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	r := f.Routes[0].Steps[2].Respond
+	if r.StatusRef != "res.status" || r.Status != "" || r.ContentType != "json" || len(r.Body) != 1 {
+		t.Fatalf("expected respond with status ref res.status, got %+v", r)
+	}
+	if r.StatusPos.Line != 4 || r.StatusPos.Column != 14 {
+		t.Errorf("expected status at 4:14, got %d:%d", r.StatusPos.Line, r.StatusPos.Column)
+	}
+	if r := f.Routes[1].Steps[0].Respond; r.Status != "200" || r.StatusRef != "" || r.ContentType != "json" {
+		t.Fatalf("expected literal status 200, got %+v", r)
+	}
+}
+
+func TestParseRespondSchema(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> respond 200: User json { id: id }
+GET /proxy
+  |> upstream() as res
+  |> respond res.status: User { ...res.body }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	r := f.Routes[0].Steps[1].Respond
+	if r.Status != "200" || r.Schema != "User" || r.ContentType != "json" || len(r.Body) != 1 {
+		t.Fatalf("expected respond 200: User json, got %+v", r)
+	}
+	if r.SchemaPos.Line != 3 || r.SchemaPos.Column != 19 {
+		t.Errorf("expected schema at 3:19, got %d:%d", r.SchemaPos.Line, r.SchemaPos.Column)
+	}
+	if r := f.Routes[1].Steps[1].Respond; r.StatusRef != "res.status" || r.Schema != "User" {
+		t.Fatalf("expected a schema after a status ref, got %+v", r)
+	}
+
+	_, errs = parseWithErrors(t, "GET /u\n  |> respond 200: { id: \"1\" }")
+	if len(errs) != 1 || !strings.Contains(errs[0], "expected type name after ':' in respond, got {") {
+		t.Errorf("expected a missing type name error, got %v", errs)
+	}
+}
+
+func TestParseRespondExample(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> respond 200: User example { id: 1, name: "Ada", tags: ["admin"], active: true } { id: id }
+GET /example
+  |> respond 200 { example: "yes" }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	r := f.Routes[0].Steps[1].Respond
+	if r.Schema != "User" || len(r.Example) != 4 || len(r.Body) != 1 || r.Body[0].Key != "id" {
+		t.Fatalf("expected an example of 4 fields and a body with id, got %+v", r)
+	}
+	if r.ExamplePos.Line != 3 || r.ExamplePos.Column != 24 {
+		t.Errorf("expected the example at 3:24, got %d:%d", r.ExamplePos.Line, r.ExamplePos.Column)
+	}
+	if name := r.Example[1]; name.Key != "name" || name.Value != "Ada" || !name.IsString {
+		t.Errorf("expected the literal name Ada, got %+v", name)
+	}
+	if r := f.Routes[1].Steps[0].Respond; len(r.Example) != 0 || len(r.Body) != 1 || r.Body[0].Key != "example" {
+		t.Errorf("expected example to be a plain key inside a body, got %+v", r)
+	}
+
+	_, errs = parseWithErrors(t, `GET /u
+  |> respond 200 example { ...user, id: user.id, name }`)
+	want := []string{
+		`test.rever:2:31: spread is not allowed in example`,
+		`test.rever:2:41: example values must be literals, got "user.id"`,
+		`test.rever:2:50: example field "name" needs a literal value`,
+	}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("expected %v, got %v", want, errs)
+	}
+}
+
+func TestParseRespondUnknownContentType(t *testing.T) {
+	_, errs := parseWithErrors(t, `GET /test
+  |> respond 200 xml "<a/>"`)
+	if len(errs) != 1 || !strings.Contains(errs[0], `unknown content type "xml"`) {
+		t.Fatalf("expected unknown content type error, got %v", errs)
+	}
+}
+
+func TestParseNestedBody(t *testing.T) {
+	input := `GET /test
+  |> respond 200 { user: { id: user.id, name: user.name }, ok: "true" }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	body := f.Routes[0].Steps[0].Respond.Body
+	if len(body) != 2 || body[0].Key != "user" || body[1].Key != "ok" {
+		t.Fatalf("expected fields user and ok, got %+v", body)
+	}
+	nested, ok := body[0].Value.([]*ast.BodyField)
+	if !ok || len(nested) != 2 {
+		t.Fatalf("expected nested body with 2 fields, got %#v", body[0].Value)
+	}
+	if nested[0].Key != "id" || nested[0].Value != "user.id" || nested[1].Key != "name" || nested[1].Value != "user.name" {
+		t.Fatalf("unexpected nested fields: %+v %+v", nested[0], nested[1])
+	}
+}
+
+func TestParseBodyList(t *testing.T) {
+	input := `GET /test
+  |> respond 200 { ids: [user.id, other.id], tags: ["a", "b"] }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	body := f.Routes[0].Steps[0].Respond.Body
+
+	ids, ok := body[0].Value.(ast.BodyList)
+	if !ok || len(ids) != 2 || ids[0].Value != "user.id" || ids[1].Value != "other.id" || ids[0].IsString {
+		t.Fatalf("expected list of refs, got %#v", body[0].Value)
+	}
+	if ids[1].Pos.Column != 35 {
+		t.Fatalf("expected other.id at column 35, got %d", ids[1].Pos.Column)
+	}
+	tags, ok := body[1].Value.(ast.BodyList)
+	if !ok || len(tags) != 2 || tags[0].Value != "a" || tags[1].Value != "b" || !tags[0].IsString {
+		t.Fatalf("expected list of strings, got %#v", body[1].Value)
+	}
+}
+
+func TestParseNestedHeaders(t *testing.T) {
+	_, errs := parseWithErrors(t, `GET /test
+  |> respond 200 with headers { x: { y: "z" } }`)
+	if len(errs) != 1 || !strings.Contains(errs[0], "nested objects are not allowed in headers") {
+		t.Fatalf("expected nested headers error, got %v", errs)
+	}
+}
+
+func TestParseBodySpread(t *testing.T) {
+	input := `GET /test
+  |> respond 200 { ...user.profile, extra: "x", id: user.id }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	body := f.Routes[0].Steps[0].Respond.Body
+	if len(body) != 3 {
+		t.Fatalf("expected 3 fields, got %d", len(body))
+	}
+	if !body[0].Spread || body[0].Key != "" || body[0].Value != "user.profile" || body[0].Pos.Column != 23 {
+		t.Fatalf("expected spread of user.profile at column 23, got %+v", body[0])
+	}
+	if body[1].Spread || body[1].Key != "extra" || body[1].Value != "x" {
+		t.Fatalf("expected explicit field extra, got %+v", body[1])
+	}
+}
+
+func TestParseBodySpreadErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`GET /a
+  |> respond 200 { id: user.id, ...user }`, "spread must come before other fields"},
+		{`GET /a
+  |> respond 200 { ...user, ...other }`, "only one spread is allowed per body"},
+		{`GET /a
+  |> respond 200 with headers { ...user }`, "spread is not allowed in headers"},
+		{`GET /a
+  |> respond 200 { ... }`, "expected name after '...'"},
+	}
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, tt.input)
+		if len(errs) != 1 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
+func TestParseCookies(t *testing.T) {
+	input := `POST /login
+  |> input(session: cookie.sid)
+  |> respond 200 { ok: true } with cookies { sid: token, path: "/", max-age: 3600, http-only } with headers { x-id: "1" }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	steps := f.Routes[0].Steps
+	if from := steps[0].Input.Fields[0].From; from != "cookie.sid" {
+		t.Fatalf("expected cookie.sid source, got %q", from)
+	}
+
+	r := steps[1].Respond
+	if len(r.Cookies) != 4 {
+		t.Fatalf("expected 4 cookie fields, got %d", len(r.Cookies))
+	}
+	if r.Cookies[0].Key != "sid" || r.Cookies[0].Value != "token" {
+		t.Fatalf("expected sid: token, got %+v", r.Cookies[0])
+	}
+	if r.Cookies[2].Key != "max-age" || r.Cookies[2].Value != "3600" {
+		t.Fatalf("expected max-age: 3600, got %+v", r.Cookies[2])
+	}
+	if r.Cookies[3].Key != "http-only" || r.Cookies[3].Value != nil {
+		t.Fatalf("expected http-only flag, got %+v", r.Cookies[3])
+	}
+	if len(r.Headers) != 1 {
+		t.Fatalf("expected headers after cookies, got %+v", r.Headers)
+	}
+}
+
+func TestParseWithUnknownBlock(t *testing.T) {
+	_, errs := parseWithErrors(t, `GET /test
+  |> respond 200 with trailers { x: "1" }`)
+	if len(errs) == 0 || !strings.Contains(errs[0], "expected 'headers' or 'cookies' after 'with'") {
+		t.Fatalf("expected with error, got %v", errs)
+	}
+}
+
+func TestParseRecoversFromBrokenStep(t *testing.T) {
+	tests := []struct {
+		name   string
+		broken string
+		errMsg string
+	}{
+		{
+			name:   "unknown step",
+			broken: `|> 42 oops (here) ~> 400 { error: "x" }`,
+			errMsg: `test.rever:3:6: expected step keyword, got INT ("42")`,
+		},
+		{
+			name: "unknown multi-line step",
+			broken: `|> 42 {
+       a: b
+     }`,
+			errMsg: `test.rever:3:6: expected step keyword, got INT ("42")`,
+		},
+		{
+			name:   "trailing tokens",
+			broken: `|> fetch(User, id) as user extra tokens ~> 404 { error: "x" }`,
+			errMsg: `test.rever:3:30: unexpected IDENT ("extra") after step`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "GET /users/{id}\n  |> input(id: path.id)\n  " + tt.broken + `
+  |> respond 200 { id: id }
+
+GET /health
+  |> respond 200`
+
+			f, errs := parseWithErrors(t, input)
+			if len(errs) != 1 || errs[0] != tt.errMsg {
+				t.Fatalf("expected single error %q, got %v", tt.errMsg, errs)
+			}
+			if len(f.Routes) != 2 {
+				t.Fatalf("expected 2 routes, got %d", len(f.Routes))
+			}
+			steps := f.Routes[0].Steps
+			if steps[0].Kind != ast.StepInput || steps[len(steps)-1].Kind != ast.StepRespond {
+				t.Fatalf("expected input and respond steps to survive, got %+v", steps)
+			}
+			if len(f.Routes[1].Steps) != 1 {
+				t.Fatalf("expected next route to parse, got %+v", f.Routes[1].Steps)
+			}
+		})
+	}
+}
+
+func TestParseRoutePathVerbatim(t *testing.T) {
+	for _, path := range []string{"/v1.0/users", "/a..b", "/users/{id}", "/users.json", "/files/{path*}", "/map/respond-v2"} {
+		f, errs := parseWithErrors(t, "GET "+path+"  # comment\n  |> respond 200")
+		if len(errs) != 0 {
+			t.Errorf("%s: unexpected errors: %v", path, errs)
+			continue
+		}
+		if got := f.Routes[0].Path; got != path {
+			t.Errorf("expected path %q, got %q", path, got)
+		}
+	}
+
+	f, errs := parseWithErrors(t, "group /v2.1 {\n  GET /items.json\n    |> respond 200\n}")
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if got := f.Routes[0].Path; got != "/v2.1/items.json" {
+		t.Errorf("expected the group prefix to be kept verbatim, got %q", got)
+	}
+}
+
+func TestParseRoutePathErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"GET users\n  |> respond 200", `1:5: expected path starting with '/' after GET, got IDENT ("users")`},
+		{"GET /users extra\n  |> respond 200", `1:12: unexpected IDENT ("extra") after route path`},
+	}
+	for _, tt := range tests {
+		f, errs := parseWithErrors(t, tt.input)
+		if len(errs) != 1 || !strings.HasSuffix(errs[0], tt.want) {
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.want, errs)
+		}
+		if len(f.Routes) != 1 || len(f.Routes[0].Steps) != 1 {
+			t.Errorf("%q: expected the steps to parse, got %+v", tt.input, f.Routes)
+		}
+	}
+}
+
+func TestParseRouteWithoutSteps(t *testing.T) {
+	input := `GET /x
+  cache(max-age: 60)
+
+GET /y
+  |> respond 200`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 1 || errs[0] != "test.rever:1:1: route GET /x has no pipeline steps" {
+		t.Fatalf("expected no-steps error, got %v", errs)
+	}
+	if len(f.Routes) != 2 || len(f.Routes[1].Steps) != 1 {
+		t.Fatalf("expected following route to parse, got %+v", f.Routes)
+	}
+}
+
+func TestParseStepsAfterRespond(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name: "step after respond",
+			input: `GET /users/{id}
+  |> respond 200
+  |> fetch(User, id) as user`,
+			want: "test.rever:3:3: unreachable steps after respond at line 2",
+		},
+		{
+			name: "multiple responds",
+			input: `GET /users
+  |> respond 200 { ok: "true" }
+  |> respond 201
+  |> respond 202`,
+			want: "test.rever:3:3: unreachable steps after respond at line 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := parseWithErrors(t, tt.input)
+			if len(errs) != 1 || errs[0] != tt.want {
+				t.Fatalf("expected %q, got %v", tt.want, errs)
+			}
+		})
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	input := `defaults
+  cors(origins: ["*"])
+  auth(bearer)`
+
+	f := parse(input)
+
+	if f.Defaults == nil {
+		t.Fatal("expected defaults block")
+	}
+	if len(f.Defaults.Directives) != 2 {
+		t.Fatalf("expected 2 directives, got %d", len(f.Defaults.Directives))
+	}
+	if f.Defaults.Directives[0].Name != "cors" {
+		t.Fatalf("expected directive name 'cors', got %q", f.Defaults.Directives[0].Name)
+	}
+	if f.Defaults.Directives[1].Name != "auth" {
+		t.Fatalf("expected directive name 'auth', got %q", f.Defaults.Directives[1].Name)
+	}
+}
+
+func TestParseMethodDefaults(t *testing.T) {
+	input := `defaults
+  auth(none)
+
+defaults for POST, PUT, DELETE { auth(bearer) as user }
+
+defaults for GET
+  cache(max-age: 60)
+
+GET /users
+  |> respond 200`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if f.Defaults == nil || len(f.Defaults.Methods) != 0 || f.Defaults.Directives[0].Name != "auth" {
+		t.Fatalf("expected unscoped defaults with auth, got %+v", f.Defaults)
+	}
+	if len(f.MethodDefaults) != 2 {
+		t.Fatalf("expected 2 method-scoped blocks, got %d", len(f.MethodDefaults))
+	}
+	mutations := f.MethodDefaults[0]
+	if !reflect.DeepEqual(mutations.Methods, []string{"POST", "PUT", "DELETE"}) {
+		t.Fatalf("expected POST, PUT, DELETE, got %v", mutations.Methods)
+	}
+	if len(mutations.Directives) != 1 || mutations.Directives[0].Bind != "user" {
+		t.Fatalf("expected auth as user, got %+v", mutations.Directives)
+	}
+	if reads := f.MethodDefaults[1]; !reflect.DeepEqual(reads.Methods, []string{"GET"}) || reads.Directives[0].Name != "cache" {
+		t.Fatalf("expected cache for GET, got %+v", reads)
+	}
+	if len(f.Routes) != 1 {
+		t.Fatalf("expected the route after the blocks, got %d routes", len(f.Routes))
+	}
+}
+
+func TestParseMethodDefaultsErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"defaults for users\n  auth(bearer)", `expected HTTP method in defaults for, got IDENT ("users")`},
+		{"defaults for POST { auth(bearer) respond }", `expected '}' to close defaults, got respond ("respond")`},
+	}
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, tt.input)
+		if len(errs) == 0 || !strings.Contains(errs[0], tt.want) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
+func TestParseRouteWithDirectives(t *testing.T) {
+	input := `GET /users/{id}
+  cache(max-age: 3600, public, etag: hash(user))
+  |> input(id: path.id)
+  |> respond 200 { id: user.id }`
+
+	f := parse(input)
+	r := f.Routes[0]
+
+	if len(r.Directives) != 1 {
+		t.Fatalf("expected 1 directive, got %d", len(r.Directives))
+	}
+	if r.Directives[0].Name != "cache" {
+		t.Fatalf("expected directive 'cache', got %q", r.Directives[0].Name)
+	}
+}
+
+func TestParseRouteRetry(t *testing.T) {
+	input := `GET /users/{id}
+  retry(attempts: 3, backoff: 200ms)
+  |> input(id: path.id)
+  |> respond 200 { id: id }`
+
+	f := parse(input)
+	r := f.Routes[0]
+
+	if len(r.Directives) != 1 || r.Directives[0].Name != "retry" {
+		t.Fatalf("expected retry directive, got %+v", r.Directives)
+	}
+	args := r.Directives[0].Args
+	if len(args) != 2 {
+		t.Fatalf("expected 2 args, got %d", len(args))
+	}
+	if args[0].Name != "attempts" || args[0].Value.IntVal != "3" {
+		t.Fatalf("expected attempts 3, got %+v", args[0])
+	}
+	if args[1].Name != "backoff" || args[1].Value.Kind != ast.ExprDuration || args[1].Value.StrVal != "200ms" {
+		t.Fatalf("expected backoff duration 200ms, got %+v", args[1])
+	}
+}
+
+func TestParsePaginate(t *testing.T) {
+	input := `defaults
+  paginate(limit: 50)
+
+GET /users
+  paginate(limit: 20, max: 100, cursor: query.cursor)
+  |> respond 200 { ok: "true" }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if d := f.Defaults.Directives; len(d) != 1 || d[0].Name != "paginate" {
+		t.Fatalf("expected paginate in defaults, got %+v", d)
+	}
+	r := f.Routes[0]
+	if len(r.Directives) != 1 || r.Directives[0].Name != "paginate" {
+		t.Fatalf("expected paginate directive, got %+v", r.Directives)
+	}
+	args := r.Directives[0].Args
+	if len(args) != 3 {
+		t.Fatalf("expected 3 args, got %d", len(args))
+	}
+	if args[1].Name != "max" || args[1].Value.IntVal != "100" {
+		t.Fatalf("expected max 100, got %+v", args[1])
+	}
+	if args[2].Name != "cursor" || args[2].Value.StrVal != "query.cursor" {
+		t.Fatalf("expected cursor query.cursor, got %+v", args[2])
+	}
+}
+
+func TestParseMeta(t *testing.T) {
+	input := `meta { title: "User API", version: "1.2.0", base: "/api" }
+
+GET /users
+  |> respond 200`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if f.Meta == nil || len(f.Meta.Fields) != 3 {
+		t.Fatalf("expected a meta block with 3 fields, got %+v", f.Meta)
+	}
+	base := f.Meta.Fields[2]
+	if base.Key != "base" || base.Value != "/api" || !base.IsString {
+		t.Errorf("expected base \"/api\", got %+v", base)
+	}
+	if len(f.Routes) != 1 || f.Routes[0].Path != "/users" {
+		t.Errorf("expected the route path to be left as written, got %+v", f.Routes)
+	}
+}
+
+func TestParseMetaErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"meta { title: \"a\" }\nmeta { title: \"b\" }", "2:1: duplicate meta block (first declared at line 1)"},
+		{"meta { info: { a: \"b\" } }", "1:14: nested objects are not allowed in meta"},
+		{"meta title", "1:6: expected '{' after 'meta', got IDENT"},
+	}
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, tt.input)
+		if len(errs) != 1 || !strings.HasSuffix(errs[0], tt.want) {
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	input := `# IR 0.2
+version 0.2
+
+meta { version: "1.2.0" }
+
+GET /users
+  |> respond 200`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if f.Version != "0.2" || f.VersionPos.Line != 2 || f.VersionPos.Column != 1 {
+		t.Fatalf("expected version 0.2 at 2:1, got %q at %d:%d", f.Version, f.VersionPos.Line, f.VersionPos.Column)
+	}
+	if f.Meta == nil || len(f.Meta.Fields) != 1 || f.Meta.Fields[0].Key != "version" {
+		t.Errorf("expected version to stay a meta key, got %+v", f.Meta)
+	}
+	if f, _ := parseWithErrors(t, "GET /users\n  |> respond 200"); f.Version != "" {
+		t.Errorf("expected no version without the pragma, got %q", f.Version)
+	}
+}
+
+func TestParseVersionErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"GET /a\n  |> respond 200\nversion 0.2", "3:1: version must come before all other declarations"},
+		{"version 0.1\nversion 0.2", "2:1: version must come before all other declarations"},
+		{"version \"0.2\"", "1:9: expected a version number such as 0.2 after 'version', got STRING"},
+		{"version 0.2 beta", "1:13: unexpected IDENT after version"},
+	}
+	for _, tt := range tests {
+		_, errs := parseWithErrors(t, tt.input)
+		if len(errs) != 1 || !strings.HasSuffix(errs[0], tt.want) {
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
+
+func TestParseAccepts(t *testing.T) {
+	input := `GET /users
+  accepts("application/json")
+  |> respond 200 { ok: "true" }
+
+GET /reports
+  accepts("application/json", "text/csv")
+  cache(max-age: 60)
+  |> respond 200 { ok: "true" }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	d := f.Routes[0].Directives
+	if len(d) != 1 || d[0].Name != "accepts" || len(d[0].Args) != 1 || d[0].Args[0].Value.StrVal != "application/json" {
+		t.Fatalf("expected accepts(\"application/json\"), got %+v", d)
+	}
+	d = f.Routes[1].Directives
+	if len(d) != 2 || d[0].Name != "accepts" || d[1].Name != "cache" {
+		t.Fatalf("expected accepts followed by cache, got %+v", d)
+	}
+	if args := d[0].Args; len(args) != 2 || args[1].Value.Kind != ast.ExprString || args[1].Value.StrVal != "text/csv" {
+		t.Fatalf("expected two media types, got %+v", args)
+	}
+}
+
+func TestParseCompress(t *testing.T) {
+	input := `defaults
+  compress(gzip)
+
+GET /reports
+  compress(br, gzip, min: 1024)
+  |> respond 200 { ok: "true" }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if d := f.Defaults.Directives; len(d) != 1 || d[0].Name != "compress" {
+		t.Fatalf("expected compress in defaults, got %+v", d)
+	}
+	d := f.Routes[0].Directives
+	if len(d) != 1 || d[0].Name != "compress" || len(d[0].Args) != 3 {
+		t.Fatalf("expected compress with 3 args, got %+v", d)
+	}
+	args := d[0].Args
+	if args[0].Name != "" || args[0].Value.StrVal != "br" || args[1].Value.StrVal != "gzip" {
+		t.Errorf("expected the encodings br and gzip, got %+v and %+v", args[0], args[1])
+	}
+	if args[2].Name != "min" || args[2].Value.IntVal != "1024" {
+		t.Errorf("expected min 1024, got %+v", args[2])
+	}
+}
+
+func TestParseStepRetry(t *testing.T) {
+	input := `GET /users/{id}
+  |> fetch(User, id) retry(attempts: 3) as user  ~> 404 { error: "not found" }
+  |> fetch(Post, id) as post retry(attempts: 2)`
+
+	f := parse(input)
+	steps := f.Routes[0].Steps
+
+	first := steps[0]
+	if first.Retry == nil || first.Retry.Args[0].Value.IntVal != "3" {
+		t.Fatalf("expected retry with 3 attempts, got %+v", first.Retry)
+	}
+	if first.Bind != "user" {
+		t.Fatalf("expected bind 'user', got %q", first.Bind)
+	}
+	if first.ErrorFlow == nil || first.ErrorFlow.Status != "404" {
+		t.Fatal("expected error flow with status 404")
+	}
+
+	second := steps[1]
+	if second.Retry == nil || second.Retry.Args[0].Value.IntVal != "2" || second.Bind != "post" {
+		t.Fatalf("expected retry after bind, got retry=%+v bind=%q", second.Retry, second.Bind)
+	}
+}
+
+func TestParseRetryOnBuiltinStep(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id) retry(attempts: 3)`
+
+	_, errs := parseWithErrors(t, input)
+	if len(errs) == 0 || !strings.Contains(errs[0], "retry is only allowed after a package call") {
+		t.Fatalf("expected retry error, got %v", errs)
+	}
+}
+
+func TestParseFullSpec6Example(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
 
 GET /users/{id}
   |> input(id: path.id)
@@ -435,11 +2239,98 @@ func TestParsePkgCallWithObject(t *testing.T) {
 	if len(args) != 2 {
 		t.Fatalf("expected 2 args, got %d", len(args))
 	}
-	if !args[0].IsType || args[0].Value != "User" {
+	if args[0].Value.Kind != ast.ExprType || args[0].Value.StrVal != "User" {
 		t.Fatalf("expected type arg 'User', got %+v", args[0])
 	}
-	if len(args[1].ObjectArgs) != 2 || args[1].ObjectArgs[0] != "name" || args[1].ObjectArgs[1] != "email" {
-		t.Fatalf("expected object args [name, email], got %+v", args[1].ObjectArgs)
+	if obj := args[1].Value; obj.Kind != ast.ExprObject || !reflect.DeepEqual(obj.ListVal, []string{"name", "email"}) {
+		t.Fatalf("expected object args [name, email], got %+v", obj)
+	}
+}
+
+func TestParseArgsSharedByDirectivesAndPkgCalls(t *testing.T) {
+	input := `GET /users
+  cache(vary: ["Accept", "Accept-Language"], max-age: 60, etag: hash(user))
+  |> redis-cache(vary: ["Accept", "Accept-Language"], max-age: 60, etag: hash(user)) as user
+  |> respond 200`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	directive := f.Routes[0].Directives[0].Args
+	call := f.Routes[0].Steps[0].PkgCall.Args
+	if len(directive) != 3 || len(call) != 3 {
+		t.Fatalf("expected 3 args each, got %d and %d", len(directive), len(call))
+	}
+	for i := range directive {
+		d, c := directive[i], call[i]
+		// The args differ only in where they are written.
+		if d.Name != c.Name || !reflect.DeepEqual(d.Value, c.Value) || d.Pos.Column+9 != c.Pos.Column {
+			t.Errorf("arg %d: expected %+v in the directive and %+v in the call to match", i, d, c)
+		}
+	}
+	if v := directive[0].Value; v.Kind != ast.ExprList || !reflect.DeepEqual(v.ListVal, []string{"Accept", "Accept-Language"}) {
+		t.Errorf("expected a list arg, got %+v", v)
+	}
+	if v := directive[1].Value; directive[1].Name != "max-age" || v.Kind != ast.ExprInt || v.IntVal != "60" {
+		t.Errorf("expected the named arg max-age: 60, got %+v", directive[1])
+	}
+}
+
+func TestParseArgsStrayComma(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		args  int
+	}{
+		{"GET /a\n  cache(, max-age: 60, private)\n  |> respond 200", "test.rever:2:9: unexpected ','", 2},
+		{"GET /a\n  cache(max-age: 60,, private)\n  |> respond 200", "test.rever:2:21: unexpected ','", 2},
+		{"GET /a\n  |> input(role: query.role)\n  |> validate(role: string & oneOf(, \"a\"))\n  |> respond 200", "test.rever:3:36: unexpected ','", 1},
+	}
+	for _, tt := range tests {
+		f, errs := parseWithErrors(t, tt.input)
+		if len(errs) != 1 || errs[0] != tt.want {
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.want, errs)
+			continue
+		}
+		n := 0
+		if d := f.Routes[0].Directives; len(d) > 0 {
+			n = len(d[0].Args)
+		} else {
+			n = len(f.Routes[0].Steps[1].Validate.Rules[0].Constraints[1].Args)
+		}
+		if n != tt.args {
+			t.Errorf("%q: expected %d args without a blank one, got %d", tt.input, tt.args, n)
+		}
+	}
+}
+
+func TestParseStringPlaceholders(t *testing.T) {
+	input := `GET /users/{id}
+  |> redis-cache(key: "user:{id}", prefix: "users") as user
+  |> respond 200 { url: "/users/{user.id}", name: "{ name }" }`
+
+	f := parse(input)
+	args := f.Routes[0].Steps[0].PkgCall.Args
+	if len(args) != 2 {
+		t.Fatalf("expected 2 args, got %d", len(args))
+	}
+	if args[0].Value.Kind != ast.ExprString || !reflect.DeepEqual(args[0].Refs, []string{"id"}) {
+		t.Fatalf("expected an interpolated cache key, got %+v", args[0])
+	}
+	if pos := args[0].RefPos[0]; pos.Line != 2 || pos.Column != 30 {
+		t.Errorf("expected {id} at 2:30, got %d:%d", pos.Line, pos.Column)
+	}
+	if args[1].Value.Kind != ast.ExprString || args[1].Refs != nil {
+		t.Errorf("expected a plain string arg, got %+v", args[1])
+	}
+
+	body := f.Routes[0].Steps[1].Respond.Body
+	if !reflect.DeepEqual(body[0].Refs, []string{"user.id"}) {
+		t.Errorf("expected the url to reference user.id, got %v", body[0].Refs)
+	}
+	if body[1].Refs != nil {
+		t.Errorf("expected %q to stay plain, got %v", body[1].Value, body[1].Refs)
 	}
 }
 
@@ -494,6 +2385,45 @@ func TestParseMatchRange(t *testing.T) {
 	}
 }
 
+func TestParseMatchRegexFlags(t *testing.T) {
+	input := `GET /test
+  |> match role {
+       /^admin$/i: fetch(Admin, id)
+       /^user/: fetch(User, id)
+     } as account`
+
+	f := parse(input)
+	arms := f.Routes[0].Steps[0].Match.Arms
+
+	if arms[0].Pattern.Kind != ast.PatternRegex || arms[0].Pattern.Regex != "^admin$" || arms[0].Pattern.RegexFlags != "i" {
+		t.Fatalf("expected regex ^admin$ with flag i, got %+v", arms[0].Pattern)
+	}
+	if arms[1].Pattern.Regex != "^user" || arms[1].Pattern.RegexFlags != "" {
+		t.Fatalf("expected regex ^user without flags, got %+v", arms[1].Pattern)
+	}
+}
+
+func TestParseMatchUnterminatedRegex(t *testing.T) {
+	_, errs := parseWithErrors(t, `GET /test
+  |> match role {
+       /^admin: fetch(Admin, id)
+     } as account
+  |> respond 200`)
+	if len(errs) == 0 || errs[0] != "test.rever:3:8: unterminated regex literal" {
+		t.Fatalf("expected the lexer error first, got %v", errs)
+	}
+}
+
+func TestParseMatchUnterminated(t *testing.T) {
+	_, errs := parseWithErrors(t, `GET /test
+  |> match role {
+       "user" fetch(User, id)
+  |> respond 200 { ok: "true" }`)
+	if len(errs) == 0 {
+		t.Fatal("expected errors for malformed match")
+	}
+}
+
 func TestParseCorsNone(t *testing.T) {
 	input := `GET /test
   cors(none)
@@ -534,6 +2464,107 @@ func TestParseAuthWithBind(t *testing.T) {
 	}
 }
 
+func TestParseAuthScopesOptional(t *testing.T) {
+	input := `GET /me
+  auth(bearer, scopes: ["read:user", "read:org"], realm: "api", optional)
+  |> respond 200 { ok: "true" }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	args := f.Routes[0].Directives[0].Args
+	if len(args) != 4 {
+		t.Fatalf("expected 4 args, got %d", len(args))
+	}
+	if args[1].Name != "scopes" || len(args[1].Value.ListVal) != 2 || args[1].Value.ListVal[0] != "read:user" {
+		t.Fatalf("expected scopes list, got %+v", args[1])
+	}
+	if args[2].Name != "realm" || args[2].Value.StrVal != "api" {
+		t.Fatalf("expected realm api, got %+v", args[2])
+	}
+	if args[3].Name != "" || args[3].Value.StrVal != "optional" {
+		t.Fatalf("expected optional flag, got %+v", args[3])
+	}
+}
+
+func TestParseEnvArgs(t *testing.T) {
+	input := `GET /me
+  auth(bearer, realm: env("AUTH_REALM"))
+  cors(origins: ["https://app.example.com", env("ADMIN_ORIGIN")])
+  cache(etag: hash(user))
+  |> respond 200`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	dirs := f.Routes[0].Directives
+	realm := dirs[0].Args[1]
+	if realm.Name != "realm" || realm.Value.Kind != ast.ExprFuncCall || realm.Value.StrVal != `env("AUTH_REALM")` {
+		t.Errorf("expected realm env(\"AUTH_REALM\"), got %+v", realm)
+	}
+	if origins := dirs[1].Args[0].Value.ListVal; !reflect.DeepEqual(origins, []string{"https://app.example.com", `env("ADMIN_ORIGIN")`}) {
+		t.Errorf("expected the env call as one list item, got %q", origins)
+	}
+	if etag := dirs[2].Args[0].Value; etag.Kind != ast.ExprFuncCall || etag.StrVal != "hash(user)" {
+		t.Errorf("expected hash(user) unchanged, got %+v", etag)
+	}
+}
+
+func TestParseRegexOrigins(t *testing.T) {
+	input := `GET /me
+  cors(origins: [/^https:\/\/a\.dev$/, /\.test$/i, "*.example.com"], max-age: 60)
+  |> respond 200`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	args := f.Routes[0].Directives[0].Args
+	want := []string{`/^https:\/\/a\.dev$/`, `/\.test$/i`, "*.example.com"}
+	if origins := args[0].Value.ListVal; !reflect.DeepEqual(origins, want) {
+		t.Errorf("expected regexes in their source form %q, got %q", want, origins)
+	}
+	if len(args) != 2 || args[1].Name != "max-age" || args[1].Value.IntVal != "60" {
+		t.Errorf("expected max-age after the origins, got %+v", args)
+	}
+}
+
+func TestParseAuthAPIKey(t *testing.T) {
+	input := `GET /data
+  auth(apikey, in: header, name: "X-API-Key")
+  |> respond 200 { ok: "true" }
+
+GET /legacy
+  auth(basic)
+  |> respond 200 { ok: "true" }`
+
+	f, errs := parseWithErrors(t, input)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	args := f.Routes[0].Directives[0].Args
+	if len(args) != 3 {
+		t.Fatalf("expected 3 args, got %d", len(args))
+	}
+	if args[0].Value.StrVal != "apikey" {
+		t.Fatalf("expected method apikey, got %+v", args[0])
+	}
+	if args[1].Name != "in" || args[1].Value.StrVal != "header" {
+		t.Fatalf("expected in: header, got %+v", args[1])
+	}
+	if args[2].Name != "name" || args[2].Value.StrVal != "X-API-Key" {
+		t.Fatalf("expected name: X-API-Key, got %+v", args[2])
+	}
+	if args[2].Pos.Line != 2 || args[2].Pos.Column != 28 {
+		t.Fatalf("expected name arg at 2:28, got %d:%d", args[2].Pos.Line, args[2].Pos.Column)
+	}
+	if basic := f.Routes[1].Directives[0].Args; len(basic) != 1 || basic[0].Value.StrVal != "basic" {
+		t.Fatalf("expected basic auth, got %+v", basic)
+	}
+}
+
 func TestValidatePathParam(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -597,3 +2628,82 @@ func TestValidatePathParam(t *testing.T) {
 		})
 	}
 }
+
+// FuzzParseFile checks that the parser neither panics nor loops forever on
+// arbitrary input. The seeds are the testdata inputs plus fragments from the
+// tests above that exercise error recovery.
+func FuzzParseFile(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("..", "..", "testdata", "*.rever"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(data))
+	}
+	for _, seed := range []string{
+		"GET /users\n  |> respond 200 { list: users }",
+		"GET /users\n  |> input(id: path.id\n  |> respond 200",
+		"GET /users\n  |> cache(max-age: 60, private\n",
+		"GET /users\n  |> respond 200 { a: 1,, b: 2 }",
+		"GET /users\n  |> match role {\n    \"admin\": ~> 403\n    _: ~> 400 { error: \"x\" }\n  }",
+		"GET /users\n  |> validate name: /^[a-z]+$/i, age: int(min: 0)",
+		"GET /users\n  |> forward(\"http://upstream/{id\")",
+		"type User { id: int, name: string, }\ntype {",
+		"meta { base: \"/api\" }\ndefaults for GET, POST { cache(private) }",
+		"group /v1 {\n  GET /users\n    |> respond 200\n",
+		"import fetch = @/src/fetch.rever\nGET /x\n  |> fetch(User, id) as user",
+		"pipeline auth {\n  |> guard user ~> 401\n}",
+		"GET\nPOST /\n|> ) ] } , : ~> &",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		parseBounded(t, input)
+	})
+}
+
+// parseBounded parses input and returns the errors, failing the test if the
+// parser has not returned within a few seconds.
+func parseBounded(t *testing.T, input string) []string {
+	t.Helper()
+	done := make(chan []string, 1)
+	go func() {
+		p := New(lexer.New(input, "test.rever"))
+		p.ParseFile()
+		done <- p.ErrorStrings()
+	}()
+	select {
+	case errs := <-done:
+		return errs
+	case <-time.After(5 * time.Second):
+		t.Fatalf("parser did not terminate on %q", input)
+		return nil
+	}
+}
+
+func TestParseMalformedListsTerminate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"GET /users\n  |> input(id: path.id\n  |> respond 200", `3:3: unexpected |> ("|>") in input`},
+		{"GET /users\n  |> input(id: path.id, 42)\n  |> respond 200", `2:25: unexpected INT ("42") in input`},
+		{"GET /users\n  |> validate(id: int ~> 400)\n  |> respond 200", `2:23: unexpected ~> ("~>") in validate`},
+		{"GET /users\n  |> transform(id: int(id) \"x\")\n  |> respond 200", `2:28: unexpected STRING ("x") in transform`},
+		{"GET /users\n  |> fetch(User, { 1 })\n  |> respond 200", `2:20: unexpected INT ("1") in fetch arguments`},
+		{"GET /users\n  |> fetch(User, ~>)\n  |> respond 200", `2:18: unexpected ~> ("~>") in fetch arguments`},
+		{"GET /users\n  cache(max-age: 60, ~>)\n  |> respond 200", `2:22: unexpected ~> ("~>") in directive arguments`},
+		{"GET /users\n  |> respond 200 { a: 1 ~> 2 }", `2:25: unexpected ~> in body`},
+	}
+	for _, tt := range tests {
+		errs := parseBounded(t, tt.input)
+		if len(errs) == 0 || !strings.HasSuffix(errs[0], tt.want) {
+			t.Errorf("%q: expected first error to end with %q, got %v", tt.input, tt.want, errs)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Package resolve loads the local (@/) imports of a .rever file: it maps
+// each import to a file, parses it, and follows its own local imports,
+// reporting missing files, parse errors and import cycles.
+package resolve
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/parser"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+// LockFile marks the project root that @/ paths are relative to.
+const LockFile = "rever.lock.json"
+
+// Error is a local import that could not be loaded, reported at the import
+// declaration.
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+// Error formats the error like parser errors: "file:line:col: message".
+func (e Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Pos.File, e.Pos.Line, e.Pos.Column, e.Message)
+}
+
+// ProjectRoot returns the nearest ancestor of dir (dir included) containing
+// rever.lock.json, falling back to dir itself.
+func ProjectRoot(dir string) string {
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, LockFile)); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// LocalPath maps the "@/path" import source of the file at importer to the
+// .rever file it names. Directory imports resolve to their step.rever.
+func LocalPath(importer, source string) (string, error) {
+	root := ProjectRoot(filepath.Dir(importer))
+	target := filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(source, "@/")))
+	info, err := os.Stat(target)
+	if err != nil {
+		return "", fmt.Errorf("%s does not exist", target)
+	}
+	if info.IsDir() {
+		target = filepath.Join(target, "step.rever")
+		if _, err := os.Stat(target); err != nil {
+			return "", fmt.Errorf("%s has no step.rever", filepath.Dir(target))
+		}
+	}
+	return target, nil
+}
+
+// Unit is a local import loaded from disk.
+type Unit struct {
+	Path string
+	File *ast.File
+}
+
+type loader struct {
+	units  []*Unit
+	loaded map[string]bool
+	stack  []string // files being loaded, outermost first
+	errors []error
+}
+
+// Load loads the local imports of file, parsed from filename, and theirs in
+// turn. Each imported file is loaded once and returned in the order it was
+// first imported; errors cover missing files, parse errors of imported files
+// and import cycles.
+func Load(file *ast.File, filename string) ([]*Unit, []error) {
+	l := &loader{loaded: make(map[string]bool)}
+	path := absPath(filename)
+	l.loaded[path] = true
+	l.stack = []string{path}
+	l.loadImports(file, filename)
+	return l.units, l.errors
+}
+
+func (l *loader) loadImports(file *ast.File, filename string) {
+	for _, imp := range file.Imports {
+		if !imp.Local {
+			continue
+		}
+		target, err := LocalPath(filename, imp.Source)
+		if err != nil {
+			l.addError(imp.Pos, fmt.Sprintf("cannot resolve import %s: %v", imp.Source, err))
+			continue
+		}
+		target = absPath(target)
+		if i := l.stackIndex(target); i != -1 {
+			l.addError(imp.Pos, "import cycle: "+l.cycle(i))
+			continue
+		}
+		if l.loaded[target] {
+			continue
+		}
+		l.loaded[target] = true
+		l.load(target)
+	}
+}
+
+func (l *loader) load(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.errors = append(l.errors, err)
+		return
+	}
+	file, errs := parser.Parse(string(data), path)
+	for _, e := range errs {
+		l.errors = append(l.errors, e)
+	}
+	l.units = append(l.units, &Unit{Path: path, File: file})
+
+	l.stack = append(l.stack, path)
+	l.loadImports(file, path)
+	l.stack = l.stack[:len(l.stack)-1]
+}
+
+func (l *loader) addError(pos token.Position, msg string) {
+	l.errors = append(l.errors, Error{Pos: pos, Message: msg})
+}
+
+func (l *loader) stackIndex(path string) int {
+	for i, p := range l.stack {
+		if p == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// cycle renders the import chain from stack[i] back to itself.
+func (l *loader) cycle(i int) string {
+	names := make([]string, 0, len(l.stack)-i+1)
+	for _, p := range l.stack[i:] {
+		names = append(names, filepath.Base(p))
+	}
+	return strings.Join(append(names, filepath.Base(l.stack[i])), " -> ")
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
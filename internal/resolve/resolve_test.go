@@ -0,0 +1,114 @@
+package resolve
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/parser"
+)
+
+// writeFiles creates files (slash-separated paths relative to a temporary
+// project root containing rever.lock.json) and returns the root.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	files[LockFile] = "{}"
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func load(t *testing.T, filename string) ([]*Unit, []error) {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, errs := parser.Parse(string(data), filename)
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return Load(file, filename)
+}
+
+func TestLoadImportGraph(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"routes/users.rever": `import fetch = @/src/user/fetch.rever
+import store = @/steps/custom-cache
+
+GET /users/{id}
+  |> fetch(User, id) as user
+  |> respond 200 { id: user.id }`,
+		"src/user/fetch.rever":          "import store = @/steps/custom-cache\n\ntype User { id: int }",
+		"steps/custom-cache/step.rever": "type Entry { key: string }",
+	})
+
+	units, errs := load(t, filepath.Join(dir, "routes", "users.rever"))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(units) != 2 {
+		t.Fatalf("expected 2 loaded files, got %d", len(units))
+	}
+	if got := filepath.ToSlash(units[0].Path); !strings.HasSuffix(got, "/src/user/fetch.rever") {
+		t.Errorf("expected fetch.rever first, got %s", got)
+	}
+	if got := filepath.ToSlash(units[1].Path); !strings.HasSuffix(got, "/steps/custom-cache/step.rever") {
+		t.Errorf("expected the directory import to load step.rever, got %s", got)
+	}
+	if len(units[0].File.Types) != 1 || units[0].File.Types[0].Name != "User" {
+		t.Errorf("expected the imported file to be parsed, got %+v", units[0].File.Types)
+	}
+}
+
+func TestLoadImportCycle(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.rever": "import b = @/b.rever\n",
+		"b.rever": "import a = @/a.rever\n",
+	})
+
+	_, errs := load(t, filepath.Join(dir, "a.rever"))
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+	want := filepath.Join(dir, "b.rever") + ":1:1: import cycle: a.rever -> b.rever -> a.rever"
+	if errs[0].Error() != want {
+		t.Errorf("expected %q, got %q", want, errs[0].Error())
+	}
+}
+
+func TestLoadImportErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"main.rever": "import fetch = @/missing.rever\nimport bad = @/bad.rever\n",
+		"bad.rever":  "type {",
+	})
+
+	_, errs := load(t, filepath.Join(dir, "main.rever"))
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if e, ok := errs[0].(Error); !ok || e.Pos.Line != 1 || !strings.Contains(e.Message, "cannot resolve import @/missing.rever") {
+		t.Errorf("expected an unresolved import error on line 1, got %v", errs[0])
+	}
+	if e, ok := errs[1].(parser.Error); !ok || e.Pos.File != filepath.Join(dir, "bad.rever") {
+		t.Errorf("expected a parse error in bad.rever, got %v", errs[1])
+	}
+}
+
+func TestLoadIgnoresRemoteImports(t *testing.T) {
+	file := &ast.File{Imports: []*ast.ImportDecl{{Alias: "fetch", Source: "github.com/reverhttp/std-fetch", Version: "0.1.0"}}}
+	units, errs := Load(file, filepath.Join(t.TempDir(), "main.rever"))
+	if len(units) != 0 || len(errs) != 0 {
+		t.Errorf("expected remote imports to be skipped, got %v %v", units, errs)
+	}
+}
@@ -0,0 +1,589 @@
+// Package sema performs semantic checks on a parsed .rever file: references
+// to unbound names, unknown package aliases and pipelines, unused imports,
+// unread or undeclared path parameters, unused bindings, unreachable match
+// arms, invalid status codes and contradictory cache settings.
+package sema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/polidog/reverhttp/internal/ast"
+	"github.com/polidog/reverhttp/internal/token"
+)
+
+// Severity classifies a diagnostic.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityHint
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityHint:
+		return "hint"
+	}
+	return "error"
+}
+
+// Codes identifying diagnostics that tools act on (e.g. LSP quick fixes).
+const (
+	CodeUnknownPackage = "unknown-package"
+)
+
+// Diagnostic is a semantic issue spanning Pos..End on a single line.
+type Diagnostic struct {
+	Pos      token.Position
+	End      token.Position
+	Severity Severity
+	Message  string
+	Code     string // optional; one of the Code* constants
+}
+
+// String formats the diagnostic like parser errors: "file:line:col: message".
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.Pos.File, d.Pos.Line, d.Pos.Column, d.Message)
+}
+
+// literalValues are identifiers that never refer to a binding.
+var literalValues = map[string]bool{
+	"true":  true,
+	"false": true,
+	"null":  true,
+}
+
+// errorFlowNames are the implicit bindings available in error-flow bodies:
+// the validation errors and the request.
+var errorFlowNames = []string{"errors", "request", "path", "query"}
+
+type checker struct {
+	file      *ast.File
+	imports   map[string]bool
+	pipelines map[string]*ast.PipelineDecl
+	types     map[string]*ast.TypeDecl
+	expanding map[string]bool // pipelines whose steps are being checked
+	route     *ast.Route      // route being checked
+	params    map[string]bool // path parameters of route, true once read
+	binds     []*binding      // as bindings of route, in order
+	diags     []Diagnostic
+}
+
+// binding is a name bound with as in a route's own steps.
+type binding struct {
+	name string
+	pos  token.Position
+	read bool
+}
+
+// Check analyzes file and returns its semantic diagnostics.
+func Check(file *ast.File) []Diagnostic {
+	c := &checker{
+		file:      file,
+		imports:   make(map[string]bool),
+		pipelines: make(map[string]*ast.PipelineDecl),
+		types:     make(map[string]*ast.TypeDecl),
+		expanding: make(map[string]bool),
+	}
+	for _, td := range file.Types {
+		c.types[td.Name] = td
+	}
+	for _, imp := range file.Imports {
+		c.imports[imp.Alias] = true
+	}
+	for _, decl := range file.Pipelines {
+		c.pipelines[decl.Name] = decl
+	}
+	for _, r := range file.Routes {
+		c.checkRoute(r)
+	}
+	c.checkUnusedImports()
+	return c.diags
+}
+
+// checkUnusedImports warns about imports whose alias is never called, in a
+// route or in a named pipeline, including guard fallbacks and match arms.
+func (c *checker) checkUnusedImports() {
+	used := make(map[string]bool)
+	ast.Walk(c.file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.PkgCallStep); ok {
+			used[call.Pkg] = true
+		}
+		return true
+	})
+	for _, imp := range c.file.Imports {
+		if !used[imp.Alias] {
+			c.report(imp.AliasPos, len(imp.Alias), SeverityWarning, "unused import %q", imp.Alias)
+		}
+	}
+}
+
+// report records a diagnostic spanning width columns from pos and returns it
+// so callers can attach a code. A diagnostic already reported at pos, as
+// happens for a pipeline used by several routes, is returned instead of
+// being recorded twice.
+func (c *checker) report(pos token.Position, width int, severity Severity, format string, args ...interface{}) *Diagnostic {
+	end := pos
+	end.Column += width
+	if end.UTF16Column > 0 {
+		end.UTF16Column += width
+	}
+	d := Diagnostic{
+		Pos:      pos,
+		End:      end,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	for i := range c.diags {
+		if c.diags[i] == d {
+			return &c.diags[i]
+		}
+	}
+	c.diags = append(c.diags, d)
+	return &c.diags[len(c.diags)-1]
+}
+
+func (c *checker) checkRoute(route *ast.Route) {
+	scope := make(map[string]bool)
+	blocks := c.file.MethodDefaults
+	if c.file.Defaults != nil {
+		blocks = append([]*ast.DefaultsBlock{c.file.Defaults}, blocks...)
+	}
+	for _, block := range blocks {
+		if len(block.Methods) > 0 && !contains(block.Methods, route.Method) {
+			continue
+		}
+		for _, d := range block.Directives {
+			if d.Bind != "" {
+				scope[d.Bind] = true
+			}
+		}
+	}
+	for _, d := range route.EffectiveDirectives() {
+		if d.Bind != "" {
+			scope[d.Bind] = true
+		}
+	}
+
+	c.route = route
+	names := ast.PathParams(route.Path)
+	c.params = make(map[string]bool, len(names))
+	for _, name := range names {
+		c.params[name] = false
+	}
+	c.binds = nil
+	for _, step := range route.Steps {
+		c.checkStep(scope, step)
+	}
+	for _, name := range names {
+		if !c.params[name] {
+			c.report(route.Pos, len(route.Method), SeverityWarning,
+				"path parameter %q is never read by input", name)
+		}
+	}
+	dirs := c.directives(route)
+	// Directives such as cache(etag: hash(user)) read the bindings of the
+	// steps when the response is built.
+	for _, d := range dirs {
+		for _, arg := range d.Args {
+			c.readExpr(arg.Value)
+		}
+	}
+	for _, b := range c.binds {
+		if !b.read {
+			c.report(b.pos, len(b.name), SeverityWarning, "binding %q is never used", b.name)
+		}
+	}
+	c.checkCache(dirs)
+}
+
+// directives returns the directives in effect for route by name: its own and
+// its groups', then those of the defaults for its method, then the file-wide
+// defaults. The closest declaration wins.
+func (c *checker) directives(route *ast.Route) map[string]*ast.Directive {
+	dirs := make(map[string]*ast.Directive)
+	add := func(list []*ast.Directive) {
+		for _, d := range list {
+			if dirs[d.Name] == nil {
+				dirs[d.Name] = d
+			}
+		}
+	}
+	add(route.EffectiveDirectives())
+	for _, block := range c.file.MethodDefaults {
+		if contains(block.Methods, route.Method) {
+			add(block.Directives)
+		}
+	}
+	if c.file.Defaults != nil {
+		add(c.file.Defaults.Directives)
+	}
+	return dirs
+}
+
+// checkCache warns about a public cache on an authenticated route, which
+// lets shared caches serve one user's response to another, and about
+// lifetimes that no-store makes meaningless.
+func (c *checker) checkCache(dirs map[string]*ast.Directive) {
+	cache := dirs["cache"]
+	if cache == nil {
+		return
+	}
+	flags := make(map[string]bool)
+	for _, arg := range cache.Args {
+		switch v := arg.Value.StrVal; {
+		case arg.Name == "":
+			flags[v] = true
+		case v == "true" || v == "false":
+			flags[arg.Name] = v == "true"
+		}
+	}
+	if auth := dirs["auth"]; auth != nil && !isNone(auth) && flags["public"] && !flags["private"] && !flags["no-store"] {
+		c.report(cache.Pos, len(cache.Name), SeverityWarning,
+			"public cache on an authenticated route can leak responses through shared caches; use private")
+	}
+	if flags["no-store"] {
+		for _, arg := range cache.Args {
+			if arg.Name == "max-age" || arg.Name == "s-maxage" {
+				c.report(arg.Pos, len(arg.Name), SeverityWarning, "%s has no effect with no-store", arg.Name)
+			}
+		}
+	}
+}
+
+// isNone reports whether d is cors(none) or auth(none).
+func isNone(d *ast.Directive) bool {
+	return len(d.Args) > 0 && d.Args[0].Name == "none"
+}
+
+// checkInputSource marks the path parameter read by an input field. The
+// parser rejects undeclared parameters in a route's own steps; this catches
+// them in the steps of a used pipeline.
+func (c *checker) checkInputSource(route *ast.Route, f *ast.InputField) {
+	param, ok := strings.CutPrefix(f.From, "path.")
+	if !ok {
+		return
+	}
+	if idx := strings.Index(param, "."); idx != -1 {
+		param = param[:idx]
+	}
+	if _, declared := c.params[param]; !declared {
+		c.report(f.Pos, len(f.From), SeverityError,
+			"path parameter %q is not defined in route path %q", param, route.Path)
+		return
+	}
+	c.params[param] = true
+}
+
+// checkStep checks step against scope, then adds the names it binds.
+func (c *checker) checkStep(scope map[string]bool, step *ast.PipelineStep) {
+	switch step.Kind {
+	case ast.StepInput:
+		for _, f := range step.Input.Fields {
+			c.checkInputSource(c.route, f)
+			scope[f.Name] = true
+		}
+	case ast.StepValidate:
+		for _, rule := range step.Validate.Rules {
+			c.read(rule.Field)
+		}
+		c.checkValidate(step.Validate)
+	case ast.StepTransform:
+		for _, f := range step.Transform.Fields {
+			c.read(f.From)
+			scope[f.Name] = true
+		}
+	case ast.StepGuard:
+		c.checkRef(scope, step.Guard.Pos, step.Guard.Expr)
+		if step.Guard.Else != nil {
+			c.checkStep(scope, step.Guard.Else)
+		}
+	case ast.StepMatch:
+		c.checkMatch(scope, step.Match)
+	case ast.StepMap:
+		c.checkRef(scope, step.Map.Pos, step.Map.Source)
+		withElement := make(map[string]bool, len(scope)+1)
+		for name := range scope {
+			withElement[name] = true
+		}
+		withElement[ast.MapElement] = true
+		c.checkBody(withElement, step.Map.Body)
+	case ast.StepEnrich:
+		for i, src := range step.Enrich.Sources {
+			c.checkRef(scope, step.Enrich.SourcePos[i], src)
+		}
+	case ast.StepForward:
+		for i, name := range step.Forward.Params {
+			c.checkRef(scope, step.Forward.ParamPos[i], name)
+		}
+	case ast.StepUse:
+		c.checkUse(scope, step.Use)
+	case ast.StepPkgCall:
+		c.checkPkgCall(scope, step.PkgCall)
+	case ast.StepRespond:
+		c.checkRespond(scope, step.Respond)
+	}
+
+	if step.ErrorFlow != nil {
+		c.checkErrorFlow(scope, step.ErrorFlow)
+	}
+	if step.Bind != "" {
+		scope[step.Bind] = true
+		// A used pipeline's bindings may be read by other routes only.
+		if len(c.expanding) == 0 {
+			c.binds = append(c.binds, &binding{name: step.Bind, pos: step.BindPos})
+		}
+	}
+}
+
+func (c *checker) checkRespond(scope map[string]bool, r *ast.RespondStep) {
+	c.checkStatus(r.StatusPos, r.Status)
+	if r.StatusRef != "" {
+		c.checkRef(scope, r.StatusPos, r.StatusRef)
+	}
+	c.checkBody(scope, r.Body)
+	c.checkSchema(r)
+	c.checkBody(scope, r.Headers)
+	c.checkBody(scope, r.Cookies)
+}
+
+// checkUse checks the steps of the used pipeline as if they were written in
+// place, so they see and extend the route's scope.
+func (c *checker) checkUse(scope map[string]bool, use *ast.UseStep) {
+	decl := c.pipelines[use.Name]
+	switch {
+	case decl == nil:
+		c.report(use.Pos, len(use.Name), SeverityError, "undefined pipeline %q", use.Name)
+	case c.expanding[use.Name]:
+		c.report(use.Pos, len(use.Name), SeverityError, "pipeline %q uses itself", use.Name)
+	default:
+		c.expanding[use.Name] = true
+		for _, step := range decl.Steps {
+			c.checkStep(scope, step)
+		}
+		delete(c.expanding, use.Name)
+	}
+}
+
+// checkValidate reports a validate(Type) whose type is not declared here:
+// unlike a respond schema, the type must be local, as its fields become the
+// rules.
+func (c *checker) checkValidate(v *ast.ValidateStep) {
+	if v.Type != "" && c.types[v.Type] == nil {
+		c.report(v.TypePos, len(v.Type), SeverityError, "unknown type %q: validate needs a type declared in this file", v.Type)
+	}
+}
+
+func (c *checker) checkMatch(scope map[string]bool, m *ast.MatchStep) {
+	c.read(m.On)
+	// The subject may also name a path parameter directly.
+	if root := refRoot(m.On); root != "" && !bound(scope, root) {
+		if _, param := c.params[root]; !param {
+			c.report(m.OnPos, len(root), SeverityError, "unknown match subject %q", m.On)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var wildcard *ast.MatchArm
+
+	for _, arm := range m.Arms {
+		switch {
+		case wildcard != nil:
+			c.report(arm.Pos, 1, SeverityWarning,
+				"unreachable match arm: follows wildcard at line %d", wildcard.Pos.Line)
+		case arm.IsDefault:
+			wildcard = arm
+		default:
+			for _, v := range patternValues(arm.Pattern) {
+				if seen[v] {
+					c.report(arm.Pos, 1, SeverityWarning,
+						"unreachable match arm: pattern %q is already matched", v)
+					break
+				}
+			}
+			// A guarded arm may fall through, so it does not shadow later arms.
+			if arm.When == "" {
+				for _, v := range patternValues(arm.Pattern) {
+					seen[v] = true
+				}
+			}
+		}
+
+		if arm.When != "" {
+			c.checkRef(scope, arm.WhenPos, arm.When)
+		}
+
+		if arm.Step != nil {
+			c.checkPkgCall(scope, arm.Step)
+		}
+		if arm.Respond != nil {
+			c.checkRespond(scope, arm.Respond)
+		}
+		if arm.VarRef != "" {
+			c.checkRef(scope, arm.Pos, arm.VarRef)
+		}
+		if arm.ErrorFlow != nil {
+			c.checkErrorFlow(scope, arm.ErrorFlow)
+		}
+	}
+}
+
+// patternValues lists the literal values a pattern matches, keyed so that
+// equal patterns compare equal. Ranges and regexes are keyed by their source.
+func patternValues(p ast.Pattern) []string {
+	switch p.Kind {
+	case ast.PatternLiteral:
+		return []string{p.Value}
+	case ast.PatternMulti:
+		return p.Values
+	case ast.PatternRange:
+		return []string{p.RangeMin + ".." + p.RangeMax}
+	case ast.PatternRegex:
+		return []string{"/" + p.Regex + "/"}
+	}
+	return nil
+}
+
+func (c *checker) checkPkgCall(scope map[string]bool, call *ast.PkgCallStep) {
+	if !c.imports[call.Pkg] {
+		c.report(call.Pos, len(call.Pkg), SeverityError,
+			"unknown package %q: no import declares this alias", call.Pkg).Code = CodeUnknownPackage
+	}
+	for _, arg := range call.Args {
+		c.readExpr(arg.Value)
+		for i, ref := range arg.Refs {
+			c.checkRef(scope, arg.RefPos[i], ref)
+		}
+	}
+}
+
+// checkSchema warns about body keys that the declared body type does not
+// have. A type not declared in this file may come from an import and is not
+// checked.
+func (c *checker) checkSchema(r *ast.RespondStep) {
+	td := c.types[r.Schema]
+	if td == nil {
+		return
+	}
+	fields := make(map[string]bool, len(td.Fields))
+	for _, f := range td.Fields {
+		fields[f.Name] = true
+	}
+	for _, f := range r.Body {
+		if !f.Spread && !fields[f.Key] {
+			c.report(f.KeyPos, len(f.Key), SeverityWarning, "field %q is not declared in type %s", f.Key, td.Name)
+		}
+	}
+}
+
+func (c *checker) checkErrorFlow(scope map[string]bool, ef *ast.ErrorFlow) {
+	c.checkStatus(ef.StatusPos, ef.Status)
+
+	withErrors := make(map[string]bool, len(scope)+len(errorFlowNames))
+	for name := range scope {
+		withErrors[name] = true
+	}
+	for _, name := range errorFlowNames {
+		withErrors[name] = true
+	}
+	c.checkBody(withErrors, ef.Body)
+}
+
+func (c *checker) checkBody(scope map[string]bool, fields []*ast.BodyField) {
+	for _, f := range fields {
+		switch v := f.Value.(type) {
+		case []*ast.BodyField:
+			c.checkBody(scope, v)
+		case ast.BodyList:
+			c.checkBody(scope, v)
+		case string:
+			if !f.IsString && v != "" {
+				c.checkRef(scope, f.Pos, v)
+			}
+		case nil:
+			// A shorthand field such as { user } reads the name of its key.
+			c.read(f.Key)
+		}
+		for i, ref := range f.Refs {
+			c.checkRef(scope, f.RefPos[i], ref)
+		}
+	}
+}
+
+// checkRef reports expr when the root of its dotted name is not bound.
+func (c *checker) checkRef(scope map[string]bool, pos token.Position, expr string) {
+	c.read(expr)
+	root := refRoot(expr)
+	if root == "" || bound(scope, root) {
+		return
+	}
+	c.report(pos, len(root), SeverityError, "undefined name %q", root)
+}
+
+// read marks the latest binding of the root of expr as used.
+func (c *checker) read(expr string) {
+	root := refRoot(expr)
+	for i := len(c.binds) - 1; i >= 0; i-- {
+		if c.binds[i].name == root {
+			c.binds[i].read = true
+			return
+		}
+	}
+}
+
+// readExpr marks the bindings an argument refers to as used: user in
+// user.id, in hash(user) or in [user.id, user.updated_at].
+func (c *checker) readExpr(e ast.Expr) {
+	switch e.Kind {
+	case ast.ExprIdent:
+		c.read(e.StrVal)
+	case ast.ExprFuncCall:
+		if _, arg, ok := strings.Cut(strings.TrimSuffix(e.StrVal, ")"), "("); ok {
+			c.read(arg)
+		}
+	case ast.ExprObject, ast.ExprList:
+		for _, name := range e.ListVal {
+			c.read(name)
+		}
+	}
+}
+
+// refRoot returns the first part of a dotted name: user for user.role.
+func refRoot(expr string) string {
+	root, _, _ := strings.Cut(expr, ".")
+	return root
+}
+
+// bound reports whether name is in scope or is a literal.
+func bound(scope map[string]bool, name string) bool {
+	return scope[name] || literalValues[name] || isNumber(name)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+func (c *checker) checkStatus(pos token.Position, status string) {
+	if status == "" {
+		return
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 100 || code > 599 {
+		c.report(pos, len(status), SeverityError, "status code %s is out of range (100-599)", status)
+	}
+}
@@ -0,0 +1,602 @@
+package sema
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/polidog/reverhttp/internal/lexer"
+	"github.com/polidog/reverhttp/internal/parser"
+)
+
+func check(t *testing.T, input string) []Diagnostic {
+	t.Helper()
+	p := parser.New(lexer.New(input, "test.rever"))
+	f := p.ParseFile()
+	if errs := p.Errors(); len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+	return Check(f)
+}
+
+func expectOne(t *testing.T, diags []Diagnostic, severity Severity, substr string) Diagnostic {
+	t.Helper()
+	if len(diags) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %d: %v", len(diags), diags)
+	}
+	d := diags[0]
+	if d.Severity != severity {
+		t.Errorf("expected severity %s, got %s", severity, d.Severity)
+	}
+	if !strings.Contains(d.Message, substr) {
+		t.Errorf("expected message containing %q, got %q", substr, d.Message)
+	}
+	return d
+}
+
+func TestCheckClean(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+GET /users/{id}
+  auth(bearer) as current_user
+  |> input(id: path.id)
+  |> validate(id: int & min(1))          ~> 400 { error: "invalid id", details: errors }
+  |> fetch(User, id) as user             ~> 404 { error: "user not found" }
+  |> guard user.active                   ~> 403 { error: "inactive" }
+  |> respond 200 { id: user.id, by: current_user.id, ok: true }`
+
+	if diags := check(t, input); len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", diags)
+	}
+}
+
+func TestCheckUnboundReference(t *testing.T) {
+	input := `GET /users
+  |> respond 200 { id: user.id }`
+
+	d := expectOne(t, check(t, input), SeverityError, `undefined name "user"`)
+	if d.Pos.Line != 2 || d.Pos.Column != 24 || d.End.Column != 28 {
+		t.Errorf("expected 2:24-28, got %d:%d-%d", d.Pos.Line, d.Pos.Column, d.End.Column)
+	}
+}
+
+func TestCheckMapElement(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+GET /users
+  |> fetch(User) as users
+  |> map(users, { id: it.id, team: team.name }) as result
+  |> map(missing, { id: it.id })
+  |> respond 200 { users: result, first: it.id }`
+
+	diags := check(t, input)
+	want := []string{`undefined name "team"`, `undefined name "missing"`, `undefined name "it"`}
+	if len(diags) != len(want) {
+		t.Fatalf("expected %d diagnostics, got %v", len(want), diags)
+	}
+	for i, msg := range want {
+		if diags[i].Message != msg {
+			t.Errorf("diagnostic %d: expected %q, got %q", i, msg, diags[i].Message)
+		}
+	}
+}
+
+func TestCheckEnrichSources(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> enrich(user, profile) as full
+  |> respond 200 { user: full }`
+
+	d := expectOne(t, check(t, input), SeverityError, `undefined name "profile"`)
+	if d.Pos.Line != 6 || d.Pos.Column != 19 {
+		t.Errorf("expected 6:19, got %d:%d", d.Pos.Line, d.Pos.Column)
+	}
+}
+
+func TestCheckForwardPlaceholders(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> forward("http://users-svc/users/{id}/{tenant}") as upstream
+  |> respond upstream.status { ...upstream.body }`
+
+	d := expectOne(t, check(t, input), SeverityError, `undefined name "tenant"`)
+	if d.Pos.Line != 3 || d.Pos.Column != 44 {
+		t.Errorf("expected 3:44, got %d:%d", d.Pos.Line, d.Pos.Column)
+	}
+}
+
+func TestCheckStringPlaceholders(t *testing.T) {
+	input := `import redis-cache = github.com/reverhttp/std-redis-cache@0.1.0
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> redis-cache(key: "user:{id}:{tenant}") as user
+  |> respond 200 { url: "/users/{user.id}", note: "\{raw\}" }`
+
+	d := expectOne(t, check(t, input), SeverityError, `undefined name "tenant"`)
+	if d.Pos.Line != 5 || d.Pos.Column != 35 {
+		t.Errorf("expected 5:35, got %d:%d", d.Pos.Line, d.Pos.Column)
+	}
+}
+
+func TestCheckMethodDefaultsBinding(t *testing.T) {
+	input := `defaults for POST { auth(bearer) as user }
+
+POST /posts
+  |> respond 201 { author: user.id }
+
+GET /posts
+  |> respond 200 { viewer: user.id }`
+
+	d := expectOne(t, check(t, input), SeverityError, `undefined name "user"`)
+	if d.Pos.Line != 7 {
+		t.Errorf("expected the GET route to be reported, got line %d", d.Pos.Line)
+	}
+}
+
+func TestCheckPipelineUse(t *testing.T) {
+	input := `pipeline userInput {
+  |> input(name: body.name)
+  |> guard label ~> 400
+}
+
+POST /users
+  |> use userInput
+  |> use missing
+  |> respond 201 { name: name }
+
+PUT /users
+  |> use userInput
+  |> respond 200 { name: name }`
+
+	diags := check(t, input)
+	want := []string{`undefined name "label"`, `undefined pipeline "missing"`}
+	if len(diags) != len(want) {
+		t.Fatalf("expected %d diagnostics, got %v", len(want), diags)
+	}
+	for i, msg := range want {
+		if diags[i].Message != msg {
+			t.Errorf("diagnostic %d: expected %q, got %q", i, msg, diags[i].Message)
+		}
+	}
+	if d := diags[1]; d.Pos.Line != 8 || d.Pos.Column != 10 || d.End.Column != 17 {
+		t.Errorf("expected 8:10-17, got %d:%d-%d", d.Pos.Line, d.Pos.Column, d.End.Column)
+	}
+}
+
+func TestCheckErrorFlowImplicitNames(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> validate(id: int)  ~> 400 { details: errors, id: path.id, page: query.page, ip: request.ip }
+  |> respond 200 { id: path.id }`
+
+	d := expectOne(t, check(t, input), SeverityError, `undefined name "path"`)
+	if d.Pos.Line != 4 {
+		t.Errorf("expected error on line 4, got %d", d.Pos.Line)
+	}
+}
+
+func TestCheckUnboundReferenceInNestedBody(t *testing.T) {
+	input := `GET /users
+  |> respond 200 { data: { id: user.id } }`
+
+	d := expectOne(t, check(t, input), SeverityError, `undefined name "user"`)
+	if d.Pos.Line != 2 || d.Pos.Column != 32 {
+		t.Errorf("expected 2:32, got %d:%d", d.Pos.Line, d.Pos.Column)
+	}
+}
+
+func TestCheckReferenceBeforeBinding(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> guard user
+  |> fetch(User, id) as user
+  |> respond 200 { id: user.id }`
+
+	d := expectOne(t, check(t, input), SeverityError, `undefined name "user"`)
+	if d.Pos.Line != 5 {
+		t.Errorf("expected line 5, got %d", d.Pos.Line)
+	}
+}
+
+func TestCheckUnknownPackage(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { id: user.id }`
+
+	d := expectOne(t, check(t, input), SeverityError, `unknown package "fetch"`)
+	if d.Pos.Line != 3 || d.Pos.Column != 6 {
+		t.Errorf("expected 3:6, got %d:%d", d.Pos.Line, d.Pos.Column)
+	}
+}
+
+func TestCheckUnknownPackageInMatchArm(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+GET /accounts
+  |> input(role: header.x-role)
+  |> match role {
+       "user":  fetch(User, role)
+       "admin": lookup(Admin, role)
+     } as account
+  |> respond 200 { id: account.id }`
+
+	expectOne(t, check(t, input), SeverityError, `unknown package "lookup"`)
+}
+
+func TestCheckUnusedImport(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+import store = @/src/store.rever
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { id: user.id }`
+
+	d := expectOne(t, check(t, input), SeverityWarning, `unused import "store"`)
+	if d.Pos.Line != 2 || d.Pos.Column != 8 || d.End.Column != 13 {
+		t.Errorf("expected 2:8-13, got %d:%d-%d", d.Pos.Line, d.Pos.Column, d.End.Column)
+	}
+}
+
+func TestCheckImportUsedInPipelineAndMatchArm(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+import lookup = github.com/reverhttp/std-lookup@0.1.0
+
+pipeline load {
+  |> fetch(User, id) as user
+}
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> match id {
+       "me": lookup(User, id)
+       _:    ~> 404 { error: "not found" }
+     }
+  |> respond 200`
+
+	if diags := check(t, input); len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", diags)
+	}
+}
+
+func TestCheckUnreadPathParam(t *testing.T) {
+	input := `GET /orgs/{org}/users/{id}
+  |> input(id: path.id)
+  |> respond 200 { id: id }`
+
+	d := expectOne(t, check(t, input), SeverityWarning, `path parameter "org" is never read by input`)
+	if d.Pos.Line != 1 || d.Pos.Column != 1 || d.End.Column != 4 {
+		t.Errorf("expected 1:1-4, got %d:%d-%d", d.Pos.Line, d.Pos.Column, d.End.Column)
+	}
+}
+
+func TestCheckPipelineInputFromUndeclaredPathParam(t *testing.T) {
+	input := `pipeline load_member {
+  |> input(id: path.id, org: path.org)
+}
+
+GET /users/{id}
+  |> use load_member
+  |> respond 200 { id: id, org: org }`
+
+	d := expectOne(t, check(t, input), SeverityError, `path parameter "org" is not defined in route path "/users/{id}"`)
+	if d.Pos.Line != 2 || d.Pos.Column != 30 || d.End.Column != 38 {
+		t.Errorf("expected 2:30-38, got %d:%d-%d", d.Pos.Line, d.Pos.Column, d.End.Column)
+	}
+}
+
+func TestCheckPathParamReadInPipeline(t *testing.T) {
+	input := `pipeline load_user {
+  |> input(id: path.id)
+}
+
+GET /users/{id}
+  |> use load_user
+  |> respond 200 { id: id }`
+
+	if diags := check(t, input); len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", diags)
+	}
+}
+
+func TestCheckUnusedBinding(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+GET /users/{id}
+  auth(bearer) as current_user
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { ok: "true" }`
+
+	d := expectOne(t, check(t, input), SeverityWarning, `binding "user" is never used`)
+	if d.Pos.Line != 6 || d.Pos.Column != 25 || d.End.Column != 29 {
+		t.Errorf("expected 6:25-29, got %d:%d-%d", d.Pos.Line, d.Pos.Column, d.End.Column)
+	}
+}
+
+func TestCheckUsedBindings(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+import update = github.com/reverhttp/std-update@0.1.0
+
+pipeline load {
+  |> fetch(User, id) as loaded
+}
+
+GET /users/{id}
+  cache(max-age: 60, private, etag: hash(etag_source))
+  |> input(id: path.id)
+  |> use load
+  |> fetch(User, id) as user
+  |> fetch(Team, user.team_id) as team
+  |> guard team ~> 404 { error: "no team" }
+  |> fetch(Role, id) as role
+  |> match role.name {
+       "admin": respond 200 { user }
+       _:       update(User, id, { role })
+     }
+  |> fetch(Tag, id) as etag_source
+  |> respond 200 { id: user.id }`
+
+	diags := check(t, input)
+	for _, d := range diags {
+		if strings.Contains(d.Message, "never used") {
+			t.Errorf("unexpected warning: %v", d)
+		}
+	}
+}
+
+func TestCheckBindingReadByETagFields(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+GET /users/{id}
+  cache(max-age: 60, etag: [user.id, user.updated_at])
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200 { id: id }`
+
+	for _, d := range check(t, input) {
+		if strings.Contains(d.Message, "never used") {
+			t.Errorf("unexpected warning: %v", d)
+		}
+	}
+}
+
+func TestCheckBindingReadByShorthand(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+GET /users
+  |> fetch(User) as users
+  |> respond 200 { users }`
+
+	for _, d := range check(t, input) {
+		t.Errorf("unexpected diagnostic: %v", d)
+	}
+}
+
+func TestCheckUnusedBindingBeforeRebind(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+PUT /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> fetch(User, id) as user
+  |> respond 200 { id: user.id }`
+
+	d := expectOne(t, check(t, input), SeverityWarning, `binding "user" is never used`)
+	if d.Pos.Line != 5 {
+		t.Errorf("expected the first binding on line 5 to be reported, got line %d", d.Pos.Line)
+	}
+}
+
+func TestCheckUnreachableArmAfterWildcard(t *testing.T) {
+	input := `GET /accounts
+  |> input(role: header.x-role)
+  |> match role {
+       _:       ~> 400 { error: "unknown role" }
+       "admin": ~> 403 { error: "forbidden" }
+     }
+  |> respond 200 { role: role }`
+
+	d := expectOne(t, check(t, input), SeverityWarning, "unreachable match arm")
+	if d.Pos.Line != 5 {
+		t.Errorf("expected line 5, got %d", d.Pos.Line)
+	}
+}
+
+func TestCheckUnreachableDuplicatePattern(t *testing.T) {
+	input := `GET /accounts
+  |> input(role: header.x-role)
+  |> match role {
+       "user", "admin": ~> 400 { error: "a" }
+       "admin":         ~> 403 { error: "b" }
+     }
+  |> respond 200 { role: role }`
+
+	expectOne(t, check(t, input), SeverityWarning, `pattern "admin" is already matched`)
+}
+
+func TestCheckStatusOutOfRange(t *testing.T) {
+	input := `GET /health
+  |> respond 700 { status: "ok" }`
+
+	d := expectOne(t, check(t, input), SeverityError, "status code 700")
+	if d.Pos.Line != 2 || d.Pos.Column != 14 {
+		t.Errorf("expected 2:14, got %d:%d", d.Pos.Line, d.Pos.Column)
+	}
+}
+
+func TestCheckErrorFlowStatusOutOfRange(t *testing.T) {
+	input := `GET /users/{id}
+  |> input(id: path.id)
+  |> validate(id: int)   ~> 40 { error: "invalid id" }
+  |> respond 200 { id: id }`
+
+	expectOne(t, check(t, input), SeverityError, "status code 40")
+}
+
+func TestCheckGuardedArmDoesNotShadow(t *testing.T) {
+	input := `GET /accounts
+  |> input(role: header.x-role, active: header.x-active)
+  |> match role {
+       "admin" when active: ~> 400 { error: "a" }
+       "admin":             ~> 403 { error: "b" }
+     }
+  |> respond 200 { role: role }`
+
+	if diags := check(t, input); len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", diags)
+	}
+}
+
+func TestCheckUnboundWhen(t *testing.T) {
+	input := `GET /accounts
+  |> input(role: header.x-role)
+  |> match role {
+       "admin" when account.active: ~> 400 { error: "a" }
+     }
+  |> respond 200 { role: role }`
+
+	d := expectOne(t, check(t, input), SeverityError, `undefined name "account"`)
+	if d.Pos.Line != 4 || d.Pos.Column != 21 {
+		t.Errorf("expected 4:21, got %d:%d", d.Pos.Line, d.Pos.Column)
+	}
+}
+
+func TestCheckMatchSubject(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+GET /users/{id}/{kind}
+  |> input(id: path.id, kind: path.kind)
+  |> fetch(User, id) as user
+  |> match user.role {
+       "admin": ~> 403 { error: "forbidden" }
+     }
+  |> match kind {
+       "full": ~> 400 { error: "unsupported" }
+     }
+  |> respond 200 { id: user.id }`
+
+	if diags := check(t, input); len(diags) != 0 {
+		t.Fatalf("expected bound subjects to resolve, got %v", diags)
+	}
+}
+
+func TestCheckUnknownMatchSubject(t *testing.T) {
+	input := `GET /accounts
+  |> match account.role {
+       "admin": ~> 403 { error: "forbidden" }
+     }
+  |> respond 200`
+
+	d := expectOne(t, check(t, input), SeverityError, `unknown match subject "account.role"`)
+	if d.Pos.Line != 2 || d.Pos.Column != 12 || d.End.Column != 19 {
+		t.Errorf("expected 2:12-19, got %d:%d-%d", d.Pos.Line, d.Pos.Column, d.End.Column)
+	}
+}
+
+func TestCheckValidateUnknownType(t *testing.T) {
+	input := `type User { name: string }
+
+POST /users
+  |> validate(User)
+  |> validate(Account)
+  |> respond 201`
+
+	d := expectOne(t, check(t, input), SeverityError, `unknown type "Account"`)
+	if d.Pos.Line != 5 || d.Pos.Column != 15 || d.End.Column != 22 {
+		t.Errorf("expected 5:15-22, got %d:%d-%d", d.Pos.Line, d.Pos.Column, d.End.Column)
+	}
+}
+
+func TestCheckPublicCacheOnAuthenticatedRoute(t *testing.T) {
+	input := `defaults
+  auth(bearer)
+
+GET /users
+  cache(max-age: 60, public)
+  |> respond 200
+
+GET /teams
+  cache(max-age: 60, public, private)
+  |> respond 200
+
+GET /health
+  cache(max-age: 60, public)
+  auth(none)
+  |> respond 200`
+
+	d := expectOne(t, check(t, input), SeverityWarning, "public cache on an authenticated route can leak responses through shared caches; use private")
+	if d.Pos.Line != 5 || d.Pos.Column != 3 || d.End.Column != 8 {
+		t.Errorf("expected 5:3-5:8, got %d:%d-%d", d.Pos.Line, d.Pos.Column, d.End.Column)
+	}
+}
+
+func TestCheckNoStoreWithMaxAge(t *testing.T) {
+	input := `GET /users
+  cache(no-store, max-age: 60)
+  |> respond 200`
+
+	d := expectOne(t, check(t, input), SeverityWarning, "max-age has no effect with no-store")
+	if d.Pos.Line != 2 || d.Pos.Column != 19 {
+		t.Errorf("expected 2:19, got %d:%d", d.Pos.Line, d.Pos.Column)
+	}
+}
+
+func TestCheckNoStoreFlagValue(t *testing.T) {
+	input := `GET /users
+  cache(no-store: true, max-age: 60)
+  |> respond 200
+
+GET /teams
+  cache(no-store: false, max-age: 60)
+  |> respond 200`
+
+	d := expectOne(t, check(t, input), SeverityWarning, "max-age has no effect with no-store")
+	if d.Pos.Line != 2 {
+		t.Errorf("expected the warning on line 2 only, got %d", d.Pos.Line)
+	}
+}
+
+func TestCheckRespondSchemaKeys(t *testing.T) {
+	input := `import fetch = github.com/reverhttp/std-fetch@0.1.0
+
+type User {
+  id: int
+  name: string
+}
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> fetch(User, id) as user
+  |> respond 200: User { id: user.id, name: user.name, nickname: user.name }`
+
+	d := expectOne(t, check(t, input), SeverityWarning, `field "nickname" is not declared in type User`)
+	if d.Pos.Line != 11 || d.Pos.Column != 56 || d.End.Column != 64 {
+		t.Errorf("expected 11:56-11:64, got %d:%d-%d", d.Pos.Line, d.Pos.Column, d.End.Column)
+	}
+}
+
+func TestCheckRespondSchemaSubset(t *testing.T) {
+	input := `type User {
+  id: int
+  name: string
+}
+
+GET /users/{id}
+  |> input(id: path.id)
+  |> respond 200: User { id: id }
+
+GET /teams/{id}
+  |> input(id: path.id)
+  |> respond 200: Team { id: id, anything: id }`
+
+	if diags := check(t, input); len(diags) != 0 {
+		t.Fatalf("expected a subset of the fields and an undeclared type to pass, got %v", diags)
+	}
+}
@@ -11,21 +11,27 @@ const (
 	// Literals
 	IDENT  // identifier (including hyphenated like redis-cache)
 	INT    // 123
+	FLOAT  // 1.5
 	STRING // "hello"
 	REGEX  // /pattern/
+	PATH   // /users/{id}, after an HTTP method or group
 
 	// Operators and delimiters
 	PIPE      // |>
 	ERROR     // ~>
 	AMPERSAND // &
 	RANGE     // ..
+	SPREAD    // ...
 	COLON     // :
 	COMMA     // ,
 	DOT       // .
 	BANG      // !
 	ASSIGN    // =
+	QUESTION  // ?
+	COALESCE  // ??
 	AT        // @
 	SLASH     // /
+	STAR      // * (catch-all path segment)
 
 	LPAREN   // (
 	RPAREN   // )
@@ -40,6 +46,10 @@ const (
 	IMPORT
 	TYPE
 	DEFAULTS
+	META
+	GROUP
+	PIPELINE
+	USE
 	AS
 	MATCH
 	GUARD
@@ -47,12 +57,23 @@ const (
 	INPUT
 	VALIDATE
 	TRANSFORM
+	MAP
+	ENRICH
+	FORWARD
 	WITH
 	HEADERS
+	COOKIES
 	CACHE
 	CORS
 	AUTH
+	RETRY
+	PAGINATE
+	ACCEPTS
+	COMPRESS
 	NONE
+	ELSE
+	WHEN
+	ENUM
 
 	// HTTP methods
 	GET
@@ -70,19 +91,25 @@ var typeNames = map[Type]string{
 	NEWLINE:    "NEWLINE",
 	IDENT:      "IDENT",
 	INT:        "INT",
+	FLOAT:      "FLOAT",
 	STRING:     "STRING",
 	REGEX:      "REGEX",
+	PATH:       "PATH",
 	PIPE:       "|>",
 	ERROR:      "~>",
 	AMPERSAND:  "&",
 	RANGE:      "..",
+	SPREAD:     "...",
 	COLON:      ":",
 	COMMA:      ",",
 	DOT:        ".",
 	BANG:       "!",
 	ASSIGN:     "=",
+	QUESTION:   "?",
+	COALESCE:   "??",
 	AT:         "@",
 	SLASH:      "/",
+	STAR:       "*",
 	LPAREN:     "(",
 	RPAREN:     ")",
 	LBRACE:     "{",
@@ -93,6 +120,10 @@ var typeNames = map[Type]string{
 	IMPORT:     "import",
 	TYPE:       "type",
 	DEFAULTS:   "defaults",
+	META:       "meta",
+	GROUP:      "group",
+	PIPELINE:   "pipeline",
+	USE:        "use",
 	AS:         "as",
 	MATCH:      "match",
 	GUARD:      "guard",
@@ -100,12 +131,23 @@ var typeNames = map[Type]string{
 	INPUT:      "input",
 	VALIDATE:   "validate",
 	TRANSFORM:  "transform",
+	MAP:        "map",
+	ENRICH:     "enrich",
+	FORWARD:    "forward",
 	WITH:       "with",
 	HEADERS:    "headers",
+	COOKIES:    "cookies",
 	CACHE:      "cache",
 	CORS:       "cors",
 	AUTH:       "auth",
+	RETRY:      "retry",
+	PAGINATE:   "paginate",
+	ACCEPTS:    "accepts",
+	COMPRESS:   "compress",
 	NONE:       "none",
+	ELSE:       "else",
+	WHEN:       "when",
+	ENUM:       "enum",
 	GET:        "GET",
 	POST:       "POST",
 	PUT:        "PUT",
@@ -126,6 +168,10 @@ var keywords = map[string]Type{
 	"import":    IMPORT,
 	"type":      TYPE,
 	"defaults":  DEFAULTS,
+	"meta":      META,
+	"group":     GROUP,
+	"pipeline":  PIPELINE,
+	"use":       USE,
 	"as":        AS,
 	"match":     MATCH,
 	"guard":     GUARD,
@@ -133,12 +179,23 @@ var keywords = map[string]Type{
 	"input":     INPUT,
 	"validate":  VALIDATE,
 	"transform": TRANSFORM,
+	"map":       MAP,
+	"enrich":    ENRICH,
+	"forward":   FORWARD,
 	"with":      WITH,
 	"headers":   HEADERS,
+	"cookies":   COOKIES,
 	"cache":     CACHE,
 	"cors":      CORS,
 	"auth":      AUTH,
+	"retry":     RETRY,
+	"paginate":  PAGINATE,
+	"accepts":   ACCEPTS,
+	"compress":  COMPRESS,
 	"none":      NONE,
+	"else":      ELSE,
+	"when":      WHEN,
+	"enum":      ENUM,
 	"GET":       GET,
 	"POST":      POST,
 	"PUT":       PUT,
@@ -165,11 +222,19 @@ func IsHTTPMethod(t Type) bool {
 	return false
 }
 
-// Position represents a source location.
+// IsKeyword returns true if the token type is a reserved word, including HTTP methods.
+func IsKeyword(t Type) bool {
+	return t >= IMPORT && t <= OPTIONS
+}
+
+// Position represents a source location. Column counts runes, as people
+// do; UTF16Column counts UTF-16 code units, as LSP clients do. Both are
+// 1-based and differ only on lines with non-ASCII text.
 type Position struct {
-	File   string
-	Line   int
-	Column int
+	File        string
+	Line        int
+	Column      int
+	UTF16Column int // 0 when unknown; use Column then
 }
 
 // Token represents a lexical token with its position and literal value.
@@ -177,4 +242,6 @@ type Token struct {
 	Type    Type
 	Literal string
 	Pos     Position
+	End     Position // just after the token's last character in the source
+	Doc     string   // leading comment block, set only when the lexer captures comments
 }
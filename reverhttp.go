@@ -0,0 +1,65 @@
+// Package reverhttp compiles ReverHTTP (.rever) source into its JSON IR.
+//
+// It is the public entry point for tools embedding the compiler; the
+// lexer, parser and generator live under internal/ and may change freely.
+package reverhttp
+
+import (
+	"github.com/polidog/reverhttp/internal/gen"
+	"github.com/polidog/reverhttp/internal/ir"
+	"github.com/polidog/reverhttp/internal/parser"
+	"github.com/polidog/reverhttp/internal/resolve"
+	"github.com/polidog/reverhttp/internal/sema"
+)
+
+// Root is the compiled IR of a file; marshal it with encoding/json.
+type Root = ir.Root
+
+// Options control optional IR transformations; see the reverc flags.
+type Options = gen.Options
+
+// Compile parses src as the file filename, checks it as reverc does and
+// generates its IR. Local imports are resolved relative to filename. Parse,
+// import and semantic errors stop compilation and are returned without a
+// Root; generation errors and the warnings of every stage are returned
+// alongside the Root they were found in. Editor hints are not reported.
+func Compile(src, filename string) (*Root, []error) {
+	return CompileWithOptions(src, filename, Options{})
+}
+
+// CompileWithOptions is like Compile with IR transformations enabled.
+func CompileWithOptions(src, filename string, opts Options) (*Root, []error) {
+	file, parseErrs := parser.Parse(src, filename)
+	if len(parseErrs) > 0 {
+		errs := make([]error, len(parseErrs))
+		for i, e := range parseErrs {
+			errs[i] = e
+		}
+		return nil, errs
+	}
+	if _, errs := resolve.Load(file, filename); len(errs) > 0 {
+		return nil, errs
+	}
+
+	var errs []error
+	failed := false
+	for _, d := range sema.Check(file) {
+		switch d.Severity {
+		case sema.SeverityError:
+			failed = true
+		case sema.SeverityHint:
+			continue
+		}
+		// gen.Error formats a diagnostic the way reverc prints it.
+		errs = append(errs, gen.Error{Pos: d.Pos, Message: d.Message, Warning: d.Severity == sema.SeverityWarning})
+	}
+	if failed {
+		return nil, errs
+	}
+
+	root, genErrs := gen.GenerateWithOptions(file, opts)
+	for _, e := range genErrs {
+		errs = append(errs, e)
+	}
+	return root, errs
+}
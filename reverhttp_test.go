@@ -0,0 +1,87 @@
+package reverhttp
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	root, errs := Compile("GET /users/{id}\n  |> input(id: path.id)\n  |> respond 200 { id: id }", "users.rever")
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	data, err := json.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"route":{"method":"GET","path":"/users/{id}"}`) {
+		t.Fatalf("expected the route in the IR, got %s", data)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	root, errs := Compile("GET /users\n  |> 42", "users.rever")
+	if root != nil {
+		t.Fatal("expected no IR when parsing fails")
+	}
+	if len(errs) == 0 || !strings.HasPrefix(errs[0].Error(), "users.rever:2:6: ") {
+		t.Fatalf("expected a parse error at 2:6, got %v", errs)
+	}
+
+	root, errs = Compile("GET /users\n  |> fetch(User) as users\n  |> respond 200 { users: users }", "users.rever")
+	if root != nil {
+		t.Fatal("expected no IR when checking fails")
+	}
+	if len(errs) != 1 || errs[0].Error() != `users.rever:2:6: unknown package "fetch": no import declares this alias` {
+		t.Fatalf("expected an unknown package error, got %v", errs)
+	}
+
+	root, errs = Compile("GET /users\n  |> input(q: query.q)", "users.rever")
+	if root == nil || len(root.Routes) != 1 {
+		t.Fatalf("expected IR alongside generation errors, got %+v", root)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "route GET /users has no response") {
+		t.Fatalf("expected missing response error, got %v", errs)
+	}
+}
+
+func TestCompileChecks(t *testing.T) {
+	root, errs := Compile("GET /users\n  |> respond 200 { id: user.id }", "users.rever")
+	if root != nil || len(errs) != 1 || errs[0].Error() != `users.rever:2:24: undefined name "user"` {
+		t.Fatalf("expected an undefined name error without IR, got %v", errs)
+	}
+
+	src := "import db = github.com/reverhttp/std-db@0.1.0\n\nGET /users\n  |> db(User) as users\n  |> respond 200"
+	root, errs = Compile(src, "users.rever")
+	if root == nil {
+		t.Fatalf("expected IR alongside warnings, got %v", errs)
+	}
+	if len(errs) != 1 || errs[0].Error() != `users.rever:4:18: warning: binding "users" is never used` {
+		t.Fatalf("expected an unused binding warning, got %v", errs)
+	}
+
+	// Local imports resolve against the project root holding rever.lock.json.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "rever.lock.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src = "import fetch = @/missing.rever\n\nGET /a\n  |> respond 200"
+	root, errs = Compile(src, filepath.Join(dir, "main.rever"))
+	if root != nil || len(errs) != 1 || !strings.Contains(errs[0].Error(), "cannot resolve import @/missing.rever") {
+		t.Fatalf("expected a missing import error without IR, got %v", errs)
+	}
+}
+
+func TestCompileWithOptions(t *testing.T) {
+	src := "defaults\n  auth(bearer)\n\nGET /users\n  |> respond 200"
+	root, errs := CompileWithOptions(src, "users.rever", Options{InlineDefaults: true})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if root.Routes[0].Auth == nil || root.Routes[0].Auth.Method != "bearer" {
+		t.Fatalf("expected inlined auth, got %+v", root.Routes[0].Auth)
+	}
+}